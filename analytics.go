@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how many days of analytics are kept
+	analyticsDays = 30
+	// the most distinct files/referrers/user agents counted each day, so
+	// e.g. a crawler with random user agents can't use up the memory
+	maxAnalyticsKeys = 1000
+	// how many of the top files/referrers/user agents are displayed
+	analyticsTop = 20
+	// how often the analytics are saved to -analytics-file
+	analyticsSaveInterval = time.Minute
+)
+
+// the requests on one (UTC) day
+type analyticsDay struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+	// the number of requests for each file (from X-Filepath), from a
+	// referrer (not from this server) and with each User-Agent
+	Files      map[string]int `json:"files"`
+	Referrers  map[string]int `json:"referrers"`
+	UserAgents map[string]int `json:"user_agents"`
+}
+
+func newAnalyticsDay(date string) *analyticsDay {
+	return &analyticsDay{
+		Date:       date,
+		Files:      make(map[string]int),
+		Referrers:  make(map[string]int),
+		UserAgents: make(map[string]int),
+	}
+}
+
+// counts key, unless the map is full and it isn't in it already
+func countKey(counts map[string]int, key string) {
+	if key == "" {
+		return
+	}
+	if _, ok := counts[key]; !ok && len(counts) >= maxAnalyticsKeys {
+		return
+	}
+	counts[key]++
+}
+
+// request counts for the last analyticsDays days, for /-/analytics
+//
+// kept in a ring buffer in memory (indexed by the day), and if
+// -analytics-file is passed, saved to it every minute, and loaded
+// from it at startup so they're kept across restarts
+type analytics struct {
+	file string
+
+	mu   sync.Mutex
+	days [analyticsDays]*analyticsDay
+	// whether anything changed since the analytics were last saved
+	dirty bool
+}
+
+func newAnalytics(file string) (*analytics, error) {
+	a := &analytics{file: file}
+	if file == "" {
+		return a, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read analytics: %w", err)
+	}
+	var days []*analyticsDay
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, fmt.Errorf("could not parse analytics in '%s': %w", file, err)
+	}
+	for _, day := range days {
+		date, err := time.Parse(time.DateOnly, day.Date)
+		if err != nil || day.Files == nil || day.Referrers == nil || day.UserAgents == nil {
+			return nil, fmt.Errorf("invalid day in analytics in '%s'", file)
+		}
+		a.days[dayIndex(date)] = day
+	}
+	return a, nil
+}
+
+// where the day is in the ring buffer
+func dayIndex(t time.Time) int {
+	return int(t.Unix()/(24*60*60)) % analyticsDays
+}
+
+// counts a request, after it has been responded to
+func (a *analytics) record(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	date := now.Format(time.DateOnly)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := dayIndex(now)
+	// replaces the day analyticsDays ago
+	if a.days[i] == nil || a.days[i].Date != date {
+		a.days[i] = newAnalyticsDay(date)
+	}
+	day := a.days[i]
+	day.Requests++
+	// bundles list every file, only count requests for one file
+	if p := w.Header().Get("X-Filepath"); !strings.Contains(p, ", ") {
+		countKey(day.Files, p)
+	}
+	if referrer, err := url.Parse(r.Referer()); err == nil && referrer.Host != "" && referrer.Host != r.Host {
+		countKey(day.Referrers, referrer.Host+referrer.Path)
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 200 {
+		userAgent = userAgent[:200]
+	}
+	countKey(day.UserAgents, userAgent)
+	a.dirty = true
+}
+
+// the days which are still in the ring buffer, oldest first
+func (a *analytics) recent() []*analyticsDay {
+	cutoff := time.Now().UTC().AddDate(0, 0, -analyticsDays).Format(time.DateOnly)
+	days := []*analyticsDay{}
+	for _, day := range a.days {
+		if day != nil && day.Date > cutoff {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+// writes the analytics to -analytics-file, if they changed
+func (a *analytics) save() error {
+	a.mu.Lock()
+	if !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(a.recent())
+	a.dirty = false
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so it's never half-written
+	tmp := a.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.file)
+}
+
+// saves the analytics every analyticsSaveInterval
+func (a *analytics) saveEvery() {
+	for range time.Tick(analyticsSaveInterval) {
+		if err := a.save(); err != nil {
+			log.Printf("Could not save analytics to %s: %s\n", a.file, err)
+		}
+	}
+}
+
+// a file/referrer/user agent, and how many requests it had
+type analyticsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// the analyticsTop keys with the most requests over every day
+func topCounts(days []*analyticsDay, counts func(*analyticsDay) map[string]int) []analyticsCount {
+	totals := make(map[string]int)
+	for _, day := range days {
+		for key, count := range counts(day) {
+			totals[key] += count
+		}
+	}
+	top := make([]analyticsCount, 0, len(totals))
+	for name, count := range totals {
+		top = append(top, analyticsCount{Name: name, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > analyticsTop {
+		top = top[:analyticsTop]
+	}
+	return top
+}
+
+// the number of requests on a day
+type dayRequests struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+}
+
+// the response for /-/analytics
+type analyticsSummary struct {
+	Days       []dayRequests    `json:"days"`
+	Files      []analyticsCount `json:"files"`
+	Referrers  []analyticsCount `json:"referrers"`
+	UserAgents []analyticsCount `json:"user_agents"`
+}
+
+func (a *analytics) summary() *analyticsSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	days := a.recent()
+	summary := &analyticsSummary{
+		Files:      topCounts(days, func(d *analyticsDay) map[string]int { return d.Files }),
+		Referrers:  topCounts(days, func(d *analyticsDay) map[string]int { return d.Referrers }),
+		UserAgents: topCounts(days, func(d *analyticsDay) map[string]int { return d.UserAgents }),
+	}
+	summary.Days = make([]dayRequests, len(days))
+	for i, day := range days {
+		summary.Days[i] = dayRequests{Date: day.Date, Requests: day.Requests}
+	}
+	return summary
+}
+
+// responds with the requests per day, and the top files, referrers and
+// user agents over the last analyticsDays days (authenticated), or a
+// JSON object with the same information if ?json is passed
+func (s *server) serveAnalytics(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.analytics == nil {
+		s.serveNotFound(ctx, w, "-/analytics", opts)
+		return
+	}
+	if _, ok := s.requireAuth(w, r, opts); !ok {
+		return
+	}
+	summary := s.analytics.summary()
+	w.Header().Set("Cache-Control", "no-store")
+	if hasQueryParam(r.URL.Query(), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+		return
+	}
+	var lines strings.Builder
+	lines.WriteString("Requests per day:\n")
+	for _, day := range summary.Days {
+		fmt.Fprintf(&lines, "%8d  %s\n", day.Requests, day.Date)
+	}
+	for _, section := range []struct {
+		title  string
+		counts []analyticsCount
+	}{
+		{"Top files", summary.Files},
+		{"Top referrers", summary.Referrers},
+		{"Top user agents", summary.UserAgents},
+	} {
+		fmt.Fprintf(&lines, "\n%s:\n", section.title)
+		for _, count := range section.counts {
+			fmt.Fprintf(&lines, "%8d  %s\n", count.Count, count.Name)
+		}
+	}
+	render(&w, &PageInfo{
+		PageContents: lines.String(),
+		Title:        "Analytics",
+	}, s.tmpl, opts.isDark)
+}
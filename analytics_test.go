@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyticsSaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "analytics.json")
+	a, err := newAnalytics(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, referrer := range []string{"https://example.com/post?id=1", "https://example.com/post?id=2", ""} {
+		r := httptest.NewRequest("GET", "http://localhost/rc.conf", nil)
+		r.Header.Set("Referer", referrer)
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Filepath", "rc.conf")
+		a.record(w, r)
+	}
+	if err := a.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := newAnalytics(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := loaded.summary()
+	if len(summary.Days) != 1 || summary.Days[0].Requests != 3 {
+		t.Fatalf("expected 3 requests on one day, got %+v", summary.Days)
+	}
+	if len(summary.Files) != 1 || summary.Files[0] != (analyticsCount{Name: "rc.conf", Count: 3}) {
+		t.Errorf("unexpected top files %+v", summary.Files)
+	}
+	if len(summary.Referrers) != 1 || summary.Referrers[0] != (analyticsCount{Name: "example.com/post", Count: 2}) {
+		t.Errorf("unexpected top referrers %+v", summary.Referrers)
+	}
+}
+
+func TestCountKeyLimit(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 0; i < maxAnalyticsKeys+10; i++ {
+		countKey(counts, string(rune('a'+i%26))+string(rune(i)))
+	}
+	countKey(counts, "a\x00")
+	if len(counts) != maxAnalyticsKeys || counts["a\x00"] != 2 {
+		t.Errorf("expected %d keys (and existing keys to still be counted), got %d", maxAnalyticsKeys, len(counts))
+	}
+}
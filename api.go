@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// maximum number of queries in a request to /-/api/resolve
+const maxResolveQueries = 100
+
+// the file a query resolved to, for /-/api/resolve
+type resolved struct {
+	Query string `json:"query"`
+	// path of the file, like /-/raw/<path>
+	Path   string `json:"path"`
+	Found  bool   `json:"found"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// matches each query against the files in its mount, and hashes the
+// files. Each mount is only walked once, for all of the queries in it
+//
+// files which can't be read are returned as not found
+func (s *server) resolve(ctx context.Context, queries []string) ([]*resolved, error) {
+	type match struct {
+		m *mount
+		q string
+	}
+	results := make([]*resolved, len(queries))
+	matches := make([]match, len(queries))
+	byMount := make(map[*mount][]string)
+	for i, query := range queries {
+		results[i] = &resolved{Query: query}
+		m, q, err := resolveMount(ctx, s.config.mounts, strings.Trim(query, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if m == nil || q == "" {
+			continue
+		}
+		matches[i] = match{m: m, q: q}
+		byMount[m] = append(byMount[m], q)
+	}
+	found := make(map[*mount]map[string]string)
+	for m, qs := range byMount {
+		done := timingsFrom(ctx).track("walk")
+		paths, err := findAll(ctx, m.src, qs)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		found[m] = paths
+	}
+	// files matched by more than one query are only read once
+	read := make(map[string]*resolved)
+	for i, match := range matches {
+		if match.m == nil {
+			continue
+		}
+		p, ok := found[match.m][match.q]
+		if !ok {
+			continue
+		}
+		full := mountPath(match.m, p)
+		file, ok := read[full]
+		if !ok {
+			done := timingsFrom(ctx).track("read")
+			data, _, err := s.readFileInfo(ctx, match.m, p)
+			done()
+			if err != nil && !errors.Is(err, fs.ErrPermission) {
+				return nil, err
+			}
+			if err == nil {
+				sum := sha256.Sum256(data)
+				file = &resolved{Path: full, Found: true, Size: int64(len(data)), Sha256: hex.EncodeToString(sum[:])}
+			}
+			read[full] = file
+		}
+		if file != nil {
+			results[i] = &resolved{Query: queries[i], Path: file.Path, Found: true, Size: file.Size, Sha256: file.Sha256}
+		}
+	}
+	return results, nil
+}
+
+// resolves each query in a JSON array in the body of a POST request,
+// responding with a JSON array of what each query matched
+func (s *server) serveResolve(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	var queries []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&queries); err != nil || len(queries) > maxResolveQueries {
+		msg := fmt.Sprintf("Expected a JSON array of at most %d queries\n", maxResolveQueries)
+		if err != nil {
+			msg = fmt.Sprintf("Could not parse body, expected a JSON array of queries: %s\n", err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: msg,
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	results, err := s.resolve(ctx, queries)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
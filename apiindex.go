@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default/maximum ?limit for /-/api/index
+const (
+	defaultIndexLimit = 1000
+	maxIndexLimit     = 10000
+)
+
+// the fields of each file which can be requested with ?fields=, and
+// the ones which are returned without it. sha256 reads every file
+var (
+	indexFields        = []string{"path", "size", "mod_time", "sha256"}
+	defaultIndexFields = []string{"path", "size", "mod_time"}
+)
+
+// a page of /-/api/index
+type indexPage struct {
+	// the requested fields of each file
+	Files []map[string]any `json:"files"`
+	// passed as ?cursor= to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// files are returned ordered by their path, compared a directory at a
+// time (so nvim/init.lua comes before nvim-old), which is the order
+// directories are walked in
+func indexKey(p string) string {
+	return strings.ReplaceAll(p, "/", "\x00")
+}
+
+// the cursor for the page after the file at p. It's opaque to clients,
+// so what it contains can change
+func encodeCursor(p string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(p))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	p, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(p) == 0 {
+		return "", fmt.Errorf("invalid cursor '%s', expected the next_cursor from the previous page", cursor)
+	}
+	return string(p), nil
+}
+
+// options for /-/api/index, parsed from the query parameters
+type indexQuery struct {
+	// the path of the last file on the previous page, empty for the first page
+	after string
+	// only files modified at or after this, if it's set
+	modifiedSince time.Time
+	limit         int
+	fields        []string
+}
+
+func parseIndexQuery(queryParams url.Values) (*indexQuery, error) {
+	q := &indexQuery{limit: defaultIndexLimit, fields: defaultIndexFields}
+	if cursor := queryParams.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.after = after
+	}
+	if value := queryParams.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxIndexLimit {
+			return nil, fmt.Errorf("invalid limit '%s', expected a number from 1 to %d", value, maxIndexLimit)
+		}
+		q.limit = limit
+	}
+	if value := queryParams.Get("modified_since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modified_since '%s', expected an RFC 3339 time (e.g. 2026-10-14T19:20:42Z) or unix timestamp", value)
+			}
+			since = time.Unix(seconds, 0)
+		}
+		q.modifiedSince = since
+	}
+	if value := queryParams.Get("fields"); value != "" {
+		q.fields = nil
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if !slices.Contains(indexFields, field) {
+				return nil, fmt.Errorf("unknown field '%s', expected some of: %s", field, strings.Join(indexFields, ", "))
+			}
+			if !slices.Contains(q.fields, field) {
+				q.fields = append(q.fields, field)
+			}
+		}
+	}
+	return q, nil
+}
+
+// a file which is on the page
+type indexFile struct {
+	key  string
+	m    *mount
+	p    string
+	info fs.FileInfo
+}
+
+// the files (in every mount) after the cursor, up to the limit, and
+// whether there are more after them
+//
+// only the files which can be on the page are kept while walking, and
+// directories which are entirely before the cursor, or after the last
+// file which can be on the page, aren't walked
+func (s *server) index(ctx context.Context, q *indexQuery) ([]*indexFile, bool, error) {
+	after := indexKey(q.after)
+	var files []*indexFile
+	// once there are more than limit files, the key of the last one which
+	// can be on the page. Anything after it isn't
+	upper := ""
+	// keeps the first limit+1 files, the extra one is how it's known
+	// there's another page
+	truncate := func() {
+		slices.SortFunc(files, func(a, b *indexFile) int { return strings.Compare(a.key, b.key) })
+		if len(files) > q.limit+1 {
+			files = files[:q.limit+1]
+		}
+		if len(files) == q.limit+1 {
+			upper = files[q.limit].key
+		}
+	}
+	for _, m := range s.config.mounts {
+		err := walkEntries(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			key := indexKey(mountPath(m, p))
+			if d.IsDir() {
+				// files under the directory have keys which start with its key
+				if (q.after != "" && key < after && !strings.HasPrefix(after, key+"\x00")) || (upper != "" && key > upper) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || (q.after != "" && key <= after) || (upper != "" && key > upper) {
+				return nil
+			}
+			if s.checkPrivate(ctx, m, p) != nil {
+				return nil
+			}
+			info, err := d.Info()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !q.modifiedSince.IsZero() && info.ModTime().Before(q.modifiedSince) {
+				return nil
+			}
+			files = append(files, &indexFile{key: key, m: m, p: p, info: info})
+			if len(files) > 4*(q.limit+1) {
+				truncate()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	truncate()
+	if len(files) > q.limit {
+		return files[:q.limit], true, nil
+	}
+	return files, false, nil
+}
+
+// the sha256 of the file, from the hashes for /-/manifest if it hasn't
+// changed since
+func (s *server) indexHash(ctx context.Context, file *indexFile) (string, error) {
+	full := mountPath(file.m, file.p)
+	if hash, ok := s.hashes.get(hashKey(full, file.info.Size(), file.info.ModTime())); ok {
+		return hash, nil
+	}
+	done := timingsFrom(ctx).track("read")
+	data, _, err := readFileInfo(ctx, file.m.src, file.p)
+	done()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// responds with a page of every file which is served, ordered by path,
+// as JSON, with the ?fields= of each file (path, size and mod_time by
+// default). ?cursor= is the next_cursor from the previous page, and
+// ?modified_since= only includes files modified at or after then, so
+// tools syncing the files can enumerate large trees incrementally
+func (s *server) serveAPIIndex(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	q, err := parseIndexQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: capitalize(err.Error()) + "\n",
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	done := timingsFrom(ctx).track("walk")
+	files, more, err := s.index(ctx, q)
+	done()
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	page := &indexPage{Files: make([]map[string]any, 0, len(files))}
+	for _, file := range files {
+		entry := make(map[string]any, len(q.fields))
+		for _, field := range q.fields {
+			switch field {
+			case "path":
+				entry[field] = mountPath(file.m, file.p)
+			case "size":
+				entry[field] = file.info.Size()
+			case "mod_time":
+				entry[field] = file.info.ModTime().UTC()
+			case "sha256":
+				hash, err := s.indexHash(ctx, file)
+				// the file was removed or can't be read, after it was listed
+				if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					renderError(&w, err, s.tmpl, opts.isDark)
+					return
+				}
+				entry[field] = hash
+			}
+		}
+		page.Files = append(page.Files, entry)
+	}
+	if more {
+		page.NextCursor = encodeCursor(mountPath(files[len(files)-1].m, files[len(files)-1].p))
+	}
+	s.setCacheHeadersAll(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIndexPages(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"z.md", "nvim-old", "nvim/lua/x.lua", "nvim/init.lua", "notes/secret.md", "a.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := newPrivateFiles(multiFlag{"notes/*"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{mounts: []*mount{{src: src}}}, private: private}
+	pages := func(q *indexQuery) []string {
+		var paths []string
+		for range 10 {
+			files, more, err := s.index(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				paths = append(paths, mountPath(file.m, file.p))
+			}
+			if !more {
+				return paths
+			}
+			q.after = paths[len(paths)-1]
+		}
+		t.Fatalf("more than 10 pages: %v", paths)
+		return nil
+	}
+	expected := []string{"a.txt", "nvim/init.lua", "nvim/lua/x.lua", "nvim-old", "z.md"}
+	for _, limit := range []int{1, 2, 5, 10} {
+		if got := pages(&indexQuery{limit: limit}); !slices.Equal(got, expected) {
+			t.Errorf("pages of %d = %v, expected %v", limit, got, expected)
+		}
+	}
+	if got := pages(&indexQuery{limit: 2, modifiedSince: time.Now().Add(-time.Hour)}); !slices.Equal(got, expected[1:]) {
+		t.Errorf("pages modified since an hour ago = %v, expected %v", got, expected[1:])
+	}
+}
+
+func TestParseIndexQuery(t *testing.T) {
+	for query, valid := range map[string]bool{
+		"cursor=" + encodeCursor("nvim/init.lua"): true,
+		"cursor=!!":                           false,
+		"limit=10000":                         true,
+		"limit=0":                             false,
+		"modified_since=2026-10-14T19:20:42Z": true,
+		"modified_since=1760000000":           true,
+		"modified_since=yesterday":            false,
+		"fields=path,sha256":                  true,
+		"fields=path,owner":                   false,
+	} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseIndexQuery(values); (err == nil) != valid {
+			t.Errorf("parseIndexQuery(%s) returned error %v", query, err)
+		}
+	}
+}
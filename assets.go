@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// number of hex characters of the sha256 of an asset in its URL
+const assetHashLength = 8
+
+// a file (e.g. CSS/JS for a template) served from a URL which
+// includes its hash, so it can be cached forever
+type asset struct {
+	data        []byte
+	contentType string
+}
+
+// the assets which can be served, keyed by their hashed name, and the
+// hashed name for each asset, e.g. dark.css -> dark.3f2a9c1b.css
+type assetStore struct {
+	byHashedName map[string]*asset
+	hashedNames  map[string]string
+	// -base-path, which asset URLs start with
+	basePath string
+}
+
+// assets used by templates, the builtin assets and the files
+// from -assets-dir, which is added before templates are rendered
+var assets = newAssetStore()
+
+func newAssetStore() *assetStore {
+	store := &assetStore{
+		byHashedName: make(map[string]*asset),
+		hashedNames:  make(map[string]string),
+	}
+	store.add("dark.css", []byte(darkCSS))
+	store.add("quickopen.js", []byte(quickOpenJS))
+	store.add("copylink.js", []byte(copyLinkJS))
+	return store
+}
+
+// dark.css -> dark.3f2a9c1b.css
+func hashedName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:assetHashLength]
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func (a *assetStore) add(name string, data []byte) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	hashed := hashedName(name, data)
+	a.hashedNames[name] = hashed
+	a.byHashedName[hashed] = &asset{data: data, contentType: contentType}
+}
+
+// adds every file in dir (which can replace the builtin assets)
+//
+// the files are read once at startup, so changing them requires a restart
+func (a *assetStore) addDir(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && isIgnored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		a.add(rel, data)
+		return nil
+	})
+}
+
+// the URL an asset is served from, used in templates like {{ asset "dark.css" }}
+func assetURL(name string) (string, error) {
+	hashed, ok := assets.hashedNames[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset '%s'", name)
+	}
+	return assets.basePath + "/-/assets/" + hashed, nil
+}
+
+// responds with the asset with the hashed name, which never
+// changes, so it can be cached forever
+func (s *server) serveAsset(ctx context.Context, w http.ResponseWriter, opts *requestOptions, name string) {
+	a, ok := assets.byHashedName[name]
+	if !ok {
+		s.serveNotFound(ctx, w, "-/assets/"+name, opts)
+		return
+	}
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(a.data)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// users who can authenticate with HTTP basic auth, from -auth-file
+//
+// the file has a 'user:hash' line for each user, where the hash is
+// from bcrypt, like the files created by 'htpasswd -B'
+type users map[string][]byte
+
+func loadUsers(authFile string) (users, error) {
+	f, err := os.Open(authFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read auth file: %w", err)
+	}
+	defer f.Close()
+	u := make(users)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid line %d in auth file '%s', expected user:hash", lineNo, authFile)
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return nil, fmt.Errorf("invalid hash for '%s' in auth file '%s' (create one with 'htpasswd -nB %s'): %w", parts[0], authFile, parts[0], err)
+		}
+		u[parts[0]] = []byte(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read auth file: %w", err)
+	}
+	return u, nil
+}
+
+// returns the user the request authenticated as, with basic auth
+func (u users) authenticate(r *http.Request) (string, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, found := u[name]
+	if !found {
+		// compare anyway, so unknown users take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// compared against for unknown users
+var dummyHash = []byte("$2a$10$E/jXHz1EFDlCGAXGYKP3NuDZpIuf09OQK8HbB6uLDzyYr3DJZxRAi")
+
+// the default -trusted-proxies, which can set the -auth-header
+var defaultTrustedProxies = [...]string{"127.0.0.1/32", "::1/128"}
+
+// parses the comma separated CIDRs (or single addresses) in -trusted-proxies
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	return parsePrefixes("trusted-proxies", value)
+}
+
+// parses comma separated CIDRs (or single addresses) passed to the flag
+func parsePrefixes(flagName string, value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -%s address '%s': %w", flagName, part, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s CIDR '%s': %w", flagName, part, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// the addresses -auth-exempt-local exempts from authenticating
+var loopbackPrefixes = [...]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+// whether the request came from an address in one of the prefixes
+func fromPrefixes(r *http.Request, prefixes []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// with -auth-header, the user the proxy in front of the server (e.g.
+// oauth2-proxy) authenticated the request as. The header is ignored
+// unless the request came from one of the -trusted-proxies
+func (s *server) headerUser(r *http.Request) (string, bool) {
+	if s.config.authHeader == "" {
+		return "", false
+	}
+	user := strings.TrimSpace(r.Header.Get(s.config.authHeader))
+	if user == "" || !s.fromTrustedProxy(r) {
+		return "", false
+	}
+	return user, true
+}
+
+// whether the request came from one of the -trusted-proxies, so the
+// headers it set (e.g. -auth-header, X-Forwarded-Proto) can be trusted
+func (s *server) fromTrustedProxy(r *http.Request) bool {
+	return fromPrefixes(r, s.config.trustedProxies)
+}
+
+// with -auth-exempt-local (or -auth-exempt-cidrs), whether the request can
+// read (GET/HEAD) without authenticating, because it came from one of
+// those addresses, e.g. a script on the same host
+//
+// requests forwarded by a proxy (with X-Forwarded-For or Forwarded) never
+// are, since with a proxy on the same host every request is from loopback
+func (s *server) authExempt(r *http.Request) bool {
+	if len(s.config.authExempt) == 0 || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	return fromPrefixes(r, s.config.authExempt)
+}
+
+// responds with a 401 (or a 403, if none of -auth-file, -oidc-issuer and
+// -auth-header were passed) unless the request is authenticated (or from
+// an -auth-exempt-local address), returns the name of the user
+func (s *server) requireAuth(w http.ResponseWriter, r *http.Request, opts *requestOptions) (string, bool) {
+	if user, ok := s.authenticatedUser(r); ok {
+		return user, true
+	}
+	if s.config.users == nil && s.oidc == nil && s.config.authHeader == "" {
+		w.WriteHeader(http.StatusForbidden)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("%s requires running with -auth-file, -oidc-issuer or -auth-header\n", r.URL.Path),
+			Title:        "403 - Forbidden",
+		}, s.tmpl, opts.isDark)
+		return "", false
+	}
+	s.serveUnauthorized(w, r, opts)
+	return "", false
+}
+
+// the name of the user the request is authenticated as, false if it
+// isn't authenticated
+func (s *server) authenticatedUser(r *http.Request) (string, bool) {
+	// the address stands in for the name of the user
+	if s.authExempt(r) {
+		return clientIP(r), true
+	}
+	if user, ok := s.headerUser(r); ok {
+		return user, true
+	}
+	if s.config.users != nil {
+		if user, ok := s.config.users.authenticate(r); ok {
+			return user, true
+		}
+	}
+	if s.oidc != nil {
+		if session, ok := s.oidc.session(r); ok {
+			return session.User, true
+		}
+	}
+	return "", false
+}
+
+// asks the client to authenticate with basic auth
+//
+// with -oidc-issuer, browsers are redirected to log in instead, and users
+// who already logged in (but can't read what they requested) get a 403
+func (s *server) serveUnauthorized(w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.oidc != nil {
+		if session, ok := s.oidc.session(r); ok {
+			w.WriteHeader(http.StatusForbidden)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("%s can't read %s\n", session.User, r.URL.Path),
+				Title:        "403 - Forbidden",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+		if r.Method == http.MethodGet && (opts.isDark || strings.Contains(r.Header.Get("Accept"), "text/html")) {
+			target := s.config.basePath + "/-/login?" + url.Values{"next": {s.config.basePath + r.URL.RequestURI()}}.Encode()
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+	}
+	message := "Invalid username or password\n"
+	if s.config.users != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="subpath-serve", charset="UTF-8"`)
+	} else if s.oidc != nil {
+		message = fmt.Sprintf("Log in at %s/-/login\n", s.config.basePath)
+	} else {
+		message = fmt.Sprintf("Not authenticated by a trusted proxy (with the %s header)\n", s.config.authHeader)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	render(&w, &PageInfo{
+		PageContents: message,
+		Title:        "401 - Unauthorized",
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderUser(t *testing.T) {
+	proxies, err := parseTrustedProxies("127.0.0.1, 10.0.0.0/8 ,::1/128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{authHeader: "X-Forwarded-User", trustedProxies: proxies}}
+	for _, tt := range []struct {
+		remoteAddr string
+		header     string
+		user       string
+	}{
+		{"127.0.0.1:5000", "alice", "alice"},
+		{"10.1.2.3:5000", " bob ", "bob"},
+		{"[::1]:5000", "carol", "carol"},
+		{"[::ffff:10.0.0.1]:5000", "dave", "dave"},
+		{"192.168.1.1:5000", "mallory", ""},
+		{"127.0.0.1:5000", "", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.header != "" {
+			r.Header.Set("X-Forwarded-User", tt.header)
+		}
+		if user, _ := s.headerUser(r); user != tt.user {
+			t.Errorf("headerUser() from %s = %q, expected %q", tt.remoteAddr, user, tt.user)
+		}
+	}
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("invalid CIDR was accepted")
+	}
+}
+
+func TestAuthExempt(t *testing.T) {
+	exempt, err := parsePrefixes("auth-exempt-cidrs", "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{authExempt: append(exempt, loopbackPrefixes[:]...)}}
+	for _, tt := range []struct {
+		method     string
+		remoteAddr string
+		header     string
+		exempt     bool
+	}{
+		{http.MethodGet, "127.0.0.1:5000", "", true},
+		{http.MethodHead, "127.0.0.5:5000", "", true},
+		{http.MethodGet, "[::1]:5000", "", true},
+		{http.MethodGet, "10.2.3.4:5000", "", true},
+		{http.MethodGet, "192.168.1.1:5000", "", false},
+		// writes still have to authenticate
+		{http.MethodPost, "127.0.0.1:5000", "", false},
+		// from a proxy on the same host
+		{http.MethodGet, "127.0.0.1:5000", "X-Forwarded-For", false},
+		{http.MethodGet, "127.0.0.1:5000", "Forwarded", false},
+	} {
+		r := httptest.NewRequest(tt.method, "/notes/journal.md", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.header != "" {
+			r.Header.Set(tt.header, "192.0.2.1")
+		}
+		if exempt := s.authExempt(r); exempt != tt.exempt {
+			t.Errorf("authExempt() for a %s from %s (%s) = %t, expected %t", tt.method, tt.remoteAddr, tt.header, exempt, tt.exempt)
+		}
+	}
+	s.config.authExempt = nil
+	r := httptest.NewRequest(http.MethodGet, "/notes/journal.md", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	if s.authExempt(r) {
+		t.Errorf("requests are exempt without -auth-exempt-local")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// writes are split into chunks of at most this many bytes, so connections
+// sharing -max-bandwidth take turns, and each one is sent smoothly
+const throttleChunk = 16 * 1024
+
+// limits how fast bytes are written through it, to rate bytes per second
+//
+// each write reserves the time it takes to send it at that rate, after
+// the writes before it, and waits until its turn. Time it was idle isn't
+// saved up, so it never bursts above the rate
+type rateLimiter struct {
+	rate int64
+
+	mu sync.Mutex
+	// when the bytes reserved so far will have been sent
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// waits until n bytes can be written. Does nothing for a nil limiter, so
+// either limit can be disabled
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// a listener whose connections write at most -max-bandwidth-per-conn
+// each, and at most -max-bandwidth together. Only what's sent to clients
+// is limited, not what they send
+type throttledListener struct {
+	net.Listener
+	// nil if it isn't limited
+	global *rateLimiter
+	// bytes per second for each connection, 0 if it isn't limited
+	perConn int64
+}
+
+func newThrottledListener(listener net.Listener, global int64, perConn int64) *throttledListener {
+	l := &throttledListener{Listener: listener, perConn: perConn}
+	if global > 0 {
+		l.global = newRateLimiter(global)
+	}
+	return l
+}
+
+func (l *throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	throttled := &throttledConn{Conn: conn, global: l.global}
+	if l.perConn > 0 {
+		throttled.own = newRateLimiter(l.perConn)
+	}
+	return throttled, nil
+}
+
+type throttledConn struct {
+	net.Conn
+	global *rateLimiter
+	own    *rateLimiter
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		c.own.wait(len(chunk))
+		c.global.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
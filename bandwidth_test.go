@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottledConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	// 64 KiB at 256 KiB/s, the first chunk is sent right away
+	conn := &throttledConn{Conn: server, own: newRateLimiter(256 * 1024)}
+	data := make([]byte, 64*1024)
+	start := time.Now()
+	go func() {
+		conn.Write(data)
+		server.Close()
+	}()
+	received, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if len(received) != len(data) {
+		t.Errorf("received %d bytes, expected %d", len(received), len(data))
+	}
+	if expected := 3 * throttleChunk * time.Second / (256 * 1024); elapsed < expected || elapsed > expected+time.Second {
+		t.Errorf("sending 64 KiB at 256 KiB/s took %s, expected about %s", elapsed, expected)
+	}
+	// without a limit, it isn't delayed
+	var unlimited *rateLimiter
+	start = time.Now()
+	unlimited.wait(1 << 30)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("nil limiter waited %s", elapsed)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parses the -bundles file, a TOML file with a list
+// of queries for each bundle, e.g. shell = ["bashrc", "zshrc"]
+func loadBundles(bundlesFile string) (map[string][]string, error) {
+	bundles := make(map[string][]string)
+	if _, err := toml.DecodeFile(bundlesFile, &bundles); err != nil {
+		return nil, fmt.Errorf("could not parse bundles '%s': %w", bundlesFile, err)
+	}
+	for name, queries := range bundles {
+		if name == "" || strings.Contains(name, "/") || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("invalid bundle name '%s' in '%s', can't contain a '/' or '.'", name, bundlesFile)
+		}
+		if len(queries) == 0 {
+			return nil, fmt.Errorf("bundle '%s' in '%s' doesn't have any queries", name, bundlesFile)
+		}
+	}
+	return bundles, nil
+}
+
+// formats a bundle can be downloaded in, by the extension of the request
+var bundleFormats = [...]string{".tar.gz", ".zip"}
+
+// a file in a bundle
+type bundleFile struct {
+	// path of the file, like /-/raw/<path>
+	path string
+	data []byte
+	info fs.FileInfo
+}
+
+// matches each query in the bundle, and reads the files
+//
+// returns the queries which didn't match any file
+func (s *server) resolveBundle(ctx context.Context, queries []string) ([]*bundleFile, []string, error) {
+	files := []*bundleFile{}
+	missing := []string{}
+	for _, query := range queries {
+		m, q, err := resolveMount(ctx, s.config.mounts, strings.Trim(query, "/"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if m == nil || q == "" {
+			missing = append(missing, query)
+			continue
+		}
+		done := timingsFrom(ctx).track("walk")
+		foundPath, err := s.find(ctx, m, q)
+		done()
+		if errors.Is(err, ErrNotFound) {
+			missing = append(missing, query)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		done = timingsFrom(ctx).track("read")
+		data, info, err := s.readFileInfo(ctx, m, foundPath)
+		done()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, &bundleFile{path: mountPath(m, foundPath), data: data, info: info})
+	}
+	return files, missing, nil
+}
+
+// responds with the files for each query in a bundle from -bundles
+//
+// /-/bundle/<name> concatenates the files, with a '==> path <==' line before each,
+// /-/bundle/<name>.tar.gz and /-/bundle/<name>.zip respond with an archive
+//
+// if any of the queries don't match a file, responds with a 404
+func (s *server) serveBundle(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+	format := ""
+	for _, ext := range bundleFormats {
+		if strings.HasSuffix(name, ext) {
+			name, format = strings.TrimSuffix(name, ext), ext
+		}
+	}
+	queries, ok := s.bundles[name]
+	if !ok {
+		s.serveNotFound(ctx, w, "-/bundle/"+name+format, opts)
+		return
+	}
+	if format != "" {
+		release, err := s.archives.acquire(ctx)
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		defer release()
+	}
+	files, missing, err := s.resolveBundle(ctx, queries)
+	if errors.Is(err, fs.ErrPermission) {
+		s.serveForbidden(w, "-/bundle/"+name+format, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Could not find a match for %s in bundle %s\n", strings.Join(missing, ", "), name),
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	// purging any of the files purges the listing for the root, like /-/mirror.tar.gz
+	s.setCacheHeadersAll(w)
+	w.Header().Set("X-Filepath", strings.Join(paths, ", "))
+	switch format {
+	case ".tar.gz", ".zip":
+		// the archive is built in memory (like the files in it are read
+		// into memory), so it has a Content-Length and can be resumed
+		var archive bytes.Buffer
+		write, contentType := s.writeBundleTar, "application/gzip"
+		if format == ".zip" {
+			write, contentType = writeBundleZip, "application/zip"
+		}
+		if err := write(&archive, files); err != nil {
+			renderError(&w, fmt.Errorf("could not write bundle %s: %w", name, err), s.tmpl, opts.isDark)
+			return
+		}
+		sum := sha256.Sum256(archive.Bytes())
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, format))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		http.ServeContent(w, r, name+format, time.Time{}, bytes.NewReader(archive.Bytes()))
+	default:
+		var contents strings.Builder
+		for i, f := range files {
+			if i > 0 {
+				contents.WriteString("\n")
+			}
+			fmt.Fprintf(&contents, "==> %s <==\n", f.path)
+			contents.Write(f.data)
+		}
+		render(&w, &PageInfo{
+			PageContents: contents.String(),
+			Title:        "Bundle " + name,
+		}, s.tmpl, opts.isDark)
+	}
+}
+
+func (s *server) writeBundleTar(w io.Writer, files []*bundleFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(s.tarHeader(f.path, f.info, int64(len(f.data)))); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeBundleZip(w io.Writer, files []*bundleFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		header := &zip.FileHeader{
+			Name:     f.path,
+			Method:   zip.Deflate,
+			Modified: f.info.ModTime(),
+		}
+		header.SetMode(f.info.Mode().Perm())
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// an in-memory cache of generated data (thumbnails, rendered pages),
+// which evicts entries once the total size is over maxSize
+type byteCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	size    int64
+	maxSize int64
+	counts  cacheCounts
+}
+
+func newByteCache(maxSize int64) *byteCache {
+	return &byteCache{entries: make(map[string][]byte), maxSize: maxSize}
+}
+
+func (c *byteCache) get(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	c.counts.count(ok)
+	return data
+}
+
+func (c *byteCache) put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := int64(len(data))
+	if size > c.maxSize {
+		return
+	}
+	if old, ok := c.entries[key]; ok {
+		c.size -= int64(len(old))
+	}
+	// evict (arbitrary) entries until this fits
+	for k, d := range c.entries {
+		if c.size+size <= c.maxSize {
+			break
+		}
+		delete(c.entries, k)
+		c.size -= int64(len(d))
+	}
+	c.entries[key] = data
+	c.size += size
+}
+
+// drops the entries for the file/directory at p (and everything
+// under it), for keys which start with the path, like renderKey
+func (c *byteCache) purge(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, data := range c.entries {
+		if p == "" || strings.HasPrefix(key, p+":") || strings.HasPrefix(key, p+"/") {
+			delete(c.entries, key)
+			c.size -= int64(len(data))
+		}
+	}
+}
+
+// rendered ?dark pages are cached (with -render-cache-size), keyed by the
+// path and metadata of the file, the template it was rendered with, the
+// theme, the options which change how it's rendered, the URL of the
+// server (which the page links to in the run with hint for scripts) and
+// the language times are displayed in
+func renderKey(p string, info fs.FileInfo, renderer string, opts *requestOptions, base string, lang string) string {
+	// the page includes the notice, and how long ago the file was modified,
+	// which can change without the file changing
+	return fmt.Sprintf("%s:%d:%d:%s:dark:%t:%t:%s:%s:%s", p, info.Size(), info.ModTime().UnixNano(), renderer, opts.isPretty, opts.toc, base, notice.get(), timestamp(info.ModTime(), lang))
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestByteCachePurge(t *testing.T) {
+	c := newByteCache(1 << 20)
+	for _, key := range []string{"notes/a.md:1:2:default:dark:false", "notes/b/c.md:1:2:default:dark:false", "notes.md:1:2:default:dark:false", "other/a.md:1:2:default:dark:false"} {
+		c.put(key, []byte(key))
+	}
+	c.purge("notes/a.md")
+	if c.get("notes/a.md:1:2:default:dark:false") != nil {
+		t.Errorf("purging a file didn't drop it")
+	}
+	c.purge("notes")
+	if c.get("notes/b/c.md:1:2:default:dark:false") != nil {
+		t.Errorf("purging a directory didn't drop the files under it")
+	}
+	if c.get("notes.md:1:2:default:dark:false") == nil || c.get("other/a.md:1:2:default:dark:false") == nil {
+		t.Errorf("purged files outside the path")
+	}
+	c.purge("")
+	if len(c.entries) != 0 || c.size != 0 {
+		t.Errorf("purging everything left %d entries (%d bytes)", len(c.entries), c.size)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long changes are kept for /-/changes
+const changesRetention = 30 * 24 * time.Hour
+
+// a file in the index the changes are found from
+type indexedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// a file which was added, removed or modified, when it was noticed
+type fileChange struct {
+	Time time.Time `json:"time"`
+	// path of the file, starting with the mount name, like /-/raw/<path>
+	Path string `json:"path"`
+	// one of: added, removed, modified
+	Change string `json:"change"`
+}
+
+// which files were added, removed or modified, for /-/changes
+//
+// every -changes-interval, every file (from each mount) is listed, and
+// compared to the last listing by its size and modification time, so
+// files are never read. Only the latest listing is kept, and the changes
+// from the last changesRetention. If -changes-file is passed, they're
+// saved to it after each listing, and loaded from it at startup so
+// they're kept across restarts (and changes while it was stopped are
+// noticed when it starts)
+type changeLog struct {
+	file string
+
+	mu    sync.Mutex
+	index map[string]indexedFile
+	// when the first listing was taken, changes before it aren't known
+	knownSince time.Time
+	changes    []fileChange
+}
+
+// the contents of -changes-file
+type savedChanges struct {
+	KnownSince time.Time              `json:"known_since"`
+	Index      map[string]indexedFile `json:"index"`
+	Changes    []fileChange           `json:"changes"`
+}
+
+func newChangeLog(file string) (*changeLog, error) {
+	c := &changeLog{file: file}
+	if file == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read changes: %w", err)
+	}
+	var saved savedChanges
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("could not parse changes in '%s': %w", file, err)
+	}
+	if saved.Index == nil {
+		return nil, fmt.Errorf("invalid changes in '%s', missing the index", file)
+	}
+	c.index, c.knownSince, c.changes = saved.Index, saved.KnownSince, saved.Changes
+	return c, nil
+}
+
+// lists every file which is served (including private ones, which are
+// filtered out when the changes are requested)
+func (s *server) listIndex(ctx context.Context) (map[string]indexedFile, error) {
+	index := make(map[string]indexedFile)
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			info, err := d.Info()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			index[mountPath(m, p)] = indexedFile{Size: info.Size(), ModTime: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// compares the index to the last one, and records what changed. The
+// first index is only what later ones are compared to
+func (c *changeLog) record(index map[string]indexedFile, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.index == nil {
+		c.index, c.knownSince = index, now
+		return
+	}
+	changes := []fileChange{}
+	for p, file := range index {
+		previous, ok := c.index[p]
+		if !ok {
+			changes = append(changes, fileChange{Time: now, Path: p, Change: "added"})
+		} else if previous.Size != file.Size || !previous.ModTime.Equal(file.ModTime) {
+			changes = append(changes, fileChange{Time: now, Path: p, Change: "modified"})
+		}
+	}
+	for p := range c.index {
+		if _, ok := index[p]; !ok {
+			changes = append(changes, fileChange{Time: now, Path: p, Change: "removed"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	c.index = index
+	// drop changes older than changesRetention, so this doesn't grow forever
+	cutoff := now.Add(-changesRetention)
+	i := sort.Search(len(c.changes), func(i int) bool { return c.changes[i].Time.After(cutoff) })
+	c.changes = append(c.changes[i:], changes...)
+	if c.knownSince.Before(cutoff) {
+		c.knownSince = cutoff
+	}
+}
+
+// writes the index and changes to -changes-file
+func (c *changeLog) save() error {
+	c.mu.Lock()
+	data, err := json.Marshal(&savedChanges{KnownSince: c.knownSince, Index: c.index, Changes: c.changes})
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so it's never half-written
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// lists the files every interval, starting now, and records the changes
+func (s *server) recordChangesEvery(interval time.Duration) {
+	for {
+		// the mounts don't change on reload, but which files are ignored can
+		live := s.live()
+		index, err := live.listIndex(context.Background())
+		if err != nil {
+			log.Printf("Could not list files for /-/changes: %s\n", err)
+		} else {
+			live.changes.record(index, time.Now())
+			if live.changes.file != "" {
+				if err := live.changes.save(); err != nil {
+					log.Printf("Could not save changes to %s: %s\n", live.changes.file, err)
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// the net change to each file between since and until: a file which was
+// added and then removed again isn't included, and one which was removed
+// and then added again was modified. Also returns when the changes are
+// known from
+func (c *changeLog) between(since time.Time, until time.Time) ([]fileChange, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	first := make(map[string]fileChange)
+	last := make(map[string]fileChange)
+	for _, change := range c.changes {
+		if !change.Time.After(since) || change.Time.After(until) {
+			continue
+		}
+		if _, ok := first[change.Path]; !ok {
+			first[change.Path] = change
+		}
+		last[change.Path] = change
+	}
+	changes := []fileChange{}
+	for p, change := range last {
+		existedBefore, existsAfter := first[p].Change != "added", change.Change != "removed"
+		switch {
+		case !existedBefore && existsAfter:
+			change.Change = "added"
+		case existedBefore && !existsAfter:
+			change.Change = "removed"
+		case existedBefore && existsAfter:
+			change.Change = "modified"
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, c.knownSince
+}
+
+// parses ?since=/?until=, either how long ago (e.g. 24h, or 7d for days),
+// an RFC 3339 time (e.g. 2026-10-14T03:00:00Z), or a date (UTC)
+func parseChangesTime(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected how long ago (e.g. 24h or 7d), an RFC 3339 time or a date", value)
+}
+
+// the response for /-/changes?json
+type changesResponse struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// when the changes are known from, later than since if the files
+	// weren't being listed yet
+	KnownSince time.Time `json:"known_since"`
+	Added      []string  `json:"added"`
+	Removed    []string  `json:"removed"`
+	Modified   []string  `json:"modified"`
+}
+
+// responds with the files which were added (A), removed (D) or modified
+// (M) between ?since= (default 24h ago) and ?until= (default now), one per
+// line like 'git diff --name-status', or as a JSON object if ?json is
+// passed. Private files are only included for requests which can read them
+func (s *server) serveChanges(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.changes == nil {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: "Changes require running with -changes-interval\n",
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	now := time.Now()
+	times := map[string]time.Time{"since": now.Add(-24 * time.Hour), "until": now}
+	for _, param := range []string{"since", "until"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseChangesTime(value, now)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("?%s: %s\n", param, err),
+				Title:        "400 - Bad Request",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+		times[param] = t
+	}
+	response := &changesResponse{Since: times["since"], Until: times["until"], Added: []string{}, Removed: []string{}, Modified: []string{}}
+	changes, knownSince := s.changes.between(response.Since, response.Until)
+	response.KnownSince = knownSince
+	var lines strings.Builder
+	if knownSince.After(response.Since) {
+		fmt.Fprintf(&lines, "# changes are only known since %s\n", exactTime(knownSince))
+	}
+	for _, change := range changes {
+		if m, p := matchMount(s.config.mounts, change.Path); m == nil || s.checkPrivate(ctx, m, p) != nil {
+			continue
+		}
+		switch change.Change {
+		case "added":
+			response.Added = append(response.Added, change.Path)
+			fmt.Fprintf(&lines, "A\t%s\n", change.Path)
+		case "removed":
+			response.Removed = append(response.Removed, change.Path)
+			fmt.Fprintf(&lines, "D\t%s\n", change.Path)
+		case "modified":
+			response.Modified = append(response.Modified, change.Path)
+			fmt.Fprintf(&lines, "M\t%s\n", change.Path)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if hasQueryParam(r.URL.Query(), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	render(&w, &PageInfo{
+		PageContents: lines.String(),
+		Title:        "Changes",
+	}, s.tmpl, opts.isDark)
+}
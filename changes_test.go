@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeLog(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	modTime := start.Add(-time.Hour)
+	file := filepath.Join(t.TempDir(), "changes.json")
+	c, err := newChangeLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.record(map[string]indexedFile{"bashrc": {1, modTime}, "old.sh": {2, modTime}, "vimrc": {3, modTime}}, start)
+	// an hour later, bashrc was modified, old.sh removed and new.sh added,
+	// and tmp.txt was added, then removed an hour after that
+	c.record(map[string]indexedFile{"bashrc": {4, start}, "new.sh": {5, start}, "tmp.txt": {6, start}, "vimrc": {3, modTime}}, start.Add(time.Hour))
+	c.record(map[string]indexedFile{"bashrc": {4, start}, "new.sh": {5, start}, "vimrc": {3, modTime}}, start.Add(2*time.Hour))
+	// the changes are kept across restarts
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = newChangeLog(file); err != nil {
+		t.Fatal(err)
+	}
+	summarize := func(changes []fileChange) map[string]string {
+		summary := make(map[string]string)
+		for _, change := range changes {
+			summary[change.Path] = change.Change
+		}
+		return summary
+	}
+	changes, knownSince := c.between(start.Add(-24*time.Hour), start.Add(3*time.Hour))
+	if !knownSince.Equal(start) {
+		t.Errorf("changes known since %s, expected %s", knownSince, start)
+	}
+	if got, expected := summarize(changes), map[string]string{"bashrc": "modified", "new.sh": "added", "old.sh": "removed"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("changes = %v, expected %v", got, expected)
+	}
+	// only the second listing
+	changes, _ = c.between(start.Add(90*time.Minute), start.Add(3*time.Hour))
+	if got, expected := summarize(changes), map[string]string{"tmp.txt": "removed"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("later changes = %v, expected %v", got, expected)
+	}
+	// changes older than changesRetention are dropped
+	c.record(map[string]indexedFile{"vimrc": {3, modTime}}, start.Add(changesRetention+90*time.Minute))
+	changes, knownSince = c.between(start.Add(-24*time.Hour), start.Add(changesRetention+2*time.Hour))
+	if got, expected := summarize(changes), map[string]string{"tmp.txt": "removed", "bashrc": "removed", "new.sh": "removed"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("changes after changesRetention = %v, expected %v", got, expected)
+	}
+	if !knownSince.Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("changes known since %s after changesRetention", knownSince)
+	}
+}
+
+func TestParseChangesTime(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		value    string
+		expected time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2026-10-14T03:00:00Z", time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)},
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		got, err := parseChangesTime(tt.value, now)
+		if err != nil || !got.Equal(tt.expected) {
+			t.Errorf("parseChangesTime(%q) = %s, %v, expected %s", tt.value, got, err, tt.expected)
+		}
+	}
+	for _, value := range []string{"", "-1h", "yesterday", "-2d"} {
+		if _, err := parseChangesTime(value, now); err == nil {
+			t.Errorf("parseChangesTime(%q) didn't fail", value)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// orders listings can be sorted in, with -sort
+//
+// bytes is the order the folder is walked in (by the bytes of each name),
+// unicode ignores case and accents, and natural is unicode, with numbers
+// compared by their value, so 2-bar.md is listed before 10-foo.md
+var sortOrders = [...]string{"bytes", "unicode", "natural"}
+
+// accented Latin letters, and the letter each one is sorted as, from
+// their canonical decompositions (e.g. é is e + a combining acute accent)
+var accentedLetters = "ÀÁÂÃÄÅÇÈÉÊËÌÍÎÏÑÒÓÔÕÖÙÚÛÜÝàáâãäåçèéêëìíî" +
+	"ïñòóôõöùúûüýÿĀāĂăĄąĆćĈĉĊċČčĎďĒēĔĕĖėĘęĚěĜ" +
+	"ĝĞğĠġĢģĤĥĨĩĪīĬĭĮįİĴĵĶķĹĺĻļĽľŃńŅņŇňŌōŎŏŐő" +
+	"ŔŕŖŗŘřŚśŜŝŞşŠšŢţŤťŨũŪūŬŭŮůŰűŲųŴŵŶŷŸŹźŻżŽ" +
+	"žƠơƯưǍǎǏǐǑǒǓǔǕǖǗǘǙǚǛǜǞǟǠǡǦǧǨǩǪǫǬǭǰǴǵǸǹǺǻ" +
+	"ȀȁȂȃȄȅȆȇȈȉȊȋȌȍȎȏȐȑȒȓȔȕȖȗȘșȚțȞȟȦȧȨȩȪȫȬȭȮȯ" +
+	"ȰȱȲȳ"
+
+var accentBases = "aaaaaaceeeeiiiinooooouuuuyaaaaaaceeeeiii" +
+	"inooooouuuuyyaaaaaaccccccccddeeeeeeeeeeg" +
+	"ggggggghhiiiiiiiiijjkkllllllnnnnnnoooooo" +
+	"rrrrrrssssssssttttuuuuuuuuuuuuwwyyyzzzzz" +
+	"zoouuaaiioouuuuuuuuuuaaaaggkkoooojggnnaa" +
+	"aaaaeeeeiiiioooorrrruuuusstthhaaeeoooooo" +
+	"ooyy"
+
+// the accented letter -> the letter it's sorted as
+var accentFolds = func() map[rune]rune {
+	folds := make(map[rune]rune)
+	bases := []rune(accentBases)
+	for i, r := range []rune(accentedLetters) {
+		folds[r] = bases[i]
+	}
+	return folds
+}()
+
+// r, lowercased and without an accent
+func foldRune(r rune) rune {
+	if base, ok := accentFolds[r]; ok {
+		return base
+	}
+	return unicode.ToLower(r)
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// compares two names by their folded letters, and with natural, the
+// numbers in them by their value
+func compareNames(a string, b string, natural bool) int {
+	for a != "" && b != "" {
+		if natural && isDigit(a[0]) && isDigit(b[0]) {
+			i, j := 1, 1
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			// longer numbers (without leading zeroes) are larger
+			x, y := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if c := cmp.Compare(len(x), len(y)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		x, i := utf8.DecodeRuneInString(a)
+		y, j := utf8.DecodeRuneInString(b)
+		if c := cmp.Compare(foldRune(x), foldRune(y)); c != 0 {
+			return c
+		}
+		a, b = a[i:], b[j:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// compares two paths in a listing, a directory at a time, so the files
+// in a directory stay together. Paths which are only different in case,
+// accents or leading zeroes are compared by their bytes
+func comparePaths(a string, b string, order string) int {
+	x, y := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := compareNames(x[i], y[i], order == "natural"); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(len(x), len(y)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// sorts the lines of a listing in the order from -sort, unless it's bytes
+func sortListing(lines []string, order string) {
+	if order == "bytes" {
+		return
+	}
+	slices.SortStableFunc(lines, func(a, b string) int {
+		return comparePaths(a, b, order)
+	})
+}
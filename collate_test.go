@@ -0,0 +1,21 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortListing(t *testing.T) {
+	lines := []string{"Zeta.md", "notes/10-foo.md", "f.md", "notes/2-bar.md", "é.md", "B.md", "notes.md", "a.md", "notes/02-baz.md"}
+	for order, expected := range map[string][]string{
+		"bytes":   lines,
+		"unicode": {"a.md", "B.md", "é.md", "f.md", "notes/02-baz.md", "notes/10-foo.md", "notes/2-bar.md", "notes.md", "Zeta.md"},
+		"natural": {"a.md", "B.md", "é.md", "f.md", "notes/2-bar.md", "notes/02-baz.md", "notes/10-foo.md", "notes.md", "Zeta.md"},
+	} {
+		sorted := slices.Clone(lines)
+		sortListing(sorted, order)
+		if !slices.Equal(sorted, expected) {
+			t.Errorf("sortListing(%s) = %v, expected %v", order, sorted, expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// default/maximum number of completions returned
+const (
+	defaultCompletions = 20
+	maxCompletions     = 1000
+)
+
+// a possible completion for a query
+type completion struct {
+	// a path which can be requested, which matches the file
+	Path string `json:"path"`
+	// prefix or fuzzy
+	Match string `json:"match"`
+	// lower is a better match
+	score int
+}
+
+// if some suffix of path (starting at a '/') starts with q,
+// returns the shortest such suffix, which is a valid query for the file
+func prefixCompletion(path string, q string) (string, bool) {
+	for start := strings.LastIndex(path, "/") + 1; ; {
+		if strings.HasPrefix(path[start:], q) {
+			return path[start:], true
+		}
+		if start == 0 {
+			return "", false
+		}
+		start = strings.LastIndex(path[:start-1], "/") + 1
+	}
+}
+
+// if every character in q appears in path in order (case-insensitive),
+// returns a score for how spread out they are (lower is better)
+func fuzzyScore(path string, q string) (int, bool) {
+	path, q = strings.ToLower(path), strings.ToLower(q)
+	score, last := 0, -1
+	for _, c := range q {
+		i := strings.IndexRune(path[last+1:], c)
+		if i == -1 {
+			return 0, false
+		}
+		score += i
+		last += i + 1
+	}
+	// prefer shorter paths when the characters are equally spread out
+	return score*1000 + len(path), true
+}
+
+// lists completions for q from each mount, prefix matches first
+func complete(ctx context.Context, mounts []*mount, q string, limit int) ([]completion, error) {
+	prefixMatches := []completion{}
+	fuzzyMatches := []completion{}
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		prefix := ""
+		inner, hasMount := q, true
+		if m.name != "" {
+			prefix = m.name + "/"
+			hasMount = strings.HasPrefix(q, prefix)
+			inner = strings.TrimPrefix(q, prefix)
+		}
+		err := walkFiles(ctx, m.src, ".", func(path string, d fs.DirEntry) error {
+			if hasMount {
+				if suffix, ok := prefixCompletion(path, inner); ok {
+					candidate := prefix + suffix
+					if !seen[candidate] {
+						seen[candidate] = true
+						prefixMatches = append(prefixMatches, completion{Path: candidate, Match: "prefix", score: len(candidate)})
+					}
+					return nil
+				}
+			}
+			full := prefix + path
+			if score, ok := fuzzyScore(full, q); ok && !seen[full] {
+				seen[full] = true
+				fuzzyMatches = append(fuzzyMatches, completion{Path: full, Match: "fuzzy", score: score})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, matches := range [][]completion{prefixMatches, fuzzyMatches} {
+		sort.SliceStable(matches, func(i, j int) bool {
+			if matches[i].score != matches[j].score {
+				return matches[i].score < matches[j].score
+			}
+			return matches[i].Path < matches[j].Path
+		})
+	}
+	completions := append(prefixMatches, fuzzyMatches...)
+	if len(completions) > limit {
+		completions = completions[:limit]
+	}
+	return completions, nil
+}
+
+// responds with completions for ?q=, one per line
+// or as a JSON array, if ?json is passed
+func (s *server) serveComplete(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	queryParams := r.URL.Query()
+	limit := defaultCompletions
+	if l, err := strconv.Atoi(queryParams.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxCompletions {
+		limit = maxCompletions
+	}
+	completions, err := complete(ctx, s.config.mounts, strings.TrimLeft(queryParams.Get("q"), "/"), limit)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if hasQueryParam(queryParams, "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(completions)
+		return
+	}
+	var lines strings.Builder
+	for _, c := range completions {
+		lines.WriteString(c.Path)
+		lines.WriteString("\n")
+	}
+	render(&w, &PageInfo{
+		PageContents: lines.String(),
+		Title:        "Completions",
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// how many of the most recently modified files the dashboard lists
+const dashboardRecent = 10
+
+// a summary of the files in the index, displayed above
+// the ?dark index with -dashboard
+type Dashboard struct {
+	Files int
+	Size  int64
+	// the latest modification time of any file
+	LastModified time.Time
+	// when the latest snapshot was taken, with -snapshot
+	SnapshotAt time.Time
+	// the most recently modified files, newest first
+	Recent []RecentFile
+}
+
+type RecentFile struct {
+	// relative to the index, like the lines in the listing
+	Path    string
+	ModTime time.Time
+}
+
+// walks each root of the index to summarize the files in it
+func (s *server) dashboard(ctx context.Context, roots []indexRoot) (*Dashboard, error) {
+	d := &Dashboard{}
+	for _, root := range roots {
+		if snapshot, ok := root.m.src.(*snapshotSource); ok {
+			if takenAt := snapshot.takenTime(); takenAt.After(d.SnapshotAt) {
+				d.SnapshotAt = takenAt
+			}
+		}
+		err := walkFiles(ctx, root.m.src, root.dir, func(p string, entry fs.DirEntry) error {
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			d.Files++
+			d.Size += info.Size()
+			if info.ModTime().After(d.LastModified) {
+				d.LastModified = info.ModTime()
+			}
+			d.addRecent(RecentFile{Path: root.prefix + p, ModTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// keeps the dashboardRecent most recently modified files
+func (d *Dashboard) addRecent(file RecentFile) {
+	if len(d.Recent) == dashboardRecent && !file.ModTime.After(d.Recent[len(d.Recent)-1].ModTime) {
+		return
+	}
+	i := sort.Search(len(d.Recent), func(i int) bool { return file.ModTime.After(d.Recent[i].ModTime) })
+	d.Recent = append(d.Recent, RecentFile{})
+	copy(d.Recent[i+1:], d.Recent[i:])
+	d.Recent[i] = file
+	if len(d.Recent) > dashboardRecent {
+		d.Recent = d.Recent[:dashboardRecent]
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+)
+
+// with -dev, a copy of the server with -template and -template-rules
+// parsed again, so changes to them show up on the next request
+func (s *server) reloadTemplates() (*server, error) {
+	tmpl, err := setupTemplate(s.config.templateFile)
+	if err != nil {
+		return nil, err
+	}
+	lineNumbersTmpl, err := withVariant(tmpl, "code")
+	if err != nil {
+		return nil, err
+	}
+	var templateRules []*templateRule
+	if s.config.templateRulesFile != "" {
+		templateRules, err = loadTemplateRules(s.config.templateRulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dev := *s
+	dev.tmpl, dev.lineNumbersTmpl, dev.templateRules = tmpl, lineNumbersTmpl, templateRules
+	return &dev, nil
+}
+
+// with -dev, responds with the error from parsing the template, rendered
+// with the builtin template since the custom one can't be used
+func serveTemplateError(w http.ResponseWriter, err error, opts *requestOptions) {
+	tmpl, _ := builtinTemplate("dark")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	render(&w, &PageInfo{
+		PageContents: capitalize(err.Error()) + "\n",
+		Title:        "500 - Internal Server Error",
+	}, tmpl, opts.isDark)
+}
+
+// with -dev, replaces the Cache-Control header of every response, so the
+// browser requests pages again instead of using what it cached
+type noStoreWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noStoreWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *noStoreWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+)
+
+// a directory in a listing, with -dirs-first
+type DirEntry struct {
+	Name string
+	// number of files in the directory, including in subdirectories
+	Files int
+	// total size of the files in it, with -dir-sizes, else 0
+	Size int64
+}
+
+// splits the lines of a listing into the directories directly in
+// the listing (with the number of files in each), and the files
+// directly in the listing. Both keep the order they were walked in
+func groupDirs(lines []string) ([]DirEntry, []string) {
+	dirs := []DirEntry{}
+	index := make(map[string]int)
+	files := []string{}
+	for _, line := range lines {
+		name, _, isDir := strings.Cut(line, "/")
+		if !isDir {
+			files = append(files, line)
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(dirs)
+			index[name] = i
+			dirs = append(dirs, DirEntry{Name: name})
+		}
+		dirs[i].Files++
+	}
+	return dirs, files
+}
+
+// the page of the directories and files (directories first) for opts,
+// and whether there are more entries after the page
+func paginateDirs(dirs []DirEntry, files []string, opts *requestOptions) ([]DirEntry, []string, bool) {
+	total := len(dirs) + len(files)
+	start := min(opts.offset, total)
+	end := total
+	if opts.limit != 0 {
+		end = min(start+opts.limit, total)
+	}
+	dirStart, dirEnd := min(start, len(dirs)), min(end, len(dirs))
+	fileStart, fileEnd := max(start-len(dirs), 0), max(end-len(dirs), 0)
+	return dirs[dirStart:dirEnd], files[fileStart:fileEnd], end < total
+}
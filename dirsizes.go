@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the total size of the files in directories, with -dir-sizes, computed
+// when they're requested and cached for ttl, by the path of the directory
+// (like mountPath)
+type dirSizes struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	sizes  map[string]dirSize
+	counts cacheCounts
+}
+
+type dirSize struct {
+	size int64
+	at   time.Time
+}
+
+func newDirSizes(ttl time.Duration) *dirSizes {
+	return &dirSizes{ttl: ttl, sizes: make(map[string]dirSize)}
+}
+
+func (d *dirSizes) get(key string) (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cached, ok := d.sizes[key]
+	if !ok || time.Since(cached.at) > d.ttl {
+		d.counts.count(false)
+		return 0, false
+	}
+	d.counts.count(true)
+	return cached.size, true
+}
+
+func (d *dirSizes) put(sizes map[string]int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	// drop expired sizes, so this doesn't grow forever
+	for key, cached := range d.sizes {
+		if now.Sub(cached.at) > d.ttl {
+			delete(d.sizes, key)
+		}
+	}
+	for key, size := range sizes {
+		d.sizes[key] = dirSize{size: size, at: now}
+	}
+}
+
+// drops the sizes of the directory at p, the directories under it, and
+// the directories above it, which it's part of
+func (d *dirSizes) purge(p string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.sizes {
+		if p == "" || key == "" || key == p || strings.HasPrefix(key, p+"/") || strings.HasPrefix(p, key+"/") {
+			delete(d.sizes, key)
+		}
+	}
+}
+
+// the total size of the files in the directory at dir in the mount
+//
+// the sizes of every directory under it are computed (and cached) at the
+// same time, so listing them afterwards doesn't walk them again
+func (s *server) dirSize(ctx context.Context, m *mount, dir string) (int64, error) {
+	key := mountPath(m, dir)
+	if size, ok := s.dirSizes.get(key); ok {
+		return size, nil
+	}
+	v, err := s.lookups.do(ctx, "dirsize\x00"+key, func(ctx context.Context) (interface{}, error) {
+		sizes := map[string]int64{key: 0}
+		err := walkFiles(ctx, m.src, dir, func(p string, d fs.DirEntry) error {
+			info, err := d.Info()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for parent := path.Dir(p); ; parent = path.Dir(parent) {
+				sizes[mountPath(m, parent)] += info.Size()
+				if parent == dir || parent == "." {
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.dirSizes.put(sizes)
+		return sizes[key], nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+// the mount and path of a directory in a -dirs-first listing of the roots,
+// by its name in the listing
+func listedDir(roots []indexRoot, name string) (*mount, string) {
+	for _, root := range roots {
+		if root.prefix == name+"/" {
+			return root.m, root.dir
+		}
+		if root.prefix == "" {
+			return root.m, path.Join(root.dir, name)
+		}
+	}
+	return nil, ""
+}
+
+// sets the size of each directory in the tree from /-/api/tree, the
+// root of which is in the mount
+func (s *server) treeSizes(ctx context.Context, m *mount, node *treeNode) error {
+	if node.Type != "dir" {
+		return nil
+	}
+	_, p := matchMount([]*mount{m}, node.Path)
+	if p == "" {
+		p = "."
+	}
+	size, err := s.dirSize(ctx, m, p)
+	if err != nil {
+		return err
+	}
+	node.Size = size
+	for _, child := range node.Children {
+		if err := s.treeSizes(ctx, m, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// the most output a dynamic file can respond with
+	maxDynamicOutput = 10 << 20
+	// how much of what a dynamic file writes to stderr is logged if it fails
+	maxDynamicStderr = 4 << 10
+)
+
+// returned when a dynamic file writes more than maxDynamicOutput
+var errDynamicOutput = fmt.Errorf("wrote more than %s", humanizeBytes(maxDynamicOutput))
+
+// files which are executed when they're requested, responding with what
+// they write to stdout instead of their contents, from -dynamic
+type dynamicFiles struct {
+	patterns []*regexp.Regexp
+	// how long they can run for, before they're killed
+	timeout time.Duration
+}
+
+// compiles each -dynamic pattern, matched against the path of a file
+// (starting with the mount name, with -mount), where * matches anything
+func newDynamicFiles(patterns multiFlag, timeout time.Duration) (*dynamicFiles, error) {
+	d := &dynamicFiles{timeout: timeout}
+	for _, pattern := range patterns {
+		compiled, err := compilePathPattern("dynamic", pattern)
+		if err != nil {
+			return nil, err
+		}
+		d.patterns = append(d.patterns, compiled)
+	}
+	return d, nil
+}
+
+// the path on disk of the file at p in the mount, if it matches a
+// -dynamic pattern, else an empty string. Only files in a local folder
+// (or a snapshot of one) can be executed, not files at a git ref
+func (s *server) dynamicPath(m *mount, p string) string {
+	if len(s.dynamic.patterns) == 0 || m.parent != nil {
+		return ""
+	}
+	src := m.src
+	if snap, ok := src.(*snapshotSource); ok {
+		src = snap.src
+	}
+	local, ok := src.(*localSource)
+	if !ok {
+		return ""
+	}
+	full := mountPath(m, p)
+	for _, pattern := range s.dynamic.patterns {
+		if pattern.MatchString(full) {
+			return local.fullPath(p)
+		}
+	}
+	return ""
+}
+
+// a buffer which fails writes past max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+	err error
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		if b.err != nil {
+			return 0, b.err
+		}
+		// stderr is only logged, keep the start of it
+		b.Buffer.Write(p[:b.max-b.Len()])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// exec copies the output with io.Copy, which would use the ReadFrom of the
+// bytes.Buffer instead of Write, without the limit
+func (b *limitedBuffer) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{b}, r)
+}
+
+// the environment a dynamic file is run with: only PATH from the
+// environment of the server, and the request, like a CGI script
+func dynamicEnv(r *http.Request, scriptName string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"REQUEST_METHOD=" + r.Method,
+		"REQUEST_URI=" + r.URL.RequestURI(),
+		"QUERY_STRING=" + r.URL.RawQuery,
+		"REMOTE_ADDR=" + clientIP(r),
+		"SCRIPT_NAME=" + scriptName,
+		"HTTP_HOST=" + r.Host,
+		"HTTP_USER_AGENT=" + r.UserAgent(),
+		"HTTP_REFERER=" + r.Referer(),
+		"HTTP_ACCEPT=" + r.Header.Get("Accept"),
+	}
+}
+
+// runs the dynamic file at full (the file p in the mount), and
+// responds with what it wrote to stdout
+//
+// if it exits with an error, takes longer than -dynamic-timeout or writes
+// more than maxDynamicOutput, responds with a 502/504 and logs its stderr
+func (s *server) serveDynamic(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, m *mount, p string, full string) {
+	defer timingsFrom(ctx).track("render")()
+	ctx, cancel := context.WithTimeout(ctx, s.dynamic.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, full)
+	cmd.Dir = filepath.Dir(full)
+	cmd.Env = dynamicEnv(r, mountPath(m, p))
+	// don't wait for processes it started, which still have stdout open
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{max: maxDynamicOutput, err: errDynamicOutput}
+	stderr := &limitedBuffer{max: maxDynamicStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+	if err != nil {
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			return
+		}
+		status, message := http.StatusBadGateway, fmt.Sprintf("Could not run %s", p)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status, message = http.StatusGatewayTimeout, fmt.Sprintf("%s took longer than %s", p, s.dynamic.timeout)
+		}
+		log.Printf("%s: %s\n%s", message, err, strings.TrimSpace(stderr.String()))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		render(&w, &PageInfo{
+			PageContents: message + "\n",
+			Title:        fmt.Sprintf("%d - %s", status, http.StatusText(status)),
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	w.Header().Set("X-Filepath", p)
+	w.Header().Set("Cache-Control", "no-store")
+	if !opts.isDark {
+		w.Header().Set("Content-Type", http.DetectContentType(stdout.Bytes()))
+		w.Write(stdout.Bytes())
+		return
+	}
+	render(&w, &PageInfo{
+		PageContents: stdout.String(),
+		Title:        p,
+		Breadcrumbs:  breadcrumbs(s.config.basePath, m, path.Dir(p)),
+	}, s.tmpl, opts.isDark)
+}
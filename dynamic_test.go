@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDynamic(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't installed")
+	}
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"env.cgi.sh":  "#!/bin/sh\nenv | sort\n",
+		"fail.cgi.sh": "#!/bin/sh\necho 'something went wrong' >&2\nexit 1\n",
+		"slow.cgi.sh": "#!/bin/sh\nexec sleep 5\n",
+		"big.cgi.sh":  "#!/bin/sh\nexec head -c 11000000 /dev/zero\n",
+		// executable, but doesn't match -dynamic
+		"run.sh": "#!/bin/sh\necho ran\n",
+	}
+	for name, contents := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasGit := false
+	if _, err := exec.LookPath("git"); err == nil {
+		hasGit = true
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"add", "-A"},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "scripts"},
+			{"tag", "v1.0"},
+		} {
+			if _, err := runGit(context.Background(), dir, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// not passed on to the scripts
+	t.Setenv("SUBPATH_SERVE_SECRET", "hunter2")
+	s := newTestServer(t, dir, []string{"-folder", "{dir}", "-dynamic", "*.cgi.sh", "-dynamic-timeout", "200ms", "-git-refs"})
+	for _, tt := range []struct {
+		target string
+		code   int
+		// what the body has to contain
+		contains string
+		git      bool
+	}{
+		{"/env.cgi.sh?a=b", 200, "QUERY_STRING=a=b\n", false},
+		{"/env.cgi.sh", 200, "PATH=" + os.Getenv("PATH") + "\n", false},
+		{"/fail.cgi.sh", 502, "Could not run fail.cgi.sh", false},
+		{"/slow.cgi.sh", 504, "slow.cgi.sh took longer than 200ms", false},
+		{"/big.cgi.sh", 502, "Could not run big.cgi.sh", false},
+		// served, not executed
+		{"/run.sh", 200, scripts["run.sh"], false},
+		{"/@v1.0/env.cgi.sh", 200, scripts["env.cgi.sh"], true},
+	} {
+		if tt.git && !hasGit {
+			continue
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		body := w.Body.String()
+		if w.Code != tt.code || !strings.Contains(body, tt.contains) {
+			t.Errorf("GET %s = %d %.200q, expected %d containing %q", tt.target, w.Code, body, tt.code, tt.contains)
+		}
+		if strings.Contains(body, "SUBPATH_SERVE_SECRET") {
+			t.Errorf("GET %s: the environment of the server was passed on: %.200q", tt.target, body)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+// returned (possibly wrapped) when resolving a request, so it's
+// responded to with the same status code wherever it happens
+var (
+	// nothing matched the query
+	ErrNotFound = errors.New("not found")
+	// the path is (or is in) a directory from ignorePaths, or a
+	// mount file like .moved
+	ErrIgnored = errors.New("ignored path")
+	// the file is larger than -max-file-size
+	ErrTooLarge = errors.New("file too large")
+)
+
+// returned from a walk func to stop walking once it found what it was
+// looking for. Never returned by the walk functions in find.go
+var errStopWalk = errors.New("stop walking")
+
+// the status code a request which failed with err is responded to with
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrIgnored), errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, errPrivate):
+		return http.StatusUnauthorized
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errFileChanged), errors.Is(err, errBusy), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// with -debug, 500 pages include the error chain and a stack trace. Set
+// in main, before the server starts
+var debugErrors bool
+
+// identifies a request in the X-Request-Id header, and in the log
+// if it fails with a 500, so an error page can be matched to its log line
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// err, and each error it wraps
+func errorChain(err error) []string {
+	chain := []string{}
+	for err != nil {
+		chain = append(chain, err.Error())
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				chain = append(chain, errorChain(e)...)
+			}
+			break
+		}
+		err = errors.Unwrap(err)
+	}
+	return chain
+}
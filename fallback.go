@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// how a request which doesn't match anything is sent to -fallback-url
+//
+// proxy responds with the response from the other instance, redirect
+// checks the other instance has it (with a HEAD request) and redirects there
+var fallbackModes = [...]string{"proxy", "redirect"}
+
+// sent with requests to -fallback-url, so two instances which fall back
+// to each other don't keep sending a request back and forth
+const fallbackHeader = "X-Subpath-Serve-Fallback"
+
+// headers from the response of the other instance which are passed on
+var fallbackResponseHeaders = [...]string{
+	"Content-Type",
+	"Last-Modified",
+	"Link",
+	"Retry-After",
+	"X-Filepath",
+	"X-Symlink-Target",
+	"X-Total-Lines",
+}
+
+var fallbackClient = &http.Client{Timeout: 30 * time.Second}
+
+// if -fallback-url is set, tries the request against that instance, and if
+// it doesn't respond with a 404, proxies or redirects to its response.
+// Returns false if the request should be responded to with a 404
+func (s *server) serveFallback(ctx context.Context, w http.ResponseWriter, r *http.Request, reqPath string) bool {
+	if s.config.fallbackURL == "" || r.Header.Get(fallbackHeader) != "" {
+		return false
+	}
+	target := s.config.fallbackURL + (&url.URL{Path: "/" + reqPath}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	method := r.Method
+	if s.config.fallbackMode == "redirect" {
+		method = http.MethodHead
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		log.Printf("Could not request %s from -fallback-url: %s\n", target, err)
+		return false
+	}
+	req.Header.Set(fallbackHeader, "1")
+	for _, name := range [...]string{"Accept", "If-Modified-Since", "User-Agent"} {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := fallbackClient.Do(req)
+	if err != nil {
+		log.Printf("Could not request %s from -fallback-url: %s\n", target, err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false
+	}
+	if s.config.fallbackMode == "redirect" {
+		http.Redirect(w, r, target, http.StatusFound)
+		return true
+	}
+	for _, name := range fallbackResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set("X-Fallback", strings.TrimSuffix(s.config.fallbackURL, "/"))
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Error while proxying %s from -fallback-url: %s\n", target, err)
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestFallback(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+		if r.URL.Path == "/missing.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Filepath", r.URL.Path)
+		w.Write([]byte("from upstream"))
+	}))
+	t.Cleanup(upstream.Close)
+	dir := fixtureFolder(t)
+	for _, mode := range fallbackModes {
+		s := newTestServer(t, dir, []string{"-folder", "{dir}", "-fallback-url", upstream.URL, "-fallback-mode", mode})
+		for _, tt := range []struct {
+			target string
+			loop   bool
+			code   int
+			// the path requested from the other instance, empty if it isn't
+			upstream string
+		}{
+			// the ? is part of the path, not the start of a query
+			{"/notes/a%3Fb%20c.txt", false, 200, "/notes/a?b c.txt"},
+			{"/missing.txt", false, 404, "/missing.txt"},
+			// from another instance falling back to this one
+			{"/notes/a.txt", true, 404, ""},
+			// matches, so doesn't fall back
+			{"/bashrc", false, 200, ""},
+		} {
+			requests = nil
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.loop {
+				r.Header.Set(fallbackHeader, "1")
+			}
+			s.ServeHTTP(w, r)
+			code := w.Code
+			if mode == "redirect" && code == http.StatusFound {
+				if location := w.Header().Get("Location"); location != upstream.URL+tt.target {
+					t.Errorf("%s: GET %s redirected to %s, expected %s", mode, tt.target, location, upstream.URL+tt.target)
+				}
+				code = 200
+			}
+			if mode == "proxy" && tt.upstream != "" && code == 200 && (w.Body.String() != "from upstream" || w.Header().Get("X-Fallback") != upstream.URL) {
+				t.Errorf("%s: GET %s = %q (X-Fallback: %q), expected the response of the other instance", mode, tt.target, w.Body.String(), w.Header().Get("X-Fallback"))
+			}
+			if code != tt.code {
+				t.Errorf("%s: GET %s = %d, expected %d", mode, tt.target, w.Code, tt.code)
+			}
+			if tt.upstream == "" {
+				if len(requests) != 0 {
+					t.Errorf("%s: GET %s fell back to the other instance", mode, tt.target)
+				}
+				continue
+			}
+			if len(requests) != 1 || requests[0].URL.Path != tt.upstream || requests[0].Header.Get(fallbackHeader) == "" {
+				t.Errorf("%s: GET %s didn't request %s from the other instance with the %s header", mode, tt.target, tt.upstream, fallbackHeader)
+				continue
+			}
+			// redirect checks the other instance has it first
+			if expected := map[string]string{"proxy": "GET", "redirect": "HEAD"}[mode]; requests[0].Method != expected {
+				t.Errorf("%s: requested %s with %s, expected %s", mode, tt.target, requests[0].Method, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// whether the path is one of the files in the root of a mount which
+// configure it (.moved, .tombstones), which aren't served or listed
+func isMountFile(p string) bool {
+	return p == movedFile || p == tombstonesFile || p == mimeTypesFile
+}
+
+// whether or not the file/directory at path (relative to the root of
+// the mount) is ignored by the ignore rules, see ignoreRules
+func isIgnored(path string, isDir bool) bool {
+	return ignores.Load().matches(path, isDir)
+}
+
+// returns ErrIgnored if the file/directory p (relative to the root of a
+// mount) is a mount file, is ignored or is in an ignored directory, so it
+// can't be served even if it's requested by its exact path
+func checkIgnored(p string, isDir bool) error {
+	if isMountFile(p) || isIgnored(p, isDir) {
+		return ErrIgnored
+	}
+	for i, c := range p {
+		if c == '/' && isIgnored(p[:i], true) {
+			return ErrIgnored
+		}
+	}
+	return nil
+}
+
+// walks dir in src, calling fn for each file/directory under it
+// which isn't ignored (or a mount file, like .moved). path
+// is relative to the root of src
+//
+// directories which can't be read (because of permissions) are skipped
+//
+// stops walking (and returns the context error) if ctx is cancelled
+func walkEntries(ctx context.Context, src source, dir string, fn func(path string, d fs.DirEntry) error) error {
+	return src.walk(ctx, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		// if the path is ignored, skip the directory
+		// (SkipDir on a file would skip the rest of its directory)
+		if isIgnored(path, d.IsDir()) {
+			if !d.IsDir() {
+				return nil
+			}
+			return fs.SkipDir
+		}
+		if isMountFile(path) {
+			return nil
+		}
+		return fn(path, d)
+	})
+}
+
+// like walkEntries, but only calls fn for regular files
+func walkFiles(ctx context.Context, src source, dir string, fn func(path string, d fs.DirEntry) error) error {
+	return walkEntries(ctx, src, dir, func(path string, d fs.DirEntry) error {
+		if d.Type().IsRegular() {
+			return fn(path, d)
+		}
+		return nil
+	})
+}
+
+// whether or not query matches the path, i.e. the query is a suffix
+// of the path, and the last part of the query is the entire name
+func matchesQuery(path string, name string, query string) bool {
+	return strings.HasSuffix(path, query) &&
+		query[strings.LastIndex(query, "/")+1:] == name
+}
+
+// path relative to dir, which path is inside of
+func relativeTo(dir string, path string) string {
+	if dir == "." {
+		return path
+	}
+	return strings.TrimPrefix(path, dir+"/")
+}
+
+// calls fn with each line of the index for a directory, a path to each file
+// (and the path of the file, relative to the root of src)
+//
+// paths are relative to dir, and prefix is prepended to each line
+//
+// if search isn't nil, only includes files where it matches the path or
+// the contents of the file. Binary files, and files
+// canRead returns false for are only matched by path
+func listFiles(ctx context.Context, src source, dir string, prefix string, search *regexp.Regexp, canRead func(path string) bool, fn func(line string, path string) error) error {
+	return walkFiles(ctx, src, dir, func(path string, d fs.DirEntry) error {
+		rel := relativeTo(dir, path)
+		if search != nil && !search.MatchString(rel) {
+			if !canRead(path) {
+				return nil
+			}
+			data, err := readFile(ctx, src, path)
+			// files which can't be read can only be matched by path
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if isBinary(data) || !search.Match(data) {
+				return nil
+			}
+		}
+		return fn(prefix+rel, path)
+	})
+}
+
+// returns the path of the file matching query, relative to the root
+// of src, or ErrNotFound if no file matches it
+//
+// other errors signify an application error (should be converted to 500)
+func find(ctx context.Context, src source, query string) (string, error) {
+	var foundPath string
+	err := walkFiles(ctx, src, ".", func(path string, d fs.DirEntry) error {
+		// the query matches this path
+		if matchesQuery(path, d.Name(), query) {
+			foundPath = path
+			// stop walking once we find the file
+			return errStopWalk
+		}
+		return nil
+	})
+	if errors.Is(err, errStopWalk) {
+		return foundPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", ErrNotFound
+}
+
+// like find, for each query, but walks src once
+//
+// returns the path each query matched, queries which didn't match aren't included
+func findAll(ctx context.Context, src source, queries []string) (map[string]string, error) {
+	found := make(map[string]string)
+	pending := make(map[string]bool)
+	for _, query := range queries {
+		pending[query] = true
+	}
+	err := walkFiles(ctx, src, ".", func(path string, d fs.DirEntry) error {
+		for query := range pending {
+			if matchesQuery(path, d.Name(), query) {
+				found[query] = path
+				delete(pending, query)
+			}
+		}
+		if len(pending) == 0 {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	return found, nil
+}
+
+// like find, but matches directories instead of files
+func findDir(ctx context.Context, src source, query string) (string, error) {
+	var foundPath string
+	err := walkEntries(ctx, src, ".", func(path string, d fs.DirEntry) error {
+		if d.IsDir() && matchesQuery(path, d.Name(), query) {
+			foundPath = path
+			return errStopWalk
+		}
+		return nil
+	})
+	if errors.Is(err, errStopWalk) {
+		return foundPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", ErrNotFound
+}
+
+// guesses whether data is binary by checking for a NUL byte near the start
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMatchesQuery(t *testing.T) {
+	for _, tt := range []struct {
+		path  string
+		query string
+		ok    bool
+	}{
+		{"nvim/init.lua", "init.lua", true},
+		{"nvim/init.lua", "nvim/init.lua", true},
+		{"nvim/init.lua", "it.lua", false},
+		{"nvim/init.lua", "init", false},
+		{"nvim/lua/plugins.lua", "lua/plugins.lua", true},
+		{"bashrc", "bashrc", true},
+	} {
+		if ok := matchesQuery(tt.path, path.Base(tt.path), tt.query); ok != tt.ok {
+			t.Errorf("matchesQuery(%q, %q) = %v, expected %v", tt.path, tt.query, ok, tt.ok)
+		}
+	}
+}
+
+func FuzzMatchesQuery(f *testing.F) {
+	f.Add("nvim/init.lua", "init.lua")
+	f.Add("nvim/lua/plugins.lua", "lua/plugins.lua")
+	f.Add("bashrc", "rc")
+	f.Add("a/b", "")
+	f.Fuzz(func(t *testing.T, p string, query string) {
+		p = cleanRequestPath(p)
+		if p == "" || strings.HasSuffix(p, "/") {
+			return
+		}
+		name := path.Base(p)
+		if matchesQuery(p, name, query) {
+			if !strings.HasSuffix(p, query) || path.Base("/"+query) != name {
+				t.Fatalf("matchesQuery(%q, %q) matched, but it isn't a suffix ending with the name", p, query)
+			}
+		}
+		// the path, and every part of it starting at a directory, matches it
+		for i := 0; i < len(p); i++ {
+			if (i == 0 || p[i-1] == '/') && !matchesQuery(p, name, p[i:]) {
+				t.Fatalf("matchesQuery(%q, %q) didn't match", p, p[i:])
+			}
+		}
+	})
+}
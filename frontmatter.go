@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extensions of files which are checked for frontmatter
+var frontmatterExts = [...]string{".md", ".markdown", ".mdx", ".txt"}
+
+// FrontmatterField is one top-level key in a files frontmatter
+// Value includes any nested/continuation lines after the key
+type FrontmatterField struct {
+	Key   string
+	Value string
+}
+
+func hasFrontmatterExt(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, fext := range frontmatterExts {
+		if ext == fext {
+			return true
+		}
+	}
+	return false
+}
+
+// splits YAML (---) or TOML (+++) frontmatter from the start of contents
+//
+// returns the frontmatter (without the delimiters) and the rest of the file,
+// if there is no frontmatter, returns "" and the original contents
+func splitFrontmatter(contents string) (string, string) {
+	firstLine := strings.IndexByte(contents, '\n')
+	if firstLine == -1 {
+		return "", contents
+	}
+	delim := strings.TrimRight(contents[:firstLine], " \t\r")
+	if delim != "---" && delim != "+++" {
+		return "", contents
+	}
+	// look for the closing delimiter at the start of a line
+	offset := firstLine + 1
+	for offset < len(contents) {
+		line := contents[offset:]
+		end := strings.IndexByte(line, '\n')
+		if end != -1 {
+			line = line[:end]
+		}
+		if strings.TrimRight(line, " \t\r") == delim {
+			if end == -1 {
+				return contents[firstLine+1 : offset], ""
+			}
+			return contents[firstLine+1 : offset], contents[offset+end+1:]
+		}
+		if end == -1 {
+			break
+		}
+		offset += end + 1
+	}
+	// no closing delimiter, not frontmatter
+	return "", contents
+}
+
+// parses frontmatter into a list of fields to display in the HTML view
+//
+// this doesn't try to fully parse YAML/TOML, it splits top-level lines on
+// the first ':' or '=' and attaches indented lines/list items to the previous key
+func parseFrontmatter(frontmatter string) []FrontmatterField {
+	fields := []FrontmatterField{}
+	for _, line := range strings.Split(strings.TrimRight(frontmatter, "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		continuation := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "- ")
+		sep := strings.IndexAny(line, ":=")
+		if !continuation && sep > 0 {
+			fields = append(fields, FrontmatterField{
+				Key:   strings.TrimSpace(line[:sep]),
+				Value: strings.Trim(strings.TrimSpace(line[sep+1:]), "\"'"),
+			})
+			continue
+		}
+		// a line we can't split, add it to the previous value
+		// (or as a field without a key, if its the first line)
+		if len(fields) == 0 {
+			fields = append(fields, FrontmatterField{Value: strings.TrimSpace(line)})
+			continue
+		}
+		last := &fields[len(fields)-1]
+		if last.Value != "" {
+			last.Value += "\n"
+		}
+		last.Value += strings.TrimSpace(line)
+	}
+	return fields
+}
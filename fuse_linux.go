@@ -0,0 +1,496 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// the FUSE protocol (see linux/fuse.h), only what a read-only filesystem
+// needs. Requests start with a 40 byte header, replies with a 16 byte one
+const (
+	fuseInHeaderSize  = 40
+	fuseOutHeaderSize = 16
+	// the oldest minor version of the protocol which is supported, the
+	// sizes of the replies below are the ones since 7.23 (linux 3.15)
+	fuseMinMinor = 23
+	fuseMinor    = 31
+	fuseMaxWrite = 128 * 1024
+	fuseRootID   = 1
+
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+
+	fuseAsyncRead = 1 << 0
+)
+
+// operations which would change the filesystem, they fail with EROFS
+// instead of ENOSYS (setattr, symlink, mknod, mkdir, unlink, rmdir, rename,
+// link, write, setxattr, removexattr, create, fallocate, rename2)
+var fuseWriteOps = map[uint32]bool{4: true, 6: true, 8: true, 9: true, 10: true, 11: true, 12: true, 13: true, 16: true, 21: true, 24: true, 35: true, 43: true, 45: true}
+
+type fuseRequest struct {
+	opcode uint32
+	unique uint64
+	nodeid uint64
+	body   []byte
+}
+
+// serves the files of another instance over /dev/fuse
+type fuseServer struct {
+	fd     int
+	remote *remoteFiles
+	// how long the kernel caches names and attributes
+	valid    time.Duration
+	uid, gid uint32
+
+	mu sync.Mutex
+	// inodes are given out as paths are looked up, and kept until it's
+	// unmounted, so a path has the same inode after the files are listed again
+	inodes    map[string]uint64
+	paths     map[uint64]string
+	nextInode uint64
+	// open directories, by their handle. They're listed as they were when
+	// they were opened, so the offsets of entries don't change while reading
+	dirs       map[uint64]*memNode
+	nextHandle uint64
+}
+
+// mounts the files of remote at mountpoint, and serves them until it's
+// unmounted (or interrupted, which unmounts it)
+func serveFUSE(mountpoint string, remote *remoteFiles, valid time.Duration) error {
+	fd, unmount, err := mountFUSE(mountpoint)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Unmounting %s\n", mountpoint)
+		if err := unmount(); err != nil {
+			log.Printf("Could not unmount %s: %s\n", mountpoint, err)
+			os.Exit(1)
+		}
+	}()
+	s := &fuseServer{
+		fd:        fd,
+		remote:    remote,
+		valid:     valid,
+		uid:       uint32(os.Getuid()),
+		gid:       uint32(os.Getgid()),
+		inodes:    map[string]uint64{".": fuseRootID},
+		paths:     map[uint64]string{fuseRootID: "."},
+		nextInode: fuseRootID,
+		dirs:      map[uint64]*memNode{},
+	}
+	defer unix.Close(fd)
+	return s.serve()
+}
+
+// opens /dev/fuse and mounts it at mountpoint, directly when running as
+// root, else with fusermount (which is setuid root), which sends back the
+// file descriptor over a socket
+func mountFUSE(mountpoint string) (int, func() error, error) {
+	if os.Geteuid() == 0 {
+		fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, nil, fmt.Errorf("could not open /dev/fuse: %w", err)
+		}
+		options := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions", fd, os.Getuid(), os.Getgid())
+		if err := unix.Mount("subpath-serve", mountpoint, "fuse.subpath-serve", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_RDONLY, options); err != nil {
+			unix.Close(fd)
+			return -1, nil, fmt.Errorf("could not mount %s: %w", mountpoint, err)
+		}
+		return fd, func() error { return unix.Unmount(mountpoint, unix.MNT_DETACH) }, nil
+	}
+	fusermount, err := exec.LookPath("fusermount3")
+	if err != nil {
+		if fusermount, err = exec.LookPath("fusermount"); err != nil {
+			return -1, nil, errors.New("fusermount3 (or fusermount) is needed to mount without running as root, install fuse3")
+		}
+	}
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	local, remote := os.NewFile(uintptr(pair[0]), "fusermount"), os.NewFile(uintptr(pair[1]), "fusermount")
+	defer local.Close()
+	var stderr bytes.Buffer
+	cmd := exec.Command(fusermount, "-o", "ro,nosuid,nodev,default_permissions,fsname=subpath-serve,subtype=subpath-serve", "--", mountpoint)
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	remote.Close()
+	if err != nil {
+		return -1, nil, err
+	}
+	fd, recvErr := receiveFD(int(local.Fd()))
+	if err := cmd.Wait(); err != nil {
+		return -1, nil, fmt.Errorf("could not mount %s: %w: %s", mountpoint, err, strings.TrimSpace(stderr.String()))
+	}
+	if recvErr != nil {
+		return -1, nil, fmt.Errorf("could not mount %s: %w", mountpoint, recvErr)
+	}
+	return fd, func() error { return exec.Command(fusermount, "-u", "-z", "--", mountpoint).Run() }, nil
+}
+
+// the file descriptor fusermount sends over the socket
+func receiveFD(socket int) (int, error) {
+	buf, oob := make([]byte, 1), make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(socket, buf, oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return -1, errors.New("fusermount didn't send a file descriptor")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) == 0 {
+		return -1, errors.New("fusermount didn't send a file descriptor")
+	}
+	return fds[0], nil
+}
+
+// reads requests until it's unmounted, handling each one concurrently
+func (s *fuseServer) serve() error {
+	buf := make([]byte, fuseMaxWrite+4096)
+	for {
+		n, err := unix.Read(s.fd, buf)
+		switch {
+		case errors.Is(err, unix.EINTR), errors.Is(err, unix.EAGAIN):
+			continue
+		// the request was interrupted before it was read
+		case errors.Is(err, unix.ENOENT):
+			continue
+		// unmounted
+		case errors.Is(err, unix.ENODEV):
+			return nil
+		case err != nil:
+			return fmt.Errorf("could not read from /dev/fuse: %w", err)
+		}
+		if n < fuseInHeaderSize {
+			continue
+		}
+		req := &fuseRequest{
+			opcode: binary.NativeEndian.Uint32(buf[4:]),
+			unique: binary.NativeEndian.Uint64(buf[8:]),
+			nodeid: binary.NativeEndian.Uint64(buf[16:]),
+			body:   bytes.Clone(buf[fuseInHeaderSize:n]),
+		}
+		switch req.opcode {
+		// these don't have replies
+		case fuseForget, fuseBatchForget, fuseInterrupt:
+			continue
+		case fuseInit:
+			// replied to before reading anything else
+			payload, errno := s.init(req)
+			s.reply(req, payload, errno)
+			continue
+		}
+		go func() {
+			payload, errno := s.handle(req)
+			s.reply(req, payload, errno)
+		}()
+	}
+}
+
+// writes the reply to req, an error if errno isn't 0
+func (s *fuseServer) reply(req *fuseRequest, payload []byte, errno unix.Errno) {
+	if errno != 0 {
+		payload = nil
+	}
+	out := make([]byte, 0, fuseOutHeaderSize+len(payload))
+	out = binary.NativeEndian.AppendUint32(out, uint32(fuseOutHeaderSize+len(payload)))
+	out = binary.NativeEndian.AppendUint32(out, uint32(-int32(errno)))
+	out = binary.NativeEndian.AppendUint64(out, req.unique)
+	out = append(out, payload...)
+	// ENOENT means the request was interrupted, nothing's waiting for it
+	if _, err := unix.Write(s.fd, out); err != nil && !errors.Is(err, unix.ENOENT) {
+		log.Printf("Could not reply to a FUSE request: %s\n", err)
+	}
+}
+
+func (s *fuseServer) init(req *fuseRequest) ([]byte, unix.Errno) {
+	if len(req.body) < 16 {
+		return nil, unix.EINVAL
+	}
+	major, minor := binary.NativeEndian.Uint32(req.body), binary.NativeEndian.Uint32(req.body[4:])
+	maxReadahead, flags := binary.NativeEndian.Uint32(req.body[8:]), binary.NativeEndian.Uint32(req.body[12:])
+	if major != 7 || minor < fuseMinMinor {
+		log.Printf("Warning: The kernel uses version %d.%d of the FUSE protocol, 7.%d or later is needed\n", major, minor, fuseMinMinor)
+		return nil, unix.EPROTO
+	}
+	out := make([]byte, 0, 64)
+	out = binary.NativeEndian.AppendUint32(out, 7)
+	out = binary.NativeEndian.AppendUint32(out, min(minor, fuseMinor))
+	out = binary.NativeEndian.AppendUint32(out, maxReadahead)
+	out = binary.NativeEndian.AppendUint32(out, flags&fuseAsyncRead)
+	// max_background, congestion_threshold
+	out = binary.NativeEndian.AppendUint16(out, 16)
+	out = binary.NativeEndian.AppendUint16(out, 12)
+	out = binary.NativeEndian.AppendUint32(out, fuseMaxWrite)
+	// time_gran, then the fields which aren't used
+	out = binary.NativeEndian.AppendUint32(out, 1)
+	return append(out, make([]byte, 64-len(out))...), 0
+}
+
+func (s *fuseServer) handle(req *fuseRequest) ([]byte, unix.Errno) {
+	if fuseWriteOps[req.opcode] {
+		return nil, unix.EROFS
+	}
+	tree, listedAt := s.remote.current()
+	switch req.opcode {
+	case fuseLookup:
+		parent, ok := s.path(req.nodeid)
+		name, _, _ := bytes.Cut(req.body, []byte{0})
+		if !ok || len(name) == 0 || string(name) == "." || string(name) == ".." {
+			return nil, unix.ENOENT
+		}
+		p := path.Join(parent, string(name))
+		node := tree.lookup(p)
+		if node == nil {
+			return nil, unix.ENOENT
+		}
+		ino := s.inode(p)
+		sec, nsec := s.validFor()
+		out := make([]byte, 0, 128)
+		out = binary.NativeEndian.AppendUint64(out, ino)
+		// generation, entry_valid, attr_valid
+		out = binary.NativeEndian.AppendUint64(out, 0)
+		out = binary.NativeEndian.AppendUint64(out, sec)
+		out = binary.NativeEndian.AppendUint64(out, sec)
+		out = binary.NativeEndian.AppendUint32(out, nsec)
+		out = binary.NativeEndian.AppendUint32(out, nsec)
+		return s.appendAttr(out, ino, node, listedAt), 0
+	case fuseGetattr:
+		node, errno := s.node(tree, req.nodeid)
+		if errno != 0 {
+			return nil, errno
+		}
+		sec, nsec := s.validFor()
+		out := make([]byte, 0, 104)
+		out = binary.NativeEndian.AppendUint64(out, sec)
+		out = binary.NativeEndian.AppendUint32(out, nsec)
+		out = binary.NativeEndian.AppendUint32(out, 0)
+		return s.appendAttr(out, req.nodeid, node, listedAt), 0
+	case fuseOpen:
+		node, errno := s.node(tree, req.nodeid)
+		if errno != 0 {
+			return nil, errno
+		}
+		if node.isDir {
+			return nil, unix.EISDIR
+		}
+		if len(req.body) < 4 || binary.NativeEndian.Uint32(req.body)&unix.O_ACCMODE != unix.O_RDONLY {
+			return nil, unix.EROFS
+		}
+		return s.openOut(0), 0
+	case fuseRead:
+		if len(req.body) < 20 {
+			return nil, unix.EINVAL
+		}
+		p, ok := s.path(req.nodeid)
+		if !ok {
+			return nil, unix.ENOENT
+		}
+		offset, size := binary.NativeEndian.Uint64(req.body[8:]), binary.NativeEndian.Uint32(req.body[16:])
+		data, err := s.remote.read(context.Background(), p, int64(offset), int(size))
+		if err != nil {
+			return nil, fuseErrno(p, err)
+		}
+		return data, 0
+	case fuseOpendir:
+		node, errno := s.node(tree, req.nodeid)
+		if errno != 0 {
+			return nil, errno
+		}
+		if !node.isDir {
+			return nil, unix.ENOTDIR
+		}
+		s.mu.Lock()
+		s.nextHandle++
+		handle := s.nextHandle
+		s.dirs[handle] = node
+		s.mu.Unlock()
+		return s.openOut(handle), 0
+	case fuseReaddir:
+		if len(req.body) < 20 {
+			return nil, unix.EINVAL
+		}
+		handle, offset := binary.NativeEndian.Uint64(req.body), binary.NativeEndian.Uint64(req.body[8:])
+		size := int(binary.NativeEndian.Uint32(req.body[16:]))
+		dir, ok := s.handleDir(handle)
+		parent, found := s.path(req.nodeid)
+		if !ok || !found {
+			return nil, unix.EBADF
+		}
+		out := []byte{}
+		for i := int(offset); i < len(dir.children); i++ {
+			child := dir.children[i]
+			entry := appendDirent(nil, s.inode(path.Join(parent, child.name)), uint64(i+1), child)
+			if len(out)+len(entry) > size {
+				break
+			}
+			out = append(out, entry...)
+		}
+		return out, 0
+	case fuseReleasedir:
+		if len(req.body) >= 8 {
+			s.mu.Lock()
+			delete(s.dirs, binary.NativeEndian.Uint64(req.body))
+			s.mu.Unlock()
+		}
+		return nil, 0
+	case fuseRelease, fuseFlush, fuseDestroy:
+		return nil, 0
+	case fuseStatfs:
+		out := make([]byte, 0, 80)
+		// blocks, bfree, bavail, files, ffree
+		out = binary.NativeEndian.AppendUint64(out, 0)
+		out = binary.NativeEndian.AppendUint64(out, 0)
+		out = binary.NativeEndian.AppendUint64(out, 0)
+		out = binary.NativeEndian.AppendUint64(out, uint64(tree.fileCount()))
+		out = binary.NativeEndian.AppendUint64(out, 0)
+		// bsize, namelen, frsize
+		out = binary.NativeEndian.AppendUint32(out, 4096)
+		out = binary.NativeEndian.AppendUint32(out, 255)
+		out = binary.NativeEndian.AppendUint32(out, 4096)
+		return append(out, make([]byte, 80-len(out))...), 0
+	}
+	return nil, unix.ENOSYS
+}
+
+// the node with the inode in the current listing, ENOENT if it was removed
+func (s *fuseServer) node(tree *memTree, ino uint64) (*memNode, unix.Errno) {
+	p, ok := s.path(ino)
+	if !ok {
+		return nil, unix.ENOENT
+	}
+	node := tree.lookup(p)
+	if node == nil {
+		return nil, unix.ENOENT
+	}
+	return node, 0
+}
+
+func (s *fuseServer) path(ino uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.paths[ino]
+	return p, ok
+}
+
+func (s *fuseServer) inode(p string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ino, ok := s.inodes[p]; ok {
+		return ino
+	}
+	s.nextInode++
+	s.inodes[p], s.paths[s.nextInode] = s.nextInode, p
+	return s.nextInode
+}
+
+func (s *fuseServer) handleDir(handle uint64) (*memNode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, ok := s.dirs[handle]
+	return dir, ok
+}
+
+// the -refresh interval, as the seconds and nanoseconds replies use
+func (s *fuseServer) validFor() (uint64, uint32) {
+	return uint64(s.valid / time.Second), uint32(s.valid % time.Second)
+}
+
+func (s *fuseServer) openOut(handle uint64) []byte {
+	out := binary.NativeEndian.AppendUint64(make([]byte, 0, 16), handle)
+	return append(out, make([]byte, 8)...)
+}
+
+// appends the attributes of node. Everything is read-only and owned by
+// whoever mounted it, directories (which don't have a modification time
+// in the index) were modified when the files were listed
+func (s *fuseServer) appendAttr(out []byte, ino uint64, node *memNode, listedAt time.Time) []byte {
+	mode, nlink, modTime := uint32(unix.S_IFREG|0o444), uint32(1), node.modTime
+	if node.isDir {
+		mode, nlink, modTime = unix.S_IFDIR|0o555, 2, listedAt
+	}
+	out = binary.NativeEndian.AppendUint64(out, ino)
+	out = binary.NativeEndian.AppendUint64(out, uint64(node.size))
+	out = binary.NativeEndian.AppendUint64(out, uint64(node.size+511)/512)
+	// atime, mtime, ctime, then their nanoseconds
+	for range 3 {
+		out = binary.NativeEndian.AppendUint64(out, uint64(modTime.Unix()))
+	}
+	for range 3 {
+		out = binary.NativeEndian.AppendUint32(out, uint32(modTime.Nanosecond()))
+	}
+	out = binary.NativeEndian.AppendUint32(out, mode)
+	out = binary.NativeEndian.AppendUint32(out, nlink)
+	out = binary.NativeEndian.AppendUint32(out, s.uid)
+	out = binary.NativeEndian.AppendUint32(out, s.gid)
+	// rdev, blksize, flags
+	out = binary.NativeEndian.AppendUint32(out, 0)
+	out = binary.NativeEndian.AppendUint32(out, 4096)
+	return binary.NativeEndian.AppendUint32(out, 0)
+}
+
+// appends a directory entry, padded to 8 bytes. off is the offset the
+// next readdir starts at after it
+func appendDirent(out []byte, ino uint64, off uint64, node *memNode) []byte {
+	typ := uint32(unix.DT_REG)
+	if node.isDir {
+		typ = unix.DT_DIR
+	}
+	out = binary.NativeEndian.AppendUint64(out, ino)
+	out = binary.NativeEndian.AppendUint64(out, off)
+	out = binary.NativeEndian.AppendUint32(out, uint32(len(node.name)))
+	out = binary.NativeEndian.AppendUint32(out, typ)
+	out = append(out, node.name...)
+	return append(out, make([]byte, (8-len(out)%8)%8)...)
+}
+
+// the errno a read fails with
+func fuseErrno(p string, err error) unix.Errno {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return unix.ENOENT
+	case errors.Is(err, fs.ErrPermission):
+		return unix.EACCES
+	}
+	log.Printf("Could not read %s: %s\n", p, err)
+	return unix.EIO
+}
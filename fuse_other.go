@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// 'subpath-serve mount' is only supported on linux, where it talks to
+// /dev/fuse directly
+func serveFUSE(mountpoint string, remote *remoteFiles, valid time.Duration) error {
+	return errors.New("FUSE mounts are only supported on linux")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the files served by another instance, for 'subpath-serve mount'. They're
+// listed with /-/api/index, and read with range requests to /-/raw/
+type remoteFiles struct {
+	// URL of the instance (including its -base-path), without a trailing /.
+	// Can have a password, so it's only logged with String()
+	url    *url.URL
+	client *http.Client
+
+	mu       sync.Mutex
+	tree     *memTree
+	listedAt time.Time
+}
+
+func newRemoteFiles(instance string) (*remoteFiles, error) {
+	u, err := url.Parse(instance)
+	// the error from url.Parse includes the whole URL, password and all
+	if err != nil {
+		return nil, errors.New("invalid -url, expected the URL of a subpath-serve instance, e.g. https://sean.fish/d")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -url '%s', expected the URL of a subpath-serve instance, e.g. https://sean.fish/d", u.Redacted())
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return &remoteFiles{url: u, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// the URL of the instance, with the password replaced by xxxxx
+func (r *remoteFiles) String() string {
+	return r.url.Redacted()
+}
+
+// requests the path (and query) on the instance. Credentials in the URL
+// (user:password@) are sent with basic auth
+func (r *remoteFiles) get(ctx context.Context, p string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url.String()+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", "subpath-serve mount")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fs.ErrPermission
+	}
+	return nil, fmt.Errorf("GET %s%s responded with %s", r, p, resp.Status)
+}
+
+// lists every file on the instance, a page of /-/api/index at a time
+func (r *remoteFiles) list(ctx context.Context) (*memTree, error) {
+	files := []memFile{}
+	cursor := ""
+	for {
+		query := url.Values{"limit": {fmt.Sprint(maxIndexLimit)}, "fields": {"path,size,mod_time"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		resp, err := r.get(ctx, "/-/api/index?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Files []struct {
+				Path    string    `json:"path"`
+				Size    int64     `json:"size"`
+				ModTime time.Time `json:"mod_time"`
+			} `json:"files"`
+			NextCursor string `json:"next_cursor"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the files from %s: %w", r, err)
+		}
+		for _, file := range page.Files {
+			// read-only, the permissions on the instance aren't in the index
+			files = append(files, memFile{path: file.Path, size: file.Size, modTime: file.ModTime, perm: 0o444})
+		}
+		if page.NextCursor == "" {
+			return newMemTree(files), nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// the listing of the files, and when it was fetched
+func (r *remoteFiles) current() (*memTree, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tree, r.listedAt
+}
+
+// lists the files again, keeps the last listing if it fails
+func (r *remoteFiles) refresh(ctx context.Context) error {
+	tree, err := r.list(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.tree, r.listedAt = tree, time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *remoteFiles) refreshEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := r.refresh(context.Background()); err != nil {
+			log.Printf("Could not list the files of %s: %s\n", r, err)
+		}
+	}
+}
+
+// reads up to size bytes of the file at p from offset, fewer at the end
+// of the file
+func (r *remoteFiles) read(ctx context.Context, p string, offset int64, size int) ([]byte, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+int64(size)-1)}}
+	resp, err := r.get(ctx, (&url.URL{Path: "/-/raw/" + p}).EscapedPath(), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// the range starts after the end of the file
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return nil, nil
+	}
+	// the whole file, if the range was ignored
+	if resp.StatusCode == http.StatusOK {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// subpath-serve mount <mountpoint> -url <instance>: mounts the files served
+// by an instance at mountpoint as a read-only FUSE filesystem, until it's
+// interrupted
+func runMount(args []string) {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	instance := flags.String("url", "", "URL of the subpath-serve instance, including its -base-path (e.g. https://sean.fish/d). Add user:password@ to authenticate with basic auth")
+	refresh := flags.Duration("refresh", time.Minute, "how often the list of files is fetched again, which is also how long the kernel caches what's in each directory")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: subpath-serve mount <mountpoint> -url <instance> [FLAG...]\nMounts the files served by an instance as a read-only FUSE filesystem (linux only)")
+		fmt.Fprintln(os.Stderr, "")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	// flags can be before or after the mountpoint
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	mountpoint := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		log.Fatalf("Error: Expected one mountpoint, got '%s'\n", strings.Join(append([]string{mountpoint}, flags.Args()...), "', '"))
+	}
+	if *instance == "" {
+		log.Fatalln("Error: Pass the URL of the instance to mount with -url")
+	}
+	if *refresh <= 0 {
+		log.Fatalln("Error: -refresh must be positive")
+	}
+	remote, err := newRemoteFiles(*instance)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	if err := remote.refresh(context.Background()); err != nil {
+		log.Fatalf("Error: Could not list the files of %s: %s\n", remote, err)
+	}
+	go remote.refreshEvery(*refresh)
+	tree, _ := remote.current()
+	log.Printf("Mounting %s (%d files) at %s\n", remote, tree.fileCount(), mountpoint)
+	if err := serveFUSE(mountpoint, remote, *refresh); err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+}
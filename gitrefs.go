@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runs git in dir, returning what it printed
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// serves the files from a commit in a git repository
+//
+// commits never change, so the listing is only read once
+type gitSource struct {
+	repo   string
+	commit string
+	// path of the served folder in the repository, e.g. 'dotfiles/', empty for the root
+	prefix string
+	tree   *memTree
+}
+
+// lists the files in the commit, under prefix. Every file has the time of the commit,
+// and is executable if it's executable in the commit
+func newGitSource(ctx context.Context, repo string, commit string, prefix string) (*gitSource, error) {
+	out, err := runGit(ctx, repo, "show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse commit time for %s: %w", commit, err)
+	}
+	modTime := time.Unix(timestamp, 0)
+	out, err = runGit(ctx, repo, "ls-tree", "--full-tree", "-r", "-l", "-z", commit+":"+prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := []memFile{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> <type> <object> <size>\t<path>
+		meta, p, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		// skip submodules and symlinks
+		if !ok || len(fields) != 4 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse size of %s in %s: %w", p, commit, err)
+		}
+		// git only tracks whether a file is executable
+		perm := fs.FileMode(0o644)
+		if fields[0] == "100755" {
+			perm = 0o755
+		}
+		files = append(files, memFile{path: p, size: size, modTime: modTime, perm: perm})
+	}
+	return &gitSource{repo: repo, commit: commit, prefix: prefix, tree: newMemTree(files)}, nil
+}
+
+func (g *gitSource) String() string {
+	return fmt.Sprintf("%s@%s", g.repo, g.commit[:12])
+}
+
+func (g *gitSource) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	return g.tree.walk(dir, fn)
+}
+
+func (g *gitSource) stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	return g.tree.stat(p)
+}
+
+func (g *gitSource) open(ctx context.Context, p string) (fs.File, error) {
+	node := g.tree.lookup(p)
+	if node == nil || node.isDir {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	data, err := runGit(ctx, g.repo, "cat-file", "blob", g.commit+":"+g.prefix+p)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteFile{body: io.NopCloser(bytes.NewReader(data)), info: node}, nil
+}
+
+// the branches/tags of the git repository the folder of a mount is in,
+// which are served under /@<ref>/ (or /<mount>/@<ref>/), with -git-refs
+type gitRefs struct {
+	folder string
+	// path of the folder in the repository
+	prefix string
+	// the URL of the repository's origin remote, empty if it doesn't have one
+	remote string
+
+	mu sync.Mutex
+	// the commit each ref pointed to when it was last requested, and
+	// the mount for it, so the listing is only read again if the ref moves
+	commits map[string]string
+	mounts  map[string]*mount
+}
+
+// returns an error if folder isn't in a git repository
+func newGitRefs(folder string) (*gitRefs, error) {
+	out, err := runGit(context.Background(), folder, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("'%s' isn't in a git repository: %w", folder, err)
+	}
+	// exits with 1 if there's no origin remote
+	remote, _ := runGit(context.Background(), folder, "config", "--get", "remote.origin.url")
+	return &gitRefs{
+		folder:  folder,
+		prefix:  strings.TrimSpace(string(out)),
+		remote:  strings.TrimSpace(string(remote)),
+		commits: make(map[string]string),
+		mounts:  make(map[string]*mount),
+	}, nil
+}
+
+// how many refs have their listing cached, more than this evicts (arbitrary) refs
+const maxGitRefMounts = 32
+
+// names of branches/tags which can be requested, so revision
+// expressions (e.g. HEAD~3, main@{2}, a commit hash) can't be
+var validRefName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// the commit a branch (or if there isn't one with that name, a tag)
+// points to. Returns an empty string if there's no branch/tag named ref
+func (g *gitRefs) commit(ctx context.Context, ref string) (string, error) {
+	if !validRefName.MatchString(ref) || strings.Contains(ref, "..") {
+		return "", nil
+	}
+	for _, prefix := range [...]string{"refs/heads/", "refs/tags/"} {
+		// the full name (and validRefName) means only that ref is looked up
+		out, err := runGit(ctx, g.folder, "rev-parse", "--verify", "--quiet", prefix+ref+"^{commit}")
+		if err != nil {
+			// --quiet exits with 1 (without printing anything) if the ref doesn't exist
+			var exitErr *exec.ExitError
+			if ctx.Err() == nil && errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+				continue
+			}
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", nil
+}
+
+// the -git-http-prefix and -git-raw-prefix of the files at the commit,
+// like the ones of a submodule, if the mount has them. The mount's are
+// for the files it serves (e.g. .../blob/master), not the ones at the
+// commit, so they're empty (linking to /-/raw/@<ref>/ instead) unless the
+// origin remote is on one of the forges
+func (g *gitRefs) prefixes(m *mount, commit string) (string, string) {
+	f, repo, ok := parseRemote(g.remote)
+	if !ok {
+		return "", ""
+	}
+	replacer := strings.NewReplacer("{repo}", repo, "{commit}", commit)
+	// the files are relative to the folder, not the root of the repository
+	dir := ""
+	if g.prefix != "" {
+		dir = "/" + strings.TrimSuffix(g.prefix, "/")
+	}
+	repoPrefix, rawPrefix := "", ""
+	if m.repoPrefix != "" {
+		repoPrefix = replacer.Replace(f.blob) + dir
+	}
+	if m.rawPrefix != "" {
+		rawPrefix = replacer.Replace(f.raw) + dir
+	}
+	return repoPrefix, rawPrefix
+}
+
+// the mount for the files at ref, nil if there's no branch/tag named ref
+func (g *gitRefs) mount(ctx context.Context, m *mount, ref string) (*mount, error) {
+	commit, err := g.commit(ctx, ref)
+	if err != nil || commit == "" {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.commits[ref] == commit {
+		return g.mounts[ref], nil
+	}
+	src, err := newGitSource(ctx, g.folder, commit, g.prefix)
+	if err != nil {
+		return nil, err
+	}
+	name := "@" + ref
+	if m.name != "" {
+		name = m.name + "/" + name
+	}
+	refMount := &mount{name: name, src: src, parent: m}
+	refMount.repoPrefix, refMount.rawPrefix = g.prefixes(m, commit)
+	refMount.prefixName = capitalize(getDomainName(refMount.repoPrefix))
+	for cached := range g.mounts {
+		if len(g.mounts) < maxGitRefMounts {
+			break
+		}
+		delete(g.mounts, cached)
+		delete(g.commits, cached)
+	}
+	g.commits[ref] = commit
+	g.mounts[ref] = refMount
+	return refMount, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitRefs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := runGit(context.Background(), repo, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	folder := filepath.Join(repo, "dotfiles")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	for _, contents := range []string{"old", "new"} {
+		if err := os.WriteFile(filepath.Join(folder, "rc.conf"), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "-A")
+		git("commit", "-qm", contents)
+		if contents == "old" {
+			git("tag", "v1.0")
+		}
+	}
+	commit := git("rev-parse", "v1.0")
+	git("remote", "add", "origin", "https://github.com/u/dotfiles.git")
+	args := []string{
+		"-folder", "{dir}", "-git-refs",
+		"-git-http-prefix", "https://github.com/u/dotfiles/blob/master/dotfiles",
+		"-git-raw-prefix", "https://raw.githubusercontent.com/u/dotfiles/master/dotfiles",
+	}
+	get := func(s *server, target string) (int, string, string) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Code, w.Body.String(), w.Header().Get("Location")
+	}
+	s := newTestServer(t, folder, args)
+	for _, tt := range []struct {
+		target   string
+		code     int
+		body     string
+		location string
+	}{
+		{"/rc.conf", 200, "new", ""},
+		{"/@v1.0/rc.conf", 200, "old", ""},
+		{"/@nope/rc.conf", 404, "", ""},
+		// the ref's commit, not the branch of the -git-http-prefix
+		{"/rc.conf?redirect=blob", 302, "", "https://github.com/u/dotfiles/blob/master/dotfiles/rc.conf"},
+		{"/@v1.0/rc.conf?redirect=blob", 302, "", "https://github.com/u/dotfiles/blob/" + commit + "/dotfiles/rc.conf"},
+		{"/@v1.0/rc.conf?redirect=raw", 302, "", "https://raw.githubusercontent.com/u/dotfiles/" + commit + "/dotfiles/rc.conf"},
+	} {
+		code, body, location := get(s, tt.target)
+		if code != tt.code || (tt.body != "" && body != tt.body) || location != tt.location {
+			t.Errorf("GET %s = %d %q (Location: %q), expected %d %q (Location: %q)", tt.target, code, body, location, tt.code, tt.body, tt.location)
+		}
+	}
+	// without a forge to link to, the raw file is on this server
+	git("remote", "set-url", "origin", "https://git.example.com/u/dotfiles.git")
+	s = newTestServer(t, folder, args)
+	if code, _, location := get(s, "/@v1.0/rc.conf?redirect=raw"); code != 302 || location != "/-/raw/@v1.0/rc.conf" {
+		t.Errorf("GET /@v1.0/rc.conf?redirect=raw = %d (Location: %q), expected a redirect to /-/raw/@v1.0/rc.conf", code, location)
+	}
+}
@@ -1,3 +1,18 @@
 module github.com/seanbreckenridge/subpath-serve
 
-go 1.17
+go 1.24.0
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/karrick/godirwalk v1.17.0
+	github.com/pkg/sftp v1.13.10
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/crypto v0.43.0
+	golang.org/x/image v0.32.0
+	golang.org/x/sync v0.17.0
+	golang.org/x/sys v0.37.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/kr/fs v0.1.0 // indirect
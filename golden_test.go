@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -run TestGolden -update rewrites the golden files with the
+// current responses, check the diff before committing them
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// the headers included in golden files, the rest (e.g. X-Request-Id,
+// ETag) change between runs or don't matter to what's displayed
+var goldenHeaders = []string{"Content-Type", "Location"}
+
+// copies testdata/fixture into a temporary folder with every file
+// modified at the same time, so pages are the same on every run
+func fixtureFolder(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS("testdata/fixture")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(p, modTime, modTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// sets up the server like main does, with the flags in args, where {dir}
+// is replaced with the folder
+func newTestServer(t *testing.T, dir string, args []string) *server {
+	t.Helper()
+	resolved := []string{}
+	for _, arg := range args {
+		resolved = append(resolved, strings.ReplaceAll(arg, "{dir}", dir))
+	}
+	// parseFlags and newServer set globals (and reloading sets the ignore
+	// rules), the next test gets them back
+	previousBasePath, previousAbsoluteTimes, previousDebug := assets.basePath, absoluteTimes, debugErrors
+	previousNotice, previousIgnores := *notice, ignores.Load()
+	t.Cleanup(func() {
+		assets.basePath, absoluteTimes, debugErrors = previousBasePath, previousAbsoluteTimes, previousDebug
+		*notice = previousNotice
+		ignores.Store(previousIgnores)
+	})
+	s, err := newServer(parseFlags(flag.NewFlagSet("subpath-serve", flag.ContinueOnError), resolved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// the status, goldenHeaders and body of the response, with the folder
+// replaced with {dir}
+func formatResponse(w *httptest.ResponseRecorder, dir string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP %d\n", w.Code)
+	for _, name := range goldenHeaders {
+		if value := w.Header().Get(name); value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(w.Body.String())
+	return strings.ReplaceAll(b.String(), dir, "{dir}")
+}
+
+func TestGolden(t *testing.T) {
+	dir := fixtureFolder(t)
+	folder := func(args ...string) []string {
+		return append([]string{"-folder", "{dir}"}, args...)
+	}
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		target string
+	}{
+		{"index", folder(), "/"},
+		{"index-dark", folder(), "/?dark"},
+		{"find", folder(), "/init.lua"},
+		{"find-nested", folder(), "/lua/plugins.lua"},
+		{"find-dark", folder(), "/backup.sh?dark"},
+		{"find-dir", folder(), "/nvim/"},
+		{"find-dir-dark", folder(), "/lua/?dark"},
+		{"find-unclean", folder(), "//nvim/../nvim/./init.lua"},
+		{"not-found", folder(), "/init.vim"},
+		{"not-found-dark", folder(), "/nvim/init.vim?dark"},
+		{"reserved-not-found", folder(), "/-/bashrc"},
+		{"redirect-raw", folder(), "/bashrc?redirect=raw"},
+		{"redirect-raw-prefix", folder("-git-raw-prefix", "https://raw.example.com/dotfiles/master"), "/init.lua?redirect=raw"},
+		{"redirect-blob", folder("-git-http-prefix", "https://example.com/dotfiles/blob/master"), "/init.lua?redirect=blob"},
+		{"redirect-mount", []string{"-mount", "dotfiles={dir}"}, "/dotfiles?dark"},
+		{"base-path", folder("-base-path", "/d"), "/bashrc?dark"},
+		{"base-path-redirect", folder("-base-path", "/d"), "/nvim/init.lua?redirect=raw"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// times are displayed as how long ago they were without -absolute-times
+			s := newTestServer(t, dir, append(tt.args, "-absolute-times"))
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			got := formatResponse(w, dir)
+			golden := filepath.Join("testdata", "golden", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+			if got != string(expected) {
+				t.Errorf("GET %s doesn't match %s (run with -update if it should've changed)\n--- got:\n%s\n--- expected:\n%s", tt.target, golden, got, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// handles every request
+type server struct {
+	config *config
+	tmpl   *template.Template
+	// templates for specific types of files, from -template-rules
+	templateRules []*templateRule
+	// nil unless running with -thumbnails
+	thumbnails *byteCache
+	// rendered ?dark pages for files, nil unless running with -render-cache-size
+	renderCache *byteCache
+	// tmpl, with the contents of files in a table with line numbers, for ?ln
+	lineNumbersTmpl *template.Template
+	// from -user-agent-rule and -user-agent-rules
+	userAgentRules []*userAgentRule
+	// queries for each bundle, from -bundles
+	bundles map[string][]string
+	// nil unless running with -mirror-rate-limit
+	mirrorLimiter *mirrorLimiter
+	// files which require authenticating or a signed URL, from -private
+	private *privateFiles
+	// shares walks/reads between identical concurrent requests
+	lookups *lookupGroup
+	// hashes of files for /-/manifest
+	hashes *hashCache
+	// nil unless running with -analytics
+	analytics *analytics
+	// signs files for ?sig, nil unless running with -minisign-key
+	minisignKey *minisignKey
+	// limit how many archives (/-/mirror.tar.gz, bundle archives) and
+	// content searches run at once, nil if they aren't limited
+	archives *heavyLimiter
+	searches *heavyLimiter
+	// files which are executed instead of served, from -dynamic
+	dynamic *dynamicFiles
+	// files whose contents are the output of a command, by their path,
+	// from -virtual-files
+	virtual map[string]*virtualFile
+	// nil unless running with -oidc-issuer
+	oidc *oidcProvider
+	// nil unless running with -quota-requests, -quota-bytes or -quota-tokens
+	quotas *quotas
+	// nil unless running with -dir-sizes
+	dirSizes *dirSizes
+	// change plaintext responses for files, from -transforms
+	transforms []*transformRule
+	// Content-Types for plaintext responses, from -mimetypes-file
+	mimeTypes []*mimeTypeRule
+	// the server after it's been reloaded, see reload
+	reloaded *liveServer
+	// the requests for each route, for /-/status
+	requests *requestRates
+	// nil unless running with -changes-interval
+	changes *changeLog
+}
+
+// options parsed from the query parameters of a request
+type requestOptions struct {
+	isDark bool
+	// the request was made ?dark by a -user-agent-rule
+	darkByDefault bool
+	// redirect to the -git-http-prefix ("blob") or -git-raw-prefix ("raw")
+	// URL of the file, empty if the request shouldn't be redirected
+	redirect string
+	isPlain  bool
+	// display JSON/YAML/TOML files as a collapsible tree, in the ?dark view
+	isPretty bool
+	// render markdown files with a table of contents, in the ?dark view
+	toc bool
+	// display line numbers next to files in the ?dark view
+	lineNumbers bool
+	// respond with a thumbnail of an image, if running with -thumbnails
+	isThumbnail bool
+	// filters listings to files which match this, in their path or contents
+	search string
+	// search compiled with ?regex and ?case=, if there is one
+	searchPattern *regexp.Regexp
+	// with -follow-symlinks, add ' -> <target>' to the lines for symlinks in plaintext listings
+	showSymlinks bool
+	// respond with the minisign signature of the file, for ?sig
+	isSignature bool
+	// respond with the file rendered as a PDF
+	isPDF bool
+	// only return these lines of a plaintext file
+	lines *lineRange
+	// paginates listings, limit is 0 if there's no limit
+	limit  int
+	offset int
+}
+
+// returns an error if any of the query parameters are invalid
+func parseRequestOptions(queryParams url.Values) (*requestOptions, error) {
+	opts := &requestOptions{
+		isDark:      hasQueryParam(queryParams, "dark") && !isFalse(queryParams.Get("dark")),
+		isPlain:     hasQueryParam(queryParams, "plain"),
+		isPretty:    hasQueryParam(queryParams, "pretty"),
+		toc:         hasQueryParam(queryParams, "toc") && !isFalse(queryParams.Get("toc")),
+		lineNumbers: hasQueryParam(queryParams, "ln") && !isFalse(queryParams.Get("ln")),
+		isThumbnail: hasQueryParam(queryParams, "thumbnail"),
+		search:      strings.TrimSpace(queryParams.Get("q")),
+
+		showSymlinks: hasQueryParam(queryParams, "symlinks"),
+		// signed URLs from /-/sign have a ?sig= with a value
+		isSignature: hasQueryParam(queryParams, "sig") && queryParams.Get("sig") == "",
+		isPDF:       hasQueryParam(queryParams, "pdf") && !isFalse(queryParams.Get("pdf")),
+	}
+	if hasQueryParam(queryParams, "redirect") {
+		switch target := queryParams.Get("redirect"); target {
+		case "", "blob":
+			opts.redirect = "blob"
+		case "raw":
+			opts.redirect = "raw"
+		default:
+			return opts, fmt.Errorf("invalid redirect '%s', expected raw or blob", target)
+		}
+	}
+	if opts.search != "" {
+		pattern, err := compileSearch(queryParams, opts.search)
+		if err != nil {
+			return opts, err
+		}
+		opts.searchPattern = pattern
+	}
+	if hasQueryParam(queryParams, "lines") {
+		lines, err := parseLineRange(queryParams.Get("lines"))
+		if err != nil {
+			return opts, err
+		}
+		opts.lines = lines
+	}
+	for _, param := range [...]string{"limit", "offset"} {
+		if !hasQueryParam(queryParams, param) {
+			continue
+		}
+		value, err := strconv.Atoi(queryParams.Get(param))
+		if err != nil || value < 0 {
+			return opts, fmt.Errorf("invalid %s '%s', expected a positive integer", param, queryParams.Get(param))
+		}
+		if param == "limit" {
+			opts.limit = value
+		} else {
+			opts.offset = value
+		}
+	}
+	return opts, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s = s.live()
+	if s.analytics != nil {
+		defer s.analytics.record(w, r)
+	}
+	w.Header().Set("X-Request-Id", newRequestID())
+	if !s.redirectToHTTPS(w, r) {
+		return
+	}
+	opts, err := parseRequestOptions(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: err.Error() + "\n",
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if s.config.dev {
+		dev, err := s.reloadTemplates()
+		if err != nil {
+			serveTemplateError(w, err, opts)
+			return
+		}
+		s, w = dev, &noStoreWriter{ResponseWriter: w}
+	}
+	if !s.applyUserAgentRules(w, r, opts) {
+		return
+	}
+	// /-/quota is how clients check their quota, so it doesn't count against it
+	if s.quotas != nil && cleanRequestPath(r.URL.Path) != "-/quota" {
+		var ok bool
+		if w, ok = s.applyQuota(w, r, opts); !ok {
+			return
+		}
+	}
+	// with -line-numbers, they're displayed unless ?ln=0 is passed
+	if s.config.lineNumbers && !hasQueryParam(r.URL.Query(), "ln") {
+		opts.lineNumbers = true
+	}
+	// stop walking/reading if the client disconnects or the request takes too long
+	timings := newRequestTimings()
+	defer timings.logIfSlow(r, s.config.slowRequestThreshold, s.config.logFormat)
+	ctx := withTimings(r.Context(), timings)
+	ctx = withAccess(ctx, s.requestAccess(r))
+	if s.config.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.requestTimeout)
+		defer cancel()
+	}
+	s.route(ctx, w, r, opts, cleanRequestPath(r.URL.Path))
+}
+
+// responds with the index, a directory listing, or the file matching the path
+func (s *server) serveQuery(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, reqPath string) {
+	if hasQueryParam(r.URL.Query(), "trace") {
+		s.serveTrace(ctx, w, r, opts, reqPath)
+		return
+	}
+	if v, ok := s.virtual[reqPath]; ok {
+		s.serveVirtual(ctx, w, r, opts, v)
+		return
+	}
+	m, query, err := resolveMount(ctx, s.config.mounts, reqPath)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if m == nil {
+		// a request to / when serving multiple mounts, list files from each
+		if reqPath == "" {
+			roots := []indexRoot{}
+			for _, m := range s.config.mounts {
+				roots = append(roots, indexRoot{m: m, dir: ".", prefix: m.name + "/"})
+			}
+			setSurrogateKeys(w, "", true)
+			s.serveIndex(ctx, w, r, opts, "Index", roots, nil)
+			return
+		}
+		if s.serveFallback(ctx, w, r, reqPath) {
+			return
+		}
+		s.serveNotFound(ctx, w, reqPath, opts)
+		return
+	}
+	if query == "" {
+		// make sure relative links on the index for a mount resolve under the mount
+		if m.name != "" && !strings.HasSuffix(reqPath, "/") {
+			target := s.config.basePath + "/" + m.name + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		setSurrogateKeys(w, mountPath(m, "."), true)
+		s.serveIndex(ctx, w, r, opts, "Index", []indexRoot{{m: m, dir: "."}}, breadcrumbs(s.config.basePath, m, "."))
+		return
+	}
+	// a request ending with a '/' lists the files in a matching directory
+	if strings.HasSuffix(query, "/") {
+		done := timingsFrom(ctx).track("walk")
+		dirPath, err := s.findDir(ctx, m, strings.TrimRight(query, "/"))
+		done()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		if err == nil {
+			setSurrogateKeys(w, mountPath(m, dirPath), true)
+			s.serveIndex(ctx, w, r, opts, dirPath+"/", []indexRoot{{m: m, dir: dirPath}}, breadcrumbs(s.config.basePath, m, dirPath))
+			return
+		}
+	}
+	s.serveFile(ctx, w, r, opts, m, reqPath, strings.TrimRight(query, "/"))
+}
+
+// a directory to list files from in the index
+type indexRoot struct {
+	m   *mount
+	dir string
+	// prepended to each line
+	prefix string
+}
+
+// returned from listFiles callbacks to stop once the page is full
+var errPageFull = errors.New("page full")
+
+// lists the files in each root
+//
+// if the request has a search query, only lists matching files
+//
+// plaintext listings without a ?limit= are streamed as the folder is walked,
+// else the lines for the page are collected and then rendered. If there are
+// more lines after the page, sets a Link header with the URL for the next page
+//
+// with -dirs-first, ?dark listings (which aren't searches) list the directories
+// in the listing first, then the files which are directly in it
+func (s *server) serveIndex(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, title string, roots []indexRoot, crumbs []Breadcrumb) {
+	// with -sort, every line is needed to sort them, the page is taken after
+	sorted := s.config.sortOrder != "bytes"
+	stream := !opts.isDark && opts.limit == 0 && !sorted
+	// every line is needed to group them, the page is taken after
+	group := s.config.dirsFirst && opts.isDark && opts.search == ""
+	// the contents of private files are only searched if the request can read them
+	if opts.search != "" && len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+	}
+	if opts.search != "" {
+		release, err := s.searches.acquire(ctx)
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		defer release()
+	}
+	pageLines := []string{}
+	// the file each symlink in the ?dark listing points to, by line
+	symlinks := make(map[string]string)
+	seen, written := 0, 0
+	hasMore := false
+	for _, root := range roots {
+		done := timingsFrom(ctx).track("walk")
+		canRead := func(p string) bool { return s.checkPrivate(ctx, root.m, p) == nil }
+		err := listFiles(ctx, root.m.src, root.dir, root.prefix, opts.searchPattern, canRead, func(line string, p string) error {
+			if opts.isDark || opts.showSymlinks {
+				if target := symlinkTarget(root.m.src, p); target != "" && opts.isDark {
+					symlinks[line] = target
+				} else if target != "" {
+					line += " -> " + target
+				}
+			}
+			if group || sorted {
+				pageLines = append(pageLines, line)
+				return nil
+			}
+			seen++
+			if seen <= opts.offset {
+				return nil
+			}
+			if opts.limit != 0 && len(pageLines) == opts.limit {
+				hasMore = true
+				return errPageFull
+			}
+			if stream {
+				// the notice is written with the first line, so an error
+				// before it can still be responded to
+				if written == 0 && opts.offset == 0 {
+					fmt.Fprint(w, notice.comment())
+				}
+				written++
+				_, err := fmt.Fprintln(w, line)
+				return err
+			}
+			pageLines = append(pageLines, line)
+			return nil
+		})
+		done()
+		if err == errPageFull {
+			break
+		}
+		if err != nil {
+			// if part of the listing was already streamed, the status can't be changed
+			if written > 0 {
+				log.Printf("Error while streaming listing for %s: %s\n", r.URL.RequestURI(), err)
+				return
+			}
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	if stream {
+		if written == 0 && opts.offset == 0 {
+			fmt.Fprint(w, notice.comment())
+		}
+		return
+	}
+	var dirs []DirEntry
+	sortListing(pageLines, s.config.sortOrder)
+	if group {
+		dirs, pageLines = groupDirs(pageLines)
+		dirs, pageLines, hasMore = paginateDirs(dirs, pageLines, opts)
+	} else if sorted {
+		_, pageLines, hasMore = paginateDirs(nil, pageLines, opts)
+	}
+	if s.dirSizes != nil {
+		done := timingsFrom(ctx).track("walk")
+		for i := range dirs {
+			m, dir := listedDir(roots, dirs[i].Name)
+			if m == nil {
+				continue
+			}
+			size, err := s.dirSize(ctx, m, dir)
+			if err != nil {
+				done()
+				renderError(&w, err, s.tmpl, opts.isDark)
+				return
+			}
+			dirs[i].Size = size
+		}
+		done()
+	}
+	setPageLinks(w, r, s.config.basePath, opts, hasMore)
+	pageContents := ""
+	if len(pageLines) > 0 {
+		pageContents = strings.Join(pageLines, "\n") + "\n"
+	}
+	// the dark template links each line, keep the lines separate
+	if opts.isDark && len(pageLines) == 0 && len(dirs) == 0 {
+		pageContents = "No matching files\n"
+	}
+	if !opts.isDark {
+		pageLines = nil
+		if opts.offset == 0 {
+			pageContents = notice.comment() + pageContents
+		}
+	}
+	// with -dashboard, the first page of the ?dark index has a summary above it
+	var dashboard *Dashboard
+	if s.config.dashboard && opts.isDark && opts.search == "" && opts.offset == 0 && isIndex(roots) {
+		done := timingsFrom(ctx).track("walk")
+		var err error
+		dashboard, err = s.dashboard(ctx, roots)
+		done()
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	// the index of every mount, or a directory in one of them
+	canonical, share := s.canonicalURL("", true), s.shareURL(r, "", true)
+	if len(roots) == 1 && roots[0].prefix == "" {
+		canonical = s.canonicalURL(mountPath(roots[0].m, roots[0].dir), true)
+		share = s.shareURL(r, mountPath(roots[0].m, roots[0].dir), true)
+	}
+	defer timingsFrom(ctx).track("render")()
+	render(&w, &PageInfo{
+		PageContents: pageContents,
+		Title:        title,
+		PageLines:    pageLines,
+		Dirs:         dirs,
+		IsListing:    true,
+		Search:       opts.search,
+		Breadcrumbs:  crumbs,
+		RawUrl:       plainURL(r, s.config.basePath, opts),
+		Thumbnails:   s.thumbnails != nil,
+		Symlinks:     symlinks,
+		Dashboard:    dashboard,
+		CanonicalUrl: canonical,
+		ShareUrl:     share,
+		Lang:         s.pageLang(w, r, opts),
+	}, s.tmpl, opts.isDark)
+}
+
+// the language times on the ?dark page are displayed in, which depends
+// on the Accept-Language header of the request
+func (s *server) pageLang(w http.ResponseWriter, r *http.Request, opts *requestOptions) string {
+	if opts.isDark {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	return preferredLang(r.Header.Get("Accept-Language"))
+}
+
+// whether the roots are the index of the mount(s), not a directory
+func isIndex(roots []indexRoot) bool {
+	for _, root := range roots {
+		if root.dir != "." {
+			return false
+		}
+	}
+	return true
+}
+
+// the request URL (under basePath), without ?dark
+//
+// if the request was made ?dark by a -user-agent-rule, with ?dark=0 instead
+func plainURL(r *http.Request, basePath string, opts *requestOptions) string {
+	u := *r.URL
+	query := u.Query()
+	query.Del("dark")
+	if opts.darkByDefault {
+		query.Set("dark", "0")
+	}
+	u.RawQuery = query.Encode()
+	return basePath + u.RequestURI()
+}
+
+// the request URL (under basePath), with line numbers turned on/off
+func lineNumbersURL(r *http.Request, basePath string, on bool) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("ln", "0")
+	if on {
+		query.Set("ln", "")
+	}
+	u.RawQuery = query.Encode()
+	return basePath + u.RequestURI()
+}
+
+// links to the index of the mount, and the listing for each directory down to dir
+func breadcrumbs(basePath string, m *mount, dir string) []Breadcrumb {
+	base := basePath + "/"
+	name := "index"
+	if m.name != "" {
+		base = basePath + "/" + m.name + "/"
+		name = m.name
+	}
+	crumbs := []Breadcrumb{{Name: name, Url: base}}
+	if dir == "." {
+		return crumbs
+	}
+	parts := splitPath(dir)
+	for i, part := range parts {
+		crumbs = append(crumbs, Breadcrumb{
+			Name: part,
+			Url:  base + strings.Join(parts[:i+1], "/") + "/",
+		})
+	}
+	return crumbs
+}
+
+// sets a Link header with the next/previous pages of a paginated listing
+func setPageLinks(w http.ResponseWriter, r *http.Request, basePath string, opts *requestOptions, hasMore bool) {
+	if opts.limit == 0 {
+		return
+	}
+	pageURL := func(offset int) string {
+		u := *r.URL
+		query := u.Query()
+		query.Set("limit", strconv.Itoa(opts.limit))
+		query.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = query.Encode()
+		return basePath + u.RequestURI()
+	}
+	links := []string{}
+	if hasMore {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(opts.offset+opts.limit)))
+	}
+	if opts.offset > 0 {
+		prev := opts.offset - opts.limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(prev)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// responds with the -not-found-file, if it was passed
+func (s *server) serveNotFound(ctx context.Context, w http.ResponseWriter, reqPath string, opts *requestOptions) {
+	if s.config.notFoundFile != "" && s.serveNotFoundFile(ctx, w, reqPath, opts) {
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Could not find a match for %s\n", reqPath),
+		Title:        "404 - Not Found",
+		NotFound:     s.notFoundSearch(reqPath),
+	}, s.tmpl, opts.isDark)
+}
+
+// the search box for a 404, starting with the last part of the path
+// which didn't match, e.g. init.lua for nvim/init.lua
+func (s *server) notFoundSearch(reqPath string) *NotFoundSearch {
+	query := strings.Trim(reqPath, "/")
+	if i := strings.LastIndex(query, "/"); i != -1 {
+		query = query[i+1:]
+	}
+	return &NotFoundSearch{Query: query, IndexUrl: s.config.basePath + "/"}
+}
+
+// the file matched, but the server doesn't have permission to read it
+func (s *server) serveForbidden(w http.ResponseWriter, reqPath string, opts *requestOptions) {
+	w.WriteHeader(http.StatusForbidden)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Permission denied reading %s\n", reqPath),
+		Title:        "403 - Forbidden",
+	}, s.tmpl, opts.isDark)
+}
+
+// searches the mount for query, and responds with the file
+func (s *server) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, m *mount, reqPath string, query string) {
+	// search for the file
+	done := timingsFrom(ctx).track("walk")
+	foundPath, err := s.find(ctx, m, query)
+	done()
+	// if there was an OS error
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	// if the file couldn't be found, it may have been moved
+	if err != nil {
+		target, moved, err := s.movedURL(ctx, r, m, query)
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		if moved {
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		// it may have been removed on purpose
+		if removed, err := s.serveTombstone(ctx, w, opts, m, query); removed || err != nil {
+			if err != nil {
+				renderError(&w, err, s.tmpl, opts.isDark)
+			}
+			return
+		}
+		if s.serveFallback(ctx, w, r, reqPath) {
+			return
+		}
+		s.serveNotFound(ctx, w, reqPath, opts)
+		return
+	}
+	// file was found
+	// files in a submodule link to the submodule's repository
+	repoPrefix, rawPrefix, prefixName, repoPath := m.prefixesFor(foundPath)
+	url := prefixURL(repoPrefix, repoPath)
+	// if were meant to redirect, early return
+	if opts.redirect == "raw" {
+		// without -git-raw-prefix, the raw file is on this server
+		if rawPrefix == "" {
+			http.Redirect(w, r, rawURL(s.config.basePath, m, foundPath), 302)
+		} else {
+			http.Redirect(w, r, prefixURL(rawPrefix, repoPath), 302)
+		}
+		return
+	}
+	if opts.redirect == "blob" {
+		if repoPrefix != "" {
+			http.Redirect(w, r, url, 302)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Warning: tried to redirect to %s but no repoPrefix set\n", url)
+	}
+	if opts.isThumbnail && s.thumbnails != nil {
+		s.serveThumbnail(ctx, w, r, opts, m, reqPath, foundPath)
+		return
+	}
+	if err := s.checkPrivate(ctx, m, foundPath); err != nil {
+		s.serveUnauthorized(w, r, opts)
+		return
+	}
+	if full := s.dynamicPath(m, foundPath); full != "" {
+		s.serveDynamic(ctx, w, r, opts, m, foundPath, full)
+		return
+	}
+	tmpl, renderer := s.templateFor(foundPath)
+	// files with a -template-rules template are always displayed with it
+	canNumberLines := renderer == "default"
+	if canNumberLines && opts.lineNumbers {
+		tmpl, renderer = s.lineNumbersTmpl, "default:ln"
+	}
+	linkTarget := symlinkTarget(m.src, foundPath)
+	if linkTarget != "" {
+		w.Header().Set("X-Symlink-Target", linkTarget)
+	}
+	lang := s.pageLang(w, r, opts)
+	// the file hasn't changed since it was last rendered, respond with that
+	if opts.isDark && !opts.isSignature && s.renderCache != nil {
+		if info, err := statFile(ctx, m.src, foundPath); err == nil {
+			if page := s.renderCache.get(renderKey(mountPath(m, foundPath), info, renderer, opts, s.externalURL(r), lang)); page != nil {
+				w.Header().Set("X-Filepath", foundPath)
+				s.setCacheHeaders(w, m, foundPath, false)
+				w.Write(page)
+				return
+			}
+		}
+	}
+	// if the file was found, return the read file
+	done = timingsFrom(ctx).track("read")
+	data, info, err := s.readFileInfo(ctx, m, foundPath)
+	done()
+	if errors.Is(err, fs.ErrPermission) {
+		s.serveForbidden(w, reqPath, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if opts.isSignature {
+		s.serveSignature(w, opts, m, foundPath, data, info)
+		return
+	}
+	if opts.isPDF {
+		s.servePDF(ctx, w, r, opts, m, foundPath, data, info)
+		return
+	}
+	contents := string(data)
+	// strip frontmatter for ?plain, display it as a table for ?dark
+	var frontmatter []FrontmatterField
+	if (opts.isDark || opts.isPlain) && hasFrontmatterExt(foundPath) {
+		var matter string
+		matter, contents = splitFrontmatter(contents)
+		if opts.isDark && matter != "" {
+			frontmatter = parseFrontmatter(matter)
+		}
+	}
+	// plaintext responses for text files are changed by -transforms
+	if !opts.isDark && !isBinary(data) {
+		contents = s.transform(privatePath(m, foundPath), contents, r.URL.Query())
+	}
+	// notebooks, ?pretty and ?toc are only rendered for the ?dark view
+	var rendered template.HTML
+	var toc []Heading
+	if opts.isDark && strings.HasSuffix(strings.ToLower(foundPath), ".ipynb") {
+		if rendered, err = renderNotebook(data); err != nil {
+			// display the file as usual, if it can't be parsed
+			log.Printf("Could not render notebook %s: %s\n", foundPath, err)
+		}
+	} else if opts.isDark && opts.isPretty {
+		node, err := parsePretty(foundPath, data)
+		if err != nil {
+			log.Printf("Could not parse %s for ?pretty: %s\n", foundPath, err)
+		} else if node != nil {
+			rendered = node.html()
+		}
+	} else if opts.isDark && opts.toc && isMarkdown(foundPath) {
+		if rendered, toc, err = renderMarkdownTOC(contents); err != nil {
+			log.Printf("Could not render %s for ?toc: %s\n", foundPath, err)
+		}
+	}
+	// only return part of the file if ?lines= was passed
+	if opts.lines != nil && !opts.isDark {
+		var total int
+		contents, total = opts.lines.slice(contents)
+		w.Header().Set("X-Total-Lines", strconv.Itoa(total))
+	}
+	w.Header().Set("X-Filepath", foundPath)
+	s.setCacheHeaders(w, m, foundPath, false)
+	// the ?dark view also depends on the template, so only plaintext responses can be a 304
+	if !opts.isDark && notModified(w, r, info.ModTime()) {
+		return
+	}
+	if !opts.isDark {
+		setScriptHeaders(w, data)
+		s.setMimeType(ctx, w, m, foundPath, data)
+	}
+	defer timingsFrom(ctx).track("render")()
+	page := &PageInfo{
+		PageContents: contents,
+		Title:        foundPath,
+		Frontmatter:  frontmatter,
+		Rendered:     rendered,
+		TOC:          toc,
+		File: &FileMeta{
+			Path:       foundPath,
+			Name:       path.Base(foundPath),
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			LinkTarget: linkTarget,
+		},
+		Breadcrumbs: breadcrumbs(s.config.basePath, m, path.Dir(foundPath)),
+		RawUrl:      rawURL(s.config.basePath, m, foundPath),
+		PdfUrl:      pdfURL(s.config.basePath, m, foundPath, data),
+		RunWith:     runWith(data, s.shareURL(r, mountPath(m, foundPath), false)),
+
+		CanonicalUrl: s.canonicalURL(mountPath(m, foundPath), false),
+		ShareUrl:     s.shareURL(r, mountPath(m, foundPath), false),
+		Lang:         lang,
+	}
+	if page.CanonicalUrl != "" {
+		page.Description = describe(contents)
+	}
+	if canNumberLines {
+		page.LineNumbers = opts.lineNumbers
+		page.LineNumbersUrl = lineNumbersURL(r, s.config.basePath, !opts.lineNumbers)
+	}
+	// without -git-http-prefix, there's nothing to link to
+	if repoPrefix != "" {
+		page.PrefixInfo = &HttpPrefix{Url: url, Hostname: prefixName}
+	}
+	if opts.isDark && s.renderCache != nil {
+		html, err := renderHTML(page, tmpl)
+		if err != nil {
+			log.Printf("Could not render %s: %s\n", foundPath, err)
+		} else {
+			s.renderCache.put(renderKey(mountPath(m, foundPath), info, renderer, opts, s.externalURL(r), lang), html)
+		}
+		w.Write(html)
+		return
+	}
+	render(&w, page, tmpl, opts.isDark)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// how long a request waits for another expensive request like it to
+// finish, before it's responded to with a 503
+const heavyQueueTimeout = 5 * time.Second
+
+// returned when a request waited heavyQueueTimeout for a heavyLimiter
+var errBusy = errors.New("too many requests like this one are in progress, try again")
+
+// limits how many expensive requests of one kind (archives, content
+// searches) run at once, so they can't use up the CPU/disk that quick
+// lookups of single files need
+//
+// a nil limiter doesn't limit anything
+type heavyLimiter struct {
+	slots chan struct{}
+}
+
+// nil if max isn't positive
+func newHeavyLimiter(max int) *heavyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &heavyLimiter{slots: make(chan struct{}, max)}
+}
+
+// waits for one of the requests in progress to finish, if there are too
+// many, and returns a function to call once the request is done
+//
+// returns errBusy after waiting heavyQueueTimeout, or the context error
+// if the client disconnects (or the request times out) while waiting
+func (l *heavyLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	timeout := time.NewTimer(heavyQueueTimeout)
+	defer timeout.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-timeout.C:
+		return nil, errBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHeavyLimiter(t *testing.T) {
+	l := newHeavyLimiter(1)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// waits for the slot until the request is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire while full = %v, expected %v", err, context.Canceled)
+	}
+	release()
+	release, err = l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire after release = %v", err)
+	}
+	release()
+	// a nil limiter doesn't limit anything
+	var unlimited *heavyLimiter
+	for i := 0; i < 3; i++ {
+		if _, err := unlimited.acquire(ctx); err != nil {
+			t.Errorf("acquire from nil limiter = %v", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// whether the request was made over HTTPS, either to the server itself,
+// or to one of the -trusted-proxies in front of it (from X-Forwarded-Proto)
+func (s *server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	// with multiple proxies, the first one is the one the client connected to
+	if i := strings.Index(proto, ","); i != -1 {
+		proto = proto[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(proto), "https") && s.fromTrustedProxy(r)
+}
+
+// parses the -https-redirect-exempt patterns, e.g. '-/healthz' or 'public/*'
+func parseHTTPSExempt(patterns []string) ([]*regexp.Regexp, error) {
+	exempt := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := compilePathPattern("https-redirect-exempt", pattern)
+		if err != nil {
+			return nil, err
+		}
+		exempt = append(exempt, re)
+	}
+	return exempt, nil
+}
+
+// with -https-redirect, redirects requests made over plain HTTP to the same
+// URL over HTTPS, unless the path matches one of the -https-redirect-exempt
+// patterns (e.g. for a load balancer's health checks). Returns false if it
+// redirected the request
+func (s *server) redirectToHTTPS(w http.ResponseWriter, r *http.Request) bool {
+	if !s.config.httpsRedirect || s.isHTTPS(r) {
+		return true
+	}
+	reqPath := strings.TrimSuffix(cleanRequestPath(r.URL.Path), "/")
+	for _, exempt := range s.config.httpsExempt {
+		if exempt.MatchString(reqPath) {
+			return true
+		}
+	}
+	target := fmt.Sprintf("https://%s%s%s", r.Host, s.config.basePath, r.URL.RequestURI())
+	if after, ok := strings.CutPrefix(s.config.canonicalURL, "https://"); ok {
+		target = "https://" + after + r.URL.RequestURI()
+	}
+	// a 308 keeps the method and body, so POSTs are redirected too
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	return false
+}
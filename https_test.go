@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exempt, err := parseHTTPSExempt([]string{"-/healthz", "public/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{basePath: "/d", trustedProxies: proxies, httpsRedirect: true, httpsExempt: exempt}}
+	for _, tt := range []struct {
+		remoteAddr string
+		proto      string
+		target     string
+		location   string
+	}{
+		{"10.0.0.1:5000", "", "/bashrc?dark", "https://example.com/d/bashrc?dark"},
+		{"10.0.0.1:5000", "https", "/bashrc", ""},
+		{"10.0.0.1:5000", "HTTPS, http", "/bashrc", ""},
+		{"10.0.0.1:5000", "http", "/bashrc", "https://example.com/d/bashrc"},
+		// only trusted from -trusted-proxies
+		{"192.0.2.1:5000", "https", "/bashrc", "https://example.com/d/bashrc"},
+		{"10.0.0.1:5000", "", "/-/healthz", ""},
+		{"10.0.0.1:5000", "", "/-/healthz/", ""},
+		{"10.0.0.1:5000", "", "/public/notes.md", ""},
+		{"10.0.0.1:5000", "", "/publicity", "https://example.com/d/publicity"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.target, nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		w := httptest.NewRecorder()
+		served := s.redirectToHTTPS(w, r)
+		if tt.location == "" {
+			if !served {
+				t.Errorf("%s from %s (%q) was redirected to %s", tt.target, tt.remoteAddr, tt.proto, w.Header().Get("Location"))
+			}
+			continue
+		}
+		if served || w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s from %s (%q) = %d %s, expected a redirect to %s", tt.target, tt.remoteAddr, tt.proto, w.Code, w.Header().Get("Location"), tt.location)
+		}
+	}
+	// with -canonical-url, redirects go there
+	s.config.canonicalURL = "https://files.example.com/d"
+	r := httptest.NewRequest(http.MethodGet, "http://10.0.0.5/bashrc", nil)
+	w := httptest.NewRecorder()
+	if s.redirectToHTTPS(w, r) || w.Header().Get("Location") != "https://files.example.com/d/bashrc" {
+		t.Errorf("redirect with -canonical-url = %s", w.Header().Get("Location"))
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{basePath: "/d", trustedProxies: proxies}}
+	for _, tt := range []struct {
+		remoteAddr string
+		proto      string
+		expected   string
+	}{
+		{"10.0.0.1:5000", "", "http://example.com/d"},
+		{"10.0.0.1:5000", "https", "https://example.com/d"},
+		// only trusted from -trusted-proxies
+		{"192.0.2.1:5000", "https", "http://example.com/d"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/d/bashrc", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if url := s.externalURL(r); url != tt.expected {
+			t.Errorf("externalURL from %s (%q) = %s, expected %s", tt.remoteAddr, tt.proto, url, tt.expected)
+		}
+	}
+}
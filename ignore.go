@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// patterns which are always ignored, before any -ignore patterns
+var defaultIgnores = [...]string{".git"}
+
+// the files/directories which aren't listed, matched or served, from
+// defaultIgnores, -ignore and -ignore-file. Set in parseFlags, before
+// any mounts are created (since -snapshot reads the files at startup),
+// and replaced when the server is reloaded
+var ignores atomic.Pointer[ignoreRules]
+
+func init() {
+	ignores.Store(mustIgnoreRules(defaultIgnores[:]))
+}
+
+// one line of a gitignore file
+type ignorePattern struct {
+	// the pattern as it was written, for errors
+	line     string
+	expr     *regexp.Regexp
+	negate   bool
+	onlyDirs bool
+}
+
+// ignore patterns with gitignore semantics, matched against paths
+// relative to the root of a mount:
+//
+//   - a pattern without a / (other than at the end) matches the name of a
+//     file/directory at any depth, else it's anchored to the root
+//   - a trailing / only matches directories
+//   - * and ? match anything (or any character) except a /, [...] matches
+//     a character class, and ** matches any number of directories
+//   - a leading ! re-includes anything matched by an earlier pattern,
+//     except for files in an ignored directory (which isn't walked)
+//   - the last pattern which matches a path decides whether it's ignored
+type ignoreRules struct {
+	patterns []*ignorePattern
+}
+
+// compiles the lines of a gitignore file, skipping blank lines and comments
+func newIgnoreRules(lines []string) (*ignoreRules, error) {
+	rules := &ignoreRules{}
+	for _, line := range lines {
+		pattern, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if pattern != nil {
+			rules.patterns = append(rules.patterns, pattern)
+		}
+	}
+	return rules, nil
+}
+
+func mustIgnoreRules(lines []string) *ignoreRules {
+	rules, err := newIgnoreRules(lines)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// the defaultIgnores, then each -ignore pattern, then the lines of the
+// -ignore-file, if it's not empty
+func loadIgnoreRules(flags multiFlag, file string) (*ignoreRules, error) {
+	lines := slices.Concat(defaultIgnores[:], flags)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read -ignore-file: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read -ignore-file: %w", err)
+		}
+	}
+	return newIgnoreRules(lines)
+}
+
+// nil for blank lines and comments
+func compileIgnorePattern(line string) (*ignorePattern, error) {
+	pattern := &ignorePattern{line: line}
+	// trailing spaces are ignored unless they're escaped
+	trimmed := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		trimmed += " "
+	}
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil, nil
+	}
+	if strings.HasPrefix(trimmed, "!") {
+		pattern.negate = true
+		trimmed = trimmed[1:]
+	}
+	if strings.HasSuffix(trimmed, "/") {
+		pattern.onlyDirs = true
+		trimmed = strings.TrimRight(trimmed, "/")
+	}
+	anchored := strings.Contains(trimmed, "/")
+	trimmed = strings.TrimPrefix(trimmed, "/")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid ignore pattern '%s'", line)
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		switch {
+		case c == '*' && strings.HasPrefix(trimmed[i:], "**/") && (i == 0 || trimmed[i-1] == '/'):
+			// any number of directories, including none
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && trimmed[i:] == "**" && (i == 0 || trimmed[i-1] == '/'):
+			// everything inside the directory
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '\\' && i+1 < len(trimmed):
+			i++
+			expr.WriteString(regexp.QuoteMeta(trimmed[i : i+1]))
+		case c == '[':
+			end := strings.Index(trimmed[i+1:], "]")
+			if end == -1 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := trimmed[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(trimmed[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	compiled, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore pattern '%s': %w", line, err)
+	}
+	pattern.expr = compiled
+	return pattern, nil
+}
+
+// whether the file/directory at p (relative to the root of a mount) is
+// ignored. Doesn't check the directories p is in, see checkIgnored
+func (r *ignoreRules) matches(p string, isDir bool) bool {
+	return r.ignoredBy(p, isDir) != nil
+}
+
+// the pattern which ignores the file/directory at p, nil if it isn't
+// ignored (or a later pattern re-includes it)
+func (r *ignoreRules) ignoredBy(p string, isDir bool) *ignorePattern {
+	var by *ignorePattern
+	for _, pattern := range r.patterns {
+		if pattern.onlyDirs && !isDir {
+			continue
+		}
+		if pattern.negate == (by != nil) && pattern.expr.MatchString(p) {
+			by = pattern
+			if pattern.negate {
+				by = nil
+			}
+		}
+	}
+	return by
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := newIgnoreRules([]string{
+		"# a comment",
+		"",
+		".git",
+		"*.swp",
+		"!keep.swp",
+		"/build/",
+		"logs/**",
+		"!logs/important.log",
+		"**/cache/*.tmp",
+		"doc/[a-c]?.md",
+		`\#literal`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		p       string
+		isDir   bool
+		ignored bool
+	}{
+		{".git", true, true},
+		{"nvim/.git", false, true},
+		{"nvim/init.vim", false, false},
+		{"a.swp", false, true},
+		{"nvim/b.swp", false, true},
+		{"nvim/keep.swp", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"nvim/build", true, false},
+		{"logs/a.log", false, true},
+		{"logs/important.log", false, false},
+		{"logs", true, false},
+		{"cache/x.tmp", false, true},
+		{"a/b/cache/x.tmp", false, true},
+		{"a/cache/x.txt", false, false},
+		{"doc/a1.md", false, true},
+		{"doc/d1.md", false, false},
+		{"#literal", false, true},
+	} {
+		if got := rules.matches(tt.p, tt.isDir); got != tt.ignored {
+			t.Errorf("matches(%q, %v) = %v, expected %v", tt.p, tt.isDir, got, tt.ignored)
+		}
+	}
+}
+
+func TestCheckIgnored(t *testing.T) {
+	for _, tt := range []struct {
+		p       string
+		ignored bool
+	}{
+		{"rc.conf", false},
+		{"sub/rc.conf", false},
+		{".git/config", true},
+		{"sub/.git/config", true},
+		{".moved", true},
+		{"sub/.moved", false},
+	} {
+		if got := checkIgnored(tt.p, false) != nil; got != tt.ignored {
+			t.Errorf("checkIgnored(%q) ignored=%v, expected %v", tt.p, got, tt.ignored)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// whether name can be the name of a file uploaded to -inbox-dir. It's
+// only ever one segment of a path, so it can't be outside of the folder
+func validInboxName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, "/\\\x00")
+}
+
+// saves the body of a PUT request to name in -inbox-dir, replacing the
+// file if it's already there (unless the request has If-None-Match: *)
+//
+// the body is written to a temporary file, which is renamed once it's
+// complete, so an interrupted upload never leaves a partial file
+func (s *server) serveInbox(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+	user, ok := s.requireAuth(w, r, opts)
+	if !ok {
+		return
+	}
+	if s.config.inboxDir == "" {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: "Uploads require running with -inbox-dir\n",
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if !validInboxName(name) {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Invalid name '%s' for an upload\n", name),
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if r.ContentLength > s.config.inboxMaxSize {
+		s.serveInboxTooLarge(w, opts)
+		return
+	}
+	dest := filepath.Join(s.config.inboxDir, name)
+	_, err := os.Stat(dest)
+	exists := err == nil
+	if exists && r.Header.Get("If-None-Match") == "*" {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("%s is already in the inbox\n", name),
+			Title:        "412 - Precondition Failed",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	tmp, err := os.CreateTemp(s.config.inboxDir, ".upload-*")
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, s.config.inboxMaxSize))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		s.serveInboxTooLarge(w, opts)
+		return
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	log.Printf("%s uploaded %s (%s) to the inbox\n", user, name, humanizeBytes(size))
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Saved %s (%s)\n", name, humanizeBytes(size)),
+		Title:        "Inbox",
+	}, s.tmpl, opts.isDark)
+}
+
+func (s *server) serveInboxTooLarge(w http.ResponseWriter, opts *requestOptions) {
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Uploads can be at most %s\n", humanizeBytes(s.config.inboxMaxSize)),
+		Title:        "413 - Request Entity Too Large",
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInbox(t *testing.T) {
+	proxies, err := parseTrustedProxies("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	s := &server{config: &config{authHeader: "X-Forwarded-User", trustedProxies: proxies, inboxDir: dir, inboxMaxSize: 10}}
+	put := func(name string, body string, headers map[string]string) int {
+		r := httptest.NewRequest(http.MethodPut, "/-/inbox/"+name, strings.NewReader(body))
+		r.Header.Set("X-Forwarded-User", "alice")
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		s.serveInbox(context.Background(), w, r, &requestOptions{}, name)
+		return w.Code
+	}
+	for _, tt := range []struct {
+		name     string
+		body     string
+		headers  map[string]string
+		expected int
+	}{
+		{"a.txt", "first", nil, http.StatusCreated},
+		{"a.txt", "second", nil, http.StatusOK},
+		{"a.txt", "third", map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+		{"big.txt", "more than ten bytes", nil, http.StatusRequestEntityTooLarge},
+		{"..", "x", nil, http.StatusBadRequest},
+		{".upload-1", "x", nil, http.StatusBadRequest},
+	} {
+		if code := put(tt.name, tt.body, tt.headers); code != tt.expected {
+			t.Errorf("PUT %s = %d, expected %d", tt.name, code, tt.expected)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "second" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	// nothing is left over from the uploads which failed
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the inbox has %d files, expected 1", len(entries))
+	}
+}
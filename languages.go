@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// a language files are counted as, by their extension or name, with the
+// colors from github/linguist
+type language struct {
+	name       string
+	color      string
+	extensions []string
+	filenames  []string
+}
+
+var languages = [...]language{
+	{"C", "#555555", []string{".c", ".h"}, nil},
+	{"C++", "#f34b7d", []string{".cpp", ".cc", ".cxx", ".hpp"}, nil},
+	{"CSS", "#563d7c", []string{".css"}, nil},
+	{"Dockerfile", "#384d54", nil, []string{"Dockerfile", "Containerfile"}},
+	{"Emacs Lisp", "#c065db", []string{".el"}, []string{".emacs"}},
+	{"Fish", "#4aae47", []string{".fish"}, nil},
+	{"Git Config", "#f44d27", nil, []string{".gitconfig", ".gitmodules", ".gitattributes"}},
+	{"Go", "#00add8", []string{".go"}, nil},
+	{"HTML", "#e34c26", []string{".html", ".htm"}, nil},
+	{"Haskell", "#5e5086", []string{".hs"}, nil},
+	{"INI", "#d1dbe0", []string{".ini", ".cfg"}, nil},
+	{"Java", "#b07219", []string{".java"}, nil},
+	{"JavaScript", "#f1e05a", []string{".js", ".mjs", ".cjs"}, nil},
+	{"JSON", "#292929", []string{".json"}, nil},
+	{"Jupyter Notebook", "#da5b0b", []string{".ipynb"}, nil},
+	{"Lua", "#000080", []string{".lua"}, nil},
+	{"Makefile", "#427819", []string{".mk"}, []string{"Makefile", "GNUmakefile"}},
+	{"Markdown", "#083fa1", []string{".md", ".markdown"}, nil},
+	{"Nix", "#7e7eff", []string{".nix"}, nil},
+	{"Perl", "#0298c3", []string{".pl", ".pm"}, nil},
+	{"Python", "#3572a5", []string{".py"}, nil},
+	{"Ruby", "#701516", []string{".rb"}, []string{"Gemfile", "Rakefile"}},
+	{"Rust", "#dea584", []string{".rs"}, nil},
+	{"SCSS", "#c6538c", []string{".scss"}, nil},
+	{"SQL", "#e38c00", []string{".sql"}, nil},
+	{"Shell", "#89e051", []string{".sh", ".bash", ".zsh"}, []string{".bashrc", ".bash_profile", ".bash_aliases", ".profile", ".zshrc", ".zshenv", ".zprofile", ".xinitrc"}},
+	{"TeX", "#3d6117", []string{".tex"}, nil},
+	{"TOML", "#9c4221", []string{".toml"}, nil},
+	{"Text", "#cccccc", []string{".txt"}, nil},
+	{"TypeScript", "#3178c6", []string{".ts", ".tsx"}, nil},
+	{"Vim Script", "#199f4b", []string{".vim"}, []string{".vimrc", ".gvimrc"}},
+	{"XML", "#0060ac", []string{".xml"}, nil},
+	{"YAML", "#cb171e", []string{".yml", ".yaml"}, nil},
+}
+
+// files which don't match any of the languages
+var otherLanguage = &language{name: "Other", color: "#4a5573"}
+
+// the language of the file with this name, otherLanguage if it's unknown
+func languageOf(name string) *language {
+	ext := strings.ToLower(path.Ext(name))
+	for i := range languages {
+		l := &languages[i]
+		for _, filename := range l.filenames {
+			if name == filename {
+				return l
+			}
+		}
+		for _, e := range l.extensions {
+			if ext == e {
+				return l
+			}
+		}
+	}
+	return otherLanguage
+}
+
+// the number of files/bytes in a language, for /-/stats/languages
+type languageStat struct {
+	Name    string  `json:"name"`
+	Color   string  `json:"color"`
+	Files   int     `json:"files"`
+	Bytes   int64   `json:"bytes"`
+	Percent float64 `json:"percent"`
+}
+
+type languageStats struct {
+	Files     int             `json:"files"`
+	Bytes     int64           `json:"bytes"`
+	Languages []*languageStat `json:"languages"`
+}
+
+// walks every mount, counting the files and bytes in each language
+func (s *server) languageStats(ctx context.Context) (*languageStats, error) {
+	stats := &languageStats{Languages: []*languageStat{}}
+	byName := make(map[string]*languageStat)
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			l := languageOf(d.Name())
+			stat, ok := byName[l.name]
+			if !ok {
+				stat = &languageStat{Name: l.name, Color: l.color}
+				byName[l.name] = stat
+				stats.Languages = append(stats.Languages, stat)
+			}
+			stat.Files++
+			stat.Bytes += info.Size()
+			stats.Files++
+			stats.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, stat := range stats.Languages {
+		if stats.Bytes > 0 {
+			stat.Percent = float64(stat.Bytes) * 100 / float64(stats.Bytes)
+		}
+	}
+	// most bytes first, with Other last
+	sort.Slice(stats.Languages, func(i, j int) bool {
+		a, b := stats.Languages[i], stats.Languages[j]
+		if (a.Name == otherLanguage.name) != (b.Name == otherLanguage.name) {
+			return b.Name == otherLanguage.name
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Name < b.Name
+	})
+	return stats, nil
+}
+
+// the stats as a bar, with a row for each language below it
+func (stats *languageStats) html() template.HTML {
+	var b strings.Builder
+	b.WriteString("<div class=\"languages\">\n<div class=\"bar\">")
+	for _, stat := range stats.Languages {
+		fmt.Fprintf(&b, "<span style=\"width: %.2f%%; background-color: %s\" title=\"%s %.1f%%\"></span>", stat.Percent, stat.Color, html.EscapeString(stat.Name), stat.Percent)
+	}
+	b.WriteString("</div>\n<table>\n")
+	for _, stat := range stats.Languages {
+		fmt.Fprintf(&b, "<tr><td><span class=\"color\" style=\"background-color: %s\"></span>%s</td><td>%.1f%%</td><td>%s</td><td class=\"count\">%d file%s</td></tr>\n", stat.Color, html.EscapeString(stat.Name), stat.Percent, humanizeBytes(stat.Bytes), stat.Files, plural(stat.Files))
+	}
+	b.WriteString("</table>\n</div>\n")
+	return template.HTML(b.String())
+}
+
+// responds with the number of files/bytes in each language, as a bar
+// chart in the ?dark view, or a JSON object if ?json is passed
+func (s *server) serveLanguages(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	done := timingsFrom(ctx).track("walk")
+	stats, err := s.languageStats(ctx)
+	done()
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	setSurrogateKeys(w, "", true)
+	if hasQueryParam(r.URL.Query(), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	var lines strings.Builder
+	for _, stat := range stats.Languages {
+		fmt.Fprintf(&lines, "%5.1f%%  %-20s %10s  %d file%s\n", stat.Percent, stat.Name, humanizeBytes(stat.Bytes), stat.Files, plural(stat.Files))
+	}
+	info := &PageInfo{
+		PageContents: lines.String(),
+		Title:        "Languages",
+	}
+	if opts.isDark {
+		info.Rendered = stats.html()
+	}
+	render(&w, info, s.tmpl, opts.isDark)
+}
+
+// "s" unless n is 1
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a 1-indexed, inclusive range of lines, parsed from ?lines=
+//
+// end is 0 if the range is open-ended (e.g. ?lines=100-)
+type lineRange struct {
+	start int
+	end   int
+}
+
+// parses 100-200, 100- or 100 (just that line)
+func parseLineRange(value string) (*lineRange, error) {
+	value = strings.TrimSpace(value)
+	startStr, endStr := value, value
+	if i := strings.IndexByte(value, '-'); i != -1 {
+		startStr, endStr = value[:i], value[i+1:]
+	}
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 1 {
+		return nil, fmt.Errorf("invalid line range '%s', expected something like 100-200", value)
+	}
+	lr := &lineRange{start: start}
+	if endStr != "" {
+		end, err := strconv.Atoi(endStr)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid line range '%s', expected something like 100-200", value)
+		}
+		lr.end = end
+	}
+	return lr, nil
+}
+
+// returns the lines in the range, and the total number of lines in contents
+//
+// a trailing newline at the end of the file doesn't count as another line
+func (lr *lineRange) slice(contents string) (string, int) {
+	if contents == "" {
+		return "", 0
+	}
+	lines := strings.SplitAfter(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	total := len(lines)
+	if lr.start > total {
+		return "", total
+	}
+	end := total
+	if lr.end != 0 && lr.end < total {
+		end = lr.end
+	}
+	return strings.Join(lines[lr.start-1:end], ""), total
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the sha256 of each file, keyed by the path and the metadata of
+// the file, so files are only hashed again when they change
+type hashCache struct {
+	mu     sync.Mutex
+	hashes map[string]string
+	counts cacheCounts
+}
+
+func hashKey(p string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", p, size, modTime.UnixNano())
+}
+
+func (c *hashCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.hashes[key]
+	c.counts.count(ok)
+	return hash, ok
+}
+
+// replaces the cached hashes with the ones from the latest
+// manifest, so hashes of files which changed are dropped
+func (c *hashCache) replace(hashes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes = hashes
+}
+
+// a file in the manifest
+type manifestEntry struct {
+	// path of the file, like /-/raw/<path>
+	path   string
+	sha256 string
+}
+
+// hashes every file which is served (from each mount)
+//
+// files which can't be read are skipped, like in /-/mirror.tar.gz
+func (s *server) manifest(ctx context.Context) ([]manifestEntry, error) {
+	entries := []manifestEntry{}
+	hashes := make(map[string]string)
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			if s.checkPrivate(ctx, m, p) != nil {
+				return nil
+			}
+			full := mountPath(m, p)
+			info, err := d.Info()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			key := hashKey(full, info.Size(), info.ModTime())
+			hash, ok := s.hashes.get(key)
+			if !ok {
+				done := timingsFrom(ctx).track("read")
+				data, info, err := readFileInfo(ctx, m.src, p)
+				done()
+				if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				sum := sha256.Sum256(data)
+				hash = hex.EncodeToString(sum[:])
+				key = hashKey(full, info.Size(), info.ModTime())
+			}
+			hashes[key] = hash
+			entries = append(entries, manifestEntry{path: full, sha256: hash})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.hashes.replace(hashes)
+	return entries, nil
+}
+
+// responds with the sha256 and path of every file, one per line (like
+// sha256sum), or a JSON object of path -> sha256, if ?json is passed
+func (s *server) serveManifest(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	entries, err := s.manifest(ctx)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	s.setCacheHeadersAll(w)
+	if hasQueryParam(r.URL.Query(), "json") {
+		hashes := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			hashes[entry.path] = entry.sha256
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hashes)
+		return
+	}
+	var lines strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&lines, "%s  %s\n", entry.sha256, entry.path)
+	}
+	render(&w, &PageInfo{
+		PageContents: lines.String(),
+		Title:        "Manifest",
+	}, s.tmpl, opts.isDark)
+}
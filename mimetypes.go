@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// file in the root of a mount, with a 'pattern type' line for each kind
+// of file whose plaintext responses have that Content-Type
+const mimeTypesFile = ".mimetypes"
+
+// a line in a .mimetypes file (or -mimetypes-file)
+type mimeTypeRule struct {
+	// an extension (e.g. .conf), matched case-insensitively, else a
+	// pattern where * matches anything
+	ext     string
+	pattern *regexp.Regexp
+	// the Content-Type, empty if the line only sets the charset, and the
+	// charset, empty if it's whatever contentType has
+	contentType string
+	charset     string
+}
+
+func (rule *mimeTypeRule) matches(p string) bool {
+	if rule.ext != "" {
+		return strings.EqualFold(path.Ext(p), rule.ext)
+	}
+	return rule.pattern.MatchString(p)
+}
+
+// parses the lines of a .mimetypes file, e.g.
+//
+//	.conf      text/plain; charset=utf-8
+//	systemd/*  text/plain
+//	*.txt      charset=iso-8859-1
+func parseMimeTypes(data string) ([]*mimeTypeRule, error) {
+	rules := []*mimeTypeRule{}
+	for lineNo, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i == -1 {
+			return nil, fmt.Errorf("line %d: expected 'pattern type', got '%s'", lineNo+1, line)
+		}
+		pattern, value := line[:i], strings.TrimSpace(line[i+1:])
+		rule := &mimeTypeRule{}
+		if strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern, "*/") {
+			rule.ext = pattern
+		} else {
+			re, err := compilePathPattern("mimetypes", pattern)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo+1, err)
+			}
+			rule.pattern = re
+		}
+		if charset, ok := strings.CutPrefix(value, "charset="); ok {
+			if rule.charset = strings.TrimSpace(charset); rule.charset == "" {
+				return nil, fmt.Errorf("line %d: empty charset", lineNo+1)
+			}
+		} else {
+			mediaType, params, err := mime.ParseMediaType(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid type '%s': %w", lineNo+1, value, err)
+			}
+			rule.contentType = mime.FormatMediaType(mediaType, params)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// loads -mimetypes-file, where patterns are matched against the path of
+// the file starting with the mount name, like -private
+func loadMimeTypes(mimeTypesFile string) ([]*mimeTypeRule, error) {
+	data, err := os.ReadFile(mimeTypesFile)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseMimeTypes(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mimetypes-file '%s': %w", mimeTypesFile, err)
+	}
+	return rules, nil
+}
+
+// parses the .mimetypes file in the root of src, returns nil if there
+// isn't one. If it's invalid, it's logged and ignored
+func readMimeTypes(ctx context.Context, src source) []*mimeTypeRule {
+	data, err := readFile(ctx, src, mimeTypesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Could not read %s in %s: %s\n", mimeTypesFile, src, err)
+		return nil
+	}
+	rules, err := parseMimeTypes(string(data))
+	if err != nil {
+		log.Printf("Invalid %s in %s, %s\n", mimeTypesFile, src, err)
+		return nil
+	}
+	return rules
+}
+
+// the first rule which matches p
+func findMimeType(rules []*mimeTypeRule, p string) *mimeTypeRule {
+	for _, rule := range rules {
+		if rule.matches(p) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// for plaintext responses of the file at p in the mount, replaces the
+// Content-Type with the one for the first line in the .mimetypes file
+// of the mount which matches it, else the first one in -mimetypes-file
+func (s *server) setMimeType(ctx context.Context, w http.ResponseWriter, m *mount, p string, data []byte) {
+	rule := findMimeType(readMimeTypes(ctx, m.src), p)
+	if rule == nil {
+		rule = findMimeType(s.mimeTypes, privatePath(m, p))
+	}
+	if rule == nil {
+		return
+	}
+	contentType := rule.contentType
+	if contentType == "" {
+		// what it would've been served as
+		if contentType = w.Header().Get("Content-Type"); contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+	}
+	if rule.charset != "" {
+		if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+			params["charset"] = rule.charset
+			contentType = mime.FormatMediaType(mediaType, params)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseMimeTypes(t *testing.T) {
+	rules, err := parseMimeTypes("# comment\n.CONF text/plain; charset=utf-8\nsystemd/*\ttext/x-systemd-unit\n*.txt charset=iso-8859-1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path        string
+		contentType string
+		charset     string
+	}{
+		{"nginx/site.conf", "text/plain; charset=utf-8", ""},
+		{"systemd/user/x.service", "text/x-systemd-unit", ""},
+		{"notes/a.txt", "", "iso-8859-1"},
+		{"a.conf.bak", "", ""},
+	} {
+		rule := findMimeType(rules, tt.path)
+		var contentType, charset string
+		if rule != nil {
+			contentType, charset = rule.contentType, rule.charset
+		}
+		if contentType != tt.contentType || charset != tt.charset {
+			t.Errorf("%s has type %q, charset %q, expected %q, %q", tt.path, contentType, charset, tt.contentType, tt.charset)
+		}
+	}
+	for _, invalid := range []string{".conf", ".conf text/", "*.txt charset="} {
+		if _, err := parseMimeTypes(invalid); err == nil {
+			t.Errorf("parseMimeTypes(%q) didn't return an error", invalid)
+		}
+	}
+}
+
+func TestSetMimeTypeCharset(t *testing.T) {
+	rules, _ := parseMimeTypes("*.txt charset=iso-8859-1\n")
+	// a folder without a .mimetypes file
+	src, err := newLocalSource(t.TempDir(), "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{mimeTypes: rules}
+	w := httptest.NewRecorder()
+	s.setMimeType(t.Context(), w, &mount{src: src}, "a.txt", []byte("caf\xe9\n"))
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=iso-8859-1" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
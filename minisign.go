@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// signs files with -minisign-key for ?sig, in the format minisign
+// (https://jedisct1.github.io/minisign/) verifies
+type minisignKey struct {
+	// minisign generates a random key id, this is derived from the
+	// public key instead, so it's the same every time the key is loaded
+	id   [8]byte
+	priv ed25519.PrivateKey
+}
+
+// reads an Ed25519 private key from a PEM file, e.g.
+// from 'openssl genpkey -algorithm ed25519'
+func loadMinisignKey(file string) (*minisignKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read minisign key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("could not parse minisign key in '%s', expected a PEM file", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse minisign key in '%s': %w", file, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("minisign key in '%s' is not an Ed25519 key", file)
+	}
+	k := &minisignKey{priv: priv}
+	sum := blake2b.Sum256(priv.Public().(ed25519.PublicKey))
+	copy(k.id[:], sum[:8])
+	return k, nil
+}
+
+// the key id, like minisign displays it
+func (k *minisignKey) keyID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id[:]))
+}
+
+// the public key, in the format of a minisign .pub file
+func (k *minisignKey) publicKey() string {
+	blob := append([]byte("Ed"), k.id[:]...)
+	blob = append(blob, k.priv.Public().(ed25519.PublicKey)...)
+	return fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", k.keyID(), base64.StdEncoding.EncodeToString(blob))
+}
+
+// a (prehashed) minisign signature of data, in the format of a .minisig
+// file. The trusted comment is signed too, so it can't be changed
+func (k *minisignKey) sign(data []byte, trustedComment string) string {
+	hash := blake2b.Sum512(data)
+	sig := ed25519.Sign(k.priv, hash[:])
+	blob := append([]byte("ED"), k.id[:]...)
+	blob = append(blob, sig...)
+	global := ed25519.Sign(k.priv, append(sig, trustedComment...))
+	return fmt.Sprintf("untrusted comment: signature from subpath-serve key %s\n%s\ntrusted comment: %s\n%s\n",
+		k.keyID(), base64.StdEncoding.EncodeToString(blob), trustedComment, base64.StdEncoding.EncodeToString(global))
+}
+
+// responds with that signing requires -minisign-key
+func (s *server) serveNoMinisignKey(w http.ResponseWriter, reqPath string, opts *requestOptions) {
+	w.WriteHeader(http.StatusNotFound)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("%s requires running with -minisign-key\n", reqPath),
+		Title:        "404 - Not Found",
+	}, s.tmpl, opts.isDark)
+}
+
+// responds with the signature of the file at p in the mount, for ?sig.
+// The trusted comment includes the modification time and path of the file
+func (s *server) serveSignature(w http.ResponseWriter, opts *requestOptions, m *mount, p string, data []byte, info fs.FileInfo) {
+	if s.minisignKey == nil {
+		s.serveNoMinisignKey(w, "?sig", opts)
+		return
+	}
+	full := mountPath(m, p)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Filepath", p)
+	s.setCacheHeaders(w, m, p, false)
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", info.ModTime().Unix(), strings.ReplaceAll(full, "\n", " "))
+	fmt.Fprint(w, s.minisignKey.sign(data, comment))
+}
+
+// responds with the public key for -minisign-key, to verify ?sig with
+func (s *server) servePubkey(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.minisignKey == nil {
+		s.serveNoMinisignKey(w, "-/pubkey", opts)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, s.minisignKey.publicKey())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// verifies the signature like minisign -V does
+func TestMinisignSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadMinisignKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubLines := strings.Split(k.publicKey(), "\n")
+	pubBlob, err := base64.StdEncoding.DecodeString(pubLines[1])
+	if err != nil || len(pubBlob) != 42 || string(pubBlob[:2]) != "Ed" {
+		t.Fatalf("invalid public key %q", pubLines[1])
+	}
+	pub := ed25519.PublicKey(pubBlob[10:])
+
+	data := []byte("#!/bin/sh\necho hello\n")
+	lines := strings.Split(k.sign(data, "timestamp:0\tfile:install.sh\thashed"), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "untrusted comment: ") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		t.Fatalf("invalid signature %q", lines)
+	}
+	blob, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(blob) != 74 || string(blob[:2]) != "ED" {
+		t.Fatalf("invalid signature %q", lines[1])
+	}
+	if !bytes.Equal(blob[2:10], pubBlob[2:10]) {
+		t.Errorf("key id of the signature doesn't match the public key")
+	}
+	hash := blake2b.Sum512(data)
+	if !ed25519.Verify(pub, hash[:], blob[10:]) {
+		t.Errorf("signature doesn't verify")
+	}
+	hash = blake2b.Sum512([]byte("#!/bin/sh\necho goodbye\n"))
+	if ed25519.Verify(pub, hash[:], blob[10:]) {
+		t.Errorf("signature verifies for a different file")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(pub, append(blob[10:], comment...), global) {
+		t.Errorf("trusted comment doesn't verify")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limits how often each client can download the mirror
+type mirrorLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	// when each client last started a download
+	last map[string]time.Time
+}
+
+func newMirrorLimiter(interval time.Duration) *mirrorLimiter {
+	return &mirrorLimiter{interval: interval, last: make(map[string]time.Time)}
+}
+
+// returns how long the client has to wait before it can download
+// the mirror again, or 0 if it can (and records the download)
+func (l *mirrorLimiter) wait(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	// drop clients which can download again, so this doesn't grow forever
+	for c, t := range l.last {
+		if now.Sub(t) >= l.interval {
+			delete(l.last, c)
+		}
+	}
+	if t, ok := l.last[client]; ok {
+		return l.interval - now.Sub(t)
+	}
+	l.last[client] = now
+	return 0
+}
+
+// the IP address a request is from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// streams every file which is served (from each mount) as a .tar.gz
+//
+// ignored files, files which can't be read (or were removed while walking),
+// private files (unless the request is authenticated) and files larger
+// than -max-file-size are skipped.
+// Files from -mounts are under a directory with the name of the mount
+//
+// with -archive-cache-dir, it's served from the last mirror which was
+// built instead, see serveCachedMirror
+func (s *server) serveMirror(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.config.archiveCacheDir != "" {
+		s.serveCachedMirror(ctx, w, r, opts)
+		return
+	}
+	if !s.allowMirror(w, r, opts) {
+		return
+	}
+	release, err := s.archives.acquire(ctx)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	defer release()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="mirror.tar.gz"`)
+	s.setCacheHeadersAll(w)
+	// the response has already started, so the status can't be changed
+	if err := s.writeMirror(ctx, w); err != nil {
+		log.Printf("Error while streaming mirror for %s: %s\n", r.URL.RequestURI(), err)
+	}
+}
+
+// counts a download of the mirror against -mirror-rate-limit. Responds
+// with a 429 and returns false if the client downloaded it too recently
+func (s *server) allowMirror(w http.ResponseWriter, r *http.Request, opts *requestOptions) bool {
+	if s.mirrorLimiter == nil {
+		return true
+	}
+	wait := s.mirrorLimiter.wait(clientIP(r))
+	if wait <= 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Already downloaded the mirror recently, try again in %s\n", wait.Round(time.Second)),
+		Title:        "429 - Too Many Requests",
+	}, s.tmpl, opts.isDark)
+	return false
+}
+
+// whether the request resumes a download of the archive with the ETag
+// (or checks its size), rather than downloading all of it again: a HEAD
+// request, or a Range request whose If-Range is the ETag, or (without an
+// If-Range) whose ranges all start after the first byte
+func resumesDownload(r *http.Request, etag string) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	header := r.Header.Get("Range")
+	if header == "" {
+		return false
+	}
+	// a different If-Range gets the whole archive
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		return ifRange == etag
+	}
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return false
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		start, _, _ := strings.Cut(strings.TrimSpace(spec), "-")
+		// suffix ranges (e.g. -500) can be the whole archive too
+		if n, err := strconv.ParseInt(start, 10, 64); err != nil || n <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writes every file the request can read to w, as a .tar.gz
+func (s *server) writeMirror(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			if s.checkPrivate(ctx, m, p) != nil {
+				return nil
+			}
+			// files larger than -max-file-size are skipped
+			if err := s.checkFileSize(ctx, m, p); errors.Is(err, ErrTooLarge) {
+				return nil
+			}
+			done := timingsFrom(ctx).track("read")
+			data, info, err := readFileInfo(ctx, m.src, p)
+			done()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			data = s.redact(data)
+			if err := tw.WriteHeader(s.tarHeader(mountPath(m, p), info, int64(len(data)))); err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// the header for a file in a .tar.gz archive. It keeps the permissions
+// of the file, so scripts are still executable once it's extracted, and
+// is owned by -archive-uid/-archive-gid
+func (s *server) tarHeader(name string, info fs.FileInfo, size int64) *tar.Header {
+	return &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Uid:     s.config.archiveUID,
+		Gid:     s.config.archiveGID,
+		Size:    size,
+		ModTime: info.ModTime(),
+	}
+}
+
+// a hash of the path, size, modification time and permissions of every
+// file in the mirror for the request (and who they're owned by), which
+// changes when any of them do
+func (s *server) mirrorKey(ctx context.Context) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%d\n", s.config.archiveUID, s.config.archiveGID)
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			if s.checkPrivate(ctx, m, p) != nil {
+				return nil
+			}
+			info, err := d.Info()
+			// the file was removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00%o\n", mountPath(m, p), info.Size(), info.ModTime().UnixNano(), info.Mode().Perm())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32], nil
+}
+
+// with -archive-cache-dir, the mirror is built into a file there the first
+// time it's requested, keyed by mirrorKey, and served from it until a file
+// changes. That way it has a Content-Length (for HEAD requests too), and
+// an interrupted download can be resumed with a Range request
+//
+// resuming a download of the mirror which is already built (or checking
+// its size) doesn't count against -mirror-rate-limit. Anything else does,
+// including every request which would build it
+func (s *server) serveCachedMirror(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	key, err := s.mirrorKey(ctx)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	f, err := os.Open(s.mirrorPath(key))
+	if err != nil || !resumesDownload(r, `"`+key+`"`) {
+		if err == nil {
+			f.Close()
+		}
+		if !s.allowMirror(w, r, opts) {
+			return
+		}
+		// requests which start at once share one build
+		v, err := s.lookups.do(ctx, "mirror\x00"+key, func(ctx context.Context) (interface{}, error) {
+			return s.buildMirror(ctx, key)
+		})
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		if f, err = os.Open(v.(string)); err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="mirror.tar.gz"`)
+	w.Header().Set("ETag", `"`+key+`"`)
+	s.setCacheHeadersAll(w)
+	http.ServeContent(w, r, "mirror.tar.gz", time.Time{}, f)
+}
+
+// builds the mirror with the key into -archive-cache-dir, unless it's
+// already there, and returns its path. Only the last one which was built
+// is kept
+func (s *server) buildMirror(ctx context.Context, key string) (string, error) {
+	p := s.mirrorPath(key)
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+	release, err := s.archives.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	tmp, err := os.CreateTemp(s.config.archiveCacheDir, ".mirror-*.tar.gz")
+	if err != nil {
+		return "", fmt.Errorf("could not create mirror in -archive-cache-dir: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = s.writeMirror(ctx, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("could not save mirror in -archive-cache-dir: %w", err)
+	}
+	// downloads of the previous mirror which are in progress keep reading it
+	previous, _ := filepath.Glob(filepath.Join(s.config.archiveCacheDir, "mirror-*.tar.gz"))
+	for _, old := range previous {
+		if old != p {
+			os.Remove(old)
+		}
+	}
+	return p, nil
+}
+
+// where the mirror with the key is built in -archive-cache-dir
+func (s *server) mirrorPath(key string) string {
+	return filepath.Join(s.config.archiveCacheDir, "mirror-"+key+".tar.gz")
+}
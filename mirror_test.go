@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirrorPermissions(t *testing.T) {
+	dir := t.TempDir()
+	for name, perm := range map[string]fs.FileMode{"install.sh": 0o755, "notes.txt": 0o644} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), perm); err != nil {
+			t.Fatal(err)
+		}
+		// regardless of the umask
+		if err := os.Chmod(filepath.Join(dir, name), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the permissions are kept in snapshots too
+	snap, err := newSnapshotSource(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := newPrivateFiles(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []source{local, snap} {
+		s := &server{config: &config{mounts: []*mount{{src: src}}, archiveUID: 1000, archiveGID: 100}, private: private, dynamic: &dynamicFiles{}}
+		var archive bytes.Buffer
+		if err := s.writeMirror(context.Background(), &archive); err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(&archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		modes := make(map[string]int64)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			modes[header.Name] = header.Mode
+			if header.Uid != 1000 || header.Gid != 100 {
+				t.Errorf("%s in the mirror of %s is owned by %d:%d, expected 1000:100", header.Name, src, header.Uid, header.Gid)
+			}
+		}
+		if modes["install.sh"] != 0o755 || modes["notes.txt"] != 0o644 {
+			t.Errorf("modes in the mirror of %s = %o and %o, expected 755 and 644", src, modes["install.sh"], modes["notes.txt"])
+		}
+	}
+}
+
+func TestMirrorRateLimit(t *testing.T) {
+	dir := fixtureFolder(t)
+	s := newTestServer(t, dir, []string{"-folder", "{dir}", "-archive-cache-dir", t.TempDir(), "-mirror-rate-limit", "1h"})
+	etag := ""
+	for _, tt := range []struct {
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		// the mirror isn't built yet, so even a resumed download counts
+		{"GET", map[string]string{"Range": "bytes=10-"}, 206},
+		{"GET", nil, 429},
+		{"GET", map[string]string{"Range": "bytes=10-"}, 206},
+		{"HEAD", nil, 200},
+		{"GET", map[string]string{"Range": "bytes=0-"}, 429},
+		{"GET", map[string]string{"Range": "bytes=10-,0-"}, 429},
+		{"GET", map[string]string{"Range": "bytes=-100000"}, 429},
+		{"GET", map[string]string{"Range": "bytes=0-", "If-Range": "{etag}"}, 206},
+		{"GET", map[string]string{"Range": "bytes=10-", "If-Range": `"previous"`}, 429},
+	} {
+		r := httptest.NewRequest(tt.method, "/-/mirror.tar.gz", nil)
+		for name, value := range tt.headers {
+			r.Header.Set(name, strings.ReplaceAll(value, "{etag}", etag))
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s with %v = %d, expected %d", tt.method, tt.headers, w.Code, tt.status)
+		}
+		if etag == "" {
+			etag = w.Header().Get("ETag")
+		}
+	}
+}
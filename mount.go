@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// a folder which files are served from
+//
+// if name is empty, this is the -folder (or -backend), served at the root
+// else, this is served under /name/
+type mount struct {
+	name       string
+	src        source
+	repoPrefix string
+	// like repoPrefix, for the raw contents of the file (e.g.
+	// https://raw.githubusercontent.com/user/repo/master), for ?redirect=raw
+	rawPrefix string
+	// capitalized hostname of repoPrefix, displayed in the footer
+	prefixName string
+	// the branches/tags served under @<ref>/, nil unless running with -git-refs
+	refs *gitRefs
+	// for the mount of a ref, the mount it's a ref of
+	parent *mount
+	// the git submodules in the folder, deepest first. Only read if the
+	// mount has a repoPrefix or rawPrefix, since they're only for links
+	submodules []*submodule
+}
+
+// a flag which can be passed multiple times
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ", ")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// splits a name=value flag
+func splitNameValue(flagName string, value string) (string, string) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		log.Fatalf("Error: Expected name=value for -%s, got '%s'\n", flagName, value)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// location is a local folder, or a backend URL like s3://bucket/prefix
+func newMount(name string, location string, repoPrefix string, rawPrefix string, opts *sourceOptions) *mount {
+	src, err := newSource(location, opts)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	return &mount{
+		name:       name,
+		src:        src,
+		repoPrefix: repoPrefix,
+		rawPrefix:  rawPrefix,
+		prefixName: capitalize(getDomainName(repoPrefix)),
+	}
+}
+
+// the URL of the file at p (relative to the mount) with the prefix: p
+// replaces {path} in it, or is appended to it if it doesn't have one
+func prefixURL(prefix string, p string) string {
+	if strings.Contains(prefix, "{path}") {
+		return strings.ReplaceAll(prefix, "{path}", p)
+	}
+	return prefix + "/" + p
+}
+
+// the reverse of prefixURL, the path of the file (relative to the mount)
+// which the URL is for, if it's the prefix with a path. The #fragment
+// and ?query are ignored, unless the query is part of the prefix
+func prefixPath(prefix string, fileURL string) (string, bool) {
+	fileURL, _, _ = strings.Cut(fileURL, "#")
+	before, after, ok := strings.Cut(prefix, "{path}")
+	if !ok {
+		before, after = prefix+"/", ""
+	}
+	if !strings.Contains(after, "?") {
+		fileURL, _, _ = strings.Cut(fileURL, "?")
+	}
+	if len(fileURL) < len(before)+len(after) || !strings.HasPrefix(fileURL, before) || !strings.HasSuffix(fileURL, after) {
+		return "", false
+	}
+	p, err := url.PathUnescape(fileURL[len(before) : len(fileURL)-len(after)])
+	if err != nil {
+		return "", false
+	}
+	p = path.Clean("/" + p)[1:]
+	return p, p != ""
+}
+
+// parses a -mount-git-*-prefix flag, the prefix for each mount by its name
+func parseMountPrefixes(flagName string, values multiFlag) map[string]string {
+	prefixes := make(map[string]string)
+	for _, value := range values {
+		name, prefix := splitNameValue(flagName, value)
+		prefixes[name] = prefix
+	}
+	return prefixes
+}
+
+// parses the -mount, -mount-git-http-prefix and -mount-git-raw-prefix flags
+func parseMounts(mountFlags multiFlag, prefixFlags multiFlag, rawPrefixFlags multiFlag, opts *sourceOptions) []*mount {
+	prefixes := parseMountPrefixes("mount-git-http-prefix", prefixFlags)
+	rawPrefixes := parseMountPrefixes("mount-git-raw-prefix", rawPrefixFlags)
+	mounts := []*mount{}
+	seen := make(map[string]bool)
+	for _, value := range mountFlags {
+		name, location := splitNameValue("mount", value)
+		name = strings.Trim(name, "/")
+		if name == "" || strings.Contains(name, "/") {
+			log.Fatalf("Error: Mount name '%s' can't contain a '/'\n", name)
+		}
+		if seen[name] {
+			log.Fatalf("Error: Mount name '%s' was specified more than once\n", name)
+		}
+		seen[name] = true
+		mounts = append(mounts, newMount(name, location, prefixes[name], rawPrefixes[name], opts))
+	}
+	for name := range prefixes {
+		if !seen[name] {
+			log.Fatalf("Error: -mount-git-http-prefix specified for '%s', but there is no -mount with that name\n", name)
+		}
+	}
+	for name := range rawPrefixes {
+		if !seen[name] {
+			log.Fatalf("Error: -mount-git-raw-prefix specified for '%s', but there is no -mount with that name\n", name)
+		}
+	}
+	return mounts
+}
+
+// returns the mount this request path is for, and the query relative to that mount
+//
+// returns nil if the path doesn't belong to any mount
+func matchMount(mounts []*mount, reqPath string) (*mount, string) {
+	for _, m := range mounts {
+		if m.name == "" {
+			return m, reqPath
+		}
+		if reqPath == m.name || strings.HasPrefix(reqPath, m.name+"/") {
+			return m, strings.TrimPrefix(reqPath[len(m.name):], "/")
+		}
+	}
+	return nil, reqPath
+}
+
+// like matchMount, but if the query starts with @<ref> and the mount
+// serves git refs, returns the mount for the files at that ref
+//
+// returns nil if the ref doesn't exist
+func resolveMount(ctx context.Context, mounts []*mount, reqPath string) (*mount, string, error) {
+	m, query := matchMount(mounts, reqPath)
+	if m == nil || m.refs == nil || !strings.HasPrefix(query, "@") {
+		return m, query, nil
+	}
+	ref, rest, _ := strings.Cut(query[1:], "/")
+	refMount, err := m.refs.mount(ctx, m, ref)
+	if err != nil || refMount == nil {
+		return nil, query, err
+	}
+	return refMount, rest, nil
+}
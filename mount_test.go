@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrefixURL(t *testing.T) {
+	for _, tt := range []struct {
+		prefix   string
+		expected string
+	}{
+		{"https://github.com/user/repo/blob/master", "https://github.com/user/repo/blob/master/nvim/init.lua"},
+		{"https://gitlab.com/user/repo/-/raw/master/{path}?inline=false", "https://gitlab.com/user/repo/-/raw/master/nvim/init.lua?inline=false"},
+	} {
+		if got := prefixURL(tt.prefix, "nvim/init.lua"); got != tt.expected {
+			t.Errorf("prefixURL(%q) = %q, expected %q", tt.prefix, got, tt.expected)
+		}
+	}
+}
+
+func FuzzMatchMount(f *testing.F) {
+	mounts := []*mount{{name: "notes"}, {name: "dotfiles"}}
+	for _, p := range []string{"", "notes", "notes/", "notes/todo.md", "notesx/todo.md", "dotfiles/nvim/init.lua", "other"} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		reqPath := cleanRequestPath(p)
+		m, query := matchMount(mounts, reqPath)
+		if m == nil {
+			if query != reqPath {
+				t.Fatalf("matchMount(%q) matched no mount, but returned %q", reqPath, query)
+			}
+			return
+		}
+		if reqPath != m.name && !strings.HasPrefix(reqPath, m.name+"/") {
+			t.Fatalf("matchMount(%q) matched the mount %q", reqPath, m.name)
+		}
+		// the query is looked up relative to the root of the mount
+		if strings.HasPrefix(query, "/") || !strings.HasSuffix(reqPath, query) {
+			t.Fatalf("matchMount(%q) = %q, %q", reqPath, m.name, query)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// file in the root of a mount, with an 'oldpath -> newpath' line for each moved file
+const movedFile = ".moved"
+
+// a file (or directory, if both paths end with a '/') which was moved
+type move struct {
+	from string
+	to   string
+	dir  bool
+}
+
+// parses the .moved file in the root of src, returns nil if there isn't one
+//
+// invalid lines are logged and skipped, so a typo doesn't break every other redirect
+func readMoves(ctx context.Context, src source) ([]move, error) {
+	data, err := readFile(ctx, src, movedFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	moves := []move{}
+	for lineNo, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(line, "->")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		fromDir, toDir := strings.HasSuffix(from, "/"), strings.HasSuffix(to, "/")
+		from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+		if !ok || from == "" || to == "" || fromDir != toDir {
+			log.Printf("Invalid line %d in %s in %s, expected 'oldpath -> newpath'\n", lineNo+1, movedFile, src)
+			continue
+		}
+		moves = append(moves, move{from: from, to: to, dir: fromDir})
+	}
+	return moves, nil
+}
+
+// whether query matches the old path of a file (or directory, if dir is
+// true) which was moved/removed, or is under that directory. Returns the
+// part of the query under the directory
+//
+// the old path is matched like the path of a file would be, i.e. the
+// query is a suffix of it
+func matchesOldPath(old string, dir bool, query string) (string, bool) {
+	if matchesQuery(old, path.Base(old), query) {
+		return "", true
+	}
+	if !dir {
+		return "", false
+	}
+	// a file under the directory, the part of the query
+	// before some '/' has to match the directory
+	for i, c := range query {
+		if c == '/' && matchesQuery(old, path.Base(old), query[:i]) {
+			return query[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// the new path for query (which didn't match any file), if it
+// matches the old path of a file, or is under a moved directory.
+// The first matching line is used
+func movedPath(moves []move, query string) (string, bool) {
+	for _, mv := range moves {
+		rest, ok := matchesOldPath(mv.from, mv.dir, query)
+		if !ok {
+			continue
+		}
+		if rest == "" {
+			return mv.to, true
+		}
+		return mv.to + "/" + rest, true
+	}
+	return "", false
+}
+
+// the URL to redirect to for a query which didn't match any file in the mount,
+// from the .moved file. Returns false if the query wasn't moved
+func (s *server) movedURL(ctx context.Context, r *http.Request, m *mount, query string) (string, bool, error) {
+	moves, err := readMoves(ctx, m.src)
+	if err != nil || len(moves) == 0 {
+		return "", false, err
+	}
+	to, ok := movedPath(moves, query)
+	if !ok {
+		return "", false, nil
+	}
+	target := s.config.basePath + "/" + mountPath(m, to)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		target += "/"
+	}
+	target = (&url.URL{Path: target}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	return target, true, nil
+}
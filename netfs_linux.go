@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// filesystems (from the magic number statfs returns) where inotify only gets
+// events for changes made on this machine, so changes have to be polled for
+var networkFilesystems = map[uint32]string{
+	unix.NFS_SUPER_MAGIC:  "nfs",
+	unix.SMB_SUPER_MAGIC:  "smb",
+	unix.SMB2_SUPER_MAGIC: "smb2",
+	unix.CIFS_SUPER_MAGIC: "cifs",
+	unix.AFS_SUPER_MAGIC:  "afs",
+	unix.CODA_SUPER_MAGIC: "coda",
+	// e.g. sshfs, rclone
+	unix.FUSE_SUPER_MAGIC: "fuse",
+}
+
+// the name of the network filesystem path is on, or an empty string
+func networkFilesystem(path string) string {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return ""
+	}
+	return networkFilesystems[uint32(stat.Type)]
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// the name of the network filesystem path is on, or an empty string.
+// Network filesystems are only detected on linux
+func networkFilesystem(path string) string {
+	return ""
+}
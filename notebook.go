@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// a Jupyter notebook (.ipynb), only the fields which are displayed
+type notebook struct {
+	Cells []struct {
+		CellType       string           `json:"cell_type"`
+		Source         notebookText     `json:"source"`
+		ExecutionCount *int             `json:"execution_count"`
+		Outputs        []notebookOutput `json:"outputs"`
+	} `json:"cells"`
+}
+
+type notebookOutput struct {
+	OutputType string `json:"output_type"`
+	// stream outputs
+	Text notebookText `json:"text"`
+	// execute_result/display_data outputs, keyed by MIME type
+	Data map[string]notebookText `json:"data"`
+	// error outputs
+	Ename     string   `json:"ename"`
+	Evalue    string   `json:"evalue"`
+	Traceback []string `json:"traceback"`
+}
+
+// text in a notebook can be a string, or a list of lines
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = notebookText(s)
+	return nil
+}
+
+// the escape codes which color tracebacks
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// renders the cells of a notebook, and their outputs
+//
+// HTML outputs aren't displayed (they could contain scripts), the
+// plaintext version of the output is displayed instead if there is one
+func renderNotebook(data []byte) (template.HTML, error) {
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(`<div class="notebook">` + "\n")
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			rendered, err := renderMarkdown(string(cell.Source))
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "<div class=\"cell markdown\">%s</div>\n", rendered)
+		case "code":
+			prompt := " "
+			if cell.ExecutionCount != nil {
+				prompt = fmt.Sprint(*cell.ExecutionCount)
+			}
+			fmt.Fprintf(&b, "<div class=\"cell code\"><span class=\"prompt\">In [%s]:</span><pre><code>%s</code></pre>\n", prompt, html.EscapeString(string(cell.Source)))
+			for _, output := range cell.Outputs {
+				writeNotebookOutput(&b, &output)
+			}
+			b.WriteString("</div>\n")
+		default:
+			fmt.Fprintf(&b, "<div class=\"cell raw\"><pre><code>%s</code></pre></div>\n", html.EscapeString(string(cell.Source)))
+		}
+	}
+	b.WriteString("</div>\n")
+	return template.HTML(b.String()), nil
+}
+
+func writeNotebookOutput(b *strings.Builder, output *notebookOutput) {
+	switch output.OutputType {
+	case "stream":
+		fmt.Fprintf(b, "<pre class=\"output\">%s</pre>\n", html.EscapeString(string(output.Text)))
+	case "error":
+		traceback := ansiEscape.ReplaceAllString(strings.Join(output.Traceback, "\n"), "")
+		if traceback == "" {
+			traceback = output.Ename + ": " + output.Evalue
+		}
+		fmt.Fprintf(b, "<pre class=\"output error\">%s</pre>\n", html.EscapeString(traceback))
+	default:
+		for _, mimeType := range [...]string{"image/png", "image/jpeg", "image/gif"} {
+			if encoded, ok := output.Data[mimeType]; ok {
+				// re-encoded, so only valid base64 ends up in the attribute
+				image, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\n", ""))
+				if err == nil {
+					fmt.Fprintf(b, "<img class=\"output\" src=\"data:%s;base64,%s\">\n", mimeType, base64.StdEncoding.EncodeToString(image))
+					return
+				}
+			}
+		}
+		if text, ok := output.Data["text/plain"]; ok {
+			fmt.Fprintf(b, "<pre class=\"output\">%s</pre>\n", html.EscapeString(string(text)))
+		}
+	}
+}
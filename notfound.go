@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// responds with the -not-found-file, returns false if it
+// couldn't be read, so the default message should be used
+//
+// HTML files are responded with as-is, markdown files are rendered
+// in the ?dark view (with the search box), and anything else is
+// displayed like a file
+func (s *server) serveNotFoundFile(ctx context.Context, w http.ResponseWriter, reqPath string, opts *requestOptions) bool {
+	m, p := matchMount(s.config.mounts, s.config.notFoundFile)
+	if m == nil {
+		return false
+	}
+	data, _, err := readRegularFile(ctx, m.src, p)
+	if err != nil {
+		log.Printf("Could not read -not-found-file %s: %s\n", s.config.notFoundFile, err)
+		return false
+	}
+	contents := string(data)
+	var rendered template.HTML
+	var toc []Heading
+	switch strings.ToLower(path.Ext(p)) {
+	case ".html", ".htm":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(data)
+		return true
+	case ".md", ".markdown":
+		_, contents = splitFrontmatter(contents)
+		if opts.isDark && opts.toc {
+			if rendered, toc, err = renderMarkdownTOC(contents); err != nil {
+				log.Printf("Could not render -not-found-file %s: %s\n", s.config.notFoundFile, err)
+			}
+		} else if opts.isDark {
+			if rendered, err = renderMarkdown(contents); err != nil {
+				log.Printf("Could not render -not-found-file %s: %s\n", s.config.notFoundFile, err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	render(&w, &PageInfo{
+		PageContents: contents,
+		Title:        "404 - Not Found",
+		Rendered:     rendered,
+		TOC:          toc,
+		NotFound:     s.notFoundSearch(reqPath),
+	}, s.tmpl, opts.isDark)
+	return true
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNotFoundSearch(t *testing.T) {
+	s := &server{config: &config{basePath: "/dotfiles"}}
+	for _, tt := range []struct {
+		reqPath string
+		query   string
+	}{
+		{"nvim/init.lau", "init.lau"},
+		{"bashrc", "bashrc"},
+		{"notes/old/", "old"},
+		{"", ""},
+	} {
+		search := s.notFoundSearch(tt.reqPath)
+		if search.Query != tt.query {
+			t.Errorf("notFoundSearch(%q).Query = %q, expected %q", tt.reqPath, search.Query, tt.query)
+		}
+		if search.IndexUrl != "/dotfiles/" {
+			t.Errorf("notFoundSearch(%q).IndexUrl = %q, expected /dotfiles/", tt.reqPath, search.IndexUrl)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// a notice displayed as a banner on every ?dark page, and as a comment at
+// the start of plaintext listings, e.g. to announce maintenance
+type siteNotice struct {
+	// from -notice
+	text string
+	// from -notice-file, which is read again for every page, so the notice
+	// can be changed (or removed, by deleting the file) without a restart
+	file string
+}
+
+// the notice from -notice and -notice-file, set in main
+var notice = &siteNotice{}
+
+// the current notice, the contents of -notice-file if it exists, else
+// -notice. Empty if there isn't one
+func (n *siteNotice) get() string {
+	if n.file != "" {
+		if data, err := os.ReadFile(n.file); err == nil {
+			if text := strings.TrimSpace(string(data)); text != "" {
+				return text
+			}
+		}
+	}
+	return n.text
+}
+
+// the notice as '# ' comment lines, for plaintext listings
+func (n *siteNotice) comment() string {
+	text := n.get()
+	if text == "" {
+		return ""
+	}
+	return "# " + strings.ReplaceAll(text, "\n", "\n# ") + "\n"
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// how long a user stays logged in after logging in with OpenID Connect
+	oidcSessionTTL = 12 * time.Hour
+	// how long the login at the provider can take
+	oidcLoginTTL = 10 * time.Minute
+	// how often the keys of the provider can be fetched again, when an ID
+	// token is signed with a key which isn't in them
+	oidcKeysRefresh = time.Minute
+	// the cookies with the session, and the state of a login in progress
+	sessionCookie = "subpath_serve_session"
+	loginCookie   = "subpath_serve_login"
+)
+
+// a rule from -oidc-rules: files matching the path can only be read by
+// users where the claim (e.g. groups) has one of the values
+type oidcRule struct {
+	Path   string   `toml:"path"`
+	Claim  string   `toml:"claim"`
+	Values []string `toml:"values"`
+
+	pattern *regexp.Regexp
+}
+
+// parses the [[rule]] tables in the TOML file. The claim defaults to groups
+func loadOIDCRules(rulesFile string) ([]*oidcRule, error) {
+	var file struct {
+		Rules []*oidcRule `toml:"rule"`
+	}
+	if _, err := toml.DecodeFile(rulesFile, &file); err != nil {
+		return nil, fmt.Errorf("could not parse OIDC rules '%s': %w", rulesFile, err)
+	}
+	for i, rule := range file.Rules {
+		pattern, err := compilePathPattern("oidc-rules", rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d in '%s': %w", i+1, rulesFile, err)
+		}
+		if len(rule.Values) == 0 {
+			return nil, fmt.Errorf("rule %d ('%s') in '%s' doesn't have any values", i+1, rule.Path, rulesFile)
+		}
+		if rule.Claim == "" {
+			rule.Claim = "groups"
+		}
+		rule.pattern = pattern
+	}
+	return file.Rules, nil
+}
+
+// where to log in and fetch the keys ID tokens are signed with, from
+// the discovery document of the provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// an OpenID Connect provider users can log in with (the authorization
+// code flow, with PKCE), from -oidc-issuer
+//
+// the discovery document and keys are fetched when they're first
+// needed, so the server starts even if the provider is down
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       string
+	// the claim used as the name of the user (e.g. email)
+	userClaim string
+	rules     []*oidcRule
+	// signs the session and login cookies
+	key    []byte
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+}
+
+func newOIDCProvider(config *config, key []byte) (*oidcProvider, error) {
+	o := &oidcProvider{
+		issuer:    config.oidcIssuer,
+		clientID:  config.oidcClientID,
+		scopes:    config.oidcScopes,
+		userClaim: config.oidcUserClaim,
+		key:       key,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if config.oidcClientSecretFile != "" {
+		secret, err := os.ReadFile(config.oidcClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read OIDC client secret: %w", err)
+		}
+		o.clientSecret = strings.TrimSpace(string(secret))
+	}
+	if config.oidcRulesFile != "" {
+		rules, err := loadOIDCRules(config.oidcRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		o.rules = rules
+	}
+	return o, nil
+}
+
+// fetches JSON from the provider into v
+func (o *oidcProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// the discovery document of the provider, fetched once
+func (o *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	d := &oidcDiscovery{}
+	if err := o.getJSON(ctx, o.issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("could not fetch OIDC discovery document: %w", err)
+	}
+	if d.Issuer != o.issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer '%s', expected '%s'", d.Issuer, o.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing an endpoint")
+	}
+	o.discovery = d
+	return d, nil
+}
+
+// a key from the JWKS of the provider
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+// the key with the ID, fetching the keys of the provider again if it
+// isn't one of them (e.g. they were rotated)
+func (o *oidcProvider) publicKey(ctx context.Context, jwksURI string, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(o.fetched) < oidcKeysRefresh {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+	var jwks struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("could not fetch OIDC keys: %w", err)
+	}
+	o.keys = make(map[string]crypto.PublicKey)
+	o.fetched = time.Now()
+	for _, k := range jwks.Keys {
+		// keys for other algorithms are skipped
+		if key, err := k.publicKey(); err == nil {
+			o.keys[k.Kid] = key
+		}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key '%s'", kid)
+}
+
+// verifies the signature and claims of an ID token, returns its claims
+func (o *oidcProvider) verify(ctx context.Context, d *oidcDiscovery, idToken string, nonce string) (map[string]any, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	key, err := o.publicKey(ctx, d.JWKSURI, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	valid := false
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		valid = header.Alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		valid = header.Alg == "ES256" && len(sig) == 64 && ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid %s signature on ID token", header.Alg)
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if claims["iss"] != d.Issuer {
+		return nil, fmt.Errorf("ID token is from issuer '%v', expected '%s'", claims["iss"], d.Issuer)
+	}
+	audiences := claimValues(claims["aud"])
+	if !slices.Contains(audiences, o.clientID) {
+		return nil, fmt.Errorf("ID token is for %v, not client '%s'", audiences, o.clientID)
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, errors.New("ID token expired")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("ID token has the wrong nonce")
+	}
+	return claims, nil
+}
+
+// decodes a base64url encoded JSON segment of a JWT
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// a claim as a list of strings, e.g. groups, or an aud which can
+// be a string or a list
+func claimValues(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := []string{}
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case bool, float64:
+		return []string{fmt.Sprint(value)}
+	}
+	return nil
+}
+
+// a user who logged in with OpenID Connect, stored in a signed cookie
+type oidcSession struct {
+	User string `json:"user"`
+	// the claims used by -oidc-rules
+	Claims  map[string][]string `json:"claims"`
+	Expires int64               `json:"exp"`
+}
+
+// the state of a login in progress, stored in a signed cookie until
+// the provider redirects back
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// where to redirect to after logging in
+	Next    string `json:"next"`
+	Expires int64  `json:"exp"`
+}
+
+// encodes v as a cookie value, signed for the purpose (the name of the cookie)
+func (o *oidcProvider) seal(purpose string, v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + o.mac(purpose, payload)
+}
+
+// decodes a cookie value from seal into v, false if it wasn't signed for the purpose
+func (o *oidcProvider) open(purpose string, value string, v any) bool {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(o.mac(purpose, payload))) {
+		return false
+	}
+	return decodeSegment(payload, v) == nil
+}
+
+func (o *oidcProvider) mac(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, o.key)
+	fmt.Fprintf(mac, "%s\x00%s", purpose, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// the user the request is logged in as, from the session cookie
+func (o *oidcProvider) session(r *http.Request) (*oidcSession, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	session := &oidcSession{}
+	if !o.open(sessionCookie, cookie.Value, session) || time.Now().Unix() > session.Expires {
+		return nil, false
+	}
+	return session, true
+}
+
+// whether the user can read the private file at p (starting with the
+// mount name). Files which don't match any rule can be read by every
+// user, else the user needs one of the values of one of the rules
+func (o *oidcProvider) allows(session *oidcSession, p string) bool {
+	matched := false
+	for _, rule := range o.rules {
+		if !rule.pattern.MatchString(p) {
+			continue
+		}
+		matched = true
+		for _, value := range session.Claims[rule.Claim] {
+			if slices.Contains(rule.Values, value) {
+				return true
+			}
+		}
+	}
+	return !matched
+}
+
+// the patterns of the rules, which make the files matching them private
+func (o *oidcProvider) patterns() []*regexp.Regexp {
+	patterns := []*regexp.Regexp{}
+	for _, rule := range o.rules {
+		patterns = append(patterns, rule.pattern)
+	}
+	return patterns
+}
+
+// a random string for the state, nonce and PKCE verifier
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// the URL the server is at, which the provider redirects back to: the
+// -canonical-url, or the host the request was made to
+func (s *server) externalURL(r *http.Request) string {
+	if s.config.canonicalURL != "" {
+		return s.config.canonicalURL
+	}
+	// behind a TLS-terminating proxy, from its X-Forwarded-Proto
+	scheme := "http"
+	if s.isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.config.basePath
+}
+
+// sets a cookie for the whole server, which expires at expires
+func (s *server) setCookie(w http.ResponseWriter, r *http.Request, name string, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     s.config.basePath + "/",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(s.externalURL(r), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// where to redirect to after logging in/out: ?next= if it's a path on
+// this server, else the ?dark index
+func (s *server) nextURL(r *http.Request) string {
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, s.config.basePath+"/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return s.config.basePath + "/?dark"
+	}
+	return next
+}
+
+// redirects to the provider to log in, which redirects back to
+// /-/oidc/callback
+func (s *server) serveLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.oidc == nil {
+		s.serveNotFound(ctx, w, "-/login", opts)
+		return
+	}
+	d, err := s.oidc.discover(ctx)
+	if err != nil {
+		s.serveLoginError(w, opts, err)
+		return
+	}
+	login := &oidcLogin{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Next:     s.nextURL(r),
+		Expires:  time.Now().Add(oidcLoginTTL).Unix(),
+	}
+	s.setCookie(w, r, loginCookie, s.oidc.seal(loginCookie, login), time.Unix(login.Expires, 0))
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.oidc.clientID},
+		"redirect_uri":          {s.externalURL(r) + "/-/oidc/callback"},
+		"scope":                 {s.oidc.scopes},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, d.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// exchanges the code from the provider for an ID token, and logs the user in
+func (s *server) serveOIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.oidc == nil {
+		s.serveNotFound(ctx, w, "-/oidc/callback", opts)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	query := r.URL.Query()
+	login := &oidcLogin{}
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil || !s.oidc.open(loginCookie, cookie.Value, login) || time.Now().Unix() > login.Expires {
+		s.serveLoginError(w, opts, errors.New("the login expired, try again"))
+		return
+	}
+	if !hmac.Equal([]byte(query.Get("state")), []byte(login.State)) {
+		s.serveLoginError(w, opts, errors.New("the state doesn't match, try again"))
+		return
+	}
+	if provided := query.Get("error"); provided != "" {
+		s.serveLoginError(w, opts, fmt.Errorf("%s %s", provided, query.Get("error_description")))
+		return
+	}
+	d, err := s.oidc.discover(ctx)
+	if err != nil {
+		s.serveLoginError(w, opts, err)
+		return
+	}
+	idToken, err := s.oidc.exchange(ctx, d, query.Get("code"), login.Verifier, s.externalURL(r)+"/-/oidc/callback")
+	if err != nil {
+		s.serveLoginError(w, opts, err)
+		return
+	}
+	claims, err := s.oidc.verify(ctx, d, idToken, login.Nonce)
+	if err != nil {
+		s.serveLoginError(w, opts, err)
+		return
+	}
+	session := &oidcSession{Claims: make(map[string][]string), Expires: time.Now().Add(oidcSessionTTL).Unix()}
+	if user := claimValues(claims[s.oidc.userClaim]); len(user) > 0 {
+		session.User = user[0]
+	} else {
+		session.User = fmt.Sprint(claims["sub"])
+	}
+	// only the claims the rules use are kept, so the cookie stays small
+	for _, rule := range s.oidc.rules {
+		session.Claims[rule.Claim] = claimValues(claims[rule.Claim])
+	}
+	s.setCookie(w, r, loginCookie, "", time.Unix(0, 0))
+	s.setCookie(w, r, sessionCookie, s.oidc.seal(sessionCookie, session), time.Unix(session.Expires, 0))
+	log.Printf("%s logged in with OIDC\n", session.User)
+	http.Redirect(w, r, login.Next, http.StatusFound)
+}
+
+// exchanges the authorization code at the token endpoint, returns the ID token
+func (o *oidcProvider) exchange(ctx context.Context, d *oidcDiscovery, code string, verifier string, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	if o.clientSecret == "" {
+		form.Set("client_id", o.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not exchange the code: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("could not parse the token response (%s): %w", resp.Status, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("could not exchange the code: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("the token response doesn't have an ID token")
+	}
+	return token.IDToken, nil
+}
+
+// logs the user out, by removing the session cookie
+func (s *server) serveLogout(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.oidc == nil {
+		s.serveNotFound(ctx, w, "-/logout", opts)
+		return
+	}
+	s.setCookie(w, r, sessionCookie, "", time.Unix(0, 0))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, s.nextURL(r), http.StatusFound)
+}
+
+// responds with why logging in failed, and logs it
+func (s *server) serveLoginError(w http.ResponseWriter, opts *requestOptions, err error) {
+	log.Printf("OIDC login failed: %s\n", err)
+	w.WriteHeader(http.StatusUnauthorized)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Could not log in: %s\n", err),
+		Title:        "401 - Unauthorized",
+	}, s.tmpl, opts.isDark)
+}
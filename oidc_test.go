@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// a provider with one RSA key, which signs ID tokens with it
+type testProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&oidcDiscovery{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []*jsonWebKey{{
+			Kty: "RSA",
+			Kid: "k1",
+			N:   encode(key.N.Bytes()),
+			E:   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	p := newTestProvider(t)
+	o := &oidcProvider{issuer: p.URL, clientID: "serve", client: p.Client()}
+	ctx := context.Background()
+	d, err := o.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":    p.URL,
+			"aud":    []string{"other", "serve"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"nonce":  "n",
+			"email":  "a@example.com",
+			"groups": []string{"eng"},
+		}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+	verified, err := o.verify(ctx, d, p.sign(t, "k1", claims(nil)), "n")
+	if err != nil {
+		t.Fatalf("valid ID token wasn't accepted: %v", err)
+	}
+	if groups := claimValues(verified["groups"]); len(groups) != 1 || groups[0] != "eng" {
+		t.Errorf("groups = %v, expected [eng]", groups)
+	}
+	for name, token := range map[string]string{
+		"wrong audience": p.sign(t, "k1", claims(map[string]any{"aud": "other"})),
+		"wrong issuer":   p.sign(t, "k1", claims(map[string]any{"iss": "https://example.com"})),
+		"expired":        p.sign(t, "k1", claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"wrong nonce":    p.sign(t, "k1", claims(map[string]any{"nonce": "m"})),
+		"unknown key":    p.sign(t, "k2", claims(nil)),
+		"tampered":       p.sign(t, "k1", claims(nil))[:40] + "x" + p.sign(t, "k1", claims(nil))[41:],
+		"malformed":      "a.b",
+	} {
+		if _, err := o.verify(ctx, d, token, "n"); err == nil {
+			t.Errorf("ID token with %s was accepted", name)
+		}
+	}
+}
+
+func TestOIDCSession(t *testing.T) {
+	o := &oidcProvider{key: []byte("0123456789abcdef"), rules: []*oidcRule{
+		{Claim: "groups", Values: []string{"eng", "ops"}, pattern: regexp.MustCompile("^team/.*$")},
+	}}
+	session := &oidcSession{User: "a", Claims: map[string][]string{"groups": {"eng"}}, Expires: time.Now().Add(time.Hour).Unix()}
+	value := o.seal(sessionCookie, session)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	if got, ok := o.session(r); !ok || got.User != "a" {
+		t.Fatalf("session() = %v, %v", got, ok)
+	}
+	// signed for the login cookie, not the session
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: o.seal(loginCookie, session)})
+	if _, ok := o.session(r); ok {
+		t.Errorf("login cookie was accepted as a session")
+	}
+	if !o.allows(session, "team/plan.md") || !o.allows(session, "notes/a.md") {
+		t.Errorf("user in eng can't read team/plan.md")
+	}
+	if o.allows(&oidcSession{Claims: map[string][]string{"groups": {"sales"}}}, "team/plan.md") {
+		t.Errorf("user in sales can read team/plan.md")
+	}
+}
+
+func TestNextURL(t *testing.T) {
+	s := &server{config: &config{basePath: "/d"}}
+	for next, expected := range map[string]string{
+		"/d/notes/a.md?dark": "/d/notes/a.md?dark",
+		"//evil.com/d/":      "/d/?dark",
+		"https://evil.com":   "/d/?dark",
+		"/other":             "/d/?dark",
+		"":                   "/d/?dark",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/-/login?"+url.Values{"next": {next}}.Encode(), nil)
+		if got := s.nextURL(r); got != expected {
+			t.Errorf("nextURL(%q) = %q, expected %q", next, got, expected)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// the most characters from the start of a file used as its description
+const maxDescription = 200
+
+// with -canonical-url, the URL of the ?dark page for the file/directory
+// at p (relative to the root, starting with the mount name), which pages
+// link to as the canonical URL and in their OpenGraph metadata
+//
+// requests for any query which matches the file have the same canonical URL
+func (s *server) canonicalURL(p string, isDir bool) string {
+	if s.config.canonicalURL == "" {
+		return ""
+	}
+	if isDir && p != "" {
+		p += "/"
+	}
+	return s.config.canonicalURL + (&url.URL{Path: "/" + p}).EscapedPath() + "?dark"
+}
+
+// the URL of the file/directory at p which the "Copy link" control in
+// ?dark pages copies, without the theme (?dark), line number or other
+// view params, or the #anchor the viewer arrived with, so it's the same
+// however the page was opened. Uses -canonical-url, or the host the page
+// was requested from
+func (s *server) shareURL(r *http.Request, p string, isDir bool) string {
+	if isDir && p != "" {
+		p += "/"
+	}
+	return s.externalURL(r) + (&url.URL{Path: "/" + p}).EscapedPath()
+}
+
+// the first lines of a file, with the whitespace collapsed, used as the
+// description in the OpenGraph metadata. Empty for binary files
+func describe(contents string) string {
+	// only the start of the file is needed, without splitting a character
+	end := min(len(contents), 4*maxDescription)
+	for end < len(contents) && !utf8.RuneStart(contents[end]) {
+		end--
+	}
+	head := contents[:end]
+	if isBinary([]byte(head)) || !utf8.ValidString(head) {
+		return ""
+	}
+	var description strings.Builder
+	for _, line := range strings.Split(head, "\n") {
+		for _, word := range strings.Fields(line) {
+			if description.Len() > 0 {
+				description.WriteString(" ")
+			}
+			description.WriteString(word)
+		}
+		if description.Len() >= maxDescription {
+			break
+		}
+	}
+	text := description.String()
+	if len(text) <= maxDescription {
+		return text
+	}
+	// cut at the last space, without splitting a character
+	cut := strings.LastIndex(text[:maxDescription], " ")
+	if cut <= 0 {
+		cut = maxDescription
+		for !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return text[:cut] + "…"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	for _, tt := range []struct {
+		contents string
+		expected string
+	}{
+		{"", ""},
+		{"# Title\n\n  some   text\nmore\n", "# Title some text more"},
+		{"binary\x00data", ""},
+		{strings.Repeat("word ", 100), strings.TrimSpace(strings.Repeat("word ", 40)) + "…"},
+		{strings.Repeat("é", 300), strings.Repeat("é", 100) + "…"},
+	} {
+		if got := describe(tt.contents); got != tt.expected {
+			t.Errorf("describe(%q) = %q, expected %q", tt.contents, got, tt.expected)
+		}
+	}
+}
+
+func TestShareURL(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/d/notes/a%20b.md?dark&ln#L3", nil)
+	s := &server{config: &config{basePath: "/d"}}
+	if got := s.shareURL(r, "notes/a b.md", false); got != "http://example.com/d/notes/a%20b.md" {
+		t.Errorf("shareURL = %q", got)
+	}
+	if got := s.shareURL(r, "notes", true); got != "http://example.com/d/notes/" {
+		t.Errorf("shareURL of a directory = %q", got)
+	}
+	s.config.canonicalURL = "https://sean.fish/d"
+	if got := s.shareURL(r, "", true); got != "https://sean.fish/d/" {
+		t.Errorf("shareURL of the index = %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// what -paranoid does if the audit finds anything
+var paranoidModes = [...]string{"warn", "refuse"}
+
+// names (matched with path.Match) of files which are probably secrets
+var secretPatterns = [...]string{
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	".env", ".env.*", ".netrc", ".pgpass", ".htpasswd", ".npmrc", ".pypirc",
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.kdbx", "credentials.json",
+}
+
+// suffixes of example files, e.g. .env.example, which aren't secrets
+var exampleSuffixes = [...]string{".example", ".sample", ".template"}
+
+// the secretPatterns name matches, or an empty string
+func secretPattern(name string) string {
+	for _, suffix := range exampleSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return ""
+		}
+	}
+	for _, pattern := range secretPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// something which probably shouldn't be served, found by -paranoid
+type auditFinding struct {
+	// starting with the mount name, with -mount
+	path   string
+	reason string
+}
+
+// walks the mount, looking for files which look like secrets. For a local
+// folder, also looks for world-writable files/directories, and symlinks
+// which point outside of the folder
+func auditMount(m *mount) ([]auditFinding, error) {
+	src := m.src
+	// the permissions of the files are only in the folder
+	if snap, ok := src.(*snapshotSource); ok {
+		src = snap.src
+	}
+	local, _ := src.(*localSource)
+	findings := []auditFinding{}
+	err := src.walk(context.Background(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// like walkEntries, skip directories which can't be read
+			if p != "." && errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if p != "." && (isIgnored(p, d.IsDir()) || isMountFile(p)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		found := func(reason string) {
+			findings = append(findings, auditFinding{path: mountPath(m, p), reason: reason})
+		}
+		if !d.IsDir() {
+			if pattern := secretPattern(d.Name()); pattern != "" {
+				found(fmt.Sprintf("looks like a secret (matches %s)", pattern))
+			}
+		}
+		if local == nil {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(local.fullPath(p))
+			// broken links can't be served
+			if err != nil {
+				return nil
+			}
+			if rel, err := filepath.Rel(local.folder, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				found(fmt.Sprintf("symlink to %s, outside of %s", target, local.folder))
+			}
+			return nil
+		}
+		info, err := d.Info()
+		// removed while walking
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0o002 != 0 {
+			found(fmt.Sprintf("world-writable (%s)", info.Mode().Perm()))
+		}
+		return nil
+	})
+	return findings, err
+}
+
+// audits every mount, logging what was found. With -paranoid refuse,
+// exits if anything was found
+func audit(config *config) {
+	findings := []auditFinding{}
+	for _, m := range config.mounts {
+		mountFindings, err := auditMount(m)
+		if err != nil {
+			log.Fatalf("Error: Could not audit %s: %s\n", m.src, err)
+		}
+		findings = append(findings, mountFindings...)
+	}
+	for _, finding := range findings {
+		log.Printf("Paranoid: /%s: %s\n", finding.path, finding.reason)
+	}
+	if len(findings) == 0 {
+		return
+	}
+	if config.paranoid == "refuse" {
+		log.Fatalf("Error: -paranoid found %d problems, refusing to start\n", len(findings))
+	}
+	log.Printf("Warning: -paranoid found %d problems\n", len(findings))
+}
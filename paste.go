@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// the extension of a paste, from ?ext=
+var pasteExtPattern = regexp.MustCompile(`^[a-zA-Z0-9]{1,10}$`)
+
+// the local folder -paste-dir is in, and its path relative to the root of
+// it. The mount has to be a local folder (or a snapshot of one)
+func pasteFolder(mounts []*mount, pasteDir string) (*mount, *localSource, string, error) {
+	m, dir := matchMount(mounts, pasteDir)
+	if m == nil || dir == "" {
+		return nil, nil, "", fmt.Errorf("-paste-dir '%s' isn't a directory in -folder or a -mount", pasteDir)
+	}
+	src := m.src
+	if snap, ok := src.(*snapshotSource); ok {
+		src = snap.src
+	}
+	local, ok := src.(*localSource)
+	if !ok {
+		return nil, nil, "", fmt.Errorf("-paste-dir '%s' isn't in a local folder", pasteDir)
+	}
+	if checkIgnored(dir, true) != nil {
+		return nil, nil, "", fmt.Errorf("-paste-dir '%s' is ignored, so pastes wouldn't be served", pasteDir)
+	}
+	return m, local, dir, nil
+}
+
+// saves the body of the request as a new file in -paste-dir, named after
+// the time it was pasted (e.g. 2026-10-15-034916.txt), and responds with
+// its URL. Pastes are never replaced, a paste in the same second gets a
+// -2, -3... suffix
+func (s *server) servePaste(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	user, ok := s.requireAuth(w, r, opts)
+	if !ok {
+		return
+	}
+	if s.config.pasteDir == "" {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: "Pastes require running with -paste-dir\n",
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	ext := r.URL.Query().Get("ext")
+	if ext == "" {
+		ext = "txt"
+	}
+	if !pasteExtPattern.MatchString(ext) {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Invalid extension '%s', expected up to 10 letters/numbers\n", ext),
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.pasteMaxSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Pastes can be at most %s\n", humanizeBytes(s.config.pasteMaxSize)),
+			Title:        "413 - Request Entity Too Large",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: "Expected the paste as the body of the request\n",
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	m, local, dir, err := pasteFolder(s.config.mounts, s.config.pasteDir)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	p, err := writePaste(local, dir, time.Now(), ext, data)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	// snapshots and cached directory sizes don't include it yet
+	if snap, ok := m.src.(*snapshotSource); ok {
+		snap.refresh(ctx)
+	}
+	if s.dirSizes != nil {
+		s.dirSizes.purge(mountPath(m, dir))
+	}
+	log.Printf("%s pasted %s (%s)\n", user, mountPath(m, p), humanizeBytes(int64(len(data))))
+	pasteURL := s.shareURL(r, mountPath(m, p), false)
+	w.Header().Set("Location", pasteURL)
+	w.WriteHeader(http.StatusCreated)
+	render(&w, &PageInfo{
+		PageContents: pasteURL + "\n",
+		Title:        "Paste",
+	}, s.tmpl, opts.isDark)
+}
+
+// creates the file for a paste in dir (relative to the root of the
+// folder), without replacing one which is already there, and returns
+// its path relative to the root of the folder
+func writePaste(local *localSource, dir string, at time.Time, ext string, data []byte) (string, error) {
+	if err := os.MkdirAll(local.fullPath(dir), 0o755); err != nil {
+		return "", err
+	}
+	name := at.UTC().Format("2006-01-02-150405")
+	for i := 1; ; i++ {
+		p := path.Join(dir, name+"."+ext)
+		if i > 1 {
+			p = path.Join(dir, fmt.Sprintf("%s-%d.%s", name, i, ext))
+		}
+		f, err := os.OpenFile(local.fullPath(p), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(local.fullPath(p))
+			return "", err
+		}
+		return p, nil
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWritePaste(t *testing.T) {
+	dir := t.TempDir()
+	local, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 10, 15, 3, 49, 16, 0, time.UTC)
+	var paths []string
+	for _, contents := range []string{"first", "second", "third"} {
+		p, err := writePaste(local, "pastes", at, "txt", []byte(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	expected := []string{"pastes/2026-10-15-034916.txt", "pastes/2026-10-15-034916-2.txt", "pastes/2026-10-15-034916-3.txt"}
+	for i, p := range paths {
+		if p != expected[i] {
+			t.Errorf("paste %d was saved to %s, expected %s", i, p, expected[i])
+		}
+	}
+	// pastes in the same second don't replace each other
+	if data, _ := os.ReadFile(filepath.Join(dir, "pastes", "2026-10-15-034916.txt")); string(data) != "first" {
+		t.Errorf("the first paste was replaced with %q", data)
+	}
+}
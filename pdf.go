@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// a minimal PDF writer for ?pdf, which lays out text with the standard
+// Type 1 fonts (Helvetica and Courier). PDF readers have those builtin,
+// so no fonts are embedded, and only characters in WinAnsiEncoding
+// (Latin-1, and some punctuation) can be displayed
+
+// A4, in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+	// the footer is in the bottom margin
+	pdfFooterSize = 8.0
+)
+
+type pdfFont int
+
+const (
+	fontRegular pdfFont = iota
+	fontBold
+	fontItalic
+	fontBoldItalic
+	fontCode
+)
+
+// the base font for each pdfFont, which is /F<index+1> on each page
+var pdfFontNames = [...]string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Helvetica-BoldOblique", "Courier"}
+
+// widths of ASCII 32-126 in Helvetica and Helvetica-Bold, in 1/1000 of
+// the font size, from their AFM files. The obliques have the same widths,
+// and every character in Courier is 600
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// characters outside of Latin-1 which are in WinAnsiEncoding
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, '‰': 0x89,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'™': 0x99,
+}
+
+// the byte for r in WinAnsiEncoding, ? if it isn't in it
+func winAnsi(r rune) byte {
+	if (r >= 32 && r < 127) || (r >= 160 && r <= 255) {
+		return byte(r)
+	}
+	if b, ok := winAnsiExtra[r]; ok {
+		return b
+	}
+	return '?'
+}
+
+// the width of the WinAnsiEncoding byte in the font, in 1/1000 of the size
+func glyphWidth(font pdfFont, b byte) int {
+	if font == fontCode {
+		return 600
+	}
+	widths := &helveticaWidths
+	if font == fontBold || font == fontBoldItalic {
+		widths = &helveticaBoldWidths
+	}
+	switch {
+	case b >= 32 && b < 127:
+		return widths[b-32]
+	case b == 0x85 || b == 0x89 || b == 0x97 || b == 0x99:
+		return 1000
+	case b == 0x91 || b == 0x92 || b == 0x82:
+		return 222
+	case b == 0x93 || b == 0x94 || b == 0x84:
+		return 333
+	case b == 0x95:
+		return 350
+	}
+	return 556
+}
+
+// text encoded in WinAnsiEncoding
+func encodeWinAnsi(s string) []byte {
+	encoded := make([]byte, 0, len(s))
+	for _, r := range s {
+		encoded = append(encoded, winAnsi(r))
+	}
+	return encoded
+}
+
+func textWidth(font pdfFont, size float64, text []byte) float64 {
+	width := 0
+	for _, b := range text {
+		width += glyphWidth(font, b)
+	}
+	return float64(width) * size / 1000
+}
+
+// the encoded text as a PDF string, with the bytes which aren't
+// printable ASCII escaped
+func pdfString(text []byte) string {
+	var s strings.Builder
+	s.WriteByte('(')
+	for _, b := range text {
+		switch {
+		case b == '(' || b == ')' || b == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(b)
+		case b < 32 || b > 126:
+			fmt.Fprintf(&s, "\\%03o", b)
+		default:
+			s.WriteByte(b)
+		}
+	}
+	s.WriteByte(')')
+	return s.String()
+}
+
+// text in one font, part of a paragraph
+type pdfSpan struct {
+	text string
+	font pdfFont
+}
+
+// lays out text onto pages, from the top of the first page down
+type pdfDoc struct {
+	title string
+	pages []*bytes.Buffer
+	// the page being written, and the baseline of the next line on it
+	page *bytes.Buffer
+	y    float64
+}
+
+func newPDFDoc(title string) *pdfDoc {
+	d := &pdfDoc{title: title}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDoc) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// starts a new page, unless there's room for height more on this one
+func (d *pdfDoc) ensure(height float64) {
+	if d.y-height < pdfMargin && d.y < pdfPageHeight-pdfMargin {
+		d.newPage()
+	}
+}
+
+// moves down the page, without starting a new one
+func (d *pdfDoc) space(height float64) {
+	if d.y < pdfPageHeight-pdfMargin {
+		d.y -= height
+	}
+}
+
+// writes the text at x on the current line, returns the x after it
+func (d *pdfDoc) text(font pdfFont, size float64, x float64, text []byte) float64 {
+	fmt.Fprintf(d.page, "BT /F%d %.1f Tf %.2f %.2f Td %s Tj ET\n", font+1, size, x, d.y, pdfString(text))
+	return x + textWidth(font, size, text)
+}
+
+// a word of a paragraph, with the space after it
+type pdfWord struct {
+	text  []byte
+	font  pdfFont
+	space bool
+	// a line break after the word
+	br bool
+}
+
+func splitWords(spans []pdfSpan) []pdfWord {
+	var words []pdfWord
+	for _, span := range spans {
+		for i, line := range strings.Split(span.text, "\n") {
+			if i > 0 && len(words) > 0 {
+				words[len(words)-1].br = true
+			}
+			fields := strings.Fields(line)
+			// a space at the start of the span separates it from the last word
+			if len(words) > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+				words[len(words)-1].space = true
+			}
+			for j, field := range fields {
+				last := j == len(fields)-1
+				words = append(words, pdfWord{
+					text:  encodeWinAnsi(field),
+					font:  span.font,
+					space: !last || strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t"),
+				})
+			}
+		}
+	}
+	return words
+}
+
+// writes the spans wrapped to the width of the page, starting at indent.
+// The first line starts after the marker (e.g. a bullet for a list item),
+// which is written to the left of indent
+func (d *pdfDoc) paragraph(spans []pdfSpan, size float64, indent float64, marker string) {
+	leading := size * 1.4
+	maxWidth := pdfPageWidth - pdfMargin - indent
+	words := splitWords(spans)
+	first := true
+	for len(words) > 0 || first {
+		// the words on this line
+		n, width := 0, 0.0
+		for n < len(words) {
+			w := textWidth(words[n].font, size, words[n].text)
+			if n > 0 && words[n-1].space {
+				w += textWidth(words[n-1].font, size, []byte(" "))
+			}
+			if n > 0 && width+w > maxWidth {
+				break
+			}
+			width += w
+			n++
+			if words[n-1].br {
+				break
+			}
+		}
+		// a word which is wider than the page is split
+		if n == 1 && width > maxWidth {
+			word := words[0]
+			fit := len(word.text)
+			for fit > 1 && textWidth(word.font, size, word.text[:fit]) > maxWidth {
+				fit--
+			}
+			words = append([]pdfWord{{text: word.text[:fit], font: word.font}, {text: word.text[fit:], font: word.font, space: word.space, br: word.br}}, words[1:]...)
+			words[0].br = true
+		}
+		d.ensure(leading)
+		d.y -= size
+		if first && marker != "" {
+			d.text(fontRegular, size, indent-textWidth(fontRegular, size, encodeWinAnsi(marker+" ")), encodeWinAnsi(marker))
+		}
+		// consecutive words in the same font are written together, so
+		// the text can be copied with the spaces between them
+		x := indent
+		var run []byte
+		for i, word := range words[:n] {
+			if i > 0 && words[i-1].space {
+				run = append(run, ' ')
+			}
+			run = append(run, word.text...)
+			if i == n-1 || words[i+1].font != word.font {
+				x = d.text(word.font, size, x, run)
+				run = nil
+			}
+		}
+		d.y -= leading - size
+		words = words[n:]
+		first = false
+	}
+}
+
+// writes the lines as they are, in Courier on a grey background. Lines
+// which are wider than the page are wrapped
+func (d *pdfDoc) preformatted(contents string, size float64, indent float64) {
+	leading := size * 1.3
+	perLine := max(int((pdfPageWidth-pdfMargin-indent-8)/(size*0.6)), 1)
+	contents = strings.TrimSuffix(contents, "\n")
+	for _, line := range strings.Split(contents, "\n") {
+		encoded := encodeWinAnsi(expandTabs(line))
+		for first := true; first || len(encoded) > 0; first = false {
+			chunk := encoded[:min(perLine, len(encoded))]
+			encoded = encoded[len(chunk):]
+			d.ensure(leading)
+			fmt.Fprintf(d.page, "0.94 g %.2f %.2f %.2f %.2f re f 0 g\n", indent, d.y-leading, pdfPageWidth-pdfMargin-indent, leading)
+			d.y -= size
+			d.text(fontCode, size, indent+4, chunk)
+			d.y -= leading - size
+		}
+	}
+}
+
+// tabs replaced with spaces, to the next multiple of 4
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var expanded strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := 4 - column%4
+			expanded.WriteString(strings.Repeat(" ", n))
+			column += n
+			continue
+		}
+		expanded.WriteRune(r)
+		column++
+	}
+	return expanded.String()
+}
+
+// a horizontal line across the page
+func (d *pdfDoc) rule() {
+	d.ensure(12)
+	d.y -= 6
+	fmt.Fprintf(d.page, "0.6 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", pdfMargin, d.y, pdfPageWidth-pdfMargin, d.y)
+	d.y -= 6
+}
+
+// a vertical line next to the text between top and the current line,
+// for quotes
+func (d *pdfDoc) bar(x float64, top float64) {
+	fmt.Fprintf(d.page, "0.7 G 2 w %.2f %.2f m %.2f %.2f l S 0 G\n", x, top, x, d.y)
+}
+
+// the document, with the title and page number in the footer of each page
+func (d *pdfDoc) encode(modTime time.Time) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// the catalog, page tree and fonts come first, then each page and its contents
+	fonts := len(pdfFontNames)
+	firstPage := 3 + fonts
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	var kids strings.Builder
+	for i := range d.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", firstPage+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.TrimSpace(kids.String()), len(d.pages)))
+	var fontRefs strings.Builder
+	for i, name := range pdfFontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fmt.Fprintf(&fontRefs, "/F%d %d 0 R ", i+1, 3+i)
+	}
+	title := encodeWinAnsi(d.title)
+	for i, page := range d.pages {
+		number := encodeWinAnsi(fmt.Sprintf("%d / %d", i+1, len(d.pages)))
+		footerY := pdfMargin / 2
+		fmt.Fprintf(page, "0.4 g BT /F1 %.1f Tf %.2f %.2f Td %s Tj ET\n", pdfFooterSize, pdfMargin, footerY, pdfString(title))
+		fmt.Fprintf(page, "BT /F1 %.1f Tf %.2f %.2f Td %s Tj ET 0 g\n", pdfFooterSize, pdfPageWidth-pdfMargin-textWidth(fontRegular, pdfFooterSize, number), footerY, pdfString(number))
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s>> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, fontRefs.String(), firstPage+2*i+1))
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(page.Bytes())
+		zw.Close()
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+	}
+	object(fmt.Sprintf("<< /Title %s /Producer (subpath-serve) /ModDate (D:%s) >>", pdfString(title), modTime.UTC().Format("20060102150405Z")))
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	return out.Bytes()
+}
+
+// font sizes of headings, by their level
+var pdfHeadingSizes = [...]float64{20, 16, 13, 11.5, 11, 11}
+
+const (
+	pdfBodySize = 10.5
+	pdfCodeSize = 8.5
+	// how far each level of a list or quote is indented
+	pdfIndent = 18.0
+)
+
+// renders the markdown as a PDF, with headings, paragraphs, lists,
+// quotes and code blocks laid out like the ?dark view
+func markdownPDF(d *pdfDoc, source []byte) {
+	doc := markdownRenderer.Parser().Parse(text.NewReader(source))
+	for block := doc.FirstChild(); block != nil; block = block.NextSibling() {
+		pdfBlock(d, source, block, pdfMargin, false)
+	}
+}
+
+// lays out a block, and the blocks in it
+func pdfBlock(d *pdfDoc, source []byte, node ast.Node, indent float64, quoted bool) {
+	base := fontRegular
+	if quoted {
+		base = fontItalic
+	}
+	switch n := node.(type) {
+	case *ast.Heading:
+		size := pdfHeadingSizes[n.Level-1]
+		// keep the heading on the same page as what's after it
+		d.ensure(size*2 + pdfBodySize*3)
+		d.space(size * 0.6)
+		d.paragraph(pdfSpans(source, n, fontBold), size, indent, "")
+		d.space(size * 0.2)
+	case *ast.Paragraph, *ast.TextBlock:
+		d.paragraph(pdfSpans(source, n, base), pdfBodySize, indent, "")
+		if _, ok := n.(*ast.Paragraph); ok {
+			d.space(pdfBodySize * 0.6)
+		}
+	case *ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock:
+		var code strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			code.Write(segment.Value(source))
+		}
+		d.preformatted(code.String(), pdfCodeSize, indent)
+		d.space(pdfBodySize * 0.6)
+	case *ast.List:
+		number := n.Start
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := "•"
+			if n.IsOrdered() {
+				marker = fmt.Sprintf("%d.", number)
+				number++
+			}
+			for i, child := 0, item.FirstChild(); child != nil; i, child = i+1, child.NextSibling() {
+				// the marker is next to the first line of the item
+				if i == 0 && (child.Kind() == ast.KindParagraph || child.Kind() == ast.KindTextBlock) {
+					d.paragraph(pdfSpans(source, child, base), pdfBodySize, indent+pdfIndent, marker)
+					continue
+				}
+				if i == 0 {
+					d.paragraph(nil, pdfBodySize, indent+pdfIndent, marker)
+				}
+				pdfBlock(d, source, child, indent+pdfIndent, quoted)
+			}
+		}
+		d.space(pdfBodySize * 0.6)
+	case *ast.Blockquote:
+		top := d.y
+		page := d.page
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			pdfBlock(d, source, child, indent+pdfIndent, true)
+		}
+		// the bar is only drawn if the quote fits on one page
+		if d.page == page {
+			d.bar(indent+pdfIndent/3, top)
+		}
+	case *ast.ThematicBreak:
+		d.rule()
+	default:
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			pdfBlock(d, source, child, indent, quoted)
+		}
+	}
+}
+
+// the text of the inline nodes in the block, in the fonts for
+// emphasis, links and code
+func pdfSpans(source []byte, block ast.Node, font pdfFont) []pdfSpan {
+	var spans []pdfSpan
+	var walk func(n ast.Node, font pdfFont)
+	walk = func(n ast.Node, font pdfFont) {
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			switch c := child.(type) {
+			case *ast.Text:
+				text := string(c.Segment.Value(source))
+				switch {
+				case c.HardLineBreak():
+					text += "\n"
+				case c.SoftLineBreak():
+					text += " "
+				}
+				spans = append(spans, pdfSpan{text: text, font: font})
+			case *ast.String:
+				spans = append(spans, pdfSpan{text: string(c.Value), font: font})
+			case *ast.CodeSpan:
+				var code strings.Builder
+				for t := c.FirstChild(); t != nil; t = t.NextSibling() {
+					if s, ok := t.(*ast.Text); ok {
+						code.Write(s.Segment.Value(source))
+					}
+				}
+				spans = append(spans, pdfSpan{text: code.String(), font: fontCode})
+			case *ast.Emphasis:
+				emphasized := fontItalic
+				if c.Level == 2 || font == fontBold {
+					emphasized = fontBold
+				}
+				if (c.Level == 2 && font == fontItalic) || (c.Level == 1 && font == fontBold) {
+					emphasized = fontBoldItalic
+				}
+				walk(c, emphasized)
+			case *ast.Link:
+				walk(c, font)
+				// on paper, the URL has to be written out
+				if destination := string(c.Destination); !strings.HasPrefix(destination, "#") {
+					spans = append(spans, pdfSpan{text: " <" + destination + ">", font: font})
+				}
+			case *ast.AutoLink:
+				spans = append(spans, pdfSpan{text: string(c.URL(source)), font: font})
+			case *ast.Image:
+				spans = append(spans, pdfSpan{text: "[image: " + string(c.Text(source)) + "]", font: font})
+			case *ast.RawHTML:
+			default:
+				walk(c, font)
+			}
+		}
+	}
+	walk(block, font)
+	return spans
+}
+
+// the file as a PDF: markdown files are rendered, anything else is
+// written as it is, in Courier
+func renderPDF(name string, contents string, modTime time.Time) []byte {
+	d := newPDFDoc(name)
+	if isMarkdown(name) {
+		markdownPDF(d, []byte(contents))
+	} else {
+		d.preformatted(contents, pdfCodeSize, pdfMargin)
+	}
+	return d.encode(modTime)
+}
+
+// the URL of the file at p in the mount as a PDF, for the link in the
+// ?dark view, or an empty string if it's a binary file
+func pdfURL(basePath string, m *mount, p string, data []byte) string {
+	if isBinary(data) {
+		return ""
+	}
+	return (&url.URL{Path: basePath + "/" + mountPath(m, p), RawQuery: "pdf"}).String()
+}
+
+// responds with the file at p in the mount as a PDF, for ?pdf, or a 415
+// if it's a binary file
+func (s *server) servePDF(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, m *mount, p string, data []byte, info fs.FileInfo) {
+	if isBinary(data) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("%s isn't a text file, which ?pdf can render\n", p),
+			Title:        "415 - Unsupported Media Type",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	w.Header().Set("X-Filepath", p)
+	s.setCacheHeaders(w, m, p, false)
+	if notModified(w, r, info.ModTime()) {
+		return
+	}
+	defer timingsFrom(ctx).track("render")()
+	contents := string(data)
+	if hasFrontmatterExt(p) {
+		_, contents = splitFrontmatter(contents)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": path.Base(p) + ".pdf"}))
+	w.Write(renderPDF(mountPath(m, p), contents, info.ModTime()))
+}
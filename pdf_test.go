@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderPDF(t *testing.T) {
+	for name, contents := range map[string]string{
+		"notes.md":  "# Title\n\nA paragraph with **bold**, *italic* and `code` (in parens) \\ é.\n\n- a\n- b\n\n> quoted\n\n```\ncode\n```\n",
+		"long.txt":  strings.Repeat("a line of code\tafter a tab\n", 150),
+		"empty.txt": "",
+	} {
+		pdf := renderPDF(name, contents, time.Now())
+		// every object is where the xref table says it is
+		match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+		if match == nil {
+			t.Fatalf("%s: no startxref", name)
+		}
+		xref, _ := strconv.Atoi(string(match[1]))
+		lines := strings.Split(string(pdf[xref:]), "\n")
+		if lines[0] != "xref" {
+			t.Fatalf("%s: startxref points to %q", name, lines[0])
+		}
+		var objects int
+		fmt.Sscanf(lines[1], "0 %d", &objects)
+		for i := 1; i < objects; i++ {
+			offset, _ := strconv.Atoi(lines[2+i][:10])
+			if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i))) {
+				t.Errorf("%s: object %d isn't at offset %d", name, i, offset)
+			}
+		}
+	}
+	// 100 lines which are each wrapped onto 2, with 66 on a page
+	pages := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(renderPDF("long.txt", strings.Repeat(strings.Repeat("x", 100)+"\n", 100), time.Now()))
+	if string(pages[1]) != "4" {
+		t.Errorf("long.txt has %s pages, expected 4", pages[1])
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words := splitWords([]pdfSpan{{text: "with ", font: fontRegular}, {text: "bold", font: fontBold}, {text: ", and\nnext", font: fontRegular}})
+	var got []string
+	for _, word := range words {
+		got = append(got, fmt.Sprintf("%s:%d:%t:%t", word.text, word.font, word.space, word.br))
+	}
+	expected := "with:0:true:false bold:1:false:false ,:0:true:false and:0:false:true next:0:false:false"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("splitWords = %s, expected %s", strings.Join(got, " "), expected)
+	}
+}
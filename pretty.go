@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// a value in a JSON/YAML/TOML file, for ?pretty
+//
+// objects/arrays have children (objects keep the order
+// from the file), scalars have a value to display
+type prettyNode struct {
+	key      string
+	value    string
+	isArray  bool
+	children []*prettyNode
+	// true for objects and arrays
+	isContainer bool
+}
+
+// parses the file at p, if it's a JSON/YAML/TOML file
+//
+// returns nil if the file doesn't have one of those extensions
+func parsePretty(p string, data []byte) (*prettyNode, error) {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".json":
+		return parsePrettyJSON(data)
+	case ".yaml", ".yml":
+		return parsePrettyYAML(data)
+	case ".toml":
+		return parsePrettyTOML(data)
+	}
+	return nil, nil
+}
+
+func parsePrettyJSON(data []byte) (*prettyNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return node, nil
+}
+
+// reads the next value from dec, using tokens so keys stay in order
+func readJSONValue(dec *json.Decoder) (*prettyNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		node := &prettyNode{isContainer: true}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			child, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			child.key = key.(string)
+			node.children = append(node.children, child)
+		}
+		_, err := dec.Token()
+		return node, err
+	case json.Delim('['):
+		node := &prettyNode{isContainer: true, isArray: true}
+		for dec.More() {
+			child, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+		_, err := dec.Token()
+		return node, err
+	}
+	return &prettyNode{value: scalarString(token)}, nil
+}
+
+func parsePrettyYAML(data []byte) (*prettyNode, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	conv := &yamlConverter{visiting: make(map[*yaml.Node]bool)}
+	docs := []*prettyNode{}
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		node, err := conv.node(&doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, node)
+	}
+	if len(docs) == 1 {
+		return docs[0], nil
+	}
+	// a stream of documents is displayed as an array
+	return &prettyNode{isContainer: true, isArray: true, children: docs}, nil
+}
+
+// maximum number of values displayed for a YAML file, since
+// aliases can expand a small file into a huge tree (billion laughs)
+const maxYAMLNodes = 100000
+
+// converts yaml.Nodes to prettyNodes, expanding aliases
+type yamlConverter struct {
+	// the anchors of the aliases currently being expanded,
+	// an alias to one of these would recurse forever
+	visiting map[*yaml.Node]bool
+	count    int
+}
+
+func (c *yamlConverter) node(n *yaml.Node) (*prettyNode, error) {
+	if c.count++; c.count > maxYAMLNodes {
+		return nil, fmt.Errorf("more than %d values (after expanding aliases)", maxYAMLNodes)
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return &prettyNode{value: "null"}, nil
+		}
+		return c.node(n.Content[0])
+	case yaml.AliasNode:
+		if c.visiting[n.Alias] {
+			return nil, fmt.Errorf("alias *%s on line %d refers to itself", n.Value, n.Line)
+		}
+		c.visiting[n.Alias] = true
+		defer delete(c.visiting, n.Alias)
+		return c.node(n.Alias)
+	case yaml.MappingNode:
+		node := &prettyNode{isContainer: true}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			child, err := c.node(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			child.key = n.Content[i].Value
+			node.children = append(node.children, child)
+		}
+		return node, nil
+	case yaml.SequenceNode:
+		node := &prettyNode{isContainer: true, isArray: true}
+		for _, item := range n.Content {
+			child, err := c.node(item)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+		return node, nil
+	}
+	if n.Tag == "!!str" {
+		return &prettyNode{value: scalarString(n.Value)}, nil
+	}
+	return &prettyNode{value: n.Value}, nil
+}
+
+func parsePrettyTOML(data []byte) (*prettyNode, error) {
+	var values map[string]interface{}
+	md, err := toml.Decode(string(data), &values)
+	if err != nil {
+		return nil, err
+	}
+	// decoding into a map loses the order of the keys,
+	// so sort them by where they first appear in the file
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		if _, ok := order[key.String()]; !ok {
+			order[key.String()] = i
+		}
+	}
+	return tomlNode("", values, order), nil
+}
+
+func tomlNode(keyPath string, value interface{}, order map[string]int) *prettyNode {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &prettyNode{isContainer: true}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		childPath := func(key string) string {
+			if keyPath == "" {
+				return toml.Key{key}.String()
+			}
+			return keyPath + "." + toml.Key{key}.String()
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return order[childPath(keys[i])] < order[childPath(keys[j])]
+		})
+		for _, key := range keys {
+			child := tomlNode(childPath(key), v[key], order)
+			child.key = key
+			node.children = append(node.children, child)
+		}
+		return node
+	case []map[string]interface{}:
+		node := &prettyNode{isContainer: true, isArray: true}
+		for _, item := range v {
+			node.children = append(node.children, tomlNode(keyPath, item, order))
+		}
+		return node
+	case []interface{}:
+		node := &prettyNode{isContainer: true, isArray: true}
+		for _, item := range v {
+			node.children = append(node.children, tomlNode(keyPath, item, order))
+		}
+		return node
+	}
+	return &prettyNode{value: scalarString(value)}
+}
+
+// strings are quoted, everything else is displayed as-is
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		quoted, _ := json.Marshal(v)
+		return string(quoted)
+	}
+	return fmt.Sprint(value)
+}
+
+// renders the node as nested, collapsible <details> elements
+func (n *prettyNode) html() template.HTML {
+	var b strings.Builder
+	b.WriteString(`<div class="pretty">`)
+	n.writeHTML(&b)
+	b.WriteString("</div>")
+	return template.HTML(b.String())
+}
+
+func (n *prettyNode) writeHTML(b *strings.Builder) {
+	key := ""
+	if n.key != "" {
+		key = fmt.Sprintf(`<span class="key">%s</span>: `, html.EscapeString(n.key))
+	}
+	if !n.isContainer {
+		fmt.Fprintf(b, "<div>%s%s</div>\n", key, html.EscapeString(n.value))
+		return
+	}
+	open, closing := "{", "}"
+	if n.isArray {
+		open, closing = "[", "]"
+	}
+	if len(n.children) == 0 {
+		fmt.Fprintf(b, "<div>%s%s%s</div>\n", key, open, closing)
+		return
+	}
+	fmt.Fprintf(b, "<details open><summary>%s%s <span class=\"count\">%d</span></summary>\n", key, open, len(n.children))
+	for _, child := range n.children {
+		child.writeHTML(b)
+	}
+	fmt.Fprintf(b, "</details><div>%s</div>\n", closing)
+}
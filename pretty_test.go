@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePrettyYAMLAliases(t *testing.T) {
+	node, err := parsePretty("a.yaml", []byte("base: &base\n  x: 1\nchild: *base\nother: *base\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.children) != 3 || len(node.children[1].children) != 1 || node.children[2].children[0].value != "1" {
+		t.Errorf("aliases weren't expanded: %+v", node)
+	}
+	// an alias inside the value it refers to
+	if _, err := parsePretty("a.yaml", []byte("a: &a\n  b: *a\n")); err == nil {
+		t.Errorf("expected an error for a recursive alias")
+	}
+	// billion laughs, each level doubles the number of values
+	var doc strings.Builder
+	doc.WriteString("a0: &a0 [lol, lol]\n")
+	for i := 1; i < 40; i++ {
+		fmt.Fprintf(&doc, "a%d: &a%d [*a%d, *a%d]\n", i, i, i-1, i-1)
+	}
+	if _, err := parsePretty("a.yaml", []byte(doc.String())); err == nil {
+		t.Errorf("expected an error for exponentially expanding aliases")
+	}
+}
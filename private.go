@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// returned (wrapped in fs.ErrPermission) when reading a private
+// file without authenticating or a signed URL
+var errPrivate = fmt.Errorf("%w: private file", fs.ErrPermission)
+
+// files which can only be read by users from -auth-file (or who logged
+// in with -oidc-issuer, or from -auth-header), or with a signed URL from /-/sign. They're still
+// listed, only their contents (and searching their contents) are protected
+type privateFiles struct {
+	patterns []*regexp.Regexp
+	// signs the URLs from /-/sign
+	key []byte
+}
+
+// compiles each -private pattern, matched against the path of a file
+// (starting with the mount name, with -mount, and without the @<ref>/
+// with -git-refs), where * matches anything
+//
+// the key is read from keyFile, or generated if it's empty, in which case
+// signed URLs stop working when the server restarts
+func newPrivateFiles(patterns multiFlag, keyFile string) (*privateFiles, error) {
+	p := &privateFiles{}
+	for _, pattern := range patterns {
+		compiled, err := compilePathPattern("private", pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, compiled)
+	}
+	if keyFile == "" {
+		p.key = make([]byte, 32)
+		if _, err := rand.Read(p.key); err != nil {
+			return nil, fmt.Errorf("could not generate signing key: %w", err)
+		}
+		return p, nil
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %w", err)
+	}
+	p.key = []byte(strings.TrimSpace(string(key)))
+	if len(p.key) < 16 {
+		return nil, fmt.Errorf("signing key in '%s' is too short, expected at least 16 characters", keyFile)
+	}
+	return p, nil
+}
+
+// compiles a pattern from a flag (e.g. -private), matched against the
+// path of a file starting with the mount name, where * matches anything
+func compilePathPattern(flagName string, pattern string) (*regexp.Regexp, error) {
+	pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+	if pattern == "" {
+		return nil, fmt.Errorf("empty -%s pattern", flagName)
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return regexp.MustCompile("^" + expr + "$"), nil
+}
+
+func (p *privateFiles) matches(path string) bool {
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// the signature for a URL to path, which is valid until expires (a unix timestamp)
+func (p *privateFiles) signature(path string, expires string) string {
+	mac := hmac.New(sha256.New, p.key)
+	fmt.Fprintf(mac, "%s\x00%s", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// whether sig is the signature for path, and it hasn't expired
+func (p *privateFiles) verify(path string, expires string, sig string) bool {
+	timestamp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > timestamp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(p.signature(path, expires)))
+}
+
+// what a request can use to read private files
+type access struct {
+	authenticated bool
+	// logged in with OpenID Connect, nil if not
+	session *oidcSession
+	// from the ?expires= and ?sig= of a signed URL
+	expires string
+	sig     string
+}
+
+type accessKey struct{}
+
+func withAccess(ctx context.Context, a *access) context.Context {
+	return context.WithValue(ctx, accessKey{}, a)
+}
+
+func accessFrom(ctx context.Context) *access {
+	a, _ := ctx.Value(accessKey{}).(*access)
+	if a == nil {
+		return &access{}
+	}
+	return a
+}
+
+// authenticates the request, if it could read private files
+func (s *server) requestAccess(r *http.Request) *access {
+	a := &access{}
+	if len(s.private.patterns) == 0 {
+		return a
+	}
+	if _, _, ok := r.BasicAuth(); ok && s.config.users != nil {
+		_, a.authenticated = s.config.users.authenticate(r)
+	}
+	if _, ok := s.headerUser(r); ok {
+		a.authenticated = true
+	}
+	if s.authExempt(r) {
+		a.authenticated = true
+	}
+	if s.oidc != nil {
+		a.session, _ = s.oidc.session(r)
+	}
+	query := r.URL.Query()
+	a.expires, a.sig = query.Get("expires"), query.Get("sig")
+	return a
+}
+
+// whether the file at p in the mount matches a -private pattern. For a
+// git ref, that's without the @<ref>/, so the same files are private
+// in every branch/tag
+func (s *server) isPrivate(m *mount, p string) bool {
+	return s.private.matches(privatePath(m, p))
+}
+
+// the path -private patterns (and -oidc-rules) are matched against
+func privatePath(m *mount, p string) string {
+	if m.parent != nil {
+		m = m.parent
+	}
+	return mountPath(m, p)
+}
+
+// marks a response which depends on how the request is authenticated (or
+// signed), so proxies/CDNs don't cache it and serve it to anyone else
+func setPrivateCache(w http.ResponseWriter) {
+	w.Header().Del("Surrogate-Key")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Add("Vary", "Authorization, Cookie")
+}
+
+// sets the Surrogate-Key header for the file/directory at p in the
+// mount, or if it's private, marks the response as uncacheable
+func (s *server) setCacheHeaders(w http.ResponseWriter, m *mount, p string, isDir bool) {
+	if !isDir && s.isPrivate(m, p) {
+		setPrivateCache(w)
+		return
+	}
+	setSurrogateKeys(w, mountPath(m, p), isDir)
+}
+
+// like setCacheHeaders, for responses with (or about) the contents of
+// every file, which include private files if the request can read them
+func (s *server) setCacheHeadersAll(w http.ResponseWriter) {
+	if len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+		return
+	}
+	setSurrogateKeys(w, "", true)
+}
+
+// returns errPrivate if the file at p in the mount is private, and the
+// request isn't authenticated or signed for it
+func (s *server) checkPrivate(ctx context.Context, m *mount, p string) error {
+	if !s.isPrivate(m, p) {
+		return nil
+	}
+	full := mountPath(m, p)
+	a := accessFrom(ctx)
+	if a.authenticated || (a.sig != "" && s.private.verify(full, a.expires, a.sig)) {
+		return nil
+	}
+	if a.session != nil && s.oidc.allows(a.session, privatePath(m, p)) {
+		return nil
+	}
+	return &fs.PathError{Op: "open", Path: p, Err: errPrivate}
+}
+
+// the default, and maximum lifetime of a signed URL
+const (
+	defaultSignTTL = time.Hour
+	maxSignTTL     = 30 * 24 * time.Hour
+)
+
+// responds with a URL for the file matching ?path= which can be read
+// without authenticating (even if it's private), until ?ttl= passes
+func (s *server) serveSign(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if _, ok := s.requireAuth(w, r, opts); !ok {
+		return
+	}
+	ttl := defaultSignTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 || ttl > maxSignTTL {
+			w.WriteHeader(http.StatusBadRequest)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("Invalid ttl '%s', expected a duration (e.g. 1h) of at most %s\n", value, maxSignTTL),
+				Title:        "400 - Bad Request",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	query := strings.Trim(r.URL.Query().Get("path"), "/")
+	m, q, err := resolveMount(ctx, s.config.mounts, query)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if m == nil || q == "" {
+		s.serveNotFound(ctx, w, query, opts)
+		return
+	}
+	done := timingsFrom(ctx).track("walk")
+	foundPath, err := s.find(ctx, m, q)
+	done()
+	if errors.Is(err, ErrNotFound) {
+		s.serveNotFound(ctx, w, query, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	full := mountPath(m, foundPath)
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	// on the -canonical-url, like the share URLs
+	signed := s.shareURL(r, full, false) + "?" + url.Values{"expires": {expires}, "sig": {s.private.signature(full, expires)}}.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	render(&w, &PageInfo{
+		PageContents: signed + "\n",
+		Title:        "Signed URL",
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckPrivateRefs(t *testing.T) {
+	private, err := newPrivateFiles(multiFlag{"notes/*"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{private: private}
+	root := &mount{}
+	mounted := &mount{name: "dotfiles"}
+	for _, tt := range []struct {
+		m       *mount
+		p       string
+		private bool
+	}{
+		{root, "notes/a.md", true},
+		{root, "rc.conf", false},
+		{&mount{name: "@master", parent: root}, "notes/a.md", true},
+		{&mount{name: "@master", parent: root}, "rc.conf", false},
+		{mounted, "notes/a.md", false},
+		{&mount{name: "dotfiles/@v1.0", parent: mounted}, "notes/a.md", false},
+	} {
+		err := s.checkPrivate(context.Background(), tt.m, tt.p)
+		if got := errors.Is(err, errPrivate); got != tt.private {
+			t.Errorf("checkPrivate(%q, %q) = %v, expected private=%v", tt.m.name, tt.p, err, tt.private)
+		}
+	}
+	// signed for the URL including the ref
+	refMount := &mount{name: "@master", parent: root}
+	expires := "99999999999"
+	ctx := withAccess(context.Background(), &access{expires: expires, sig: private.signature("@master/notes/a.md", expires)})
+	if err := s.checkPrivate(ctx, refMount, "notes/a.md"); err != nil {
+		t.Errorf("signed URL for a ref wasn't accepted: %v", err)
+	}
+	if err := s.checkPrivate(ctx, root, "notes/a.md"); err == nil {
+		t.Errorf("signature for a ref was accepted for another path")
+	}
+}
+
+func TestSign(t *testing.T) {
+	dir := fixtureFolder(t)
+	s := newTestServer(t, dir, []string{"-folder", "{dir}", "-private", "nvim/*", "-auth-header", "X-Forwarded-User", "-canonical-url", "https://files.example.com/d"})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/-/sign?path=init.lua", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-User", "sean")
+	s.ServeHTTP(w, r)
+	// on the -canonical-url, not the Host the request was made to
+	signed := strings.TrimSpace(w.Body.String())
+	if w.Code != 200 || !strings.HasPrefix(signed, "https://files.example.com/d/nvim/init.lua?expires=") {
+		t.Fatalf("GET /-/sign = %d %q, expected a URL on the -canonical-url", w.Code, signed)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(signed, "https://files.example.com/d"), nil))
+	if w.Code != 200 || w.Body.String() != "require(\"plugins\")\nvim.opt.number = true\n" {
+		t.Errorf("GET %s = %d %q, expected the private file", signed, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sources which cache files/listings, and can drop
+// what they've cached for a path (or everything, for ".")
+type purger interface {
+	purge(p string)
+}
+
+// the surrogate keys a response for the file/directory at p
+// (a slash-separated path including the mount name) is tagged with
+//
+// responses are tagged with their own path and every directory above them,
+// so purging a directory purges everything under it. Listings are also
+// tagged with index:<dir>, so they can be purged when a file under them changes
+func surrogateKeys(p string, isDir bool) []string {
+	keys := []string{}
+	p = strings.Trim(p, "/")
+	if isDir {
+		keys = append(keys, "index:"+surrogatePath(p, true))
+	}
+	keys = append(keys, surrogatePath(p, isDir))
+	parts := splitPath(p)
+	for i := len(parts) - 1; i >= 0; i-- {
+		keys = append(keys, surrogatePath(strings.Join(parts[:i], "/"), true))
+	}
+	return keys
+}
+
+// the keys to purge from a CDN when the path changes. That's the
+// path itself, and the listings for each directory above it
+func purgeKeys(p string, isDir bool) []string {
+	p = strings.Trim(p, "/")
+	keys := []string{surrogatePath(p, isDir)}
+	parts := splitPath(p)
+	for i := len(parts) - 1; i >= 0; i-- {
+		keys = append(keys, "index:"+surrogatePath(strings.Join(parts[:i], "/"), true))
+	}
+	return keys
+}
+
+// escaped, so keys don't contain spaces (keys in the header are space-separated)
+func surrogatePath(p string, isDir bool) string {
+	if isDir && p != "" {
+		p += "/"
+	}
+	return (&url.URL{Path: "/" + p}).EscapedPath()
+}
+
+// the path of the file/directory at p in the mount, including the mount name
+func mountPath(m *mount, p string) string {
+	if p == "." {
+		p = ""
+	}
+	if m.name == "" {
+		return p
+	}
+	return strings.TrimSuffix(m.name+"/"+p, "/")
+}
+
+func setSurrogateKeys(w http.ResponseWriter, p string, isDir bool) {
+	w.Header().Set("Surrogate-Key", strings.Join(surrogateKeys(p, isDir), " "))
+}
+
+// drops anything cached for ?path= (the path of a file, or a directory
+// ending with a '/', as it would be requested), and purges the
+// surrogate keys for it from the CDN, if -purge-url is set
+func (s *server) servePurge(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	user, ok := s.requireAuth(w, r, opts)
+	if !ok {
+		return
+	}
+	reqPath := strings.TrimLeft(r.URL.Query().Get("path"), "/")
+	isDir := reqPath == "" || strings.HasSuffix(reqPath, "/")
+	reqPath = strings.TrimSuffix(reqPath, "/")
+	for _, m := range s.config.mounts {
+		src, ok := m.src.(purger)
+		if !ok {
+			continue
+		}
+		// the path may be for another mount
+		if mount, p := matchMount([]*mount{m}, reqPath); mount != nil || reqPath == "" {
+			if p == "" {
+				p = "."
+			}
+			src.purge(p)
+		}
+	}
+	for _, cache := range []*byteCache{s.renderCache, s.thumbnails} {
+		if cache != nil {
+			cache.purge(reqPath)
+		}
+	}
+	if s.dirSizes != nil {
+		s.dirSizes.purge(reqPath)
+	}
+	keys := purgeKeys(reqPath, isDir)
+	if s.config.purgeURL != "" {
+		if err := s.purgeCDN(ctx, keys); err != nil {
+			log.Printf("Error purging %s from CDN: %s\n", strings.Join(keys, " "), err)
+			w.WriteHeader(http.StatusBadGateway)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("Purged caches, but purging from the CDN failed: %s\n", err),
+				Title:        "502 - Bad Gateway",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	log.Printf("%s purged %s\n", user, strings.Join(keys, " "))
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Purged %s\n", strings.Join(keys, " ")),
+		Title:        "Purge",
+	}, s.tmpl, opts.isDark)
+}
+
+// calls -purge-url to purge the keys from a CDN
+//
+// if the URL contains {key}, makes a POST request for each key (e.g. for fastly),
+// else makes one POST request with a JSON body of {"tags": keys} (e.g. for cloudflare)
+func (s *server) purgeCDN(ctx context.Context, keys []string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	do := func(target string, body []byte) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range s.config.purgeHeaders {
+			req.Header[name] = values
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("%s responded with %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(respBody)))
+		}
+		return nil
+	}
+	if strings.Contains(s.config.purgeURL, "{key}") {
+		for _, key := range keys {
+			if err := do(strings.ReplaceAll(s.config.purgeURL, "{key}", url.PathEscape(key)), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return err
+	}
+	return do(s.config.purgeURL, body)
+}
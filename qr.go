@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// a minimal QR code encoder, for printing the URL of the server in the
+// terminal at startup with -qr. Only supports what that needs: byte mode,
+// error correction level L, and versions 1-5 (up to 106 bytes)
+
+// number of error correction codewords (in one block), and the
+// total number of codewords, for each version with level L
+var qrVersions = [...]struct {
+	ecCodewords    int
+	totalCodewords int
+}{
+	{7, 26},
+	{10, 44},
+	{15, 70},
+	{20, 100},
+	{26, 134},
+}
+
+// a QR code, true for dark modules
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// encodes data in the smallest version it fits in
+func encodeQR(data []byte) (*qrCode, error) {
+	for v, version := range qrVersions {
+		dataCodewords := version.totalCodewords - version.ecCodewords
+		// mode (4 bits) and length (8 bits), before the data
+		if len(data)+2 > dataCodewords {
+			continue
+		}
+		codewords := qrDataCodewords(data, dataCodewords)
+		codewords = append(codewords, rsRemainder(codewords, rsGenerator(version.ecCodewords))...)
+		return newQRCode(v+1, codewords), nil
+	}
+	return nil, errors.New("too long for a QR code")
+}
+
+// the data codewords for data in byte mode, padded to n codewords
+func qrDataCodewords(data []byte, n int) []byte {
+	var bits []bool
+	appendBits := func(value int, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), 8)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	// terminator, then pad to a whole byte
+	appendBits(0, min(4, n*8-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	codewords := make([]byte, 0, n)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xec); len(codewords) < n; pad ^= 0xec ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// multiplies in GF(2^8), modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// the coefficients of the Reed-Solomon generator polynomial of degree,
+// from the highest to the lowest power, without the leading 1
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for range degree {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// the error correction codewords for data
+func rsRemainder(data []byte, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range generator {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+func newQRCode(version int, codewords []byte) *qrCode {
+	size := version*4 + 17
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range size {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(codewords)
+	best, bestPenalty := 0, -1
+	for mask := range 8 {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty == -1 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// masks are undone by applying them again
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// draws the finder, timing and alignment patterns, and
+// reserves the modules for the format information
+func (q *qrCode) drawFunctionPatterns(version int) {
+	for i := range q.size {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, corner := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		// the pattern and the separator around it
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= q.size || y < 0 || y >= q.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				q.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+	// versions 2-5 have one alignment pattern, near the bottom right corner
+	if version > 1 {
+		center := q.size - 7
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				q.set(center+dx, center+dy, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+	q.drawFormatBits(0)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// the format information for the error correction level (L) and mask,
+// with its BCH error correction bits
+func qrFormatBits(mask int) int {
+	data := 1<<3 | mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// draws the format information, in both places
+func (q *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	// always dark
+	q.set(8, q.size-8, true)
+}
+
+// places the codewords in the modules which aren't function patterns,
+// in two-module wide columns, zigzagging up and down from the bottom right
+func (q *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range q.size {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if q.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// inverts the modules which aren't function patterns where the mask is true
+func (q *qrCode) applyMask(mask int) {
+	for y := range q.size {
+		for x := range q.size {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// how hard the code is to scan, lower is better: penalizes runs of
+// the same color, 2x2 blocks and an imbalance of dark and light modules
+func (q *qrCode) penalty() int {
+	penalty, dark := 0, 0
+	for a := range q.size {
+		rowRun, colRun := 1, 1
+		for b := 1; b < q.size; b++ {
+			for _, run := range []struct {
+				same bool
+				n    *int
+			}{
+				{q.modules[a][b] == q.modules[a][b-1], &rowRun},
+				{q.modules[b][a] == q.modules[b-1][a], &colRun},
+			} {
+				if !run.same {
+					*run.n = 1
+					continue
+				}
+				*run.n++
+				if *run.n == 5 {
+					penalty += 3
+				} else if *run.n > 5 {
+					penalty++
+				}
+			}
+		}
+	}
+	for y := range q.size {
+		for x := range q.size {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 && q.modules[y][x] == q.modules[y][x-1] && q.modules[y][x] == q.modules[y-1][x] && q.modules[y][x] == q.modules[y-1][x-1] {
+				penalty += 3
+			}
+		}
+	}
+	total := q.size * q.size
+	penalty += abs(dark*20-total*10) / total * 10
+	return penalty
+}
+
+// the code, drawn with half blocks (two rows per line) in black on
+// white, with a quiet zone around it, so it scans on dark terminals
+func (q *qrCode) terminal() string {
+	const quiet = 4
+	dark := func(x, y int) bool {
+		return x >= 0 && x < q.size && y >= 0 && y < q.size && q.modules[y][x]
+	}
+	var b strings.Builder
+	for y := -quiet; y < q.size+quiet; y += 2 {
+		b.WriteString("\x1b[30;47m")
+		for x := -quiet; x < q.size+quiet; x++ {
+			top, bottom := dark(x, y), dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
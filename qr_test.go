@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQRFormatBits(t *testing.T) {
+	for mask, expected := range []int{0b111011111000100, 0b111001011110011, 0b111110110101010} {
+		if bits := qrFormatBits(mask); bits != expected {
+			t.Errorf("format bits for mask %d = %015b, expected %015b", mask, bits, expected)
+		}
+	}
+}
+
+func TestRSRemainder(t *testing.T) {
+	// HELLO WORLD, version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if ec := rsRemainder(data, rsGenerator(10)); !bytes.Equal(ec, expected) {
+		t.Errorf("rsRemainder = %v, expected %v", ec, expected)
+	}
+}
+
+// reads the codewords back out of the code, like a scanner would
+func TestEncodeQR(t *testing.T) {
+	for _, data := range []string{"http://192.168.1.5:8050/", "http://[fd00::1234:5678:9abc:def0]:8050/some/longer/base/path/"} {
+		q, err := encodeQR([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		version := (q.size - 17) / 4
+		// the first copy of the format information
+		bits := 0
+		for i := 14; i >= 9; i-- {
+			bits = bits<<1 | btoi(q.modules[8][14-i])
+		}
+		bits = bits<<1 | btoi(q.modules[8][7])
+		bits = bits<<1 | btoi(q.modules[8][8])
+		bits = bits<<1 | btoi(q.modules[7][8])
+		for i := 5; i >= 0; i-- {
+			bits = bits<<1 | btoi(q.modules[i][8])
+		}
+		mask := -1
+		for m := range 8 {
+			if qrFormatBits(m) == bits {
+				mask = m
+			}
+		}
+		if mask == -1 {
+			t.Fatalf("%q: invalid format bits %015b", data, bits)
+		}
+		q.applyMask(mask)
+		codewords := make([]byte, qrVersions[version-1].totalCodewords)
+		i := 0
+		for right := q.size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := range q.size {
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				for j := range 2 {
+					if x := right - j; !q.function[y][x] && i < len(codewords)*8 {
+						codewords[i>>3] |= byte(btoi(q.modules[y][x])) << (7 - i&7)
+						i++
+					}
+				}
+			}
+		}
+		ec := qrVersions[version-1].ecCodewords
+		dataCodewords := codewords[:len(codewords)-ec]
+		if !bytes.Equal(rsRemainder(dataCodewords, rsGenerator(ec)), codewords[len(codewords)-ec:]) {
+			t.Errorf("%q: error correction codewords don't match", data)
+		}
+		if mode, length := dataCodewords[0]>>4, int(dataCodewords[0]&0xf)<<4|int(dataCodewords[1]>>4); mode != 0b0100 || length != len(data) {
+			t.Fatalf("%q: mode %04b, length %d", data, mode, length)
+		}
+		decoded := make([]byte, len(data))
+		for j := range decoded {
+			decoded[j] = dataCodewords[j+1]<<4 | dataCodewords[j+2]>>4
+		}
+		if string(decoded) != data {
+			t.Errorf("decoded %q, expected %q", decoded, data)
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// a token from -quota-tokens, which clients send to have their own quota,
+// instead of the one for their IP address. Requests and MiB override
+// -quota-requests and -quota-bytes if they're set, 0 for no limit
+type quotaToken struct {
+	Name     string `toml:"name"`
+	Token    string `toml:"token"`
+	Requests *int   `toml:"requests"`
+	MiB      *int64 `toml:"mib"`
+}
+
+func loadQuotaTokens(tokensFile string) (map[string]*quotaToken, error) {
+	var file struct {
+		Tokens []*quotaToken `toml:"token"`
+	}
+	if _, err := toml.DecodeFile(tokensFile, &file); err != nil {
+		return nil, fmt.Errorf("could not parse quota tokens '%s': %w", tokensFile, err)
+	}
+	tokens := make(map[string]*quotaToken)
+	names := make(map[string]bool)
+	for i, t := range file.Tokens {
+		if t.Name == "" || len(t.Token) < 16 {
+			return nil, fmt.Errorf("token %d in '%s' needs a name, and a token of at least 16 characters", i+1, tokensFile)
+		}
+		if names[t.Name] || tokens[t.Token] != nil {
+			return nil, fmt.Errorf("token '%s' in '%s' is a duplicate", t.Name, tokensFile)
+		}
+		if (t.Requests != nil && *t.Requests < 0) || (t.MiB != nil && *t.MiB < 0) {
+			return nil, fmt.Errorf("token '%s' in '%s' has a negative quota", t.Name, tokensFile)
+		}
+		names[t.Name] = true
+		tokens[t.Token] = t
+	}
+	return tokens, nil
+}
+
+// what a client used today
+type quotaUsage struct {
+	Requests int   `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// daily request and byte quotas for each client (IP address) or token,
+// from -quota-requests, -quota-bytes and -quota-tokens
+//
+// the usage is kept in memory, and reset at midnight UTC
+type quotas struct {
+	// the limits for each IP address, 0 for no limit
+	requests int
+	bytes    int64
+	// by the token
+	tokens map[string]*quotaToken
+
+	mu sync.Mutex
+	// the UTC day the usage is for
+	date  string
+	usage map[string]*quotaUsage
+}
+
+func newQuotas(requests int, bytes int64, tokensFile string) (*quotas, error) {
+	q := &quotas{requests: requests, bytes: bytes, tokens: make(map[string]*quotaToken)}
+	if tokensFile != "" {
+		tokens, err := loadQuotaTokens(tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		q.tokens = tokens
+	}
+	return q, nil
+}
+
+// who a request counts against, and their limits
+type quotaClient struct {
+	// ip:<address> or token:<name>
+	key      string
+	requests int
+	bytes    int64
+}
+
+// the client for the request: the token from an 'Authorization: Bearer'
+// header or ?token=, else its IP address. Tokens which aren't in
+// -quota-tokens (e.g. for an API behind the same proxy) count against the
+// IP address, like requests without one
+func (q *quotas) client(r *http.Request) *quotaClient {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	t, ok := q.tokens[token]
+	if token == "" || !ok {
+		return &quotaClient{key: "ip:" + clientIP(r), requests: q.requests, bytes: q.bytes}
+	}
+	c := &quotaClient{key: "token:" + t.Name, requests: q.requests, bytes: q.bytes}
+	if t.Requests != nil {
+		c.requests = *t.Requests
+	}
+	if t.MiB != nil {
+		c.bytes = *t.MiB << 20
+	}
+	return c
+}
+
+// what the client used today. Must be called with q.mu held
+func (q *quotas) usageLocked(key string) *quotaUsage {
+	today := time.Now().UTC().Format(time.DateOnly)
+	if q.date != today {
+		q.date, q.usage = today, make(map[string]*quotaUsage)
+	}
+	u, ok := q.usage[key]
+	if !ok {
+		u = &quotaUsage{}
+		q.usage[key] = u
+	}
+	return u
+}
+
+// a copy of what the client used today
+func (q *quotas) used(key string) quotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.usageLocked(key)
+}
+
+// counts a request against the client's quota, unless it's used up
+func (q *quotas) take(c *quotaClient) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.usageLocked(c.key)
+	if (c.requests != 0 && u.Requests >= c.requests) || (c.bytes != 0 && u.Bytes >= c.bytes) {
+		return false
+	}
+	u.Requests++
+	return true
+}
+
+func (q *quotas) addBytes(key string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.usageLocked(key).Bytes += n
+}
+
+// how long until the quotas reset, at midnight UTC
+func untilQuotaReset() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// counts the bytes of the response against the client's quota, and
+// responds with a 413 instead if it has a Content-Length larger than
+// what's left of the quota
+type quotaWriter struct {
+	http.ResponseWriter
+	q      *quotas
+	client *quotaClient
+
+	wroteHeader bool
+	rejected    bool
+}
+
+func (w *quotaWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.client.bytes != 0 && code >= 200 && code < 300 {
+		size, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+		if remaining := w.client.bytes - w.q.used(w.client.key).Bytes; err == nil && size > remaining {
+			w.rejected = true
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.ResponseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprintf(w.ResponseWriter, "The response is %s, which is more than the %s left of today's quota\n", humanizeBytes(size), humanizeBytes(max(remaining, 0)))
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	// the body of the response which was replaced is dropped
+	if w.rejected {
+		return len(p), nil
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.q.addBytes(w.client.key, int64(n))
+	return n, err
+}
+
+// counts the request against the quota of the client. Responds with a
+// 429 if it's used up, else returns the writer to respond with, which
+// counts the bytes written
+func (s *server) applyQuota(w http.ResponseWriter, r *http.Request, opts *requestOptions) (http.ResponseWriter, bool) {
+	c := s.quotas.client(r)
+	if !s.quotas.take(c) {
+		reset := untilQuotaReset()
+		w.Header().Set("Retry-After", strconv.Itoa(int(reset.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Used up today's quota, it resets in %s. See /-/quota\n", reset.Round(time.Minute)),
+			Title:        "429 - Too Many Requests",
+		}, s.tmpl, opts.isDark)
+		return w, false
+	}
+	return &quotaWriter{ResponseWriter: w, q: s.quotas, client: c}, true
+}
+
+// the response for /-/quota
+type quotaStatus struct {
+	Client string     `json:"client"`
+	Used   quotaUsage `json:"used"`
+	// 0 for no limit
+	Limit quotaUsage `json:"limit"`
+	// seconds until the quota resets
+	ResetsIn int `json:"resets_in"`
+}
+
+// responds with what the client used of its quota today, which isn't
+// counted against it, or a JSON object with ?json
+func (s *server) serveQuota(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	if s.quotas == nil {
+		s.serveNotFound(ctx, w, "-/quota", opts)
+		return
+	}
+	c := s.quotas.client(r)
+	status := &quotaStatus{
+		Client:   c.key,
+		Used:     s.quotas.used(c.key),
+		Limit:    quotaUsage{Requests: c.requests, Bytes: c.bytes},
+		ResetsIn: int(untilQuotaReset().Seconds()),
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if hasQueryParam(r.URL.Query(), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	limit := func(used string, limit string, unlimited bool) string {
+		if unlimited {
+			return used + " (no limit)"
+		}
+		return used + " of " + limit
+	}
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Client:   %s\nRequests: %s\nBytes:    %s\nResets in %s\n",
+			status.Client,
+			limit(strconv.Itoa(status.Used.Requests), strconv.Itoa(c.requests), c.requests == 0),
+			limit(humanizeBytes(status.Used.Bytes), humanizeBytes(c.bytes), c.bytes == 0),
+			untilQuotaReset().Round(time.Minute)),
+		Title: "Quota",
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	one := 1
+	q := &quotas{requests: 2, bytes: 10, tokens: map[string]*quotaToken{
+		"0123456789abcdef": {Name: "ci", Token: "0123456789abcdef", Requests: &one},
+	}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	c := q.client(r)
+	if c.key != "ip:192.0.2.1" {
+		t.Fatalf("client() = %v", c)
+	}
+	if !q.take(c) || !q.take(c) || q.take(c) {
+		t.Errorf("expected exactly 2 requests to be allowed")
+	}
+	r.Header.Set("Authorization", "Bearer 0123456789abcdef")
+	token := q.client(r)
+	if token.key != "token:ci" || token.requests != 1 || token.bytes != 10 {
+		t.Fatalf("client() with a token = %v", token)
+	}
+	if !q.take(token) || q.take(token) {
+		t.Errorf("expected exactly 1 request to be allowed with the token")
+	}
+	// e.g. for an API behind the same proxy, so it's the IP address's quota
+	r.Header.Set("Authorization", "Bearer wrong")
+	if c := q.client(r); c.key != "ip:192.0.2.1" || c.requests != 2 {
+		t.Errorf("client() with an unknown token = %v, expected the IP address's quota", c)
+	}
+
+	// bytes are counted as they're written, and a response larger
+	// than what's left is replaced with a 413
+	c = &quotaClient{key: "ip:bytes", bytes: 10}
+	rec := httptest.NewRecorder()
+	w := &quotaWriter{ResponseWriter: rec, q: q, client: c}
+	w.Write([]byte("123456"))
+	if used := q.used(c.key).Bytes; used != 6 {
+		t.Errorf("used %d bytes, expected 6", used)
+	}
+	rec = httptest.NewRecorder()
+	w = &quotaWriter{ResponseWriter: rec, q: q, client: c}
+	w.Header().Set("Content-Length", "5")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("12345"))
+	if rec.Code != http.StatusRequestEntityTooLarge || q.used(c.key).Bytes != 6 {
+		t.Errorf("response larger than the quota = %d, used %d bytes", rec.Code, q.used(c.key).Bytes)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// the canonical plaintext URL for the file at p in the mount
+func rawURL(basePath string, m *mount, p string) string {
+	if m.name != "" {
+		p = m.name + "/" + p
+	}
+	return (&url.URL{Path: basePath + "/-/raw/" + p}).EscapedPath()
+}
+
+// responds with the file at exactly reqPath (relative to the mount)
+// as-is, without the matching strategy
+func (s *server) serveRaw(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, reqPath string) {
+	m, p, err := resolveMount(ctx, s.config.mounts, reqPath)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if m == nil || p == "" || p != path.Clean(p) || strings.HasPrefix(p, "../") || p == ".." || checkIgnored(p, false) != nil {
+		s.serveNotFound(ctx, w, reqPath, opts)
+		return
+	}
+	if err := s.checkPrivate(ctx, m, p); err != nil {
+		s.serveUnauthorized(w, r, opts)
+		return
+	}
+	if full := s.dynamicPath(m, p); full != "" {
+		if info, err := statFile(ctx, m.src, p); err != nil || !info.Mode().IsRegular() {
+			s.serveNotFound(ctx, w, reqPath, opts)
+			return
+		}
+		s.serveDynamic(ctx, w, r, opts, m, p, full)
+		return
+	}
+	done := timingsFrom(ctx).track("read")
+	err = s.checkFileSize(ctx, m, p)
+	var data []byte
+	var info fs.FileInfo
+	if err == nil {
+		data, info, err = readRegularFile(ctx, m.src, p)
+	}
+	done()
+	if errors.Is(err, fs.ErrNotExist) {
+		s.serveNotFound(ctx, w, reqPath, opts)
+		return
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		s.serveForbidden(w, reqPath, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	data = s.redact(data)
+	if opts.isSignature {
+		s.serveSignature(w, opts, m, p, data, info)
+		return
+	}
+	w.Header().Set("X-Filepath", p)
+	if target := symlinkTarget(m.src, p); target != "" {
+		w.Header().Set("X-Symlink-Target", target)
+	}
+	s.setCacheHeaders(w, m, p, false)
+	setScriptHeaders(w, data)
+	s.setMimeType(ctx, w, m, p, data)
+	serveContents(w, r, info.ModTime(), data)
+}
+
+// responds with the contents of a file as-is, with a strong ETag (from
+// the hash of the contents) and Last-Modified, so an interrupted download
+// can be resumed with a Range request. If the file changed since, an
+// If-Range with the old ETag (or modification time) doesn't match, so the
+// whole file is sent again, instead of the rest of the new one
+func serveContents(w http.ResponseWriter, r *http.Request, modTime time.Time, data []byte) {
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	// like a response without a Content-Type, instead of using the extension
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+	}
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+// sets the Last-Modified header for a file, and responds with
+// a 304 if it hasn't been modified since If-Modified-Since
+//
+// returns true if the request has been responded to
+func notModified(w http.ResponseWriter, r *http.Request, modTime time.Time) bool {
+	if modTime.IsZero() || modTime.Unix() <= 0 {
+		return false
+	}
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// like readFileInfo, but returns fs.ErrNotExist if p isn't a regular file,
+// or is in the local folder through a symlink
+func readRegularFile(ctx context.Context, src source, p string) ([]byte, fs.FileInfo, error) {
+	if local, ok := src.(*localSource); ok {
+		if err := local.noSymlinks(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	f, err := src.open(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return readFileInfo(ctx, src, p)
+}
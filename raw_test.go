@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeRaw(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("first half|second half"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := newPrivateFiles(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: &config{mounts: []*mount{{src: src}}}, private: private, dynamic: &dynamicFiles{}}
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/-/raw/file.txt", nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		s.serveRaw(context.Background(), w, r, &requestOptions{}, "file.txt")
+		return w
+	}
+	// the download is interrupted after the first half
+	w := get(map[string]string{"Range": "bytes=0-10"})
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "first half|" || etag == "" {
+		t.Fatalf("first half = %d %q, ETag %q", w.Code, w.Body.String(), etag)
+	}
+	// resuming it before the file changes gets the rest
+	w = get(map[string]string{"Range": "bytes=11-", "If-Range": etag})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "second half" {
+		t.Errorf("resumed download = %d %q", w.Code, w.Body.String())
+	}
+	// the file changes (keeping its size), resuming it gets all of the new file
+	if err := os.WriteFile(file, []byte("FIRST HALF|SECOND HALF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	for _, ifRange := range []string{etag, lastModified} {
+		w = get(map[string]string{"Range": "bytes=11-", "If-Range": ifRange})
+		if w.Code != http.StatusOK || w.Body.String() != "FIRST HALF|SECOND HALF" {
+			t.Errorf("resumed download of the changed file with If-Range %s = %d %q", ifRange, w.Code, w.Body.String())
+		}
+	}
+	// weak ETags never match
+	w = get(map[string]string{"Range": "bytes=11-", "If-Range": "W/" + get(nil).Header().Get("ETag")})
+	if w.Code != http.StatusOK {
+		t.Errorf("If-Range with a weak ETag = %d, expected 200", w.Code)
+	}
+	w = get(map[string]string{"If-None-Match": get(nil).Header().Get("ETag")})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with the current ETag = %d, expected 304", w.Code)
+	}
+}
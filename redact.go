@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a kind of secret which is masked in the contents of files with
+// -redact-secrets. If the pattern has a group named secret, only
+// that part of the match is masked
+type secretContent struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var secretContents = [...]secretContent{
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`)},
+	{"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY( BLOCK)?-----`)},
+	{"bearer token", regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]{16,}=*)`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+}
+
+// what secrets are replaced with
+const redacted = "[redacted]"
+
+// data, with the matches of the secret replaced, and how many there were
+func (c *secretContent) redact(data []byte) ([]byte, int) {
+	matches := c.pattern.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data, 0
+	}
+	group := c.pattern.SubexpIndex("secret")
+	var out bytes.Buffer
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if group != -1 && match[2*group] != -1 {
+			start, end = match[2*group], match[2*group+1]
+		}
+		out.Write(data[last:start])
+		out.WriteString(redacted)
+		last = end
+	}
+	out.Write(data[last:])
+	return out.Bytes(), len(matches)
+}
+
+// data with every secret replaced, and how many of each kind there were.
+// The data isn't modified, since it can be shared between requests
+func redactSecrets(data []byte) ([]byte, map[string]int) {
+	found := make(map[string]int)
+	for i := range secretContents {
+		var n int
+		if data, n = secretContents[i].redact(data); n > 0 {
+			found[secretContents[i].name] += n
+		}
+	}
+	return data, found
+}
+
+// with -redact-secrets, the contents of a text file with secrets masked
+func (s *server) redact(data []byte) []byte {
+	if !s.config.redactSecrets || isBinary(data) {
+		return data
+	}
+	data, _ = redactSecrets(data)
+	return data
+}
+
+// logs the files with secrets which will be masked, so they can be
+// removed. Files larger than -max-file-size aren't served, so they're skipped
+func reportSecrets(config *config) {
+	files := 0
+	for _, m := range config.mounts {
+		err := walkFiles(context.Background(), m.src, ".", func(p string, d fs.DirEntry) error {
+			if info, err := d.Info(); err != nil || (config.maxFileSize > 0 && info.Size() > config.maxFileSize) {
+				return nil
+			}
+			data, err := readFile(context.Background(), m.src, p)
+			if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if isBinary(data) {
+				return nil
+			}
+			_, found := redactSecrets(data)
+			if len(found) == 0 {
+				return nil
+			}
+			kinds := []string{}
+			for name, n := range found {
+				kinds = append(kinds, fmt.Sprintf("%d %s", n, name))
+			}
+			sort.Strings(kinds)
+			log.Printf("Redacting: /%s: %s\n", mountPath(m, p), strings.Join(kinds, ", "))
+			files++
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error: Could not check %s for secrets: %s\n", m.src, err)
+		}
+	}
+	if files == 1 {
+		log.Printf("Warning: -redact-secrets found secrets in 1 file, which are masked when it's served\n")
+	} else if files > 1 {
+		log.Printf("Warning: -redact-secrets found secrets in %d files, which are masked when they're served\n", files)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the server requests are served with, replaced when it's reloaded.
+// Shared by every copy of the server
+type liveServer struct {
+	// reloads run one at a time
+	mu      sync.Mutex
+	current atomic.Pointer[server]
+}
+
+// the server new requests are served with, which is s unless it's
+// been reloaded since
+func (s *server) live() *server {
+	if s.reloaded == nil {
+		return s
+	}
+	if current := s.reloaded.current.Load(); current != nil {
+		return current
+	}
+	return s
+}
+
+// returned by reload when the config was reloaded, but a new snapshot of
+// some of the mounts couldn't be taken. They keep serving the previous one
+type snapshotReloadError struct {
+	errs []error
+}
+
+func (e *snapshotReloadError) Error() string {
+	return fmt.Sprintf("reloaded config, but could not take a new snapshot of %d mount(s): %s", len(e.errs), errors.Join(e.errs...))
+}
+
+// loads the -ignore-file, -template, -template-rules, -user-agent-rules,
+// -bundles, -transforms, -mimetypes-file and -auth-file again, takes a
+// new snapshot of each mount with -snapshot, and drops everything cached,
+// on SIGHUP or POST /-/reload. Responds with what was reloaded
+//
+// -virtual-files, -quota-tokens and -oidc-rules aren't reloaded, since the
+// virtual files are refreshed in the background, quotas keep counting
+// what was used today, and the OIDC rules decide which files are private
+//
+// if any of the files can't be loaded, the server keeps using what it
+// had. If a new snapshot of a mount can't be taken, the rest of it is
+// still reloaded, that mount keeps its previous snapshot, and it returns
+// a *snapshotReloadError with the response. Requests in progress finish
+// with the server they started with
+func (s *server) reload(ctx context.Context) (string, error) {
+	// a large snapshot isn't abandoned if the request which started it is
+	ctx = context.WithoutCancel(ctx)
+	s.reloaded.mu.Lock()
+	defer s.reloaded.mu.Unlock()
+	s = s.live()
+	rules, err := loadIgnoreRules(s.config.ignoreFlags, s.config.ignoreFile)
+	if err != nil {
+		return "", err
+	}
+	next, err := s.reloadTemplates()
+	if err != nil {
+		return "", err
+	}
+	if next.userAgentRules, err = loadUserAgentRules(s.config.userAgentRuleFlags, s.config.userAgentRulesFile); err != nil {
+		return "", err
+	}
+	if s.config.bundlesFile != "" {
+		if next.bundles, err = loadBundles(s.config.bundlesFile); err != nil {
+			return "", err
+		}
+	}
+	if s.config.transformsFile != "" {
+		if next.transforms, err = loadTransforms(s.config.transformsFile); err != nil {
+			return "", err
+		}
+	}
+	if s.config.mimeTypesFile != "" {
+		if next.mimeTypes, err = loadMimeTypes(s.config.mimeTypesFile); err != nil {
+			return "", err
+		}
+	}
+	config := *s.config
+	if config.authFile != "" {
+		if config.users, err = loadUsers(config.authFile); err != nil {
+			return "", err
+		}
+	}
+	next.config = &config
+	var response strings.Builder
+	response.WriteString("Reloaded config, ignore rules and templates\n")
+	// the snapshots are walked with the new ignore rules
+	ignores.Store(rules)
+	s.reloaded.current.Store(next)
+	var snapshotErrs []error
+	for _, m := range config.mounts {
+		if snap, ok := m.src.(*snapshotSource); ok {
+			start := time.Now()
+			if err := snap.reload(ctx); err != nil {
+				fmt.Fprintf(&response, "Could not take new snapshot of %s, still serving the previous one: %s\n", snap.src, err)
+				snapshotErrs = append(snapshotErrs, fmt.Errorf("%s: %w", snap.src, err))
+				continue
+			}
+			tree, _ := snap.snapshot()
+			fmt.Fprintf(&response, "Took new snapshot of %s (%d files) in %s\n", snap.src, tree.fileCount(), time.Since(start))
+		} else if src, ok := m.src.(purger); ok {
+			src.purge(".")
+		}
+	}
+	for _, cache := range []*byteCache{next.renderCache, next.thumbnails} {
+		if cache != nil {
+			cache.purge("")
+		}
+	}
+	if next.dirSizes != nil {
+		next.dirSizes.purge("")
+	}
+	if len(snapshotErrs) > 0 {
+		return response.String(), &snapshotReloadError{errs: snapshotErrs}
+	}
+	return response.String(), nil
+}
+
+// reloads the server like SIGHUP, see reload
+func (s *server) serveReload(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	user, ok := s.requireAuth(w, r, opts)
+	if !ok {
+		return
+	}
+	msg, err := s.reload(ctx)
+	var snapshotErr *snapshotReloadError
+	if errors.As(err, &snapshotErr) {
+		log.Printf("%s reloaded the server, but: %s\n", user, err)
+		log.Print(msg)
+		w.WriteHeader(http.StatusInternalServerError)
+		render(&w, &PageInfo{
+			PageContents: msg,
+			Title:        "500 - Internal Server Error",
+		}, s.live().tmpl, opts.isDark)
+		return
+	}
+	if err != nil {
+		log.Printf("%s could not reload: %s\n", user, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Could not reload, still using the previous config: %s\n", err),
+			Title:        "500 - Internal Server Error",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	log.Printf("%s reloaded the server\n", user)
+	log.Print(msg)
+	render(&w, &PageInfo{
+		PageContents: msg,
+		Title:        "Reload",
+	}, s.live().tmpl, opts.isDark)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// reloading on SIGHUP is only supported on unix, POST /-/reload still works
+func reloadOnSignal(s *server) {
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a/notes.txt", "b/todo.txt", "b/draft.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, p), []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignoreFile := filepath.Join(root, "ignore")
+	if err := os.WriteFile(ignoreFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, root, []string{"-mount", "a={dir}/a", "-mount", "b={dir}/b", "-snapshot", "-ignore-file", ignoreFile, "-auth-header", "X-Forwarded-User"})
+	status := func(target string) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Code
+	}
+	if err := os.WriteFile(filepath.Join(root, "b", "new.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ignoreFile, []byte("draft.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := status("/b/new.txt"); code != 404 {
+		t.Errorf("expected new.txt to be missing from the snapshot, got %d", code)
+	}
+	// the snapshot of a can't be taken
+	if err := os.RemoveAll(filepath.Join(root, "a")); err != nil {
+		t.Fatal(err)
+	}
+	// the request which started it being cancelled doesn't stop it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	msg, err := s.reload(ctx)
+	var snapshotErr *snapshotReloadError
+	if !errors.As(err, &snapshotErr) || len(snapshotErr.errs) != 1 {
+		t.Fatalf("expected the snapshot of a to fail, got %v", err)
+	}
+	if !strings.Contains(msg, "Reloaded config") || !strings.Contains(msg, "still serving the previous one") {
+		t.Errorf("expected the response to say what was reloaded, got %q", msg)
+	}
+	for target, expected := range map[string]int{
+		// still in the previous snapshot
+		"/a/notes.txt": 200,
+		// later mounts are still snapshotted, with the new ignore rules
+		"/b/new.txt":   200,
+		"/b/todo.txt":  200,
+		"/b/draft.txt": 404,
+	} {
+		if code := status(target); code != expected {
+			t.Errorf("GET %s after reloading = %d, expected %d", target, code, expected)
+		}
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/-/reload", nil)
+	// from oauth2-proxy on the same machine
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-User", "sean")
+	s.ServeHTTP(w, r)
+	// says what was reloaded, instead of that it's still using the previous config
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "Reloaded config") {
+		t.Errorf("POST /-/reload = %d %q, expected a 500 with what was reloaded", w.Code, w.Body.String())
+	}
+}
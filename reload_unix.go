@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// on SIGHUP, reloads the server like POST /-/reload
+func reloadOnSignal(s *server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		msg, err := s.reload(context.Background())
+		var snapshotErr *snapshotReloadError
+		if errors.As(err, &snapshotErr) {
+			log.Printf("Reloaded, but: %s\n", err)
+			log.Print(msg)
+			continue
+		}
+		if err != nil {
+			log.Printf("Could not reload: %s\n", err)
+			continue
+		}
+		log.Print(msg)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// with -absolute-times, pages display the exact time instead of how long ago it was
+var absoluteTimes bool
+
+// the units relative times are displayed in, largest first
+var timeUnits = [...]time.Duration{
+	365 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	24 * time.Hour,
+	time.Hour,
+	time.Minute,
+}
+
+// how relative times are written in a language
+type timeLocale struct {
+	// the singular and plural of each of the timeUnits
+	units [len(timeUnits)][2]string
+	// formats the number and unit, e.g. '%d %s ago'
+	ago      string
+	justNow  string
+	inFuture string
+}
+
+// the languages relative times can be displayed in, from Accept-Language
+var timeLocales = map[string]*timeLocale{
+	"en": {
+		units:    [...][2]string{{"year", "years"}, {"month", "months"}, {"day", "days"}, {"hour", "hours"}, {"minute", "minutes"}},
+		ago:      "%d %s ago",
+		justNow:  "just now",
+		inFuture: "in the future",
+	},
+	"de": {
+		units:    [...][2]string{{"Jahr", "Jahren"}, {"Monat", "Monaten"}, {"Tag", "Tagen"}, {"Stunde", "Stunden"}, {"Minute", "Minuten"}},
+		ago:      "vor %d %s",
+		justNow:  "gerade eben",
+		inFuture: "in der Zukunft",
+	},
+	"es": {
+		units:    [...][2]string{{"año", "años"}, {"mes", "meses"}, {"día", "días"}, {"hora", "horas"}, {"minuto", "minutos"}},
+		ago:      "hace %d %s",
+		justNow:  "justo ahora",
+		inFuture: "en el futuro",
+	},
+	"fr": {
+		units:    [...][2]string{{"an", "ans"}, {"mois", "mois"}, {"jour", "jours"}, {"heure", "heures"}, {"minute", "minutes"}},
+		ago:      "il y a %d %s",
+		justNow:  "à l'instant",
+		inFuture: "dans le futur",
+	},
+}
+
+// the language in timeLocales the client prefers most, from the
+// Accept-Language header (e.g. 'de-CH, fr;q=0.8'), else en
+func preferredLang(acceptLanguage string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	choices := []choice{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		// only the language matters, not the region
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := timeLocales[lang]; ok && q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	if len(choices) == 0 {
+		return "en"
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+// how long ago t was, e.g. '3 hours ago', in the language (en if it
+// isn't passed, or isn't one of timeLocales)
+func relativeTime(t time.Time, lang ...string) string {
+	locale := timeLocales["en"]
+	if len(lang) > 0 && timeLocales[lang[0]] != nil {
+		locale = timeLocales[lang[0]]
+	}
+	since := time.Since(t)
+	if since < 0 {
+		return locale.inFuture
+	}
+	for i, unit := range timeUnits {
+		if n := int(since / unit); n > 0 {
+			name := locale.units[i][1]
+			if n == 1 {
+				name = locale.units[i][0]
+			}
+			return fmt.Sprintf(locale.ago, n, name)
+		}
+	}
+	return locale.justNow
+}
+
+// e.g. 2026-10-15 03:49:16 UTC
+func exactTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 MST")
+}
+
+// t as a <time> element, displaying how long ago it was in the language,
+// with the exact time as its tooltip. With -absolute-times, it displays
+// the exact time instead
+func timestamp(t time.Time, lang ...string) template.HTML {
+	text, title := relativeTime(t, lang...), exactTime(t)
+	if absoluteTimes {
+		text, title = title, ""
+	}
+	html := `<time datetime="` + t.UTC().Format(time.RFC3339) + `"`
+	if title != "" {
+		html += ` title="` + title + `"`
+	}
+	return template.HTML(html + ">" + template.HTMLEscapeString(text) + "</time>")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+	"time"
+)
+
+func TestPreferredLang(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		lang   string
+	}{
+		{"", "en"},
+		{"de-CH, de;q=0.9, en;q=0.8", "de"},
+		{"ja, fr;q=0.5, es;q=0.7", "es"},
+		{"en-US;q=0.1, FR", "fr"},
+		{"de;q=0, ja", "en"},
+		{"es;q=oops, de;q=0.5", "de"},
+	} {
+		if lang := preferredLang(tt.header); lang != tt.lang {
+			t.Errorf("preferredLang(%q) = %q, expected %q", tt.header, lang, tt.lang)
+		}
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		t        time.Time
+		lang     []string
+		expected string
+	}{
+		{now.Add(-3 * 24 * time.Hour), nil, "3 days ago"},
+		{now.Add(-time.Hour - time.Minute), nil, "1 hour ago"},
+		{now.Add(-10 * time.Second), nil, "just now"},
+		{now.Add(time.Hour), nil, "in the future"},
+		{now.Add(-3 * 24 * time.Hour), []string{"de"}, "vor 3 Tagen"},
+		{now.Add(-400 * 24 * time.Hour), []string{"fr"}, "il y a 1 an"},
+		{now.Add(-2 * time.Minute), []string{"es"}, "hace 2 minutos"},
+		{now.Add(-2 * time.Minute), []string{"xx"}, "2 minutes ago"},
+	} {
+		if got := relativeTime(tt.t, tt.lang...); got != tt.expected {
+			t.Errorf("relativeTime(%s, %v) = %q, expected %q", now.Sub(tt.t), tt.lang, got, tt.expected)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	at := time.Date(2026, 10, 12, 3, 57, 49, 0, time.UTC)
+	if got := timestamp(at, "en"); got != `<time datetime="2026-10-12T03:57:49Z" title="2026-10-12 03:57:49 UTC">`+template.HTML(relativeTime(at))+`</time>` {
+		t.Errorf("timestamp = %s", got)
+	}
+	absoluteTimes = true
+	defer func() { absoluteTimes = false }()
+	if got := timestamp(at, "en"); got != `<time datetime="2026-10-12T03:57:49Z">2026-10-12 03:57:49 UTC</time>` {
+		t.Errorf("timestamp with -absolute-times = %s", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// the local URL for a forge URL, for /-/reverse
+type reversed struct {
+	// path of the file, like /-/raw/<path>
+	Path string `json:"path"`
+	// the shortest query which matches the file, and its URL on this server
+	Query string `json:"query"`
+	URL   string `json:"url"`
+}
+
+// the shortest query for the file at target (relative to the root of
+// src), a suffix of it starting at a /, which no file before it in the
+// walk also matches, so requesting it matches the file
+func shortestQuery(ctx context.Context, src source, target string) (string, error) {
+	name := target[strings.LastIndex(target, "/")+1:]
+	// the files find would check before reaching target
+	var before []string
+	found := false
+	err := walkFiles(ctx, src, ".", func(p string, d fs.DirEntry) error {
+		if p == target {
+			found = true
+			return errStopWalk
+		}
+		if d.Name() == name {
+			before = append(before, p)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return "", err
+	}
+	if !found {
+		return "", ErrNotFound
+	}
+	for start := strings.LastIndex(target, "/") + 1; ; start = strings.LastIndex(target[:start-1], "/") + 1 {
+		query := target[start:]
+		if !slices.ContainsFunc(before, func(p string) bool { return matchesQuery(p, name, query) }) || start == 0 {
+			return query, nil
+		}
+	}
+}
+
+// finds the file a forge URL (with the -git-http-prefix or -git-raw-prefix
+// of a mount, or of a submodule in it) is for, and the shortest URL for it
+// on this server
+func (s *server) reverse(ctx context.Context, r *http.Request, fileURL string) (*reversed, error) {
+	for _, m := range s.config.mounts {
+		// the prefixes, and the directory in the mount their files are in
+		prefixes := [][2]string{{m.repoPrefix, ""}, {m.rawPrefix, ""}}
+		for _, sub := range m.submodules {
+			prefixes = append(prefixes, [2]string{sub.repoPrefix, sub.path + "/"}, [2]string{sub.rawPrefix, sub.path + "/"})
+		}
+		for _, prefix := range prefixes {
+			if prefix[0] == "" {
+				continue
+			}
+			p, ok := prefixPath(prefix[0], fileURL)
+			if !ok {
+				continue
+			}
+			p = prefix[1] + p
+			if err := checkIgnored(p, false); err != nil {
+				return nil, err
+			}
+			if s.checkPrivate(ctx, m, p) != nil {
+				return nil, ErrNotFound
+			}
+			done := timingsFrom(ctx).track("walk")
+			query, err := shortestQuery(ctx, m.src, p)
+			done()
+			if err != nil {
+				return nil, err
+			}
+			return &reversed{
+				Path:  mountPath(m, p),
+				Query: mountPath(m, query),
+				URL:   s.shareURL(r, mountPath(m, query), false),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// responds with the URL on this server for the file at ?url=, a URL
+// starting with the -git-http-prefix or -git-raw-prefix of a mount, or
+// a JSON object with ?json
+func (s *server) serveReverse(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	fileURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	if fileURL == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: "Pass the URL of a file on the git web view as ?url=\n",
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	result, err := s.reverse(ctx, r, fileURL)
+	if result == nil && err == nil {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("%s doesn't start with the -git-http-prefix or -git-raw-prefix of any folder\n", fileURL),
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrIgnored) {
+		w.WriteHeader(http.StatusNotFound)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Could not find the file for %s\n", fileURL),
+			Title:        "404 - Not Found",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+	}
+	if hasQueryParam(r.URL.Query(), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	render(&w, &PageInfo{
+		PageContents: result.URL + "\n",
+		Title:        "Reverse lookup",
+	}, s.tmpl, opts.isDark)
+}
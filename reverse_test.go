@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixPath(t *testing.T) {
+	for _, tt := range []struct {
+		prefix  string
+		fileURL string
+		path    string
+	}{
+		{"https://github.com/u/r/blob/master", "https://github.com/u/r/blob/master/nvim/init.lua#L10-L20", "nvim/init.lua"},
+		{"https://github.com/u/r/blob/master", "https://github.com/u/r/blob/master/my%20notes.md?plain=1", "my notes.md"},
+		{"https://github.com/u/r/blob/master", "https://github.com/u/r/blob/main/nvim/init.lua", ""},
+		{"https://github.com/u/r/blob/master", "https://github.com/u/r/blob/master/../../etc/passwd", "etc/passwd"},
+		{"https://github.com/u/r/blob/master", "https://github.com/u/r/blob/master/", ""},
+		{"https://gitlab.com/u/r/-/raw/master/{path}?inline=false", "https://gitlab.com/u/r/-/raw/master/a/b.txt?inline=false", "a/b.txt"},
+		{"https://gitlab.com/u/r/-/raw/master/{path}?inline=false", "https://gitlab.com/u/r/-/raw/master/a/b.txt", ""},
+	} {
+		p, ok := prefixPath(tt.prefix, tt.fileURL)
+		if p != tt.path || ok != (tt.path != "") {
+			t.Errorf("prefixPath(%q, %q) = %q, %t, expected %q", tt.prefix, tt.fileURL, p, ok, tt.path)
+		}
+	}
+}
+
+func TestShortestQuery(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a/rc.conf", "b/rc.conf", "b/init.lua", "c/b/rc.conf"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, expected := range map[string]string{
+		"a/rc.conf":   "rc.conf",
+		"b/rc.conf":   "b/rc.conf",
+		"b/init.lua":  "init.lua",
+		"c/b/rc.conf": "c/b/rc.conf",
+	} {
+		query, err := shortestQuery(context.Background(), src, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != expected {
+			t.Errorf("shortestQuery(%q) = %q, expected %q", target, query, expected)
+		}
+		if found, _ := find(context.Background(), src, query); found != target {
+			t.Errorf("%q matched %q, not %q", query, found, target)
+		}
+	}
+	if _, err := shortestQuery(context.Background(), src, "missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("shortestQuery of a missing file returned %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// serves a request matching a route. param is the part of the path
+// matched by the {...} in the pattern, if it has one
+type routeHandler func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, param string)
+
+// an endpoint, and what /-/api/routes says about it
+type route struct {
+	// like the patterns for http.ServeMux, {name} matches one segment
+	// of the path, and {name...} (at the end) matches the rest of it
+	Pattern string `json:"pattern"`
+	// one of: index, file, meta, api
+	Group string `json:"group"`
+	// other methods are responded to with a 405. Any method if empty
+	Methods []string `json:"methods"`
+	// whether it requires authenticating as a user from -auth-file
+	Auth        bool   `json:"auth"`
+	Description string `json:"description"`
+
+	serve routeHandler
+}
+
+// whether the path (without the leading /) matches the pattern of the
+// route, and the part of it which was matched by the {...} in the pattern
+func (rt *route) match(p string) (string, bool) {
+	pattern := strings.TrimPrefix(rt.Pattern, "/")
+	i := strings.Index(pattern, "{")
+	if i == -1 {
+		return "", p == pattern
+	}
+	if !strings.HasPrefix(p, pattern[:i]) {
+		return "", false
+	}
+	param := p[i:]
+	if !strings.HasSuffix(pattern, "...}") && (param == "" || strings.Contains(param, "/")) {
+		return "", false
+	}
+	return param, true
+}
+
+// whether the route can be requested with the method
+func (rt *route) allows(method string) bool {
+	if len(rt.Methods) == 0 || slices.Contains(rt.Methods, method) {
+		return true
+	}
+	return method == http.MethodHead && slices.Contains(rt.Methods, http.MethodGet)
+}
+
+// adapts handlers which don't use the param from the path
+func noParam(serve func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions)) routeHandler {
+	return func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, _ string) {
+		serve(s, ctx, w, r, opts)
+	}
+}
+
+// every route, in the order they're matched. Set in init, since
+// /-/api/routes refers to it
+var routes []*route
+
+func init() {
+	routes = []*route{
+		{
+			Pattern:     "/-/api/routes",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "this list of routes, as JSON",
+			serve:       noParam((*server).serveRoutes),
+		},
+		{
+			Pattern:     "/-/api/resolve",
+			Group:       "api",
+			Methods:     []string{http.MethodPost},
+			Description: "resolves a JSON array of queries in the body, responds with the path, size and sha256 of the file each one matched",
+			serve:       noParam((*server).serveResolve),
+		},
+		{
+			Pattern:     "/-/api/rpc",
+			Group:       "api",
+			Methods:     []string{http.MethodPost},
+			Description: "JSON-RPC 2.0 for editor plugins, textDocument/resolve resolves a file:line reference to the lines and the URLs of the file",
+			serve:       noParam((*server).serveRPC),
+		},
+		{
+			Pattern:     "/-/api/trace",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "how the path in ?q= resolves (like ?trace on the path): the files considered, which ignore rules applied, which one matched and how long it took, as JSON",
+			serve:       noParam((*server).serveAPITrace),
+		},
+		{
+			Pattern:     "/-/api/tree",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "the directory at ?path=, with the files/directories in it up to ?depth= levels down, as nested JSON",
+			serve:       noParam((*server).serveTree),
+		},
+		{
+			Pattern:     "/-/api/index",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "a page of every file ordered by path, with the ?fields= of each one, after ?cursor= and modified since ?modified_since=, as JSON",
+			serve:       noParam((*server).serveAPIIndex),
+		},
+		{
+			Pattern:     "/-/complete",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "paths which start with ?q=, for shell completions",
+			serve:       noParam((*server).serveComplete),
+		},
+		{
+			Pattern:     "/-/reverse",
+			Group:       "api",
+			Methods:     []string{http.MethodGet},
+			Description: "the shortest URL on this server for the file at ?url=, a URL with the -git-http-prefix or -git-raw-prefix of a mount, or JSON with ?json",
+			serve:       noParam((*server).serveReverse),
+		},
+		{
+			Pattern:     "/-/raw/{path...}",
+			Group:       "file",
+			Methods:     []string{http.MethodGet},
+			Description: "the file at exactly this path, without matching",
+			serve:       (*server).serveRaw,
+		},
+		{
+			Pattern:     "/-/bundle/{name}",
+			Group:       "file",
+			Methods:     []string{http.MethodGet},
+			Description: "the files in a bundle from -bundles, concatenated, or as an archive if name ends with .tar.gz or .zip",
+			serve: func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+				s.serveBundle(ctx, w, r, opts, name)
+			},
+		},
+		{
+			Pattern:     "/-/manifest",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the sha256 and path of every file, or a JSON object with ?json",
+			serve:       noParam((*server).serveManifest),
+		},
+		{
+			Pattern:     "/-/changes",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the files which were added, removed or modified between ?since= (e.g. 24h) and ?until=, with -changes-interval",
+			serve:       noParam((*server).serveChanges),
+		},
+		{
+			Pattern:     "/-/mirror.tar.gz",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "every file, as a .tar.gz",
+			serve:       noParam((*server).serveMirror),
+		},
+		{
+			Pattern:     "/-/assets/{name}",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "a file from -assets-dir, by its hashed name",
+			serve: func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+				s.serveAsset(ctx, w, opts, name)
+			},
+		},
+		{
+			Pattern:     "/-/reload",
+			Group:       "meta",
+			Methods:     []string{http.MethodPost},
+			Auth:        true,
+			Description: "loads the config files, ignore rules and templates again, and takes a new snapshot of each mount with -snapshot, like SIGHUP",
+			serve:       noParam((*server).serveReload),
+		},
+		{
+			Pattern:     "/-/inbox/{name}",
+			Group:       "meta",
+			Methods:     []string{http.MethodPut},
+			Auth:        true,
+			Description: "saves the body as a file with the name in -inbox-dir",
+			serve: func(s *server, ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+				s.serveInbox(ctx, w, r, opts, name)
+			},
+		},
+		{
+			Pattern:     "/-/paste",
+			Group:       "meta",
+			Methods:     []string{http.MethodPost},
+			Auth:        true,
+			Description: "saves the body as a new file in -paste-dir, named after the time, and responds with its URL",
+			serve:       noParam((*server).servePaste),
+		},
+		{
+			Pattern:     "/-/purge",
+			Group:       "meta",
+			Methods:     []string{http.MethodPost},
+			Auth:        true,
+			Description: "drops anything cached for ?path=, and purges it from the CDN with -purge-url",
+			serve:       noParam((*server).servePurge),
+		},
+		{
+			Pattern:     "/-/login",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "logs in with the OpenID Connect provider from -oidc-issuer, then redirects to ?next=",
+			serve:       noParam((*server).serveLogin),
+		},
+		{
+			Pattern:     "/-/oidc/callback",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "where the OpenID Connect provider redirects back to after logging in",
+			serve:       noParam((*server).serveOIDCCallback),
+		},
+		{
+			Pattern:     "/-/logout",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet, http.MethodPost},
+			Description: "logs out a user who logged in at /-/login",
+			serve:       noParam((*server).serveLogout),
+		},
+		{
+			Pattern:     "/-/sign",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Auth:        true,
+			Description: "a signed URL for the file matching ?path=, which can be read without authenticating until ?ttl= passes",
+			serve:       noParam((*server).serveSign),
+		},
+		{
+			Pattern:     "/-/pubkey",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the minisign public key ?sig signatures can be verified with, with -minisign-key",
+			serve:       noParam((*server).servePubkey),
+		},
+		{
+			Pattern:     "/-/stats/languages",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the number of files and bytes in each language, as a bar chart in the ?dark view, or JSON with ?json",
+			serve:       noParam((*server).serveLanguages),
+		},
+		{
+			Pattern:     "/-/quota",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "how much of its daily quota the client (or ?token=) used, with -quota-requests/-quota-bytes, or JSON with ?json",
+			serve:       noParam((*server).serveQuota),
+		},
+		{
+			Pattern:     "/-/status",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the goroutines, memory, caches and their hit rates, the age of each snapshot and how it's watched, and the requests per second for each route, as JSON",
+			serve:       noParam((*server).serveStatus),
+		},
+		{
+			Pattern:     "/-/healthz",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "responds with ok, for load balancer health checks",
+			serve:       noParam((*server).serveHealthz),
+		},
+		{
+			Pattern:     "/-/analytics",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Auth:        true,
+			Description: "requests per day, and the top files, referrers and user agents, with -analytics",
+			serve:       noParam((*server).serveAnalytics),
+		},
+		{
+			Pattern:     "/.well-known/{path...}",
+			Group:       "meta",
+			Methods:     []string{http.MethodGet},
+			Description: "the files in -well-known-dir, as-is",
+			serve:       (*server).serveWellKnown,
+		},
+		{
+			Pattern:     "/-/search",
+			Group:       "index",
+			Methods:     []string{http.MethodGet},
+			Description: "the lines in every file which match ?q=, like ripgrep, with ?regex, ?case=, ?context= and ?max-count=, or JSON with ?json",
+			serve:       noParam((*server).serveSearch),
+		},
+		{
+			Pattern:     "/",
+			Group:       "index",
+			Methods:     []string{http.MethodGet},
+			Description: "lists every file, ?q= searches their paths and contents",
+			serve:       (*server).serveQuery,
+		},
+		{
+			Pattern:     "/{query...}",
+			Group:       "file",
+			Methods:     []string{http.MethodGet},
+			Description: "the file which best matches the query, or with a trailing /, lists the files in the matching directory",
+			serve:       (*server).serveQuery,
+		},
+	}
+}
+
+// the path of a request (without the leading /) with repeated slashes
+// collapsed and . and .. segments resolved, keeping a trailing slash,
+// so e.g. //nvim///init.vim and /./nvim/../nvim/init.vim match the
+// same file as /nvim/init.vim
+func cleanRequestPath(p string) string {
+	cleaned := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if cleaned != "" && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// responds with the route matching the path of the request
+func (s *server) route(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, reqPath string) {
+	for _, rt := range routes {
+		param, ok := rt.match(reqPath)
+		if !ok {
+			continue
+		}
+		// paths under /-/ are reserved for internal endpoints, never files
+		if strings.HasPrefix(reqPath, "-/") && rt.Pattern == "/{query...}" {
+			break
+		}
+		if s.requests != nil {
+			s.requests.record(rt.Pattern)
+		}
+		if !rt.allows(r.Method) {
+			w.Header().Set("Allow", strings.Join(rt.Methods, ", "))
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("Use a %s request for /%s\n", strings.Join(rt.Methods, " or "), reqPath),
+				Title:        "405 - Method Not Allowed",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+		rt.serve(s, ctx, w, r, opts, param)
+		return
+	}
+	s.serveNotFound(ctx, w, reqPath, opts)
+}
+
+// responds with every route, as JSON
+func (s *server) serveRoutes(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routes)
+}
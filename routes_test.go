@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		path    string
+		param   string
+		ok      bool
+	}{
+		{"/-/manifest", "-/manifest", "", true},
+		{"/-/manifest", "-/manifest/x", "", false},
+		{"/-/raw/{path...}", "-/raw/nvim/init.lua", "nvim/init.lua", true},
+		{"/-/raw/{path...}", "-/raw/", "", true},
+		{"/-/bundle/{name}", "-/bundle/shell.tar.gz", "shell.tar.gz", true},
+		{"/-/bundle/{name}", "-/bundle/a/b", "", false},
+		{"/-/bundle/{name}", "-/bundle/", "", false},
+		{"/", "", "", true},
+		{"/", "rc.conf", "", false},
+		{"/{query...}", "nvim/", "nvim/", true},
+	} {
+		param, ok := (&route{Pattern: tt.pattern}).match(tt.path)
+		if ok != tt.ok || param != tt.param {
+			t.Errorf("%s matching %q = (%q, %v), expected (%q, %v)", tt.pattern, tt.path, param, ok, tt.param, tt.ok)
+		}
+	}
+}
+
+func TestCleanRequestPath(t *testing.T) {
+	for _, tt := range []struct {
+		path     string
+		expected string
+	}{
+		{"/", ""},
+		{"//", ""},
+		{"/bashrc", "bashrc"},
+		{"/./bashrc", "bashrc"},
+		{"//nvim///init.vim", "nvim/init.vim"},
+		{"/nvim/./init.vim", "nvim/init.vim"},
+		{"/nvim/../nvim/init.vim", "nvim/init.vim"},
+		{"/nvim//", "nvim/"},
+		{"/nvim/.", "nvim/"},
+		{"/nvim/lua/..", "nvim/"},
+		{"/nvim/..", ""},
+		{"/../../etc/passwd", "etc/passwd"},
+		{"/-/raw//nvim/init.vim", "-/raw/nvim/init.vim"},
+		{"/.bashrc", ".bashrc"},
+		{"/...", "..."},
+	} {
+		if cleaned := cleanRequestPath(tt.path); cleaned != tt.expected {
+			t.Errorf("cleanRequestPath(%q) = %q, expected %q", tt.path, cleaned, tt.expected)
+		}
+	}
+}
+
+func FuzzCleanRequestPath(f *testing.F) {
+	for _, p := range []string{"/", "//nvim///init.vim", "/nvim/../nvim/init.vim", "/nvim/.", "/../../etc/passwd", "/-/raw//x", "/..."} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		cleaned := cleanRequestPath(p)
+		if again := cleanRequestPath("/" + cleaned); again != cleaned {
+			t.Fatalf("cleanRequestPath(%q) = %q, which cleans to %q", p, cleaned, again)
+		}
+		if cleaned == "" {
+			return
+		}
+		// the path can't escape the folder, or be matched differently than its cleaned form
+		for _, segment := range strings.Split(strings.TrimSuffix(cleaned, "/"), "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				t.Fatalf("cleanRequestPath(%q) = %q, which has a %q segment", p, cleaned, segment)
+			}
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// the reference didn't match a file (or the request can't read it)
+	rpcFileNotFound = -32004
+)
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	// missing for notifications, which aren't responded to
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// a file:line reference, e.g. init.lua:10, nvim/init.lua:10-20,
+// init.lua:10:5 (the column is ignored) or init.lua#L10-L20
+var referencePattern = regexp.MustCompile(`^(.+?)(?::(\d+)(?:-(\d+)|:\d+)?|#L(\d+)(?:-L?(\d+))?)?$`)
+
+// splits a reference into the query and the lines it refers to, nil if
+// it doesn't have a line
+func parseReference(reference string) (string, *lineRange, error) {
+	match := referencePattern.FindStringSubmatch(reference)
+	if match == nil {
+		return "", nil, errors.New("expected a reference like nvim/init.lua:10")
+	}
+	start, end := match[2]+match[4], match[3]+match[5]
+	if start == "" {
+		return match[1], nil, nil
+	}
+	if end == "" {
+		end = start
+	}
+	lines, err := parseLineRange(start + "-" + end)
+	if err != nil {
+		return "", nil, err
+	}
+	return match[1], lines, nil
+}
+
+// where a reference resolved to, for the textDocument/resolve method
+type editorLocation struct {
+	Reference string `json:"reference"`
+	// path of the file, like /-/raw/<path>
+	Path string `json:"path"`
+	// the lines which were referenced, omitted for the whole file
+	Line    int `json:"line,omitempty"`
+	EndLine int `json:"end_line,omitempty"`
+	// lines in the whole file
+	TotalLines int `json:"total_lines"`
+	// the referenced lines (or the whole file), like ?lines= on the
+	// file. Empty for binary files
+	Content string `json:"content"`
+	Binary  bool   `json:"binary"`
+	// the file on this server (the ?dark view, at the line), its
+	// plaintext, the git web view and -canonical-url, if there are ones
+	URL          string `json:"url"`
+	RawURL       string `json:"raw_url"`
+	BlobURL      string `json:"blob_url,omitempty"`
+	CanonicalURL string `json:"canonical_url,omitempty"`
+}
+
+// resolves the query like a request for the file, returning the lines
+// (all of them if nil) and the URLs of the file
+func (s *server) locate(ctx context.Context, r *http.Request, query string, lines *lineRange) (*editorLocation, error) {
+	m, q, err := resolveMount(ctx, s.config.mounts, cleanRequestPath(query))
+	if err != nil {
+		return nil, err
+	}
+	if m == nil || q == "" {
+		return nil, ErrNotFound
+	}
+	done := timingsFrom(ctx).track("walk")
+	p, err := s.find(ctx, m, q)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	done = timingsFrom(ctx).track("read")
+	data, _, err := s.readFileInfo(ctx, m, p)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	full := mountPath(m, p)
+	loc := &editorLocation{
+		Path:         full,
+		Binary:       isBinary(data),
+		URL:          s.shareURL(r, full, false),
+		RawURL:       s.externalURL(r) + (&url.URL{Path: "/-/raw/" + full}).EscapedPath(),
+		CanonicalURL: s.canonicalURL(full, false),
+	}
+	repoPrefix, _, _, repoPath := m.prefixesFor(p)
+	if repoPrefix != "" {
+		loc.BlobURL = prefixURL(repoPrefix, repoPath)
+	}
+	if loc.Binary {
+		return loc, nil
+	}
+	// like the plaintext response, with -transforms applied
+	contents := s.transform(privatePath(m, p), string(data), nil)
+	if lines == nil {
+		loc.Content, loc.TotalLines = (&lineRange{start: 1}).slice(contents)
+		return loc, nil
+	}
+	loc.Content, loc.TotalLines = lines.slice(contents)
+	loc.Line, loc.EndLine = lines.start, lines.end
+	anchor := "#L" + strconv.Itoa(lines.start)
+	loc.URL += "?dark&ln" + anchor
+	if loc.BlobURL != "" {
+		loc.BlobURL += anchor
+	}
+	return loc, nil
+}
+
+// calls the method of a request, returning its result or an error
+func (s *server) callRPC(ctx context.Context, r *http.Request, req *rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "textDocument/resolve":
+		var params struct {
+			Reference string `json:"reference"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Reference == "" {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: `Expected {"reference": "<path>:<line>"}`}
+		}
+		query, lines, err := parseReference(params.Reference)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: capitalize(err.Error())}
+		}
+		loc, err := s.locate(ctx, r, query, lines)
+		if err != nil {
+			// private files the request can't read aren't found, like with /-/api/resolve
+			if status := errorStatus(err); status == http.StatusInternalServerError || status == http.StatusServiceUnavailable {
+				log.Printf("Could not resolve %s: %s\n", params.Reference, err)
+				return nil, &rpcError{Code: rpcInternalError, Message: "Internal error"}
+			}
+			return nil, &rpcError{Code: rpcFileNotFound, Message: fmt.Sprintf("Could not find a file matching %s", params.Reference)}
+		}
+		loc.Reference = params.Reference
+		return loc, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("Unknown method '%s', expected textDocument/resolve", req.Method)}
+}
+
+// handles one request, nil if it's a notification
+func (s *server) handleRPC(ctx context.Context, r *http.Request, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcInvalidRequest, Message: "Invalid request, expected a JSON-RPC 2.0 request object"}}
+	}
+	result, rpcErr := s.callRPC(ctx, r, &req)
+	if req.ID == nil {
+		return nil
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+}
+
+// a JSON-RPC 2.0 endpoint for editor plugins: POST a request (or a batch
+// of them) to resolve file:line references to their contents and URLs
+func (s *server) serveRPC(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	body = bytes.TrimSpace(body)
+	var responses []*rpcResponse
+	var batch []json.RawMessage
+	isBatch := false
+	switch {
+	case err != nil || !json.Valid(body):
+		responses = append(responses, &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: "Could not parse the body as JSON"}})
+	case body[0] != '[':
+		responses = append(responses, s.handleRPC(ctx, r, body))
+	case json.Unmarshal(body, &batch) != nil || len(batch) == 0 || len(batch) > maxResolveQueries:
+		msg := fmt.Sprintf("Expected a batch of 1 to %d requests", maxResolveQueries)
+		responses = append(responses, &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcInvalidRequest, Message: msg}})
+	default:
+		isBatch = true
+		for _, raw := range batch {
+			responses = append(responses, s.handleRPC(ctx, r, raw))
+		}
+	}
+	// notifications aren't responded to
+	sent := []*rpcResponse{}
+	for _, resp := range responses {
+		if resp != nil {
+			sent = append(sent, resp)
+		}
+	}
+	if len(sent) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if isBatch {
+		json.NewEncoder(w).Encode(sent)
+		return
+	}
+	json.NewEncoder(w).Encode(sent[0])
+}
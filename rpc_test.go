@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	for _, tt := range []struct {
+		reference string
+		query     string
+		lines     *lineRange
+	}{
+		{"init.lua", "init.lua", nil},
+		{"nvim/init.lua:10", "nvim/init.lua", &lineRange{10, 10}},
+		{"init.lua:10-20", "init.lua", &lineRange{10, 20}},
+		{"init.lua:10:5", "init.lua", &lineRange{10, 10}},
+		{"init.lua#L10", "init.lua", &lineRange{10, 10}},
+		{"init.lua#L10-L20", "init.lua", &lineRange{10, 20}},
+		{"init.lua#L10-20", "init.lua", &lineRange{10, 20}},
+		// only the last :<line> is a line
+		{"a:b.txt:3", "a:b.txt", &lineRange{3, 3}},
+		{"notes:", "notes:", nil},
+	} {
+		query, lines, err := parseReference(tt.reference)
+		if err != nil {
+			t.Errorf("parseReference(%q): %s", tt.reference, err)
+			continue
+		}
+		if query != tt.query || (lines == nil) != (tt.lines == nil) || (lines != nil && *lines != *tt.lines) {
+			t.Errorf("parseReference(%q) = %q, %+v, expected %q, %+v", tt.reference, query, lines, tt.query, tt.lines)
+		}
+	}
+	for _, invalid := range []string{"", "init.lua:0", "init.lua:20-10"} {
+		if _, _, err := parseReference(invalid); err == nil {
+			t.Errorf("expected parseReference(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestRPC(t *testing.T) {
+	dir := fixtureFolder(t)
+	s := newTestServer(t, dir, []string{"-folder", "{dir}", "-git-http-prefix", "https://example.com/dotfiles/blob/master"})
+	post := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/-/api/rpc", strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+	_, body := post(`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/resolve", "params": {"reference": "init.lua:2"}}`)
+	var resp struct {
+		ID     int             `json:"id"`
+		Result *editorLocation `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("%s: %q", err, body)
+	}
+	expected := &editorLocation{
+		Reference:  "init.lua:2",
+		Path:       "nvim/init.lua",
+		Line:       2,
+		EndLine:    2,
+		TotalLines: 2,
+		Content:    "vim.opt.number = true\n",
+		URL:        "http://example.com/nvim/init.lua?dark&ln#L2",
+		RawURL:     "http://example.com/-/raw/nvim/init.lua",
+		BlobURL:    "https://example.com/dotfiles/blob/master/nvim/init.lua#L2",
+	}
+	if resp.ID != 1 || resp.Error != nil || resp.Result == nil || *resp.Result != *expected {
+		t.Errorf("expected %+v, got %q", expected, body)
+	}
+	for _, tt := range []struct {
+		body string
+		// the code of the error, for each response
+		codes []int
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/resolve", "params": {"reference": "init.vim"}}`, []int{rpcFileNotFound}},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/resolve", "params": {"reference": "init.lua:0"}}`, []int{rpcInvalidParams}},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/resolve", "params": {}}`, []int{rpcInvalidParams}},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/definition"}`, []int{rpcMethodNotFound}},
+		{`{"id": 1, "method": "textDocument/resolve"}`, []int{rpcInvalidRequest}},
+		{`{"jsonrpc": "2.0", "id": 1,`, []int{rpcParseError}},
+		{`[]`, []int{rpcInvalidRequest}},
+		// notifications aren't responded to
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "textDocument/resolve", "params": {"reference": "bashrc"}}, {"jsonrpc": "2.0", "method": "textDocument/resolve", "params": {"reference": "bashrc"}}, 1]`, []int{0, rpcInvalidRequest}},
+		{`{"jsonrpc": "2.0", "method": "textDocument/resolve", "params": {"reference": "bashrc"}}`, nil},
+	} {
+		code, body := post(tt.body)
+		if tt.codes == nil {
+			if code != 204 || body != "" {
+				t.Errorf("POST %s = %d %q, expected a 204", tt.body, code, body)
+			}
+			continue
+		}
+		// batches are responded to with an array, unless the batch itself is invalid
+		var responses []rpcResponse
+		if strings.HasPrefix(body, "[") {
+			if err := json.Unmarshal([]byte(body), &responses); err != nil {
+				t.Fatalf("POST %s: %s: %q", tt.body, err, body)
+			}
+		} else {
+			var resp rpcResponse
+			if err := json.Unmarshal([]byte(body), &resp); err != nil {
+				t.Fatalf("POST %s: %s: %q", tt.body, err, body)
+			}
+			responses = append(responses, resp)
+		}
+		codes := []int{}
+		for _, resp := range responses {
+			if resp.Error == nil {
+				codes = append(codes, 0)
+			} else {
+				codes = append(codes, resp.Error.Code)
+			}
+		}
+		if code != 200 || !slices.Equal(codes, tt.codes) {
+			t.Errorf("POST %s = %d with error codes %v, expected %v", tt.body, code, codes, tt.codes)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sha256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// serves files from an S3 (compatible) bucket, e.g. s3://bucket/prefix
+//
+// the object listing is cached for cacheTTL, so walks don't
+// make a request to the bucket every time
+//
+// credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// (optionally) AWS_SESSION_TOKEN environment variables, if they're not set
+// requests are made anonymously. The region and endpoint can be set with
+// ?region= and ?endpoint= in the URL, or the AWS_REGION and AWS_ENDPOINT_URL_S3
+// environment variables
+type s3Source struct {
+	bucket string
+	// prefix of the keys in the bucket which are served, ends with a '/' (or is empty)
+	prefix   string
+	region   string
+	endpoint *url.URL
+	// if true, uses endpoint/bucket/key instead of bucket.endpoint/key
+	pathStyle bool
+	accessKey string
+	secretKey string
+	token     string
+	cacheTTL  time.Duration
+	client    *http.Client
+
+	mu       sync.Mutex
+	tree     *memTree
+	listedAt time.Time
+}
+
+func newS3Source(u *url.URL, opts *sourceOptions) (*s3Source, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("no bucket in '%s', expected s3://bucket/prefix", u)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	query := u.Query()
+	region := firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	s := &s3Source{
+		bucket:    u.Host,
+		prefix:    prefix,
+		region:    region,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		cacheTTL:  opts.cacheTTL,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	endpoint := firstNonEmpty(query.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint != "" {
+		// S3 compatible services (minio, R2, etc.) usually expect path-style requests
+		parsed, err := url.Parse(endpoint)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint '%s'", endpoint)
+		}
+		s.endpoint = parsed
+		s.pathStyle = true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", region)}
+	}
+	// list the bucket once, so a misconfigured bucket errors at startup
+	if _, err := s.listing(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *s3Source) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// returns the cached listing of the bucket, refreshing it if it's older than cacheTTL
+func (s *s3Source) listing(ctx context.Context) (*memTree, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tree != nil && time.Since(s.listedAt) < s.cacheTTL {
+		return s.tree, nil
+	}
+	files, err := s.listObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.tree = newMemTree(files)
+	s.listedAt = time.Now()
+	return s.tree, nil
+}
+
+// drops the cached listing, the bucket is listed again on the next request
+func (s *s3Source) purge(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree = nil
+}
+
+func (s *s3Source) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return err
+	}
+	return tree.walk(dir, fn)
+}
+
+func (s *s3Source) stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tree.stat(p)
+}
+
+func (s *s3Source) open(ctx context.Context, p string) (fs.File, error) {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node := tree.lookup(p)
+	if node == nil || node.isDir {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	resp, err := s.do(ctx, s.prefix+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteFile{body: resp.Body, info: node}, nil
+}
+
+// the response to a ListObjectsV2 request
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// lists every object under the prefix, following continuation tokens
+func (s *s3Source) listObjects(ctx context.Context) ([]memFile, error) {
+	files := []memFile{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse listing of %s: %w", s, err)
+		}
+		for _, obj := range result.Contents {
+			// skip 'directory' placeholder objects
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
+			}
+			files = append(files, memFile{
+				path:    strings.TrimPrefix(obj.Key, s.prefix),
+				size:    obj.Size,
+				modTime: obj.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// makes a signed GET request for key (or the bucket, if key is empty)
+//
+// returns an error if the response isn't a 200
+func (s *s3Source) do(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	req, err := s.newRequest(ctx, key, query)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("request to %s failed with %s: %s", s, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// an unsigned GET request for key (or the bucket, if key is empty)
+func (s *s3Source) newRequest(ctx context.Context, key string, query url.Values) (*http.Request, error) {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+}
+
+// signs the request with AWS Signature Version 4
+//
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (s *s3Source) sign(req *http.Request, now time.Time) {
+	if s.accessKey == "" || s.secretKey == "" {
+		return
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	// headers to sign, lowercased and sorted
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// escapes everything except unreserved characters, as SigV4 expects
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// escapes each segment of the path, leaving the slashes
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// query parameters sorted by key (and then value), with keys/values escaped
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// the most ?context= lines around each match for /-/search
+	maxSearchContext = 10
+	// the most matches /-/search responds with, and the default ?limit=
+	maxSearchMatches = 1000
+	// lines longer than this (e.g. minified files) are cut off
+	maxSearchLineLength = 500
+)
+
+// compiles the ?q= search query, which is matched against the path and
+// contents of each file
+//
+// it's a literal string, unless ?regex is passed, when it's an RE2
+// pattern. ?case= is one of insensitive (the default), sensitive or smart
+// (sensitive if the query has an uppercase letter, like ripgrep -S)
+func compileSearch(queryParams url.Values, q string) (*regexp.Regexp, error) {
+	isRegex := hasQueryParam(queryParams, "regex") && !isFalse(queryParams.Get("regex"))
+	isLiteral := hasQueryParam(queryParams, "literal") && !isFalse(queryParams.Get("literal"))
+	if isRegex && isLiteral {
+		return nil, errors.New("?regex and ?literal can't be used together")
+	}
+	pattern := q
+	if !isRegex {
+		pattern = regexp.QuoteMeta(q)
+	}
+	switch caseMode := queryParams.Get("case"); caseMode {
+	case "", "insensitive":
+		pattern = "(?i)" + pattern
+	case "sensitive":
+	case "smart":
+		if !strings.ContainsFunc(q, unicode.IsUpper) {
+			pattern = "(?i)" + pattern
+		}
+	default:
+		return nil, fmt.Errorf("invalid case '%s', expected one of: insensitive, sensitive, smart", caseMode)
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex '%s': %w", q, err)
+	}
+	return compiled, nil
+}
+
+// a line in a file which matched a search, for /-/search
+type searchMatch struct {
+	// the path of the file, like /-/raw/<path>
+	Path string `json:"path"`
+	// the 1-indexed line number
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	// the ?context= lines before/after it
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+type searchResults struct {
+	Matches []*searchMatch `json:"matches"`
+	// whether there were more matches than ?limit=
+	Truncated bool `json:"truncated"`
+}
+
+// the options for /-/search, from the query parameters
+type searchOptions struct {
+	pattern *regexp.Regexp
+	context int
+	// the most matches in each file, 0 for no limit
+	maxCount int
+	limit    int
+}
+
+// the lines in contents, without the trailing newline
+func splitLines(contents string) []string {
+	lines := strings.Split(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) > maxSearchLineLength {
+			line = strings.ToValidUTF8(line[:maxSearchLineLength], "")
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// the matching lines in the file, with the lines around them
+func searchFile(p string, data []byte, so *searchOptions) []*searchMatch {
+	var matches []*searchMatch
+	lines := splitLines(string(data))
+	for i, line := range lines {
+		if !so.pattern.MatchString(line) {
+			continue
+		}
+		match := &searchMatch{Path: p, Line: i + 1, Text: line, Before: []string{}, After: []string{}}
+		match.Before = append(match.Before, lines[max(i-so.context, 0):i]...)
+		match.After = append(match.After, lines[i+1:min(i+1+so.context, len(lines))]...)
+		matches = append(matches, match)
+		if so.maxCount != 0 && len(matches) == so.maxCount {
+			break
+		}
+	}
+	return matches
+}
+
+// searches the contents of every file (which the request can read)
+// for lines matching the pattern, up to so.limit matches
+func (s *server) search(ctx context.Context, so *searchOptions, canRead func(m *mount, p string) bool) (*searchResults, error) {
+	results := &searchResults{Matches: []*searchMatch{}}
+	for _, m := range s.config.mounts {
+		err := walkFiles(ctx, m.src, ".", func(p string, d fs.DirEntry) error {
+			if !canRead(m, p) {
+				return nil
+			}
+			data, err := readFile(ctx, m.src, p)
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			data = s.redact(data)
+			if isBinary(data) || !so.pattern.Match(data) {
+				return nil
+			}
+			for _, match := range searchFile(mountPath(m, p), data, so) {
+				if len(results.Matches) == so.limit {
+					results.Truncated = true
+					return errStopWalk
+				}
+				results.Matches = append(results.Matches, match)
+			}
+			return nil
+		})
+		if errors.Is(err, errStopWalk) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// a line in the output for /-/search, a matching line or one of the
+// lines around it
+type searchLine struct {
+	path string
+	// 0 for the -- between groups of lines which aren't next to each other
+	number  int
+	text    string
+	isMatch bool
+}
+
+// the matches, with the lines around them, in order. The lines between
+// two matches which are close together are only included once, and
+// with context, groups of lines which aren't next to each other are
+// separated by a searchLine with a 0 number
+func (results *searchResults) searchLines(context int) []searchLine {
+	var lines []searchLine
+	lastPath, lastLine := "", 0
+	for i, match := range results.Matches {
+		first := match.Line - len(match.Before)
+		if match.Path == lastPath && first <= lastLine+1 {
+			// the lines before it were already included after the last match
+			first = lastLine + 1
+		} else if context > 0 && lastPath != "" {
+			lines = append(lines, searchLine{})
+		}
+		for n := first; n < match.Line; n++ {
+			lines = append(lines, searchLine{match.Path, n, match.Before[len(match.Before)-(match.Line-n)], false})
+		}
+		lines = append(lines, searchLine{match.Path, match.Line, match.Text, true})
+		lastPath, lastLine = match.Path, match.Line
+		for j, text := range match.After {
+			// the next match is included as a match, not as context
+			if i+1 < len(results.Matches) && results.Matches[i+1].Path == match.Path && results.Matches[i+1].Line == lastLine+1 {
+				break
+			}
+			lastLine = match.Line + 1 + j
+			lines = append(lines, searchLine{match.Path, lastLine, text, false})
+		}
+	}
+	return lines
+}
+
+// the matches like ripgrep prints them: path:line:text for matching
+// lines, and with context, path-line-text for the lines around them,
+// and -- between groups of lines which aren't next to each other
+func (results *searchResults) lines(context int) string {
+	var b strings.Builder
+	for _, line := range results.searchLines(context) {
+		switch {
+		case line.number == 0:
+			b.WriteString("--\n")
+		case line.isMatch:
+			fmt.Fprintf(&b, "%s:%d:%s\n", line.path, line.number, line.text)
+		default:
+			fmt.Fprintf(&b, "%s-%d-%s\n", line.path, line.number, line.text)
+		}
+	}
+	return b.String()
+}
+
+// text, escaped, with each match of the pattern in a <mark>
+func highlightMatches(text string, pattern *regexp.Regexp) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[loc[0]:loc[1]]) + "</mark>")
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// the matches for the ?dark view, grouped by file, with the matched text
+// highlighted, and each line linking to that line in the file
+func (results *searchResults) html(basePath string, pattern *regexp.Regexp, context int) template.HTML {
+	var b strings.Builder
+	b.WriteString("<table class=\"code search\">\n")
+	lastPath := ""
+	for _, line := range results.searchLines(context) {
+		if line.number == 0 {
+			b.WriteString("<tr class=\"separator\"><td class=\"line-number\">&hellip;</td><td></td></tr>\n")
+			continue
+		}
+		fileURL := (&url.URL{Path: basePath + "/" + line.path}).EscapedPath()
+		if line.path != lastPath {
+			fmt.Fprintf(&b, "<tr class=\"file\"><td colspan=\"2\"><a href=\"%s?dark\">%s</a></td></tr>\n", fileURL, html.EscapeString(line.path))
+			lastPath = line.path
+		}
+		class := "context"
+		if line.isMatch {
+			class = "match"
+		}
+		fmt.Fprintf(&b, "<tr class=\"%s\"><td class=\"line-number\"><a href=\"%s?dark&amp;ln#L%d\">%d</a></td><td><code>%s</code></td></tr>\n", class, fileURL, line.number, line.number, highlightMatches(line.text, pattern))
+	}
+	b.WriteString("</table>\n")
+	return template.HTML(b.String())
+}
+
+// responds with the lines in every file which match ?q=, like ripgrep,
+// or a JSON object with ?json
+//
+// ?context= includes that many lines around each match, ?max-count= is
+// the most matches in each file, and ?limit= the most matches in total
+func (s *server) serveSearch(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	queryParams := r.URL.Query()
+	so := &searchOptions{pattern: opts.searchPattern, limit: maxSearchMatches}
+	if opts.limit != 0 {
+		so.limit = min(opts.limit, maxSearchMatches)
+	}
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		render(&w, &PageInfo{
+			PageContents: message + "\n",
+			Title:        "400 - Bad Request",
+		}, s.tmpl, opts.isDark)
+	}
+	if opts.search == "" {
+		badRequest("Pass a search query with ?q=")
+		return
+	}
+	for _, param := range [...]struct {
+		name  string
+		max   int
+		value *int
+	}{
+		{"context", maxSearchContext, &so.context},
+		{"max-count", maxSearchMatches, &so.maxCount},
+	} {
+		value := queryParams.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > param.max {
+			badRequest(fmt.Sprintf("Invalid %s '%s', expected a number from 0 to %d", param.name, value, param.max))
+			return
+		}
+		*param.value = n
+	}
+	if len(s.private.patterns) > 0 {
+		setPrivateCache(w)
+	}
+	release, err := s.searches.acquire(ctx)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	defer release()
+	done := timingsFrom(ctx).track("walk")
+	results, err := s.search(ctx, so, func(m *mount, p string) bool { return s.checkPrivate(ctx, m, p) == nil })
+	done()
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	if hasQueryParam(queryParams, "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+	if results.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	contents := results.lines(so.context)
+	if opts.isDark && contents == "" {
+		contents = "No matches\n"
+	}
+	info := &PageInfo{
+		PageContents: contents,
+		Title:        "Search: " + opts.search,
+	}
+	if opts.isDark && len(results.Matches) > 0 {
+		info.Rendered = results.html(s.config.basePath, so.pattern, so.context)
+	}
+	render(&w, info, s.tmpl, opts.isDark)
+}
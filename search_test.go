@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCompileSearch(t *testing.T) {
+	for _, tt := range []struct {
+		query   string
+		text    string
+		matches bool
+		err     bool
+	}{
+		{"q=Alias", "alias ls='ls -la'", true, false},
+		{"q=a.ias", "alias", false, false},
+		{"q=a.ias&literal", "a.ias", true, false},
+		{"q=a.ias&regex", "alias", true, false},
+		{"q=^export+[A-Z]%2B%3D&regex", "export PATH=", true, false},
+		{"q=Alias&case=sensitive", "alias", false, false},
+		{"q=alias&case=smart", "ALIAS", true, false},
+		{"q=Alias&case=smart", "alias", false, false},
+		{"q=a&case=upper", "", false, true},
+		{"q=(&regex", "", false, true},
+		{"q=a&regex&literal", "", false, true},
+		{"q=a&regex=0&literal", "a", true, false},
+	} {
+		queryParams, _ := url.ParseQuery(tt.query)
+		pattern, err := compileSearch(queryParams, queryParams.Get("q"))
+		if (err != nil) != tt.err {
+			t.Errorf("compileSearch(%s) returned error %v", tt.query, err)
+			continue
+		}
+		if err == nil && pattern.MatchString(tt.text) != tt.matches {
+			t.Errorf("compileSearch(%s) matching %q = %v, expected %v", tt.query, tt.text, !tt.matches, tt.matches)
+		}
+	}
+}
+
+func TestSearchLines(t *testing.T) {
+	data := []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n")
+	pattern, _ := compileSearch(url.Values{"regex": {""}}, "^(two|three|seven)$")
+	so := &searchOptions{pattern: pattern, context: 1}
+	results := &searchResults{Matches: searchFile("n.txt", data, so)}
+	expected := `n.txt-1-one
+n.txt:2:two
+n.txt:3:three
+n.txt-4-four
+--
+n.txt-6-six
+n.txt:7:seven
+n.txt-8-eight
+`
+	if lines := results.lines(so.context); lines != expected {
+		t.Errorf("lines() = %q, expected %q", lines, expected)
+	}
+	so.maxCount = 1
+	if matches := searchFile("n.txt", data, so); len(matches) != 1 || matches[0].Line != 2 {
+		t.Errorf("searchFile with maxCount 1 = %v", matches)
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	pattern, _ := compileSearch(url.Values{}, "<b")
+	if highlighted := highlightMatches("a<b>c<B", pattern); highlighted != "a<mark>&lt;b</mark>&gt;c<mark>&lt;B</mark>" {
+		t.Errorf("highlightMatches() = %q", highlighted)
+	}
+}
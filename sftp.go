@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// total size of file contents cached from an SFTP source
+const sftpContentCacheSize = 64 << 20
+
+// serves files from a directory on another machine over SFTP, e.g. sftp://user@host/path
+//
+// a path starting with /~/ is relative to the users home directory
+//
+// authenticates with the ssh-agent (if SSH_AUTH_SOCK is set), the password in the URL,
+// and the private key from ?identity= (or the default ~/.ssh/id_* keys). The host key
+// is checked against ~/.ssh/known_hosts, or the file from ?known_hosts=
+//
+// like the S3 backend, the listing is cached for cacheTTL. The contents of files are
+// cached until the listing shows they've changed (by size or modification time)
+type sftpSource struct {
+	addr     string
+	root     string
+	config   *ssh.ClientConfig
+	cacheTTL time.Duration
+	display  string
+
+	// only held while connecting/disconnecting, the client is safe
+	// for concurrent use, so files are transferred without it
+	connMu sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+
+	mu       sync.Mutex
+	tree     *memTree
+	listedAt time.Time
+
+	contentMu   sync.Mutex
+	contents    map[string]*cachedContent
+	contentSize int64
+}
+
+// the contents of a file, and the metadata it had when it was read
+type cachedContent struct {
+	data    []byte
+	size    int64
+	modTime time.Time
+}
+
+func newSFTPSource(u *url.URL, opts *sourceOptions) (*sftpSource, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in '%s', expected sftp://user@host/path", u.Redacted())
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	home, _ := os.UserHomeDir()
+	query := u.Query()
+
+	auth := []ssh.AuthMethod{}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if agentConn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	identities := query["identity"]
+	if len(identities) == 0 {
+		for _, name := range [...]string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			identities = append(identities, filepath.Join(home, ".ssh", name))
+		}
+	}
+	signers := []ssh.Signer{}
+	for _, identity := range identities {
+		key, err := os.ReadFile(identity)
+		if err != nil {
+			if len(query["identity"]) > 0 {
+				return nil, fmt.Errorf("could not read identity file: %w", err)
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse identity file '%s' (keys with a passphrase should be added to ssh-agent instead): %w", identity, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	knownHostsFile := query.Get("known_hosts")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts from '%s': %w", knownHostsFile, err)
+	}
+
+	root := u.Path
+	if root == "/~" || len(root) > 2 && root[:3] == "/~/" {
+		// relative paths are resolved from the home directory by the server
+		root = "." + root[2:]
+	}
+	if root == "" {
+		root = "."
+	}
+
+	s := &sftpSource{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		root: path.Clean(root),
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		cacheTTL: opts.cacheTTL,
+		display:  fmt.Sprintf("sftp://%s@%s%s", user, u.Host, u.Path),
+		contents: make(map[string]*cachedContent),
+	}
+	// list the directory once, so misconfiguration errors at startup
+	if _, err := s.listing(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sftpSource) String() string {
+	return s.display
+}
+
+// runs fn with a connected client. If it fails with something other
+// than a filesystem error, reconnects and tries again, in case the
+// connection was dropped
+func (s *sftpSource) withClient(fn func(client *sftp.Client) error) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var client *sftp.Client
+		if client, err = s.connected(); err != nil {
+			continue
+		}
+		err = fn(client)
+		if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		s.disconnect(client)
+	}
+	return err
+}
+
+// returns the client, connecting if there isn't one
+func (s *sftpSource) connected() (*sftp.Client, error) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.client == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return s.client, nil
+}
+
+// must be called with s.connMu held
+func (s *sftpSource) connect() error {
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", s.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("could not start sftp session on %s: %w", s.addr, err)
+	}
+	s.conn = conn
+	s.client = client
+	return nil
+}
+
+// closes the connection client is from, unless another
+// request already replaced it with a new connection
+func (s *sftpSource) disconnect(client *sftp.Client) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.client == nil || s.client != client {
+		return
+	}
+	s.client.Close()
+	s.conn.Close()
+	s.client = nil
+	s.conn = nil
+}
+
+// returns the cached listing, refreshing it if it's older than cacheTTL
+func (s *sftpSource) listing(ctx context.Context) (*memTree, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tree != nil && time.Since(s.listedAt) < s.cacheTTL {
+		return s.tree, nil
+	}
+	var files []memFile
+	err := s.withClient(func(client *sftp.Client) error {
+		files = []memFile{}
+		return s.listDir(ctx, client, ".", &files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tree = newMemTree(files)
+	s.listedAt = time.Now()
+	return s.tree, nil
+}
+
+// recursively lists the regular files in dir, skipping ignored directories
+//
+// ignored directories are skipped while listing (instead of while walking, like
+// other sources) so large ignored directories (.git) aren't listed over the network
+func (s *sftpSource) listDir(ctx context.Context, client *sftp.Client, dir string, files *[]memFile) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := client.ReadDir(path.Join(s.root, dir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+		if isIgnored(p, entry.IsDir()) {
+			continue
+		}
+		if entry.IsDir() {
+			if err := s.listDir(ctx, client, p, files); err != nil {
+				return err
+			}
+		} else if entry.Mode().IsRegular() {
+			*files = append(*files, memFile{path: p, size: entry.Size(), modTime: entry.ModTime(), perm: entry.Mode().Perm()})
+		}
+	}
+	return nil
+}
+
+// drops the cached listing, and the cached contents of any files under p
+func (s *sftpSource) purge(p string) {
+	s.mu.Lock()
+	s.tree = nil
+	s.mu.Unlock()
+	s.contentMu.Lock()
+	defer s.contentMu.Unlock()
+	for key, c := range s.contents {
+		if p == "." || key == p || strings.HasPrefix(key, p+"/") {
+			delete(s.contents, key)
+			s.contentSize -= int64(len(c.data))
+		}
+	}
+}
+
+func (s *sftpSource) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return err
+	}
+	return tree.walk(dir, fn)
+}
+
+func (s *sftpSource) stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tree.stat(p)
+}
+
+func (s *sftpSource) open(ctx context.Context, p string) (fs.File, error) {
+	tree, err := s.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node := tree.lookup(p)
+	if node == nil || node.isDir {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	if data := s.cached(p, node); data != nil {
+		return &remoteFile{body: io.NopCloser(bytes.NewReader(data)), info: node}, nil
+	}
+	var data []byte
+	err = s.withClient(func(client *sftp.Client) error {
+		f, err := client.Open(path.Join(s.root, p))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var buf bytes.Buffer
+		chunk := make([]byte, 32*1024)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := f.Read(chunk)
+			buf.Write(chunk[:n])
+			if err == io.EOF {
+				data = buf.Bytes()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cache(p, node, data)
+	return &remoteFile{body: io.NopCloser(bytes.NewReader(data)), info: node}, nil
+}
+
+// returns the cached contents of the file, if they're still up to date with the listing
+func (s *sftpSource) cached(p string, node *memNode) []byte {
+	s.contentMu.Lock()
+	defer s.contentMu.Unlock()
+	c, ok := s.contents[p]
+	if !ok {
+		return nil
+	}
+	if c.size != node.size || !c.modTime.Equal(node.modTime) {
+		delete(s.contents, p)
+		s.contentSize -= int64(len(c.data))
+		return nil
+	}
+	return c.data
+}
+
+func (s *sftpSource) cache(p string, node *memNode, data []byte) {
+	size := int64(len(data))
+	// don't let one file take up most of the cache
+	if size > sftpContentCacheSize/4 {
+		return
+	}
+	s.contentMu.Lock()
+	defer s.contentMu.Unlock()
+	if old, ok := s.contents[p]; ok {
+		s.contentSize -= int64(len(old.data))
+	}
+	// evict (arbitrary) entries until this fits
+	for key, c := range s.contents {
+		if s.contentSize+size <= sftpContentCacheSize {
+			break
+		}
+		delete(s.contents, key)
+		s.contentSize -= int64(len(c.data))
+	}
+	s.contents[p] = &cachedContent{data: data, size: node.size, modTime: node.modTime}
+	s.contentSize += size
+}
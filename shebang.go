@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// a script's #! line
+type shebang struct {
+	// the line, without the #!
+	line string
+	// the program which runs the script (e.g. bash, or python3 for
+	// #!/usr/bin/env python3), and the arguments it's run with
+	interpreter string
+	args        []string
+}
+
+// the MIME types of scripts, by their interpreter without a version
+// (python3.12 is python), and whether it reads a script from stdin, so
+// it can be piped into it
+var scriptTypes = map[string]struct {
+	mimeType string
+	stdin    bool
+}{
+	"sh":     {"text/x-shellscript", true},
+	"bash":   {"text/x-shellscript", true},
+	"dash":   {"text/x-shellscript", true},
+	"zsh":    {"text/x-shellscript", true},
+	"ksh":    {"text/x-shellscript", true},
+	"fish":   {"text/x-shellscript", true},
+	"python": {"text/x-python", true},
+	"perl":   {"text/x-perl", true},
+	"ruby":   {"text/x-ruby", true},
+	"node":   {"text/javascript", true},
+	"deno":   {"text/javascript", false},
+	"bun":    {"text/javascript", false},
+	"php":    {"application/x-httpd-php", true},
+	"lua":    {"text/x-lua", false},
+	"awk":    {"text/x-awk", false},
+}
+
+// the #! line at the start of the file, nil if it doesn't have one
+func parseShebang(data []byte) *shebang {
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return nil
+	}
+	line, _, _ := bytes.Cut(data[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return nil
+	}
+	s := &shebang{line: strings.TrimSpace(string(line)), interpreter: path.Base(fields[0]), args: fields[1:]}
+	// #!/usr/bin/env [-S] [NAME=value...] program args
+	if s.interpreter == "env" {
+		args := s.args
+		for len(args) > 0 && (strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "=")) {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return nil
+		}
+		s.interpreter, s.args = path.Base(args[0]), args[1:]
+	}
+	return s
+}
+
+// the interpreter without a version, e.g. python for python3.12
+func (s *shebang) language() string {
+	return strings.TrimRight(s.interpreter, "0123456789.")
+}
+
+// the MIME type of the script, for its interpreter, or text/plain if
+// it's run with something else
+func (s *shebang) contentType() string {
+	if t, ok := scriptTypes[s.language()]; ok {
+		return t.mimeType + "; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// for plaintext responses of scripts, sets the Content-Type for the
+// language they're written in, and X-Shebang to their #! line
+func setScriptHeaders(w http.ResponseWriter, data []byte) {
+	s := parseShebang(data)
+	if s == nil || isBinary(data) {
+		return
+	}
+	w.Header().Set("Content-Type", s.contentType())
+	w.Header().Set("X-Shebang", s.line)
+}
+
+// for a script with a #! line, the command to download and run it, by
+// piping it into its interpreter (e.g. curl -fsSL <url> | bash), which
+// is displayed in the ?dark view. Empty if the interpreter can't read
+// a script from stdin
+func runWith(data []byte, fileURL string) string {
+	s := parseShebang(data)
+	if s == nil || isBinary(data) || !scriptTypes[s.language()].stdin {
+		return ""
+	}
+	return "curl -fsSL " + shellQuote(fileURL) + " | " + strings.Join(append([]string{s.interpreter}, s.args...), " ")
+}
+
+// quotes the string for a shell, if it has characters which would
+// be interpreted
+func shellQuote(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@%+=,", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
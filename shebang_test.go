@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseShebang(t *testing.T) {
+	for _, tt := range []struct {
+		data        string
+		contentType string
+		runWith     string
+	}{
+		{"#!/bin/sh\necho hi\n", "text/x-shellscript; charset=utf-8", "curl -fsSL https://x.dev/install.sh | sh"},
+		{"#!/usr/bin/env bash\n", "text/x-shellscript; charset=utf-8", "curl -fsSL https://x.dev/install.sh | bash"},
+		{"#!/usr/bin/env -S python3.12 -u\n", "text/x-python; charset=utf-8", "curl -fsSL https://x.dev/install.sh | python3.12 -u"},
+		{"#!/usr/bin/env LANG=C perl -w\n", "text/x-perl; charset=utf-8", "curl -fsSL https://x.dev/install.sh | perl -w"},
+		{"#!/usr/bin/env deno\n", "text/javascript; charset=utf-8", ""},
+		{"#!/opt/bin/custom\n", "text/plain; charset=utf-8", ""},
+		{"#!/usr/bin/env\n", "", ""},
+		{"echo '#!/bin/sh'\n", "", ""},
+	} {
+		s := parseShebang([]byte(tt.data))
+		contentType := ""
+		if s != nil {
+			contentType = s.contentType()
+		}
+		if contentType != tt.contentType {
+			t.Errorf("content type of %q = %q, expected %q", tt.data, contentType, tt.contentType)
+		}
+		if got := runWith([]byte(tt.data), "https://x.dev/install.sh"); got != tt.runWith {
+			t.Errorf("runWith(%q) = %q, expected %q", tt.data, got, tt.runWith)
+		}
+	}
+	if got := runWith([]byte("#!/bin/sh\n"), "https://x.dev/it's.sh"); got != `curl -fsSL 'https://x.dev/it'\''s.sh' | sh` {
+		t.Errorf("runWith didn't quote the URL: %s", got)
+	}
+}
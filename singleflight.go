@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"runtime/debug"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// deduplicates identical concurrent lookups (walks to match a
+// query, reads of a file), so if ten clients request the same
+// path at once, the folder is only walked/the file only read once
+type lookupGroup struct {
+	group singleflight.Group
+}
+
+// calls fn, unless there's already a call for key in progress,
+// in which case this waits for that and returns its result
+//
+// fn is called with the ctx of whichever request started it. If that
+// request was cancelled (or timed out) but this one wasn't, tries again
+func (g *lookupGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		results := g.group.DoChan(key, func() (value interface{}, err error) {
+			// DoChan re-panics in a new goroutine, which would crash the server
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("Panic while looking up %q: %v\n%s", key, p, debug.Stack())
+					err = fmt.Errorf("panic while looking up %q: %v", key, p)
+				}
+			}()
+			return fn(ctx)
+		})
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result := <-results:
+			// the call is removed from the group once it finishes,
+			// so trying again starts a new one
+			if ctx.Err() == nil && (errors.Is(result.Err, context.Canceled) || errors.Is(result.Err, context.DeadlineExceeded)) {
+				continue
+			}
+			return result.Val, result.Err
+		}
+	}
+}
+
+// like find, but identical concurrent lookups share one walk
+func (s *server) find(ctx context.Context, m *mount, query string) (string, error) {
+	v, err := s.lookups.do(ctx, "find\x00"+m.name+"\x00"+query, func(ctx context.Context) (interface{}, error) {
+		return find(ctx, m.src, query)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// like findDir, but identical concurrent lookups share one walk
+func (s *server) findDir(ctx context.Context, m *mount, query string) (string, error) {
+	v, err := s.lookups.do(ctx, "dir\x00"+m.name+"\x00"+query, func(ctx context.Context) (interface{}, error) {
+		return findDir(ctx, m.src, query)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// the result of readFileInfo
+type fileContents struct {
+	data []byte
+	info fs.FileInfo
+}
+
+// like readFileInfo, but identical concurrent reads share one read
+//
+// the data is shared between requests, so it must not be modified
+//
+// returns errPrivate if the request can't read the file
+func (s *server) readFileInfo(ctx context.Context, m *mount, p string) ([]byte, fs.FileInfo, error) {
+	if err := s.checkPrivate(ctx, m, p); err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkFileSize(ctx, m, p); err != nil {
+		return nil, nil, err
+	}
+	v, err := s.lookups.do(ctx, "read\x00"+m.name+"\x00"+p, func(ctx context.Context) (interface{}, error) {
+		data, info, err := readFileInfo(ctx, m.src, p)
+		return &fileContents{data: data, info: info}, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	contents := v.(*fileContents)
+	return s.redact(contents.data), contents.info, nil
+}
+
+// returns a *fileTooLargeError (matching ErrTooLarge) if the file at p
+// in the mount is larger than -max-file-size, so it isn't read into memory
+func (s *server) checkFileSize(ctx context.Context, m *mount, p string) error {
+	if s.config.maxFileSize <= 0 {
+		return nil
+	}
+	info, err := statFile(ctx, m.src, p)
+	if err != nil {
+		return err
+	}
+	if info.Size() > s.config.maxFileSize {
+		return &fileTooLargeError{path: mountPath(m, p), size: info.Size(), max: s.config.maxFileSize}
+	}
+	return nil
+}
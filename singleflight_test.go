@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupGroupShares(t *testing.T) {
+	var g lookupGroup
+	var calls atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+				calls.Add(1)
+				<-release
+				return "value", nil
+			})
+			if err != nil || v != "value" {
+				t.Errorf("got %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected fn to be called once, was called %d times", n)
+	}
+}
+
+func TestLookupGroupPanic(t *testing.T) {
+	var g lookupGroup
+	_, err := g.do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		panic("oops")
+	})
+	if err == nil {
+		t.Fatal("expected the panic to be returned as an error")
+	}
+	// later lookups for the key don't wait on the call which panicked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	v, err := g.do(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		return "value", nil
+	})
+	if err != nil || v != "value" {
+		t.Errorf("got %v, %v", v, err)
+	}
+}
+
+func TestLookupGroupRetriesCancelled(t *testing.T) {
+	var g lookupGroup
+	started := make(chan struct{})
+	first, cancel := context.WithCancel(context.Background())
+	go g.do(first, "key", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	done := make(chan struct{})
+	var v interface{}
+	var err error
+	go func() {
+		defer close(done)
+		v, err = g.do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			return "value", nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	if err != nil || v != "value" {
+		t.Errorf("expected the lookup to be tried again, got %v, %v", v, err)
+	}
+}
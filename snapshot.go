@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+)
+
+// a copy of another source, read into memory
+//
+// once the snapshot is taken, requests never touch the underlying
+// source, so responses stay consistent even if files are being
+// rewritten (e.g. during a deploy). reload takes a new snapshot
+type snapshotSource struct {
+	src source
+
+	mu       sync.RWMutex
+	tree     *memTree
+	contents map[string][]byte
+	takenAt  time.Time
+	// of the paths/sizes/modification times/permissions of the files in the snapshot
+	fingerprint string
+	// with -watch, how it's watched for changes (native or polling), why
+	// native watches couldn't be used, and the last error from checking
+	// for changes or taking a new snapshot, for /-/status
+	watchMode  string
+	watchErr   string
+	refreshErr string
+}
+
+func newSnapshotSource(src source) (*snapshotSource, error) {
+	s := &snapshotSource{src: src}
+	if err := s.reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshotSource) String() string {
+	return s.src.String() + " (snapshot)"
+}
+
+// when the current snapshot was taken
+func (s *snapshotSource) takenTime() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.takenAt
+}
+
+// reads every file from the underlying source, and replaces
+// the current snapshot once they've all been read
+func (s *snapshotSource) reload(ctx context.Context) error {
+	files := []memFile{}
+	contents := make(map[string][]byte)
+	fingerprint := sha256.New()
+	err := walkFiles(ctx, s.src, ".", func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		writeFingerprint(fingerprint, path, info)
+		data, err := readFile(ctx, s.src, path)
+		// skip files which can't be read
+		if errors.Is(err, fs.ErrPermission) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, memFile{path: path, size: int64(len(data)), modTime: info.ModTime(), perm: info.Mode().Perm()})
+		contents[path] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tree := newMemTree(files)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree = tree
+	s.contents = contents
+	s.takenAt = time.Now()
+	s.fingerprint = hex.EncodeToString(fingerprint.Sum(nil))
+	return nil
+}
+
+func writeFingerprint(h hash.Hash, path string, info fs.FileInfo) {
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%o\n", path, info.Size(), info.ModTime().UnixNano(), info.Mode().Perm())
+}
+
+// whether any files in the underlying source were added, removed
+// or modified since the snapshot was taken, without reading them
+func (s *snapshotSource) changed(ctx context.Context) (bool, error) {
+	fingerprint := sha256.New()
+	err := walkFiles(ctx, s.src, ".", func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		writeFingerprint(fingerprint, path, info)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return hex.EncodeToString(fingerprint.Sum(nil)) != s.fingerprint, nil
+}
+
+// takes a new snapshot if the underlying source changed since the last one
+func (s *snapshotSource) refresh(ctx context.Context) {
+	changed, err := s.changed(ctx)
+	if err != nil {
+		log.Printf("Could not check %s for changes: %s\n", s.src, err)
+		s.setRefreshErr(err)
+		return
+	}
+	if !changed {
+		s.setRefreshErr(nil)
+		return
+	}
+	start := time.Now()
+	err = s.reload(ctx)
+	s.setRefreshErr(err)
+	if err != nil {
+		log.Printf("Could not take new snapshot of %s: %s\n", s.src, err)
+		return
+	}
+	tree, _ := s.snapshot()
+	log.Printf("%s changed, took new snapshot (%d files) in %s\n", s.src, tree.fileCount(), time.Since(start))
+}
+
+func (s *snapshotSource) setRefreshErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshErr = ""
+	if err != nil {
+		s.refreshErr = err.Error()
+	}
+}
+
+func (s *snapshotSource) setWatchMode(mode string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchMode = mode
+	if err != nil {
+		s.watchErr = err.Error()
+	}
+}
+
+// how it's watched for changes, why native watches couldn't be used,
+// and the last error from checking for changes
+func (s *snapshotSource) watchStatus() (string, string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.watchMode, s.watchErr, s.refreshErr
+}
+
+func (s *snapshotSource) snapshot() (*memTree, map[string][]byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree, s.contents
+}
+
+func (s *snapshotSource) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	tree, _ := s.snapshot()
+	return tree.walk(dir, fn)
+}
+
+func (s *snapshotSource) stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	tree, _ := s.snapshot()
+	return tree.stat(p)
+}
+
+func (s *snapshotSource) open(ctx context.Context, p string) (fs.File, error) {
+	tree, contents := s.snapshot()
+	node := tree.lookup(p)
+	data, ok := contents[p]
+	if node == nil || !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return &remoteFile{body: io.NopCloser(bytes.NewReader(data)), info: node}, nil
+}
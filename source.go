@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/karrick/godirwalk"
+)
+
+// where the files for a mount are served from
+//
+// paths are slash-separated and relative to the root of the source,
+// the root itself is "."
+type source interface {
+	// walks dir like fs.WalkDir, calling fn for dir and everything under it
+	walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error
+	// opens the file at path for reading
+	open(ctx context.Context, path string) (fs.File, error)
+	// describes the source, for logs
+	String() string
+}
+
+// sources which can get the info for a file without opening it
+type statter interface {
+	stat(ctx context.Context, path string) (fs.FileInfo, error)
+}
+
+// the info for the file at p in a listing
+func (t *memTree) stat(p string) (fs.FileInfo, error) {
+	node := t.lookup(p)
+	if node == nil || node.isDir {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// options used when creating sources, from the command line flags
+type sourceOptions struct {
+	walkEngine string
+	// how long a cached listing of a remote source is used before it's refreshed
+	cacheTTL time.Duration
+	// read the whole source into memory at startup
+	snapshot bool
+	// serve symlinks in a local folder to files in it, like the files
+	followSymlinks bool
+}
+
+// creates a source from a local path or a URL, e.g. s3://bucket/prefix or sftp://user@host/path
+func newSource(location string, opts *sourceOptions) (source, error) {
+	src, err := openSource(location, opts)
+	if err != nil || !opts.snapshot {
+		return src, err
+	}
+	return newSnapshotSource(src)
+}
+
+func openSource(location string, opts *sourceOptions) (source, error) {
+	if strings.Contains(location, "://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "s3":
+			return newS3Source(u, opts)
+		case "sftp":
+			return newSFTPSource(u, opts)
+		case "file":
+			location = u.Path
+		default:
+			return nil, fmt.Errorf("unsupported backend '%s'", u.Scheme)
+		}
+	}
+	local, err := newLocalSource(location, opts.walkEngine)
+	if err != nil {
+		return nil, err
+	}
+	local.followSymlinks = opts.followSymlinks
+	return local, nil
+}
+
+// reads the file at path, stopping early if ctx is cancelled
+func readFile(ctx context.Context, src source, path string) ([]byte, error) {
+	data, _, err := readFileInfo(ctx, src, path)
+	return data, err
+}
+
+// the info for the file at path, without reading it
+//
+// sources with a listing (remote backends, snapshots) use the info from that,
+// instead of opening the file, which would download it
+func statFile(ctx context.Context, src source, path string) (fs.FileInfo, error) {
+	if st, ok := src.(statter); ok {
+		return st.stat(ctx, path)
+	}
+	f, err := src.open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// how many times a file is read if it keeps changing while it's being read
+const readAttempts = 3
+
+// returned when a file was modified every time it was read
+var errFileChanged = errors.New("file changed while it was being read")
+
+// returned when reading a file which is larger than -max-file-size
+type fileTooLargeError struct {
+	path string
+	size int64
+	max  int64
+}
+
+func (e *fileTooLargeError) Error() string {
+	return fmt.Sprintf("%s is %s, which is larger than the maximum of %s this server responds with", e.path, humanizeBytes(e.size), humanizeBytes(e.max))
+}
+
+func (e *fileTooLargeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// like readFile, but also returns the info for the opened file
+//
+// if the file is modified while it's being read (e.g. the folder is being
+// rsynced), the contents could be truncated or a mix of the old/new versions.
+// If the size/modification time of the file changed between opening the file
+// and reading it, or the size doesn't match what was read, tries again
+func readFileInfo(ctx context.Context, src source, path string) ([]byte, fs.FileInfo, error) {
+	for attempt := 1; ; attempt++ {
+		data, info, changed, err := readFileOnce(ctx, src, path)
+		if err != nil || !changed {
+			return data, info, err
+		}
+		if attempt == readAttempts {
+			return nil, nil, &fs.PathError{Op: "read", Path: path, Err: errFileChanged}
+		}
+		// give whatever is writing the file a moment to finish
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+// reads the file, and whether it changed while it was being read
+func readFileOnce(ctx context.Context, src source, path string) ([]byte, fs.FileInfo, bool, error) {
+	f, err := src.open(ctx, path)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer f.Close()
+	before, err := f.Stat()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	var buf bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, false, err
+		}
+		n, err := f.Read(chunk)
+		buf.Write(chunk[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	// the info for remote files is from the (cached) listing, so it can't be used to detect changes
+	if _, isRemote := f.(*remoteFile); isRemote {
+		return buf.Bytes(), before, false, nil
+	}
+	after, err := f.Stat()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	changed := before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) || int64(buf.Len()) != after.Size()
+	return buf.Bytes(), after, changed, nil
+}
+
+// serves files from a folder on the local filesystem
+type localSource struct {
+	folder     string
+	walkEngine string
+	// walk symlinks to files in the folder as if they were the file
+	followSymlinks bool
+}
+
+func newLocalSource(folder string, walkEngine string) (*localSource, error) {
+	// make sure path is valid
+	fileInfo, err := os.Stat(folder)
+	if err != nil {
+		return nil, fmt.Errorf("folder to serve files from, '%s' does not exist", folder)
+	}
+	if !fileInfo.IsDir() {
+		return nil, fmt.Errorf("path '%s' is not a directory", folder)
+	}
+	absFolder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve absolute path for '%s': %w", folder, err)
+	}
+	return &localSource{folder: absFolder, walkEngine: walkEngine}, nil
+}
+
+func (l *localSource) String() string {
+	return l.folder
+}
+
+// the path on disk for a path relative to the folder
+func (l *localSource) fullPath(p string) string {
+	return filepath.Join(l.folder, filepath.FromSlash(p))
+}
+
+func (l *localSource) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	root := l.fullPath(dir)
+	walkFn := func(p string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(l.folder, p)
+		if relErr != nil {
+			return relErr
+		}
+		if err == nil && l.followSymlinks && d.Type()&fs.ModeSymlink != 0 {
+			if target, info, ok := l.resolveSymlink(p); ok {
+				d = symlinkEntry{DirEntry: d, info: info, target: target}
+			}
+		}
+		return fn(filepath.ToSlash(rel), d, err)
+	}
+	switch l.walkEngine {
+	case "walk":
+		return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return walkFn(p, nil, err)
+			}
+			return walkFn(p, fs.FileInfoToDirEntry(info), nil)
+		})
+	case "godirwalk":
+		return walkGodirwalk(root, walkFn)
+	}
+	return filepath.WalkDir(root, walkFn)
+}
+
+// a godirwalk.Dirent, as a fs.DirEntry
+type godirwalkEntry struct {
+	*godirwalk.Dirent
+	path string
+}
+
+func (d godirwalkEntry) Type() fs.FileMode          { return d.ModeType() }
+func (d godirwalkEntry) Info() (fs.FileInfo, error) { return os.Lstat(d.path) }
+
+// walks root with godirwalk, which reads directories with larger buffers and
+// without allocating a fs.FileInfo for each entry, calling fn like filepath.WalkDir
+func walkGodirwalk(root string, fn fs.WalkDirFunc) error {
+	// errors returned by fn are passed to ErrorCallback too, and stop the walk
+	var fnErr error
+	return godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(p string, de *godirwalk.Dirent) error {
+			fnErr = fn(p, godirwalkEntry{Dirent: de, path: p}, nil)
+			return fnErr
+		},
+		ErrorCallback: func(p string, err error) godirwalk.ErrorAction {
+			if err == fnErr {
+				return godirwalk.Halt
+			}
+			// e.g. a directory which can't be read
+			if fnErr = fn(p, nil, err); fnErr == nil || fnErr == fs.SkipDir {
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+	})
+}
+
+// opens p, which can't resolve to a file outside the folder (with ../ or symlinks)
+func (l *localSource) open(ctx context.Context, p string) (fs.File, error) {
+	// OpenInRoot doesn't follow absolute symlinks, even to files in the folder
+	if target := symlinkTarget(l, p); target != "" {
+		p = target
+	}
+	return os.OpenInRoot(l.folder, filepath.FromSlash(p))
+}
+
+// returns fs.ErrNotExist if p, or any directory above it is a symlink
+//
+// walks don't follow symlinks, so they're never matched/listed, this
+// makes sure files which are requested by path can't be read through them.
+// With -follow-symlinks, p itself can be a symlink to a file in the folder
+func (l *localSource) noSymlinks(p string) error {
+	current := l.folder
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if l.followSymlinks && i == len(parts)-1 {
+			if _, _, ok := l.resolveSymlink(current); ok {
+				continue
+			}
+		}
+		return &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// a symlink to a file in the folder, which is walked as if it was
+// the file with -follow-symlinks
+type symlinkEntry struct {
+	fs.DirEntry
+	// of the file it points to
+	info fs.FileInfo
+	// path of the file it points to, relative to the folder
+	target string
+}
+
+func (e symlinkEntry) Type() fs.FileMode          { return e.info.Mode().Type() }
+func (e symlinkEntry) Info() (fs.FileInfo, error) { return e.info, nil }
+
+// the path (relative to the folder) and info of the file the symlink at
+// full points to. ok is false if it doesn't point to a regular file in
+// the folder (directories aren't followed, so walks can't loop), or
+// points to an ignored file
+func (l *localSource) resolveSymlink(full string) (target string, info fs.FileInfo, ok bool) {
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", nil, false
+	}
+	rel, err := filepath.Rel(l.folder, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, false
+	}
+	rel = filepath.ToSlash(rel)
+	if checkIgnored(rel, false) != nil {
+		return "", nil, false
+	}
+	info, err = os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return rel, info, true
+}
+
+// with -follow-symlinks, the path of the file p is a symlink to (relative
+// to the root of the source), or an empty string if it isn't a symlink
+func symlinkTarget(src source, p string) string {
+	if snap, ok := src.(*snapshotSource); ok {
+		src = snap.src
+	}
+	local, ok := src.(*localSource)
+	if !ok || !local.followSymlinks {
+		return ""
+	}
+	full := local.fullPath(p)
+	info, err := os.Lstat(full)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return ""
+	}
+	target, _, ok := local.resolveSymlink(full)
+	if !ok {
+		return ""
+	}
+	return target
+}
+
+// a file or directory in a memTree
+//
+// implements both fs.DirEntry and fs.FileInfo
+type memNode struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+	// permissions of a file, 0 if the source doesn't have them (0o644)
+	perm fs.FileMode
+	// sorted by name, if this is a directory
+	children []*memNode
+}
+
+func (n *memNode) Name() string               { return n.name }
+func (n *memNode) IsDir() bool                { return n.isDir }
+func (n *memNode) Info() (fs.FileInfo, error) { return n, nil }
+func (n *memNode) Size() int64                { return n.size }
+func (n *memNode) ModTime() time.Time         { return n.modTime }
+func (n *memNode) Sys() interface{}           { return nil }
+
+func (n *memNode) Type() fs.FileMode {
+	return n.Mode().Type()
+}
+
+func (n *memNode) Mode() fs.FileMode {
+	if n.isDir {
+		return fs.ModeDir | 0o755
+	}
+	if n.perm != 0 {
+		return n.perm
+	}
+	return 0o644
+}
+
+// a file in a listing used to build a memTree
+type memFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	// permissions, 0 if they aren't known
+	perm fs.FileMode
+}
+
+// an in-memory tree of files, used by sources which aren't on the
+// local filesystem (e.g. a cached listing of the objects in an S3 bucket)
+type memTree struct {
+	root  *memNode
+	nodes map[string]*memNode
+}
+
+func newMemTree(files []memFile) *memTree {
+	t := &memTree{
+		root:  &memNode{name: ".", isDir: true},
+		nodes: make(map[string]*memNode),
+	}
+	t.nodes["."] = t.root
+	for _, f := range files {
+		p := path.Clean(strings.TrimPrefix(f.path, "/"))
+		if p == "." || t.nodes[p] != nil {
+			continue
+		}
+		parent := t.mkdirAll(path.Dir(p))
+		node := &memNode{name: path.Base(p), size: f.size, modTime: f.modTime, perm: f.perm.Perm()}
+		parent.children = append(parent.children, node)
+		t.nodes[p] = node
+	}
+	for _, node := range t.nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].name < node.children[j].name
+		})
+	}
+	return t
+}
+
+// returns the directory node for dir, creating it and any parents
+func (t *memTree) mkdirAll(dir string) *memNode {
+	if node, ok := t.nodes[dir]; ok {
+		return node
+	}
+	parent := t.mkdirAll(path.Dir(dir))
+	node := &memNode{name: path.Base(dir), isDir: true}
+	parent.children = append(parent.children, node)
+	t.nodes[dir] = node
+	return node
+}
+
+// number of files (not directories) in the tree
+func (t *memTree) fileCount() int {
+	count := 0
+	for _, node := range t.nodes {
+		if !node.isDir {
+			count++
+		}
+	}
+	return count
+}
+
+func (t *memTree) lookup(p string) *memNode {
+	return t.nodes[path.Clean(p)]
+}
+
+// walks the tree like fs.WalkDir
+func (t *memTree) walk(dir string, fn fs.WalkDirFunc) error {
+	node := t.lookup(dir)
+	if node == nil {
+		return fn(dir, nil, fs.ErrNotExist)
+	}
+	err := walkMemNode(path.Clean(dir), node, fn)
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkMemNode(p string, node *memNode, fn fs.WalkDirFunc) error {
+	if err := fn(p, node, nil); err != nil {
+		if err == fs.SkipDir && node.isDir {
+			return nil
+		}
+		return err
+	}
+	for _, child := range node.children {
+		if err := walkMemNode(path.Join(p, child.name), child, fn); err != nil {
+			// SkipDir on a file skips the rest of the directory
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// a file opened from a remote source, reading from body
+type remoteFile struct {
+	body io.ReadCloser
+	info *memNode
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *remoteFile) Read(b []byte) (int, error) { return f.body.Read(b) }
+func (f *remoteFile) Close() error               { return f.body.Close() }
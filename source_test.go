@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a file whose info is different every time Stat is called,
+// like a file which is being written to
+type changingFile struct {
+	io.Reader
+	stats []fs.FileInfo
+}
+
+func (f *changingFile) Stat() (fs.FileInfo, error) {
+	info := f.stats[0]
+	if len(f.stats) > 1 {
+		f.stats = f.stats[1:]
+	}
+	return info, nil
+}
+
+func (f *changingFile) Close() error { return nil }
+
+// a source which returns the next file from files each time it's opened
+type changingSource struct {
+	files []*changingFile
+	opens int
+}
+
+func (c *changingSource) walk(ctx context.Context, dir string, fn fs.WalkDirFunc) error {
+	return nil
+}
+
+func (c *changingSource) open(ctx context.Context, p string) (fs.File, error) {
+	f := c.files[c.opens]
+	c.opens++
+	return f, nil
+}
+
+func (c *changingSource) String() string { return "changing" }
+
+func fileInfo(size int64, modTime time.Time) fs.FileInfo {
+	return &memNode{name: "file", size: size, modTime: modTime}
+}
+
+func TestReadFileRetriesWhenModified(t *testing.T) {
+	start := time.Now()
+	src := &changingSource{files: []*changingFile{
+		// truncated while being read, the size changed
+		{Reader: strings.NewReader("hel"), stats: []fs.FileInfo{fileInfo(5, start), fileInfo(3, start.Add(time.Second))}},
+		// the size didn't change, but less than it was read
+		{Reader: strings.NewReader("hel"), stats: []fs.FileInfo{fileInfo(5, start), fileInfo(5, start)}},
+		{Reader: strings.NewReader("hello"), stats: []fs.FileInfo{fileInfo(5, start), fileInfo(5, start)}},
+	}}
+	data, err := readFile(context.Background(), src, "file")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expected 'hello', got '%s'", data)
+	}
+	if src.opens != 3 {
+		t.Errorf("expected the file to be opened 3 times, was opened %d times", src.opens)
+	}
+}
+
+func TestReadFileKeepsChanging(t *testing.T) {
+	start := time.Now()
+	src := &changingSource{}
+	for i := 0; i < readAttempts; i++ {
+		src.files = append(src.files, &changingFile{
+			Reader: strings.NewReader("hello"),
+			stats:  []fs.FileInfo{fileInfo(5, start), fileInfo(5, start.Add(time.Second))},
+		})
+	}
+	_, err := readFile(context.Background(), src, "file")
+	if !errors.Is(err, errFileChanged) {
+		t.Fatalf("expected errFileChanged, got %v", err)
+	}
+	if src.opens != readAttempts {
+		t.Errorf("expected the file to be opened %d times, was opened %d times", readAttempts, src.opens)
+	}
+}
+
+func TestReadFileRemoteIgnoresInfo(t *testing.T) {
+	// the size is from the listing, which may be out of date
+	tree := newMemTree([]memFile{{path: "file", size: 100}})
+	src := &snapshotSource{tree: tree, contents: map[string][]byte{"file": []byte("hello")}}
+	data, err := readFile(context.Background(), src, "file")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expected 'hello', got '%s'", data)
+	}
+}
+
+// rewrites a file in place while it's being read. While the file is being
+// written, it's always some prefix of a version, so every read should return
+// a prefix of one of the versions, which matches the size of the file (or fail)
+func TestReadFileConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "file")
+	versions := [][]byte{
+		bytes.Repeat([]byte("a"), 256*1024),
+		bytes.Repeat([]byte("b"), 128*1024),
+	}
+	if err := os.WriteFile(p, versions[0], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// write in chunks, so reads can see a partially written file
+			data := versions[i%2]
+			for start := 0; start < len(data); start += 16 * 1024 {
+				f.Write(data[start : start+16*1024])
+			}
+			f.Close()
+			// make sure the modification time changes, even on filesystems with coarse timestamps
+			os.Chtimes(p, time.Now(), time.Unix(int64(i), 0))
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		data, info, err := readFileInfo(context.Background(), src, "file")
+		if errors.Is(err, errFileChanged) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if int64(len(data)) != info.Size() {
+			t.Fatalf("read %d bytes, but the file is %d bytes", len(data), info.Size())
+		}
+		if !bytes.HasPrefix(versions[0], data) && !bytes.HasPrefix(versions[1], data) {
+			t.Fatalf("read %d bytes, which is a mix of versions of the file", len(data))
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestReadFileUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, info, err := readFileInfo(context.Background(), src, "file")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "hello" || info.Size() != 5 {
+		t.Errorf("expected 'hello' (5 bytes), got '%s' (%d bytes)", data, info.Size())
+	}
+}
+
+func TestWalkEnginesMatch(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a.txt", "b/c.txt", "b/d/e.txt", ".git/config", "f/.git/HEAD", "f/g.txt"} {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var want []string
+	for _, engine := range walkEngines {
+		src, err := newLocalSource(dir, engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := []string{}
+		err = listFiles(context.Background(), src, ".", "", nil, nil, func(line string, _ string) error {
+			lines = append(lines, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", engine, err)
+		}
+		if want == nil {
+			want = lines
+			continue
+		}
+		if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s listed %q, expected %q", engine, lines, want)
+		}
+	}
+	if len(want) != 4 {
+		t.Errorf("expected 4 files, got %q", want)
+	}
+}
+
+// numbers in the README's walk engine table are from:
+//
+//	go test -run '^$' -bench BenchmarkWalkEngines
+func BenchmarkWalkEngines(b *testing.B) {
+	dir := b.TempDir()
+	// 50,000 files, 100 in each directory
+	for i := 0; i < 500; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i/50), fmt.Sprintf("sub%d", i))
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 100; j++ {
+			if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%d.txt", j)), nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	ctx := context.Background()
+	for _, engine := range walkEngines {
+		src, err := newLocalSource(dir, engine)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(engine+"/index", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := listFiles(ctx, src, ".", "", nil, nil, func(string, string) error { return nil }); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(engine+"/no-match", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := find(ctx, src, "missing.txt"); !errors.Is(err, ErrNotFound) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRegularFileSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "inside.txt"), []byte("inside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"link.txt":        outside,
+		"linkdir":         filepath.Dir(outside),
+		"inside-link.txt": filepath.Join(dir, "sub", "inside.txt"),
+		"sublink":         filepath.Join(dir, "sub"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"link.txt", "linkdir/outside.txt", "inside-link.txt", "sublink/inside.txt", "../outside.txt"} {
+		if data, _, err := readRegularFile(ctx, src, p); err == nil {
+			t.Errorf("read %s through a symlink: %q", p, data)
+		}
+	}
+	// even if the check is skipped, opening can't leave the folder
+	if _, err := src.open(ctx, "link.txt"); err == nil {
+		t.Errorf("opened a symlink to a file outside the folder")
+	}
+	data, _, err := readRegularFile(ctx, src, "sub/inside.txt")
+	if err != nil || string(data) != "inside" {
+		t.Errorf("expected to read sub/inside.txt, got %q, %v", data, err)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, d := range []string{"stow/nvim", ".git"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, contents := range map[string]string{"stow/nvim/init.lua": "vim", ".git/config": "git"} {
+		if err := os.WriteFile(filepath.Join(dir, p), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"init.lua":   "stow/nvim/init.lua",
+		"abs.lua":    filepath.Join(dir, "stow/nvim/init.lua"),
+		"outside":    outside,
+		"gitconfig":  ".git/config",
+		"nvim":       "stow/nvim",
+		"broken.lua": "nope.lua",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := newLocalSource(dir, "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.followSymlinks = true
+	ctx := context.Background()
+	listed := []string{}
+	err = listFiles(ctx, src, ".", "", nil, nil, func(line string, _ string) error {
+		listed = append(listed, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "abs.lua init.lua stow/nvim/init.lua"; strings.Join(listed, " ") != expected {
+		t.Errorf("expected to list %s, got %v", expected, listed)
+	}
+	for _, p := range []string{"init.lua", "abs.lua"} {
+		data, _, err := readRegularFile(ctx, src, p)
+		if err != nil || string(data) != "vim" {
+			t.Errorf("expected to read %s through the symlink, got %q, %v", p, data, err)
+		}
+		if target := symlinkTarget(src, p); target != "stow/nvim/init.lua" {
+			t.Errorf("expected %s to point to stow/nvim/init.lua, got %q", p, target)
+		}
+	}
+	for _, p := range []string{"outside", "gitconfig", "nvim/init.lua", "broken.lua"} {
+		if data, _, err := readRegularFile(ctx, src, p); err == nil {
+			t.Errorf("read %s through a symlink: %q", p, data)
+		}
+	}
+}
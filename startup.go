@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats which the startup summary can be logged in
+var logFormats = [...]string{"text", "json"}
+
+// information about a mount, computed at startup
+type mountSummary struct {
+	Name          string `json:"name"`
+	Folder        string `json:"folder"`
+	GitHttpPrefix string `json:"git_http_prefix"`
+	GitRawPrefix  string `json:"git_raw_prefix,omitempty"`
+	Files         int    `json:"files"`
+	// number of filenames which are shared by more than one file,
+	// so matching on just the name could be ambiguous
+	AmbiguousNames int `json:"ambiguous_names"`
+	// number of (ignored) entries which were skipped while walking
+	Ignored int `json:"ignored"`
+}
+
+// logged at startup, so misconfiguration can be caught early
+type startupSummary struct {
+	Time   string   `json:"time"`
+	Listen []string `json:"listen"`
+	// URLs the server can be opened at, on this machine and on the LAN
+	URLs           []string       `json:"urls"`
+	Port           int            `json:"port"`
+	H2C            bool           `json:"h2c"`
+	WalkEngine     string         `json:"walk_engine"`
+	RequestTimeout string         `json:"request_timeout"`
+	Files          int            `json:"files"`
+	AmbiguousNames int            `json:"ambiguous_names"`
+	Ignored        int            `json:"ignored"`
+	Mounts         []mountSummary `json:"mounts"`
+}
+
+// walks the mount, counting files, ambiguous names and ignored entries
+func summarizeMount(m *mount) (*mountSummary, error) {
+	summary := &mountSummary{
+		Name:          m.name,
+		Folder:        m.src.String(),
+		GitHttpPrefix: m.repoPrefix,
+		GitRawPrefix:  m.rawPrefix,
+	}
+	names := make(map[string]int)
+	err := m.src.walk(context.Background(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// like walkEntries, skip directories which can't be read
+			if path != "." && errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		if isIgnored(path, d.IsDir()) || isMountFile(path) {
+			summary.Ignored++
+			if !d.IsDir() {
+				return nil
+			}
+			return fs.SkipDir
+		}
+		if d.Type().IsRegular() {
+			summary.Files++
+			names[d.Name()]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range names {
+		if count > 1 {
+			summary.AmbiguousNames++
+		}
+	}
+	return summary, nil
+}
+
+func summarize(config *config, listen []string) (*startupSummary, error) {
+	summary := &startupSummary{
+		Time:           time.Now().Format(time.RFC3339),
+		Listen:         listen,
+		URLs:           reachableURLs(config.port),
+		Port:           config.port,
+		H2C:            config.h2c,
+		WalkEngine:     config.walkEngine,
+		RequestTimeout: config.requestTimeout.String(),
+		Mounts:         []mountSummary{},
+	}
+	for _, m := range config.mounts {
+		mountSummary, err := summarizeMount(m)
+		if err != nil {
+			return nil, err
+		}
+		summary.Files += mountSummary.Files
+		summary.AmbiguousNames += mountSummary.AmbiguousNames
+		summary.Ignored += mountSummary.Ignored
+		summary.Mounts = append(summary.Mounts, *mountSummary)
+	}
+	return summary, nil
+}
+
+// logs the startup summary, in the configured -log-format
+func logStartup(config *config, listen []string) {
+	summary, err := summarize(config, listen)
+	if err != nil {
+		log.Fatalf("Error: Could not walk folder at startup: %s\n", err)
+	}
+	if config.qr {
+		defer printQR(summary.URLs)
+	}
+	if config.logFormat == "json" {
+		if err := json.NewEncoder(os.Stderr).Encode(summary); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, m := range summary.Mounts {
+		served := m.Folder
+		if m.Name != "" {
+			served = fmt.Sprintf("%s=%s", m.Name, m.Folder)
+		}
+		log.Printf("subpath-serve serving %s on port %d (%d files, %d ambiguous names, %d ignored)\n", served, config.port, m.Files, m.AmbiguousNames, m.Ignored)
+	}
+	log.Printf("Open %s\n", strings.Join(summary.URLs, " or "))
+}
+
+// the URLs the server can be opened at: localhost, and the address of
+// this machine on each LAN it's connected to. With -base-path, these are
+// what the reverse proxy forwards to, not the public URL
+func reachableURLs(port int) []string {
+	urls := []string{fmt.Sprintf("http://localhost:%d/", port)}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return urls
+	}
+	lan := []string{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsPrivate() {
+			continue
+		}
+		lan = append(lan, fmt.Sprintf("http://%s/", net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port))))
+	}
+	sort.Strings(lan)
+	return append(urls, lan...)
+}
+
+// prints a QR code of the first LAN URL, to open the server on a phone
+func printQR(urls []string) {
+	if len(urls) < 2 {
+		log.Println("Not connected to a LAN, so there's no URL to display a QR code for")
+		return
+	}
+	q, err := encodeQR([]byte(urls[1]))
+	if err != nil {
+		log.Printf("Could not display a QR code for %s: %s\n", urls[1], err)
+		return
+	}
+	fmt.Fprint(os.Stderr, q.terminal())
+}
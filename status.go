@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how many seconds of requests the rates in /-/status are over
+const rateWindow = 60
+
+// how often a cache had what was looked up in it, for /-/status
+type cacheCounts struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (c *cacheCounts) count(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// the size of a cache and how often it had what was looked up in it
+type cacheStatus struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes,omitempty"`
+	// the most bytes it can hold, 0 if it isn't limited by size
+	MaxBytes int64   `json:"max_bytes,omitempty"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+func (c *cacheCounts) status(entries int, size int64, maxSize int64) *cacheStatus {
+	status := &cacheStatus{Entries: entries, Bytes: size, MaxBytes: maxSize, Hits: c.hits.Load(), Misses: c.misses.Load()}
+	if lookups := status.Hits + status.Misses; lookups > 0 {
+		status.HitRate = float64(status.Hits) / float64(lookups)
+	}
+	return status
+}
+
+func (c *byteCache) status() *cacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts.status(len(c.entries), c.size, c.maxSize)
+}
+
+func (c *hashCache) status() *cacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts.status(len(c.hashes), 0, 0)
+}
+
+func (d *dirSizes) status() *cacheStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counts.status(len(d.sizes), 0, 0)
+}
+
+// the number of requests for each route, since the server started and
+// in each of the last rateWindow seconds
+type requestRates struct {
+	started time.Time
+
+	mu     sync.Mutex
+	routes map[string]*routeRequests
+}
+
+type routeRequests struct {
+	total int64
+	// indexed by the unix time mod rateWindow, with the second each
+	// one is for, so ones from over rateWindow seconds ago are ignored
+	seconds [rateWindow]struct {
+		at    int64
+		count int64
+	}
+}
+
+func newRequestRates() *requestRates {
+	return &requestRates{started: time.Now(), routes: make(map[string]*routeRequests)}
+}
+
+// counts a request for the route with the pattern
+func (r *requestRates) record(pattern string) {
+	now := time.Now().Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts, ok := r.routes[pattern]
+	if !ok {
+		counts = &routeRequests{}
+		r.routes[pattern] = counts
+	}
+	counts.total++
+	second := &counts.seconds[now%rateWindow]
+	if second.at != now {
+		second.at, second.count = now, 0
+	}
+	second.count++
+}
+
+// the requests for a route (or every route) in /-/status
+type requestStatus struct {
+	Total int64 `json:"total"`
+	// requests per second in the last rateWindow seconds
+	PerSecond float64 `json:"per_second"`
+}
+
+// the requests for every route, and for each route by its pattern
+func (r *requestRates) status() (*requestStatus, map[string]*requestStatus) {
+	now := time.Now().Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := &requestStatus{}
+	routes := make(map[string]*requestStatus, len(r.routes))
+	for pattern, counts := range r.routes {
+		var recent int64
+		for _, second := range counts.seconds {
+			if now-second.at < rateWindow {
+				recent += second.count
+			}
+		}
+		routes[pattern] = &requestStatus{Total: counts.total, PerSecond: float64(recent) / rateWindow}
+		all.Total += counts.total
+		all.PerSecond += routes[pattern].PerSecond
+	}
+	return all, routes
+}
+
+// a mount in /-/status
+type mountStatus struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// with -snapshot, when the snapshot was taken and the number of files in it
+	SnapshotAt  *time.Time `json:"snapshot_at,omitempty"`
+	SnapshotAge string     `json:"snapshot_age,omitempty"`
+	Files       int        `json:"files,omitempty"`
+	// with -watch, how it's watched for changes (native or polling), why
+	// native watches couldn't be used, and the last error from checking
+	// for changes
+	Watch      string `json:"watch,omitempty"`
+	WatchError string `json:"watch_error,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// the response of /-/status
+type serverStatus struct {
+	Started    time.Time `json:"started"`
+	Uptime     string    `json:"uptime"`
+	GoVersion  string    `json:"go_version"`
+	Goroutines int       `json:"goroutines"`
+	Memory     struct {
+		// bytes of allocated heap objects, of memory obtained from the OS,
+		// and the number of completed GC cycles
+		HeapAlloc uint64     `json:"heap_alloc"`
+		Sys       uint64     `json:"sys"`
+		GCCycles  uint32     `json:"gc_cycles"`
+		LastGC    *time.Time `json:"last_gc,omitempty"`
+	} `json:"memory"`
+	Caches   map[string]*cacheStatus `json:"caches"`
+	Mounts   []*mountStatus          `json:"mounts"`
+	Requests struct {
+		*requestStatus
+		Routes map[string]*requestStatus `json:"routes"`
+	} `json:"requests"`
+}
+
+// responds with ok, so a load balancer can check the server is up without
+// it matching or reading any files
+func (s *server) serveHealthz(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// responds with the internals of the running server, as JSON: the
+// goroutines, memory, caches, the age of the snapshot of each mount and
+// how it's watched, and the requests for each route
+func (s *server) serveStatus(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	status := &serverStatus{
+		Started:    s.requests.started,
+		Uptime:     time.Since(s.requests.started).Round(time.Second).String(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Caches:     map[string]*cacheStatus{"hashes": s.hashes.status()},
+		Mounts:     []*mountStatus{},
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	status.Memory.HeapAlloc, status.Memory.Sys, status.Memory.GCCycles = mem.HeapAlloc, mem.Sys, mem.NumGC
+	if mem.LastGC != 0 {
+		lastGC := time.Unix(0, int64(mem.LastGC))
+		status.Memory.LastGC = &lastGC
+	}
+	if s.renderCache != nil {
+		status.Caches["render"] = s.renderCache.status()
+	}
+	if s.thumbnails != nil {
+		status.Caches["thumbnails"] = s.thumbnails.status()
+	}
+	if s.dirSizes != nil {
+		status.Caches["dir_sizes"] = s.dirSizes.status()
+	}
+	for _, m := range s.config.mounts {
+		mount := &mountStatus{Name: m.name, Source: m.src.String()}
+		if snap, ok := m.src.(*snapshotSource); ok {
+			mount.Source = snap.src.String()
+			takenAt := snap.takenTime()
+			mount.SnapshotAt = &takenAt
+			mount.SnapshotAge = time.Since(takenAt).Round(time.Second).String()
+			tree, _ := snap.snapshot()
+			mount.Files = tree.fileCount()
+			mount.Watch, mount.WatchError, mount.Error = snap.watchStatus()
+		}
+		status.Mounts = append(status.Mounts, mount)
+	}
+	status.Requests.requestStatus, status.Requests.Routes = s.requests.status()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRequestRates(t *testing.T) {
+	rates := newRequestRates()
+	for range 30 {
+		rates.record("/{query...}")
+	}
+	rates.record("/-/status")
+	// from over a minute ago, only counted in the total
+	rates.routes["/-/status"].seconds[0].at -= 2 * rateWindow
+	rates.routes["/-/status"].seconds[0].count = 100
+	all, routes := rates.status()
+	if all.Total != 31 || routes["/{query...}"].Total != 30 || routes["/-/status"].Total != 1 {
+		t.Errorf("totals = %d, %+v", all.Total, routes)
+	}
+	if routes["/{query...}"].PerSecond != 0.5 {
+		t.Errorf("requests per second = %f, expected 0.5", routes["/{query...}"].PerSecond)
+	}
+	if routes["/-/status"].PerSecond > 1.0/rateWindow {
+		t.Errorf("requests from over a minute ago were counted in the rate: %f", routes["/-/status"].PerSecond)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	cache := newByteCache(10)
+	cache.put("a", []byte("1234"))
+	cache.get("a")
+	cache.get("a")
+	cache.get("a")
+	cache.get("b")
+	status := cache.status()
+	if status.Entries != 1 || status.Bytes != 4 || status.MaxBytes != 10 || status.Hits != 3 || status.Misses != 1 || status.HitRate != 0.75 {
+		t.Errorf("status = %+v", status)
+	}
+}
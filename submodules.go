@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a git submodule checked out in the folder of a mount. Its files are
+// served like any other (only its .git file is ignored), but links to
+// them go to the submodule's repository, at the commit which is checked out
+type submodule struct {
+	// path of the submodule, relative to the root of the mount
+	path string
+	// like the repoPrefix, rawPrefix and prefixName of a mount, empty if
+	// the mount doesn't have one, or it isn't known for the submodule's URL
+	repoPrefix string
+	rawPrefix  string
+	prefixName string
+}
+
+// the URLs of files in a repository on a forge, at a commit: the
+// -git-http-prefix and -git-raw-prefix it would be served with
+type forge struct {
+	blob string
+	raw  string
+}
+
+// forges by their host, {repo} is the path of the repository on the
+// forge (e.g. user/repo) and {commit} is the commit
+var forges = map[string]forge{
+	"github.com":    {blob: "https://github.com/{repo}/blob/{commit}", raw: "https://raw.githubusercontent.com/{repo}/{commit}"},
+	"gitlab.com":    {blob: "https://gitlab.com/{repo}/-/blob/{commit}", raw: "https://gitlab.com/{repo}/-/raw/{commit}"},
+	"codeberg.org":  {blob: "https://codeberg.org/{repo}/src/commit/{commit}", raw: "https://codeberg.org/{repo}/raw/commit/{commit}"},
+	"bitbucket.org": {blob: "https://bitbucket.org/{repo}/src/{commit}", raw: "https://bitbucket.org/{repo}/raw/{commit}"},
+	"git.sr.ht":     {blob: "https://git.sr.ht/{repo}/tree/{commit}/item", raw: "https://git.sr.ht/{repo}/blob/{commit}"},
+}
+
+// the forge a remote URL (e.g. https://github.com/user/repo.git or
+// git@github.com:user/repo) is on, and the path of the repository on it.
+// Returns false if it isn't one of the forges
+func parseRemote(remote string) (forge, string, bool) {
+	var host, repo string
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host, repo = u.Hostname(), u.Path
+	} else if userHost, p, ok := strings.Cut(remote, ":"); ok && !strings.Contains(userHost, "/") {
+		// scp-like syntax, [user@]host:path
+		host, repo = userHost[strings.LastIndex(userHost, "@")+1:], p
+	}
+	repo = strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+	f, ok := forges[strings.ToLower(host)]
+	if !ok || repo == "" {
+		return forge{}, "", false
+	}
+	return f, repo, true
+}
+
+// the submodules checked out in folder (including ones nested in them),
+// deepest first, with the prefixes of files in them. They only have a
+// prefix if the mount has one, and their URL is on one of the forges.
+// Returns nil if folder isn't in a git repository with submodules
+func findSubmodules(ctx context.Context, folder string, repoPrefix string, rawPrefix string) ([]*submodule, error) {
+	out, err := runGit(ctx, folder, "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		// not in a git repository
+		return nil, nil
+	}
+	toplevel, prefix, _ := strings.Cut(strings.TrimRight(string(out), "\n"), "\n")
+	if _, err := os.Stat(filepath.Join(toplevel, ".gitmodules")); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	// only checked out submodules are listed, with their URL as it was
+	// cloned (so relative URLs in .gitmodules are resolved)
+	out, err = runGit(ctx, toplevel, "submodule", "--quiet", "foreach", "--recursive",
+		`printf '%s\t%s\t%s\n' "$displaypath" "$(git rev-parse HEAD)" "$(git config --get remote.origin.url)"`)
+	if err != nil {
+		return nil, err
+	}
+	submodules := []*submodule{}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		// paths are relative to the root of the repository, which is
+		// prefix above the folder
+		p, ok := strings.CutPrefix(fields[0], prefix)
+		if !ok || p == "" {
+			continue
+		}
+		commit, remote := fields[1], fields[2]
+		sub := &submodule{path: p}
+		if f, repo, ok := parseRemote(remote); ok {
+			replacer := strings.NewReplacer("{repo}", repo, "{commit}", commit)
+			if repoPrefix != "" {
+				sub.repoPrefix = replacer.Replace(f.blob)
+				sub.prefixName = capitalize(getDomainName(sub.repoPrefix))
+			}
+			if rawPrefix != "" {
+				sub.rawPrefix = replacer.Replace(f.raw)
+			}
+		}
+		submodules = append(submodules, sub)
+	}
+	sort.Slice(submodules, func(i, j int) bool { return len(submodules[i].path) > len(submodules[j].path) })
+	return submodules, nil
+}
+
+// the prefixes links to the file at p (relative to the root of the mount)
+// are made with, from the submodule it's in, if it's in one. Returns the
+// path of the file relative to the repository the prefixes are for
+//
+// files in a submodule whose URL isn't on one of the forges don't have
+// a repoPrefix (or rawPrefix), rather than a broken link into the mount's
+func (m *mount) prefixesFor(p string) (repoPrefix string, rawPrefix string, prefixName string, rel string) {
+	for _, sub := range m.submodules {
+		if rest, ok := strings.CutPrefix(p, sub.path+"/"); ok {
+			return sub.repoPrefix, sub.rawPrefix, sub.prefixName, rest
+		}
+	}
+	return m.repoPrefix, m.rawPrefix, m.prefixName, p
+}
+
+// reads the submodules of each mount which is a local folder and has
+// a -git-http-prefix or -git-raw-prefix
+func readSubmodules(mounts []*mount) error {
+	for _, m := range mounts {
+		if m.repoPrefix == "" && m.rawPrefix == "" {
+			continue
+		}
+		src := m.src
+		if snap, ok := src.(*snapshotSource); ok {
+			src = snap.src
+		}
+		local, ok := src.(*localSource)
+		if !ok {
+			continue
+		}
+		submodules, err := findSubmodules(context.Background(), local.folder, m.repoPrefix, m.rawPrefix)
+		if err != nil {
+			return fmt.Errorf("could not read the submodules of %s: %w", local.folder, err)
+		}
+		m.submodules = submodules
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	for _, tt := range []struct {
+		remote string
+		repo   string
+		ok     bool
+	}{
+		{"https://github.com/user/repo.git", "user/repo", true},
+		{"https://github.com/user/repo", "user/repo", true},
+		{"git@github.com:user/repo.git", "user/repo", true},
+		{"ssh://git@gitlab.com/group/sub/repo.git", "group/sub/repo", true},
+		{"https://git.example.com/user/repo.git", "", false},
+		{"../repo.git", "", false},
+		{"/srv/git/repo", "", false},
+	} {
+		_, repo, ok := parseRemote(tt.remote)
+		if repo != tt.repo || ok != tt.ok {
+			t.Errorf("parseRemote(%q) = %q, %v, expected %q, %v", tt.remote, repo, ok, tt.repo, tt.ok)
+		}
+	}
+}
+
+func TestFindSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	dir := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)
+		out, err := runGit(context.Background(), dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	for _, repo := range []string{"plugin", "dotfiles"} {
+		if err := os.MkdirAll(filepath.Join(dir, repo), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, repo, "init.lua"), []byte(repo), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git(filepath.Join(dir, repo), "init", "-q")
+		git(filepath.Join(dir, repo), "add", ".")
+		git(filepath.Join(dir, repo), "commit", "-q", "-m", "init")
+	}
+	super := filepath.Join(dir, "dotfiles")
+	git(super, "submodule", "add", "-q", filepath.Join(dir, "plugin"), "nvim/plugin")
+	git(super, "commit", "-q", "-m", "add plugin")
+	commit := git(filepath.Join(super, "nvim/plugin"), "rev-parse", "HEAD")[:40]
+	// cloned from a local folder, which isn't on a forge
+	submodules, err := findSubmodules(context.Background(), super, "https://github.com/user/dotfiles/blob/master", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(submodules) != 1 || submodules[0].path != "nvim/plugin" || submodules[0].repoPrefix != "" {
+		t.Fatalf("findSubmodules = %+v, expected nvim/plugin without a prefix", submodules)
+	}
+	git(filepath.Join(super, "nvim/plugin"), "config", "remote.origin.url", "git@github.com:user/plugin.git")
+	// serving the nvim folder in the repository
+	submodules, err = findSubmodules(context.Background(), filepath.Join(super, "nvim"), "https://github.com/user/dotfiles/blob/master/nvim", "https://raw.githubusercontent.com/user/dotfiles/master/nvim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mount{repoPrefix: "https://github.com/user/dotfiles/blob/master/nvim", submodules: submodules}
+	for _, tt := range []struct {
+		path       string
+		repoPrefix string
+		rel        string
+	}{
+		{"plugin/init.lua", "https://github.com/user/plugin/blob/" + commit, "init.lua"},
+		{"init.lua", "https://github.com/user/dotfiles/blob/master/nvim", "init.lua"},
+		{"plugin.lua", "https://github.com/user/dotfiles/blob/master/nvim", "plugin.lua"},
+	} {
+		repoPrefix, _, _, rel := m.prefixesFor(tt.path)
+		if repoPrefix != tt.repoPrefix || rel != tt.rel {
+			t.Errorf("prefixesFor(%q) = %q, %q, expected %q, %q", tt.path, repoPrefix, rel, tt.repoPrefix, tt.rel)
+		}
+	}
+	if _, rawPrefix, _, _ := m.prefixesFor("plugin/init.lua"); rawPrefix != "https://raw.githubusercontent.com/user/plugin/"+commit {
+		t.Errorf("rawPrefix of plugin/init.lua = %q", rawPrefix)
+	}
+}
@@ -6,34 +6,249 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 )
 
 // default port to serve subpath-serve on
 const defaultPort = 8050
 
-// paths to ignore from serveFolder
-var ignorePaths = [...]string{".git"}
+// engines which can be used to walk the serveFolder
+//
+// walkdir uses filepath.WalkDir, which reads the type of each entry from the directory
+// listing instead of calling lstat on every file. walk uses filepath.Walk, which
+// lstats every entry -- that can be a lot slower on network filesystems
+var walkEngines = [...]string{"walkdir", "walk", "godirwalk"}
 
 // configuration information
 type config struct {
-	port        int
-	serveFolder string
-	repoPrefix  string
+	port           int
+	h2c            bool
+	mounts         []*mount
+	walkEngine     string
+	requestTimeout time.Duration
+	logFormat      string
+	// print a QR code of the LAN URL at startup
+	qr bool
+	// custom template to render ?dark pages with
+	templateFile string
+	// CSS/JS/images used by templates, served from hashed URLs
+	assetsDir string
+	// path the server is served under by a reverse proxy (e.g. /d), which
+	// links and redirects start with. Empty if it's served at the root
+	basePath string
+	// rules for which template to render files with
+	templateRulesFile string
+	// rules for requests from specific User-Agents, from the
+	// -user-agent-rule flags and then the -user-agent-rules file
+	userAgentRuleFlags multiFlag
+	userAgentRulesFile string
+	// TOML file with the queries for each bundle
+	bundlesFile string
+	// TOML file with the transformers for plaintext responses
+	transformsFile string
+	// file with a 'pattern type' line for each kind of file whose
+	// plaintext responses have that Content-Type, like a .mimetypes file
+	mimeTypesFile string
+	// gitignore patterns, from the -ignore flags and then the -ignore-file
+	ignoreFlags multiFlag
+	ignoreFile  string
+	// generate thumbnails for images in listings
+	thumbnails bool
+	// MiB of rendered ?dark pages to cache, 0 to disable
+	renderCacheSize int64
+	// parse the template again on every request, and don't cache pages
+	dev bool
+	// include the error chain and a stack trace in 500 pages
+	debug bool
+	// mask secrets (e.g. AWS keys) in the contents of files
+	redactSecrets bool
+	// displayed on every ?dark page and at the start of plaintext listings
+	notice     string
+	noticeFile string
+	// respond with a 413 instead of files larger than this many bytes, 0 for no limit
+	maxFileSize int64
+	// list directories before files in ?dark listings
+	dirsFirst bool
+	// how long the sizes of directories are cached, 0 to not display them
+	dirSizes time.Duration
+	// one of sortOrders
+	sortOrder string
+	// display a summary of the files above the ?dark index
+	dashboard bool
+	// whether ?dark pages display exact times instead of relative ones
+	absoluteTimes bool
+	// display line numbers next to files in the ?dark view, unless ?ln=0 is passed
+	lineNumbers bool
+	// path (relative to the served folder) of the page to respond with when nothing matches
+	notFoundFile string
+	// served as-is at /.well-known/
+	wellKnownDir string
+	// users who can authenticate, nil if -auth-file wasn't passed
+	users    users
+	authFile string
+	// CDN purge URL, and headers to send with purge requests
+	purgeURL     string
+	purgeHeaders http.Header
+	// log requests which take longer than this
+	slowRequestThreshold time.Duration
+	// minimum time between downloads of /-/mirror.tar.gz from each client
+	mirrorRateLimit time.Duration
+	// bytes per second sent to every client together, and on each
+	// connection, 0 for no limit
+	maxBandwidth        int64
+	maxBandwidthPerConn int64
+	// where the last /-/mirror.tar.gz which was built is kept, empty to stream it
+	archiveCacheDir string
+	// the uid/gid files in .tar.gz archives are owned by
+	archiveUID int
+	archiveGID int
+	// where files uploaded to /-/inbox/<name> are saved, empty if uploads
+	// aren't accepted, and the largest upload in bytes
+	inboxDir     string
+	inboxMaxSize int64
+	// the directory (relative to the root, starting with the mount name)
+	// POST /-/paste saves pastes into, empty if pastes aren't accepted,
+	// and the largest paste in bytes
+	pasteDir     string
+	pasteMaxSize int64
+	// take a new snapshot of snapshotted mounts when they change, and how
+	// often to check for changes when they can't be watched natively
+	watch         bool
+	watchInterval time.Duration
+	// audit the mounts at startup, and whether to refuse to start if
+	// anything is found. Empty to skip the audit
+	paranoid string
+	// count requests for /-/analytics, and the file to save them to
+	analytics     bool
+	analyticsFile string
+	// how often files are listed to find the changes for /-/changes, 0 if
+	// they aren't, and the file to save the changes to
+	changesInterval time.Duration
+	changesFile     string
+	// patterns for private files, and the key to sign URLs to them with
+	privateFlags multiFlag
+	signKeyFile  string
+	// patterns for files which are executed instead of served, and how long they can run for
+	dynamicFlags   multiFlag
+	dynamicTimeout time.Duration
+	// TOML file with the commands for virtual files
+	virtualFilesFile string
+	// PEM file with the Ed25519 key files are signed with for ?sig
+	minisignKeyFile string
+	// another instance to try requests which don't match anything against,
+	// and whether to proxy or redirect to it
+	fallbackURL  string
+	fallbackMode string
+	// how many archives and content searches can run at once, 0 for no limit
+	maxArchives int
+	maxSearches int
+	// public URL of the server, which pages link to as their canonical URL
+	canonicalURL string
+	// OpenID Connect provider users can log in with, the client registered
+	// with it, and the rules for which claims can read which private files
+	oidcIssuer           string
+	oidcClientID         string
+	oidcClientSecretFile string
+	oidcScopes           string
+	oidcUserClaim        string
+	oidcRulesFile        string
+	// daily requests/bytes for each IP address, and the file with tokens
+	// which have their own quotas
+	quotaRequests   int
+	quotaBytes      int64
+	quotaTokensFile string
+	// header a proxy in front of the server sets to the user it authenticated,
+	// which is only trusted from these addresses
+	authHeader     string
+	trustedProxies []netip.Prefix
+	// addresses which can read without authenticating
+	authExempt []netip.Prefix
+	// whether requests over plain HTTP are redirected to HTTPS, and the
+	// patterns for paths which aren't
+	httpsRedirect bool
+	httpsExempt   []*regexp.Regexp
 }
 
-// PageLines is used for the Index page
-// which needs each line to be split up so links can be added
-// If PageLines is empty, uses pageContents instead
+// the data passed to the template when rendering a ?dark page
+//
+// a custom -template can use any of these fields, see the README
 type PageInfo struct {
-	Title        string
+	Title string
+	// the file contents, or the plaintext response
 	PageContents string
-	PageLines    []string
-	PrefixInfo   *HttpPrefix
+	// used for the Index page, which needs each
+	// line to be split up so links can be added
+	// If PageLines is empty, uses PageContents instead
+	PageLines []string
+	// with -dirs-first, the directories in a listing, which
+	// are listed before the files in PageLines
+	Dirs []DirEntry
+	// displayed as a table above the file contents
+	Frontmatter []FrontmatterField
+	// the file rendered as HTML, for notebooks, or
+	// JSON/YAML/TOML files rendered as a collapsible tree with ?pretty
+	Rendered template.HTML
+	// with ?toc, the headings in a markdown file, which
+	// are displayed in a sidebar next to it
+	TOC        []Heading
+	PrefixInfo *HttpPrefix
+	// true for the index/directory listings, which
+	// display a search box, Search is the current search query
+	IsListing bool
+	Search    string
+	// the matched file, nil for listings/errors
+	File *FileMeta
+	// links to the listings for each directory above the
+	// file/directory, starting with the index of the mount
+	Breadcrumbs []Breadcrumb
+	// name of the theme being rendered (currently only dark)
+	Theme string
+	// the plaintext version of this page, empty for errors
+	RawUrl string
+	// the file rendered as a PDF, empty unless it's a text file
+	PdfUrl string
+	// for scripts with a #! line, a command which downloads the
+	// script and pipes it into its interpreter
+	RunWith string
+	// with -canonical-url, the canonical URL of the page, and the start of
+	// the file, for the <link rel="canonical"> and OpenGraph/Twitter tags
+	CanonicalUrl string
+	Description  string
+	// the URL the "Copy link" control copies, the file/directory without
+	// any query params, empty for errors
+	ShareUrl string
+	// whether listings should display thumbnails for images
+	Thumbnails bool
+	// whether line numbers are displayed next to the file, and the URL
+	// to toggle them. Empty if the file is rendered with a -template-rules template
+	LineNumbers    bool
+	LineNumbersUrl string
+	// with -follow-symlinks, the file each symlink in PageLines points to
+	Symlinks map[string]string
+	// with -dashboard, a summary of the files displayed above the index
+	Dashboard *Dashboard
+	// for 404s, a search box to find the file which was meant
+	NotFound *NotFoundSearch
+	// the language from Accept-Language which times are displayed in
+	// (with timestamp), e.g. en, for files and listings
+	Lang string
+}
+
+// the search box on a 404 page, which completes paths as it's typed in
+// (from /-/complete), and searches the index when it's submitted
+type NotFoundSearch struct {
+	// the name which didn't match anything, which the box starts with
+	Query    string
+	IndexUrl string
 }
 
 type HttpPrefix struct {
@@ -41,233 +256,468 @@ type HttpPrefix struct {
 	Hostname string
 }
 
-func parseFlags() *config {
+// metadata for the file being rendered
+type FileMeta struct {
+	// path of the file, relative to the root of the mount
+	Path    string
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// with -follow-symlinks, the path of the file this is a symlink to
+	LinkTarget string
+}
+
+// a link to the listing for a directory
+type Breadcrumb struct {
+	Name string
+	Url  string
+}
+
+// parses args (without the program name) with flags, e.g. os.Args[1:]
+// with flag.CommandLine
+func parseFlags(flags *flag.FlagSet, args []string) *config {
 	// flag definitions
-	port := flag.Int("port", 8050, "port to serve subpath-serve on")
-	serveFolder := flag.String("folder", "./serve", "path to serve subpath-serve on")
-	repoPrefix := flag.String("git-http-prefix", "", "Optionally, provide a prefix which when the matched filepath is appended to, links to a git web view (e.g. https://github.com/seanbreckenridge/dotfiles/blob/master)")
+	port := flags.Int("port", 8050, "port to serve subpath-serve on")
+	h2c := flags.Bool("h2c", false, "also accept HTTP/2 without TLS (h2c), e.g. from a reverse proxy")
+	serveFolder := flags.String("folder", "./serve", "path to serve subpath-serve on")
+	backend := flags.String("backend", "", "serve files from a backend instead of -folder, e.g. s3://bucket/prefix")
+	gitRefs := flags.Bool("git-refs", false, "serve each branch/tag of the git repository -folder (or each -mount) is in at /@<ref>/ (e.g. /@v1.0/rc.conf)")
+	followSymlinks := flags.Bool("follow-symlinks", false, "list, match and serve symlinks to files in the folder (e.g. a stow-managed dotfiles folder) like the file they point to, displaying what they point to in ?dark pages")
+	snapshot := flags.Bool("snapshot", false, "read every file into memory at startup, and serve from that instead of the folder/backend. POST to /-/reload to take a new snapshot")
+	watch := flags.Bool("watch", false, "with -snapshot, take a new snapshot when files in the folder/backend change. Uses inotify for a local folder, else checks for changes every -watch-interval")
+	watchInterval := flags.Duration("watch-interval", 30*time.Second, "with -watch, how often to check for changes if the folder can't be watched with inotify (e.g. on NFS), or for a remote -backend")
+	backendCacheTTL := flags.Duration("backend-cache-ttl", time.Minute, "how long the listing of files from a remote -backend is cached before it's refreshed")
+	repoPrefix := flags.String("git-http-prefix", "", "Optionally, provide a prefix which when the matched filepath is appended to, links to a git web view (e.g. https://github.com/seanbreckenridge/dotfiles/blob/master)")
+	var mountFlags multiFlag
+	flags.Var(&mountFlags, "mount", "serve a folder (or backend URL) under a prefix (e.g. notes=/srv/notes serves /srv/notes at /notes/), can be passed multiple times. If passed, -folder is not served")
+	var mountPrefixFlags multiFlag
+	flags.Var(&mountPrefixFlags, "mount-git-http-prefix", "like -git-http-prefix, for a -mount (e.g. notes=https://github.com/user/notes/blob/master), can be passed multiple times")
+	rawPrefix := flags.String("git-raw-prefix", "", "like -git-http-prefix, for the raw contents of the file (e.g. https://raw.githubusercontent.com/seanbreckenridge/dotfiles/master), which ?redirect=raw redirects to. Either prefix can have a {path} where the path goes, instead of at the end")
+	var mountRawPrefixFlags multiFlag
+	flags.Var(&mountRawPrefixFlags, "mount-git-raw-prefix", "like -git-raw-prefix, for a -mount (e.g. notes=https://raw.githubusercontent.com/user/notes/master), can be passed multiple times")
+	var ignoreFlags multiFlag
+	flags.Var(&ignoreFlags, "ignore", "a gitignore pattern (e.g. '*.swp', '/build/' or '!keep.log') for files/directories which aren't listed, matched or served, in addition to .git. Can be passed multiple times")
+	ignoreFile := flags.String("ignore-file", "", "file with gitignore patterns, applied after the -ignore patterns")
+	walkEngine := flags.String("walk-engine", "walkdir", fmt.Sprintf("method used to walk the folder, one of: %s", strings.Join(walkEngines[:], ", ")))
+	requestTimeout := flags.Duration("request-timeout", 0, "abort requests which take longer than this to respond (e.g. 10s), 0 to disable")
+	logFormat := flags.String("log-format", "text", fmt.Sprintf("format of the startup summary and slow request logs, one of: %s", strings.Join(logFormats[:], ", ")))
+	slowRequestThreshold := flags.Duration("slow-request-threshold", 0, "log requests which take longer than this (e.g. 500ms), with how long was spent walking, reading and rendering. 0 to disable")
+	maxArchives := flags.Int("max-archives", 2, "how many archives (/-/mirror.tar.gz, bundle archives) can be generated at once, other requests for one wait for up to 5s, then get a 503. 0 for no limit")
+	maxSearches := flags.Int("max-searches", 4, "how many searches of the contents of files (?q=) can run at once, other searches wait for up to 5s, then get a 503. 0 for no limit")
+	quotaRequests := flags.Int("quota-requests", 0, "how many requests each IP address (or -quota-tokens token) can make each day (UTC), after which it gets a 429. 0 for no limit")
+	quotaBytes := flags.Int64("quota-bytes", 0, "how many MiB each IP address (or -quota-tokens token) can download each day (UTC), after which it gets a 429, or a 413 for a file larger than what's left. 0 for no limit")
+	quotaTokensFile := flags.String("quota-tokens", "", "TOML file with [[token]] tables (name, token, requests, mib) for clients which send 'Authorization: Bearer <token>' or ?token=, and have their own quotas instead of their IP address's")
+	inboxDir := flags.String("inbox-dir", "", "folder to save files uploaded by authenticated users with PUT /-/inbox/<name> into. They're only served if it's in -folder")
+	inboxMaxSize := flags.Int64("inbox-max-size", 100, "the largest file (in MiB) which can be uploaded to -inbox-dir")
+	pasteDir := flags.String("paste-dir", "", "directory in -folder (or starting with a -mount name, e.g. notes/pastes) to save the body of POST /-/paste requests from authenticated users into, as a file named after the time, e.g. 2026-10-15-034916.txt")
+	pasteMaxSize := flags.Int64("paste-max-size", 1, "the largest paste (in MiB) which can be saved to -paste-dir")
+	archiveCacheDir := flags.String("archive-cache-dir", "", "folder to build /-/mirror.tar.gz into and serve it from until a file changes, so it has a Content-Length and interrupted downloads can be resumed with a Range request, instead of streaming it")
+	archiveUID := flags.Int("archive-uid", 0, "uid the files in .tar.gz archives (/-/mirror.tar.gz, bundle archives) are owned by, e.g. 1000 so they're owned by that user instead of root when extracted as root")
+	archiveGID := flags.Int("archive-gid", 0, "like -archive-uid, the gid the files in .tar.gz archives are owned by")
+	maxBandwidth := flags.Int64("max-bandwidth", 0, "the most KiB per second sent to every client together (e.g. 2048 for 2 MiB/s), so large downloads can't saturate the uplink. 0 for no limit")
+	maxBandwidthPerConn := flags.Int64("max-bandwidth-per-conn", 0, "the most KiB per second sent on each connection, 0 for no limit")
+	mirrorRateLimit := flags.Duration("mirror-rate-limit", 0, "minimum time between downloads of /-/mirror.tar.gz from the same IP address (e.g. 1h), 0 to disable")
+	paranoid := flags.String("paranoid", "", fmt.Sprintf("at startup, look for world-writable files/directories, symlinks pointing outside of the folder and files which look like secrets (e.g. id_rsa, .env), and log them. One of: %s (refuse to start if anything was found)", strings.Join(paranoidModes[:], ", ")))
+	oidcIssuer := flags.String("oidc-issuer", "", "URL of an OpenID Connect provider (e.g. https://accounts.google.com) users can log in with at /-/login, as an alternative to -auth-file")
+	oidcClientID := flags.String("oidc-client-id", "", "client ID registered with -oidc-issuer, with <-canonical-url>/-/oidc/callback as the redirect URI")
+	oidcClientSecretFile := flags.String("oidc-client-secret-file", "", "file with the client secret for -oidc-client-id. If not passed, logs in as a public client")
+	oidcScopes := flags.String("oidc-scopes", "openid email profile", "space separated scopes to request from -oidc-issuer")
+	oidcUserClaim := flags.String("oidc-user-claim", "email", "claim in the ID token used as the name of the user")
+	oidcRulesFile := flags.String("oidc-rules", "", "TOML file with [[rule]] tables (path, claim, values), which make files matching the path private, only readable by users where the claim (e.g. groups) has one of the values")
+	authHeader := flags.String("auth-header", "", "header (e.g. X-Forwarded-User) set by a proxy in front of the server (e.g. oauth2-proxy, Authelia) to the user it authenticated, which is trusted like a user from -auth-file. Only trusted from -trusted-proxies")
+	trustedProxies := flags.String("trusted-proxies", strings.Join(defaultTrustedProxies[:], ","), "comma separated addresses/CIDRs of the proxies which can set -auth-header, and X-Forwarded-Proto for -https-redirect and the URLs the server links to")
+	httpsRedirect := flags.Bool("https-redirect", false, "redirect requests made over plain HTTP to HTTPS, for a TLS-terminating proxy in -trusted-proxies which sets X-Forwarded-Proto")
+	var httpsExemptFlags multiFlag
+	flags.Var(&httpsExemptFlags, "https-redirect-exempt", "a pattern (e.g. '-/healthz') for paths which are served over plain HTTP with -https-redirect, e.g. for a load balancer's health checks. Can be passed multiple times")
+	authExemptLocal := flags.Bool("auth-exempt-local", false, "let requests from loopback (127.0.0.0/8, ::1) read private files and authenticated endpoints (GET/HEAD) without authenticating, e.g. scripts on the same host. Requests forwarded by a proxy (with X-Forwarded-For or Forwarded) still have to")
+	authExemptCIDRs := flags.String("auth-exempt-cidrs", "", "like -auth-exempt-local, for comma separated addresses/CIDRs (e.g. 10.0.0.0/8)")
+	authFile := flags.String("auth-file", "", "file with a user:bcrypt-hash line for each user (e.g. from 'htpasswd -nB user') who can use authenticated endpoints like /-/purge")
+	analytics := flags.Bool("analytics", false, "count requests per day, and requests for each file, from each referrer and user agent, which users from -auth-file can view at /-/analytics")
+	analyticsFile := flags.String("analytics-file", "", "with -analytics, file to save the analytics to every minute (and load them from at startup), so they're kept across restarts")
+	changesInterval := flags.Duration("changes-interval", 0, "how often to list the files (e.g. 1h), to find which were added, removed or modified (by their size and modification time) for /-/changes?since=24h. 0 to disable")
+	changesFile := flags.String("changes-file", "", "with -changes-interval, file to save the changes to after the files are listed (and load them from at startup), so they're kept across restarts")
+	var privateFlags multiFlag
+	flags.Var(&privateFlags, "private", "a pattern (e.g. 'notes/journal/*') for files which can only be read by authenticated users (from -auth-file, -oidc-issuer or -auth-header), or with a signed URL from /-/sign. Can be passed multiple times")
+	var dynamicFlags multiFlag
+	flags.Var(&dynamicFlags, "dynamic", "a pattern (e.g. '*.cgi.sh') for executable files which are run when they're requested, responding with their stdout instead of their contents. Can be passed multiple times")
+	dynamicTimeout := flags.Duration("dynamic-timeout", 5*time.Second, "how long a -dynamic file can run for before it's killed")
+	virtualFilesFile := flags.String("virtual-files", "", "TOML file with [[file]] tables (path, command, ttl, interval) for files whose contents are the output of a command, e.g. status = uptime")
+	minisignKeyFile := flags.String("minisign-key", "", "PEM file with an Ed25519 private key (e.g. from 'openssl genpkey -algorithm ed25519') to sign files with for ?sig, in the minisign format. The public key is served at /-/pubkey")
+	signKeyFile := flags.String("sign-key-file", "", "file with the key URLs from /-/sign are signed with. If not passed, a key is generated at startup, so signed URLs stop working when the server restarts")
+	fallbackURL := flags.String("fallback-url", "", "URL of another subpath-serve instance (e.g. https://example.com/d) to try requests which don't match anything against")
+	fallbackMode := flags.String("fallback-mode", "proxy", fmt.Sprintf("how requests are sent to -fallback-url if it has a match, one of: %s", strings.Join(fallbackModes[:], ", ")))
+	qr := flags.Bool("qr", false, "at startup, print a QR code of the URL of the server on the LAN, e.g. to open it on a phone")
+	purgeURL := flags.String("purge-url", "", "CDN URL to POST to when /-/purge is called. {key} is replaced with each surrogate key (e.g. https://api.fastly.com/service/ID/purge/{key}), without it the keys are sent as a JSON body")
+	var purgeHeaderFlags multiFlag
+	flags.Var(&purgeHeaderFlags, "purge-header", "header to send with requests to -purge-url (e.g. 'Fastly-Key: token'), can be passed multiple times")
+	var userAgentRuleFlags multiFlag
+	flags.Var(&userAgentRuleFlags, "user-agent-rule", "an 'action pattern' rule for requests with a matching User-Agent (e.g. 'block *AhrefsBot*'), where action is one of: plain, dark, block. Can be passed multiple times")
+	userAgentRulesFile := flags.String("user-agent-rules", "", "file with a -user-agent-rule on each line")
+	bundlesFile := flags.String("bundles", "", "TOML file with a list of queries for each bundle (e.g. shell = [\"bashrc\", \"zshrc\"]), which are served at /-/bundle/<name>")
+	mimeTypesFile := flags.String("mimetypes-file", "", "file with a 'pattern type' line (e.g. '.conf text/plain', or '*.txt charset=iso-8859-1') for each kind of file whose plaintext responses have that Content-Type, used when a .mimetypes file in the root of the folder doesn't match")
+	transformsFile := flags.String("transforms", "", "TOML file with [[transform]] tables (path, strip-comments, vars, redact) which change plaintext responses for matching files, e.g. replacing {{hostname}} with ?hostname=")
+	templateRulesFile := flags.String("template-rules", "", "file with 'pattern template' lines, which render files matching the pattern (e.g. *.csv or text/markdown) with a builtin (code, prose, data) or custom template")
+	renderCacheSize := flags.Int64("render-cache-size", 0, "cache up to this many MiB of rendered ?dark pages for files in memory, until the file changes. 0 to disable")
+	maxFileSize := flags.Int64("max-file-size", 0, "respond with a 413 instead of files larger than this many MiB (e.g. accidental core dumps), 0 for no limit")
+	thumbnails := flags.Bool("thumbnails", false, "display thumbnails of images in ?dark listings, generated (and cached in memory) when they're requested")
+	lineNumbers := flags.Bool("line-numbers", false, "display line numbers next to files in ?dark pages by default (they can be toggled with ?ln and ?ln=0)")
+	absoluteTimes := flags.Bool("absolute-times", false, "display exact times (e.g. 2026-10-15 03:49:16 UTC) instead of how long ago it was (e.g. 3 days ago) for when files were modified in ?dark pages")
+	dashboard := flags.Bool("dashboard", false, "display the number of files, their total size, when they were last modified and the recently modified files above the ?dark index")
+	sortOrder := flags.String("sort", "bytes", "order of the files in listings, one of: bytes (by the bytes of their names, the order they're walked in), unicode (ignoring case and accents), natural (like unicode, with numbers compared by their value, so 2-bar.md is before 10-foo.md)")
+	dirSizes := flags.Duration("dir-sizes", 0, "display the total size of the files in each directory in -dirs-first listings and /-/api/tree, computed when they're requested and cached for this long (e.g. 5m). 0 to disable")
+	dirsFirst := flags.Bool("dirs-first", false, "in ?dark listings, list each directory (with the number of files in it) first, then the files directly in the directory")
+	notFoundFile := flags.String("not-found-file", "", "path of a markdown or HTML file in -folder (or starting with the -mount name) to respond with when nothing matches, instead of the default message (e.g. 404.md)")
+	wellKnownDir := flags.String("well-known-dir", "", "serve the files in this folder as-is at /.well-known/ (e.g. for ACME challenges, security.txt), separately from -folder")
+	canonicalURL := flags.String("canonical-url", "", "public URL of the server (e.g. https://example.com/d), which ?dark pages link to as their canonical URL, with OpenGraph and Twitter card tags so links to them unfurl in chat apps")
+	basePath := flags.String("base-path", "", "path the server is served under, if a reverse proxy serves it under a subpath (e.g. /d for example.com/d/), which generated links and redirects start with")
+	assetsDir := flags.String("assets-dir", "", "folder of files (e.g. CSS/JS) for templates, served at /-/assets/<name>.<hash>.<ext> with immutable cache headers. Templates link to them with {{ asset \"name\" }}")
+	noticeText := flags.String("notice", "", "a notice (e.g. 'Maintenance on Sunday') displayed as a banner on every ?dark page, and as a '# ' comment line at the start of plaintext listings")
+	noticeFile := flags.String("notice-file", "", "file with a -notice, read again for every page so it can be changed (or removed, by deleting the file) without restarting. Takes precedence over -notice")
+	redactSecrets := flags.Bool("redact-secrets", false, "replace secrets (AWS keys, private key blocks, bearer tokens, GitHub/Slack tokens) in text files with [redacted] when they're served or searched, and log the files they're in at startup")
+	debug := flags.Bool("debug", false, "include the full error (and everything it wraps) and a stack trace in 500 pages, instead of only the request ID. They can include paths on the server")
+	dev := flags.Bool("dev", false, "for working on a custom -template: parse it (and -template-rules) again on every request, and disable caching of rendered pages and responses")
+	templateFile := flags.String("template", "", "path to a html/template file to render ?dark pages with, instead of the default dark theme")
 	// print repo in help text
-	flag.Usage = func() {
+	flags.Usage = func() {
 		fmt.Fprintln(os.Stderr, "usage: subpath-serve [FLAG...]\nFor instructions, see https://github.com/seanbreckenridge/subpath-serve")
 		fmt.Fprintln(os.Stderr, "")
-		flag.PrintDefaults()
+		flags.PrintDefaults()
 	}
 	// parse flags
-	flag.Parse()
-	// make sure path is valid
-	fileInfo, err := os.Stat(*serveFolder)
-	if err != nil {
-		log.Fatalf("Error: Folder to serve files from, '%s' does not exist\n", *serveFolder)
+	flags.Parse(args)
+	validEngine := false
+	for _, engine := range walkEngines {
+		if *walkEngine == engine {
+			validEngine = true
+		}
 	}
-	if !fileInfo.IsDir() {
-		log.Fatalf("Error: Path '%s' is not a directory", *serveFolder)
+	if !validEngine {
+		log.Fatalf("Error: Unknown walk engine '%s', expected one of: %s\n", *walkEngine, strings.Join(walkEngines[:], ", "))
 	}
-	return &config{
-		port:        *port,
-		serveFolder: *serveFolder,
-		repoPrefix:  strings.TrimSpace(*repoPrefix),
+	validFormat := false
+	for _, format := range logFormats {
+		if *logFormat == format {
+			validFormat = true
+		}
 	}
-}
-
-func setupTemplate() *template.Template {
-	tmpl, err := template.New("dark").Parse(`<!DOCTYPE html>
-<html lang="en">
-<head><meta charset="utf-8"><style>
-html, body {
-         margin: 0px;
-         padding: 0px;
-         border: 0px;
-         width: 100%;
-         min-height: 100vh;
-         background-color: #111;
-         color: white;
-         font-family: "Courier", sans-serif;
-     }
-     main {
-         display: flex;
-         justify-content: center;
-     }
-     .container {
-         width: 90%;
-         margin: 2rem;
-     }
-     div#rounded {
-         background-color: #1d2330;
-         font-size: 120%;
-         margin: 1rem;
-         padding: 1rem;
-         border-radius: min(0.25rem, 15px);
-     }
-     .title {
-         display: flex;
-         flex-direction: row;
-         justify-content: flex-end;
-         width: 90%;
-         margin-left: auto;
-         margin-right: auto;
-     }
-     code {
-         white-space: pre-wrap; /* css-3 */
-         white-space: -moz-pre-wrap; /* Mozilla, since 1999 */
-         white-space: -pre-wrap; /* Opera 4-6 */
-         white-space: -o-pre-wrap; /* Opera 7 */
-         word-wrap: break-word; /* Internet Explorer 5.5+ */
-     }
-     p {
-         margin: 4px;
-     }
-     a {
-         color: #0779e4;
-     }
-     a:visited {
-         color: #4cbbb9;
-     }
-     a:hover {
-          color: #77d8d8;
-     }
-     a:active {
-         color: #eff3c6;
-     }
-     footer {
-         display: flex;
-         flex-direction: column;
-         justify-content: flex-start;
-         width: 80%;
-         margin-left: auto;
-         margin-right: auto;
-         padding-bottom: 1rem;
-     }
-     footer div {
-         padding-top: 0.5rem;
-         padding-bottom: 0.5rem;
-    }
-    </style>
-    <title>{{ .Title }}</title>
-</head>
-<body>
-    <main>
-        <div class="container">
-            <div class="title">
-                <a href="#" onclick="RawFile()">Raw</a>
-            </div>
-            <div id="rounded">
-{{ range $element := .PageLines }}
-<p><a href="./{{ $element }}?dark">{{ $element }}</a></p>
-{{ else }}<pre><code>{{ .PageContents }}</code></pre>{{ end }}
-            </div>
-        </div>
-    </main>
-
-    <footer>
-				{{ if .PrefixInfo  }}
-				<div>View on <a href="{{ .PrefixInfo.Url }}">{{ .PrefixInfo.Hostname }}</a></div>
-				{{ end }}
-        <div>Served with <a href="https://github.com/seanbreckenridge/subpath-serve">subpath-serve</a></div>
-    </footer>
-    <script>
-        function RawFile() {
-            window.location.href = window.location.href.substring(0, window.location.href.lastIndexOf("?"));
-        }
-    </script>
-</body>
-</html>
-`)
-	if err != nil {
-		panic(err)
+	if !validFormat {
+		log.Fatalf("Error: Unknown log format '%s', expected one of: %s\n", *logFormat, strings.Join(logFormats[:], ", "))
 	}
-	return tmpl
-}
-
-func capitalize(s string) string {
-	if len(s) == 0 {
-		return s
+	validParanoid := *paranoid == ""
+	for _, mode := range paranoidModes {
+		if *paranoid == mode {
+			validParanoid = true
+		}
 	}
-	return strings.ToUpper(s[:1]) + s[1:]
-}
+	if !validParanoid {
+		log.Fatalf("Error: Unknown -paranoid mode '%s', expected one of: %s\n", *paranoid, strings.Join(paranoidModes[:], ", "))
+	}
+	validSortOrder := false
+	for _, order := range sortOrders {
+		if *sortOrder == order {
+			validSortOrder = true
+		}
+	}
+	if !validSortOrder {
+		log.Fatalf("Error: Unknown -sort order '%s', expected one of: %s\n", *sortOrder, strings.Join(sortOrders[:], ", "))
+	}
+	validFallbackMode := false
+	for _, mode := range fallbackModes {
+		if *fallbackMode == mode {
+			validFallbackMode = true
+		}
+	}
+	if !validFallbackMode {
+		log.Fatalf("Error: Unknown -fallback-mode '%s', expected one of: %s\n", *fallbackMode, strings.Join(fallbackModes[:], ", "))
+	}
+	if *fallbackURL != "" {
+		if u, err := url.Parse(*fallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Error: -fallback-url '%s' is not an http(s) URL\n", *fallbackURL)
+		}
+	}
+	if *canonicalURL != "" {
+		if u, err := url.Parse(*canonicalURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Error: -canonical-url '%s' is not an http(s) URL\n", *canonicalURL)
+		}
+	}
+	sourceOpts := &sourceOptions{
+		walkEngine: *walkEngine,
+		cacheTTL:   *backendCacheTTL,
+		snapshot:   *snapshot,
 
-// generates the response for the "/" request
-func index() string {
-	var indexBuilder strings.Builder
-	err := filepath.Walk(".",
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			// if the filename matches any of the paths in the global ignorePaths
-			// skip the directory
-			for _, ignore := range ignorePaths {
-				if info.Name() == ignore {
-					return filepath.SkipDir
-				}
+		followSymlinks: *followSymlinks,
+	}
+	if *wellKnownDir != "" {
+		if info, err := os.Stat(*wellKnownDir); err != nil || !info.IsDir() {
+			log.Fatalf("Error: -well-known-dir '%s' is not a directory\n", *wellKnownDir)
+		}
+	}
+	if *archiveCacheDir != "" {
+		if err := os.MkdirAll(*archiveCacheDir, 0o755); err != nil {
+			log.Fatalf("Error: Could not create -archive-cache-dir: %s\n", err)
+		}
+	}
+	if *maxBandwidth < 0 || *maxBandwidthPerConn < 0 {
+		log.Fatalln("Error: -max-bandwidth and -max-bandwidth-per-conn can't be negative")
+	}
+	if *archiveUID < 0 || *archiveGID < 0 {
+		log.Fatalln("Error: -archive-uid and -archive-gid can't be negative")
+	}
+	if *inboxDir != "" {
+		if err := os.MkdirAll(*inboxDir, 0o755); err != nil {
+			log.Fatalf("Error: Could not create -inbox-dir: %s\n", err)
+		}
+	}
+	if *inboxMaxSize <= 0 {
+		log.Fatalln("Error: -inbox-max-size must be positive")
+	}
+	var authUsers users
+	if *authFile != "" {
+		var err error
+		if authUsers, err = loadUsers(*authFile); err != nil {
+			log.Fatalf("Error: %s\n", capitalize(err.Error()))
+		}
+	}
+	if *oidcIssuer != "" {
+		if u, err := url.Parse(*oidcIssuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Error: -oidc-issuer '%s' is not an http(s) URL\n", *oidcIssuer)
+		}
+		if *oidcClientID == "" {
+			log.Fatalln("Error: -oidc-issuer requires -oidc-client-id")
+		}
+		if !slices.Contains(strings.Fields(*oidcScopes), "openid") {
+			log.Fatalln("Error: -oidc-scopes must include openid")
+		}
+	} else {
+		flags.Visit(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, "oidc-") {
+				log.Fatalf("Error: -%s requires -oidc-issuer\n", f.Name)
 			}
-			if path != "." {
-				// if this is a file
-				if info.Mode().IsRegular() {
-					// else append to response string
-					indexBuilder.WriteString(path)
-					indexBuilder.WriteString("\n")
-				}
+		})
+	}
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	if *authHeader == "" && !*httpsRedirect {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "trusted-proxies" {
+				log.Fatalln("Error: -trusted-proxies requires -auth-header or -https-redirect")
 			}
-			return nil
 		})
+	}
+	if len(httpsExemptFlags) > 0 && !*httpsRedirect {
+		log.Fatalln("Error: -https-redirect-exempt requires -https-redirect")
+	}
+	httpsExempt, err := parseHTTPSExempt(httpsExemptFlags)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
 	}
-	return indexBuilder.String()
-}
-
-// returns nil if file could not be found
-// else, returns the contents of the file
-//
-// errors signify an application error (should be converted to 500)
-func find(query string) (*string, error) {
-	var foundPath *string
-	err := filepath.Walk(".",
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
+	authExempt, err := parsePrefixes("auth-exempt-cidrs", *authExemptCIDRs)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	if *authExemptLocal {
+		authExempt = append(authExempt, loopbackPrefixes[:]...)
+	}
+	canAuthenticate := authUsers != nil || *oidcIssuer != "" || *authHeader != ""
+	if len(privateFlags) > 0 && !canAuthenticate {
+		log.Fatalln("Error: -private requires -auth-file, -oidc-issuer or -auth-header")
+	}
+	if *analytics && !canAuthenticate {
+		log.Fatalln("Error: -analytics requires -auth-file, -oidc-issuer or -auth-header")
+	}
+	if *analyticsFile != "" && !*analytics {
+		log.Fatalln("Error: -analytics-file requires -analytics")
+	}
+	if *changesInterval < 0 {
+		log.Fatalln("Error: -changes-interval can't be negative")
+	}
+	if *changesFile != "" && *changesInterval == 0 {
+		log.Fatalln("Error: -changes-file requires -changes-interval")
+	}
+	purgeHeaders := make(http.Header)
+	for _, header := range purgeHeaderFlags {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			log.Fatalf("Error: Invalid -purge-header '%s', expected 'Name: value'\n", header)
+		}
+		purgeHeaders.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	// the ignore rules are used to walk the mounts, which -snapshot does
+	// when they're created
+	rules, err := loadIgnoreRules(ignoreFlags, *ignoreFile)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	ignores.Store(rules)
+	var mounts []*mount
+	if len(mountFlags) > 0 {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "folder" || f.Name == "backend" || f.Name == "git-http-prefix" || f.Name == "git-raw-prefix" {
+				log.Fatalf("Error: -%s can't be used with -mount\n", f.Name)
 			}
-			// if the filename matches any of the paths in the global ignorePaths
-			// skip the directory
-			for _, ignore := range ignorePaths {
-				if info.Name() == ignore {
-					return filepath.SkipDir
+		})
+		mounts = parseMounts(mountFlags, mountPrefixFlags, mountRawPrefixFlags, sourceOpts)
+	} else {
+		if len(mountPrefixFlags) > 0 {
+			log.Fatalln("Error: -mount-git-http-prefix requires -mount")
+		}
+		if len(mountRawPrefixFlags) > 0 {
+			log.Fatalln("Error: -mount-git-raw-prefix requires -mount")
+		}
+		location := *serveFolder
+		if *backend != "" {
+			flags.Visit(func(f *flag.Flag) {
+				if f.Name == "folder" {
+					log.Fatalln("Error: -folder can't be used with -backend")
 				}
+			})
+			location = *backend
+		}
+		mounts = []*mount{newMount("", location, strings.TrimSpace(*repoPrefix), strings.TrimSpace(*rawPrefix), sourceOpts)}
+	}
+	if *watch && !*snapshot {
+		log.Fatalln("Error: -watch requires -snapshot")
+	}
+	*pasteDir = strings.Trim(*pasteDir, "/")
+	if *pasteDir != "" {
+		if strings.Trim(path.Clean("/"+*pasteDir), "/") != *pasteDir {
+			log.Fatalf("Error: -paste-dir '%s' has to be a path in the folder, without . or .. segments\n", *pasteDir)
+		}
+		_, local, dir, err := pasteFolder(mounts, *pasteDir)
+		if err != nil {
+			log.Fatalf("Error: %s\n", capitalize(err.Error()))
+		}
+		if err := os.MkdirAll(local.fullPath(dir), 0o755); err != nil {
+			log.Fatalf("Error: Could not create -paste-dir: %s\n", err)
+		}
+	}
+	if *pasteMaxSize <= 0 {
+		log.Fatalln("Error: -paste-max-size must be positive")
+	}
+	if *dynamicTimeout <= 0 {
+		log.Fatalln("Error: -dynamic-timeout must be positive")
+	}
+	if *quotaRequests < 0 || *quotaBytes < 0 {
+		log.Fatalln("Error: -quota-requests and -quota-bytes can't be negative")
+	}
+	if *watchInterval <= 0 {
+		log.Fatalln("Error: -watch-interval must be positive")
+	}
+	if *gitRefs {
+		for _, m := range mounts {
+			local, ok := m.src.(*localSource)
+			if !ok {
+				log.Fatalf("Error: -git-refs can only be used with local folders, not %s\n", m.src)
 			}
-			if path != "." {
-				// if this is a file
-				if info.Mode().IsRegular() {
-					// the query matches this path
-					if strings.HasSuffix(path, query) &&
-						query[strings.LastIndex(query, "/")+1:] == info.Name() {
-						// if this matches the suffix of the file
-						// return the filename
-						foundPath = &path
-						// return error from os.Walk func to exit once we find file
-						return errors.New("early exit os.Walk")
-					}
-				}
+			refs, err := newGitRefs(local.folder)
+			if err != nil {
+				log.Fatalf("Error: %s\n", capitalize(err.Error()))
 			}
-			return nil
-		})
-	// if os.walk error and not the early exit
-	// return the error, since some os error actually happened
-	if err != nil && err.Error() != "early exit os.Walk" {
-		return nil, err
+			m.refs = refs
+		}
+	}
+	// links to files in submodules go to the submodule's repository
+	if err := readSubmodules(mounts); err != nil {
+		log.Printf("Warning: %s, links to files in them go to the folder's repository\n", capitalize(err.Error()))
 	}
+	if *notFoundFile != "" {
+		if m, _ := matchMount(mounts, strings.Trim(*notFoundFile, "/")); m == nil {
+			log.Fatalf("Error: -not-found-file '%s' isn't in a -mount\n", *notFoundFile)
+		}
+	}
+	return &config{
+		port:              *port,
+		h2c:               *h2c,
+		mounts:            mounts,
+		walkEngine:        *walkEngine,
+		requestTimeout:    *requestTimeout,
+		logFormat:         *logFormat,
+		qr:                *qr,
+		templateFile:      *templateFile,
+		assetsDir:         *assetsDir,
+		basePath:          strings.TrimRight("/"+strings.Trim(*basePath, "/"), "/"),
+		templateRulesFile: *templateRulesFile,
+		bundlesFile:       *bundlesFile,
+		transformsFile:    *transformsFile,
+		mimeTypesFile:     *mimeTypesFile,
+		ignoreFlags:       ignoreFlags,
+		ignoreFile:        *ignoreFile,
+		authFile:          *authFile,
+		thumbnails:        *thumbnails,
+		renderCacheSize:   *renderCacheSize,
+		dev:               *dev,
+		debug:             *debug,
+		redactSecrets:     *redactSecrets,
+		notice:            *noticeText,
+		noticeFile:        *noticeFile,
+		maxFileSize:       *maxFileSize << 20,
+		dirsFirst:         *dirsFirst,
+		dirSizes:          *dirSizes,
+		sortOrder:         *sortOrder,
+		dashboard:         *dashboard,
+		absoluteTimes:     *absoluteTimes,
+		lineNumbers:       *lineNumbers,
+		notFoundFile:      strings.Trim(*notFoundFile, "/"),
+		wellKnownDir:      *wellKnownDir,
+		users:             authUsers,
+		purgeURL:          *purgeURL,
+		purgeHeaders:      purgeHeaders,
 
-	// return the filepath/nil if no file was found
-	return foundPath, nil
+		slowRequestThreshold: *slowRequestThreshold,
+		mirrorRateLimit:      *mirrorRateLimit,
+		maxBandwidth:         *maxBandwidth * 1024,
+		maxBandwidthPerConn:  *maxBandwidthPerConn * 1024,
+		archiveCacheDir:      *archiveCacheDir,
+		archiveUID:           *archiveUID,
+		archiveGID:           *archiveGID,
+		inboxDir:             *inboxDir,
+		inboxMaxSize:         *inboxMaxSize << 20,
+		pasteDir:             *pasteDir,
+		pasteMaxSize:         *pasteMaxSize << 20,
+		watch:                *watch,
+		paranoid:             *paranoid,
+		analytics:            *analytics,
+		analyticsFile:        *analyticsFile,
+		changesInterval:      *changesInterval,
+		changesFile:          *changesFile,
+		watchInterval:        *watchInterval,
+		privateFlags:         privateFlags,
+		signKeyFile:          *signKeyFile,
+		dynamicFlags:         dynamicFlags,
+		dynamicTimeout:       *dynamicTimeout,
+		virtualFilesFile:     *virtualFilesFile,
+		minisignKeyFile:      *minisignKeyFile,
+		fallbackURL:          strings.TrimRight(*fallbackURL, "/"),
+		fallbackMode:         *fallbackMode,
+		canonicalURL:         strings.TrimRight(*canonicalURL, "/"),
+		oidcIssuer:           strings.TrimRight(*oidcIssuer, "/"),
+		oidcClientID:         *oidcClientID,
+		oidcClientSecretFile: *oidcClientSecretFile,
+		oidcScopes:           strings.Join(strings.Fields(*oidcScopes), " "),
+		oidcUserClaim:        *oidcUserClaim,
+		oidcRulesFile:        *oidcRulesFile,
+		quotaRequests:        *quotaRequests,
+		quotaBytes:           *quotaBytes << 20,
+		quotaTokensFile:      *quotaTokensFile,
+		authHeader:           http.CanonicalHeaderKey(strings.TrimSpace(*authHeader)),
+		trustedProxies:       proxies,
+		authExempt:           authExempt,
+		httpsRedirect:        *httpsRedirect,
+		httpsExempt:          httpsExempt,
+		maxArchives:          *maxArchives,
+		maxSearches:          *maxSearches,
+		userAgentRuleFlags:   userAgentRuleFlags,
+		userAgentRulesFile:   *userAgentRulesFile,
+	}
 }
 
-// is dark req specifies whether or not this is a
-// plain text response or rendered dark response
-func render(w *http.ResponseWriter, info *PageInfo, tmpl *template.Template, isDarkReq bool) {
-	if isDarkReq {
-		tmpl.Execute(*w, *info)
-	} else {
-		fmt.Fprintf(*w, "%s", (*info).PageContents)
+func capitalize(s string) string {
+	if len(s) == 0 {
+		return s
 	}
+	return strings.ToUpper(s[:1]) + s[1:]
 }
 
 // https://github.com/seanbreckenridge/dotfiles/blob/master -> github.com
@@ -283,82 +733,239 @@ func getDomainName(httpPrefixUrl string) string {
 	return name
 }
 
+// whether the value of a query parameter turns it off, e.g. ?dark=0
+func isFalse(value string) bool {
+	return value == "0" || strings.EqualFold(value, "false")
+}
+
 func hasQueryParam(queryValues url.Values, queryParam string) bool {
 	_, ok := queryValues[queryParam]
 	return ok
 }
 
-func main() {
-	config := parseFlags()
-	tmpl := setupTemplate()
-	err := os.Chdir(config.serveFolder)
+// sets up the server for the config (and the globals it sets, e.g.
+// -absolute-times), and starts what runs in the background (e.g. -watch). It's the http.Handler for every request: the
+// server cleans the paths of requests itself, http.ServeMux would redirect
+// to the cleaned path without -base-path
+func newServer(config *config) (*server, error) {
+	assets.basePath = config.basePath
+	debugErrors = config.debug
+	absoluteTimes = config.absoluteTimes
+	notice.text, notice.file = config.notice, config.noticeFile
+	if config.assetsDir != "" {
+		if err := assets.addDir(config.assetsDir); err != nil {
+			return nil, fmt.Errorf("could not read -assets-dir: %w", err)
+		}
+	}
+	tmpl, err := setupTemplate(config.templateFile)
+	if err != nil {
+		return nil, err
+	}
+	lineNumbersTmpl, err := withVariant(tmpl, "code")
+	if err != nil {
+		return nil, err
+	}
+	var thumbnails *byteCache
+	if config.thumbnails {
+		thumbnails = newByteCache(thumbnailCacheSize)
+	}
+	var renderCache *byteCache
+	// with -dev, pages would be rendered with the template they were cached with
+	if config.renderCacheSize > 0 && !config.dev {
+		renderCache = newByteCache(config.renderCacheSize << 20)
+	}
+	var templateRules []*templateRule
+	if config.templateRulesFile != "" {
+		templateRules, err = loadTemplateRules(config.templateRulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	userAgentRules, err := loadUserAgentRules(config.userAgentRuleFlags, config.userAgentRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	var bundles map[string][]string
+	if config.bundlesFile != "" {
+		bundles, err = loadBundles(config.bundlesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var mimeTypes []*mimeTypeRule
+	if config.mimeTypesFile != "" {
+		if mimeTypes, err = loadMimeTypes(config.mimeTypesFile); err != nil {
+			return nil, err
+		}
+	}
+	var transforms []*transformRule
+	if config.transformsFile != "" {
+		transforms, err = loadTransforms(config.transformsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.paranoid != "" {
+		audit(config)
+	}
+	if config.redactSecrets {
+		// reads every file, so the server doesn't wait for it to start
+		go reportSecrets(config)
+	}
+	if config.watch {
+		for _, m := range config.mounts {
+			if snap, ok := m.src.(*snapshotSource); ok {
+				go snap.watch(config.watchInterval)
+			}
+		}
+	}
+	private, err := newPrivateFiles(config.privateFlags, config.signKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var oidc *oidcProvider
+	if config.oidcIssuer != "" {
+		oidc, err = newOIDCProvider(config, private.key)
+		if err != nil {
+			return nil, err
+		}
+		// files matching a rule are private, the rules decide who can read them
+		private.patterns = append(private.patterns, oidc.patterns()...)
+	}
+	dynamic, err := newDynamicFiles(config.dynamicFlags, config.dynamicTimeout)
 	if err != nil {
-		panic(err)
-	}
-	httpPrefixName := capitalize(getDomainName(config.repoPrefix))
-	// global handler
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		queryParams := r.URL.Query()
-		isDark := hasQueryParam(queryParams, "dark")
-		isRedirect := hasQueryParam(queryParams, "redirect")
-		r.URL.Query()
-		if r.URL.Path == "/" {
-			// split the content into multiple lines if this is a html response
-			// so that links can be added nicely
-			pageContents := index()
-			pageLines := []string{}
-			if isDark {
-				pageLines = strings.Split(strings.Trim(pageContents, "\n"), "\n")
+		return nil, err
+	}
+	var virtual map[string]*virtualFile
+	if config.virtualFilesFile != "" {
+		virtual, err = loadVirtualFiles(config.virtualFilesFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range virtual {
+			if v.Interval > 0 {
+				go v.refreshEvery(config.dynamicTimeout)
 			}
-			render(&w, &PageInfo{
-				PageContents: pageContents,
-				Title:        "Index",
-				PageLines:    pageLines,
-			}, tmpl, isDark)
-		} else {
-			// search for the file
-			foundPath, err := find(strings.TrimRight(r.URL.Path[1:], "/"))
-			// if there was an OS error
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				render(&w, &PageInfo{
-					PageContents: err.Error(),
-					Title:        "Server Error",
-				}, tmpl, isDark)
-			} else {
-				// if the file couldn't be found
-				if foundPath == nil {
-					w.WriteHeader(http.StatusNotFound)
-					render(&w, &PageInfo{
-						PageContents: fmt.Sprintf("Could not find a match for %s\n", r.URL.Path[1:]),
-						Title:        "404 - Not Found",
-					}, tmpl, isDark)
-					return
-				}
-				// file was found
-				url := fmt.Sprintf("%s/%s", config.repoPrefix, *foundPath)
-				// if were meant to redirect, early return
-				if isRedirect {
-					if config.repoPrefix != "" {
-						http.Redirect(w, r, url, 302)
-						return
-					}
-					fmt.Fprintf(os.Stderr, "Warning: tried to redirect to %s but no repoPrefix set\n", url)
-				}
-				// if the file was found, return the read file
-				data, _ := os.ReadFile(*foundPath)
-				w.Header().Set("X-Filepath", *foundPath)
-				render(&w, &PageInfo{
-					PageContents: string(data),
-					Title:        *foundPath,
-					PrefixInfo: &HttpPrefix{
-						Url:      url,
-						Hostname: httpPrefixName,
-					},
-				}, tmpl, isDark)
+		}
+	}
+	var analytics *analytics
+	if config.analytics {
+		analytics, err = newAnalytics(config.analyticsFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.analyticsFile != "" {
+			go analytics.saveEvery()
+		}
+	}
+	var changes *changeLog
+	if config.changesInterval > 0 {
+		changes, err = newChangeLog(config.changesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var minisignKey *minisignKey
+	if config.minisignKeyFile != "" {
+		minisignKey, err = loadMinisignKey(config.minisignKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var quotas *quotas
+	if config.quotaRequests > 0 || config.quotaBytes > 0 || config.quotaTokensFile != "" {
+		quotas, err = newQuotas(config.quotaRequests, config.quotaBytes, config.quotaTokensFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var dirSizes *dirSizes
+	if config.dirSizes > 0 {
+		dirSizes = newDirSizes(config.dirSizes)
+	}
+	var mirrorLimiter *mirrorLimiter
+	if config.mirrorRateLimit > 0 {
+		mirrorLimiter = newMirrorLimiter(config.mirrorRateLimit)
+	}
+	return &server{
+		config:        config,
+		tmpl:          tmpl,
+		templateRules: templateRules,
+		thumbnails:    thumbnails,
+		renderCache:   renderCache,
+		bundles:       bundles,
+		mirrorLimiter: mirrorLimiter,
+		private:       private,
+		analytics:     analytics,
+		minisignKey:   minisignKey,
+		archives:      newHeavyLimiter(config.maxArchives),
+		searches:      newHeavyLimiter(config.maxSearches),
+		dynamic:       dynamic,
+		virtual:       virtual,
+		oidc:          oidc,
+		quotas:        quotas,
+		dirSizes:      dirSizes,
+		transforms:    transforms,
+		mimeTypes:     mimeTypes,
+		lookups:       &lookupGroup{},
+		hashes:        &hashCache{},
+		reloaded:      &liveServer{},
+		requests:      newRequestRates(),
+		changes:       changes,
+
+		lineNumbersTmpl: lineNumbersTmpl,
+		userAgentRules:  userAgentRules,
+	}, nil
+}
+
+func main() {
+	// subpath-serve mount <mountpoint> -url <instance>, see fusemount.go
+	if len(os.Args) > 1 && os.Args[1] == "mount" {
+		runMount(os.Args[2:])
+		return
+	}
+	config := parseFlags(flag.CommandLine, os.Args[1:])
+	handler, err := newServer(config)
+	if err != nil {
+		log.Fatalf("Error: %s\n", capitalize(err.Error()))
+	}
+	listener, upgradePipe, err := listen(config.port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logStartup(config, []string{listener.Addr().String()})
+	httpServer := &http.Server{Handler: handler}
+	if config.h2c {
+		// clients which know the server supports HTTP/2 (prior knowledge)
+		// can use it, HTTP/1.1 requests are still accepted
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		httpServer.Protocols = &protocols
+	}
+	// on SIGUSR2, hand the listener to a new process, and exit once
+	// the requests in progress are done
+	upgraded := make(chan struct{})
+	go upgradeOnSignal(httpServer, listener, func() {
+		// the new process loads the analytics when it starts
+		if handler.analytics != nil && config.analyticsFile != "" {
+			if err := handler.analytics.save(); err != nil {
+				log.Printf("Could not save analytics to %s: %s\n", config.analyticsFile, err)
 			}
 		}
-	})
-	log.Printf("subpath-serve serving %s on port %d\n", config.serveFolder, config.port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.port), nil))
+	}, upgraded)
+	go reloadOnSignal(handler)
+	if handler.changes != nil {
+		go handler.recordChangesEvery(config.changesInterval)
+	}
+	upgradeReady(upgradePipe)
+	// the listener which is handed to a new process is the one underneath
+	served := listener
+	if config.maxBandwidth > 0 || config.maxBandwidthPerConn > 0 {
+		served = newThrottledListener(listener, config.maxBandwidth, config.maxBandwidthPerConn)
+	}
+	if err := httpServer.Serve(served); !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err)
+	}
+	<-upgraded
 }
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// functions which can be used in templates
+var templateFuncs = template.FuncMap{
+	"humanizeBytes": humanizeBytes,
+	"relativeTime":  relativeTime,
+	"timestamp":     timestamp,
+	"splitPath":     splitPath,
+	"markdown":      renderMarkdown,
+	"numberLines":   numberLines,
+	"table":         parseTable,
+	"isImage":       isImage,
+	"asset":         assetURL,
+	"notice":        func() string { return notice.get() },
+}
+
+// builtin templates, which replace how the contents of
+// a file are displayed in the dark template
+var templateVariants = map[string]string{
+	// line numbers, each linking to #L<number>
+	"code": `{{ define "contents" }}<table class="code">
+{{ range numberLines .PageContents }}<tr id="L{{ .Number }}"><td class="line-number"><a href="#L{{ .Number }}">{{ .Number }}</a></td><td><code>{{ .Text }}</code></td></tr>
+{{ end }}</table>{{ end }}`,
+	// wrapped, at a readable width
+	"prose": `{{ define "contents" }}<div class="prose"><pre><code>{{ .PageContents }}</code></pre></div>{{ end }}`,
+	// CSV/TSV files as a table, the first row as the header
+	"data": `{{ define "contents" }}{{ with table .File.Name .PageContents }}<table class="data">
+{{ range $i, $row := . }}<tr>{{ range $row }}{{ if $i }}<td>{{ . }}</td>{{ else }}<th>{{ . }}</th>{{ end }}{{ end }}</tr>
+{{ end }}</table>{{ else }}<pre><code>{{ .PageContents }}</code></pre>{{ end }}{{ end }}`,
+}
+
+// parses the template used for ?dark pages, from templateFile
+// if it isn't empty, else the default dark theme
+func setupTemplate(templateFile string) (*template.Template, error) {
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read template: %w", err)
+		}
+		tmpl, err := template.New("dark").Funcs(templateFuncs).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse template '%s': %w", templateFile, err)
+		}
+		return tmpl, nil
+	}
+	return builtinTemplate("dark")
+}
+
+// parses the dark template, with the contents replaced
+// by the variant with that name, if it's not 'dark'
+func builtinTemplate(name string) (*template.Template, error) {
+	tmpl := template.Must(template.New("dark").Funcs(templateFuncs).Parse(darkTemplate))
+	if name == "dark" {
+		return tmpl, nil
+	}
+	return withVariant(tmpl, name)
+}
+
+// a copy of tmpl, with the contents replaced by the variant with that
+// name. Custom templates have to use {{ block "contents" . }} for that
+func withVariant(tmpl *template.Template, name string) (*template.Template, error) {
+	variant, ok := templateVariants[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template '%s'", name)
+	}
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.Parse(variant)
+}
+
+const darkTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8">
+    <link rel="stylesheet" href="{{ asset "dark.css" }}">
+    <script src="{{ asset "quickopen.js" }}" defer></script>
+    <script src="{{ asset "copylink.js" }}" defer></script>
+    <title>{{ .Title }}</title>
+    {{ if .CanonicalUrl }}<link rel="canonical" href="{{ .CanonicalUrl }}">
+    <meta property="og:url" content="{{ .CanonicalUrl }}">
+    <meta property="og:type" content="{{ if .File }}article{{ else }}website{{ end }}">
+    <meta property="og:title" content="{{ .Title }}">
+    <meta name="twitter:card" content="summary">
+    <meta name="twitter:title" content="{{ .Title }}">{{ with .Description }}
+    <meta name="description" content="{{ . }}">
+    <meta property="og:description" content="{{ . }}">
+    <meta name="twitter:description" content="{{ . }}">{{ end }}{{ end }}
+</head>
+<body>
+    <main>
+        <div class="container">
+            {{ with notice }}<div class="notice">{{ . }}</div>{{ end }}
+            <div class="title">
+                {{ if .LineNumbersUrl }}<a href="{{ .LineNumbersUrl }}">{{ if .LineNumbers }}Hide{{ else }}Show{{ end }} line numbers</a>{{ end }}
+                {{ if .RawUrl }}<a href="{{ .RawUrl }}">Raw</a>{{ end }}
+                {{ if .PdfUrl }}<a href="{{ .PdfUrl }}">PDF</a>{{ end }}
+                {{ if .ShareUrl }}<a class="copy-link" href="{{ .ShareUrl }}">Copy link</a>{{ end }}
+            </div>
+            {{ if .Breadcrumbs }}<nav class="breadcrumbs">
+                {{ range $i, $crumb := .Breadcrumbs }}{{ if $i }}<span class="separator">/</span>{{ end }}<a href="{{ $crumb.Url }}?dark">{{ $crumb.Name }}</a>{{ end }}{{ if .File }}<span class="separator">/</span>{{ .File.Name }}{{ if .File.LinkTarget }} <span class="symlink">&rarr; {{ .File.LinkTarget }}</span>{{ end }}{{ if not .File.ModTime.IsZero }} <span class="count">{{ timestamp .File.ModTime .Lang }}</span>{{ end }}{{ end }}
+            </nav>{{ end }}
+            {{ with .RunWith }}<div class="run-with">Run with <code>{{ . }}</code></div>{{ end }}
+            {{ if .IsListing }}<form class="search" method="get">
+                <input type="text" name="q" placeholder="Search this directory" value="{{ .Search }}">
+                <input type="hidden" name="dark">
+            </form>{{ end }}
+            {{ with .NotFound }}<form class="search not-found" method="get" action="{{ .IndexUrl }}">
+                <input type="text" name="q" placeholder="Search for the file" aria-label="Search for the file" value="{{ .Query }}" autocomplete="off">
+                <input type="hidden" name="dark">
+                <ul class="completions"></ul>
+            </form>{{ end }}
+            {{ with .Dashboard }}<div class="dashboard">
+                <p>{{ .Files }} file{{ if ne .Files 1 }}s{{ end }}, {{ humanizeBytes .Size }}{{ if not .LastModified.IsZero }}, last modified {{ timestamp .LastModified $.Lang }}{{ end }}{{ if not .SnapshotAt.IsZero }}, snapshot taken {{ timestamp .SnapshotAt $.Lang }}{{ end }}</p>
+                {{ if .Recent }}<h2>Recently modified</h2>
+                {{ range .Recent }}<p><a href="./{{ .Path }}?dark">{{ .Path }}</a> <span class="count">{{ timestamp .ModTime $.Lang }}</span></p>
+                {{ end }}{{ end }}
+            </div>{{ end }}
+            {{ if .TOC }}<div class="with-toc">
+            <nav class="toc">
+                {{ range .TOC }}<a class="level-{{ .Level }}" href="#{{ .ID }}">{{ .Text }}</a>
+                {{ end }}
+            </nav>{{ end }}
+            <div id="rounded">
+{{ range .Dirs }}<p><a href="./{{ .Name }}/?dark">{{ .Name }}/</a> <span class="count">{{ .Files }} file{{ if ne .Files 1 }}s{{ end }}{{ with .Size }}, {{ humanizeBytes . }}{{ end }}</span></p>
+{{ end }}{{ range $element := .PageLines }}
+<p>{{ if and $.Thumbnails (isImage $element) }}<a href="./{{ $element }}?dark"><img class="thumbnail" src="./{{ $element }}?thumbnail" alt="" loading="lazy"></a>{{ end }}<a href="./{{ $element }}?dark">{{ $element }}</a>{{ with index $.Symlinks $element }} <span class="symlink">&rarr; {{ . }}</span>{{ end }}</p>
+{{ else }}{{ if not .Dirs }}{{ if .Frontmatter }}<table class="frontmatter">
+{{ range $field := .Frontmatter }}<tr><td class="key">{{ $field.Key }}</td><td>{{ $field.Value }}</td></tr>
+{{ end }}</table>{{ end }}{{ if .Rendered }}{{ .Rendered }}{{ else }}{{ block "contents" . }}<pre><code>{{ .PageContents }}</code></pre>{{ end }}{{ end }}{{ end }}{{ end }}
+            </div>{{ if .TOC }}
+            </div>{{ end }}
+        </div>
+    </main>
+
+    <footer>
+				{{ if .PrefixInfo  }}
+				<div>View on <a href="{{ .PrefixInfo.Url }}">{{ .PrefixInfo.Hostname }}</a></div>
+				{{ end }}
+        <div>Served with <a href="https://github.com/seanbreckenridge/subpath-serve">subpath-serve</a></div>
+    </footer>
+</body>
+</html>
+`
+
+// the stylesheet for the dark template, served at /-/assets/dark.<hash>.css
+const darkCSS = `html, body {
+    margin: 0px;
+    padding: 0px;
+    border: 0px;
+    width: 100%;
+    min-height: 100vh;
+    background-color: #111;
+    color: white;
+    font-family: "Courier", sans-serif;
+}
+main {
+    display: flex;
+    justify-content: center;
+}
+.container {
+    width: 90%;
+    margin: 2rem;
+}
+div#rounded {
+    background-color: #1d2330;
+    font-size: 120%;
+    margin: 1rem;
+    padding: 1rem;
+    border-radius: min(0.25rem, 15px);
+}
+.title {
+    display: flex;
+    flex-direction: row;
+    justify-content: flex-end;
+    gap: 1rem;
+    width: 90%;
+    margin-left: auto;
+    margin-right: auto;
+}
+code {
+    white-space: pre-wrap; /* css-3 */
+    white-space: -moz-pre-wrap; /* Mozilla, since 1999 */
+    white-space: -pre-wrap; /* Opera 4-6 */
+    white-space: -o-pre-wrap; /* Opera 7 */
+    word-wrap: break-word; /* Internet Explorer 5.5+ */
+}
+p {
+    margin: 4px;
+}
+nav.breadcrumbs {
+    margin: 0 1rem;
+}
+nav.breadcrumbs span.separator {
+    color: #2e3648;
+    padding: 0 0.25rem;
+}
+form.search {
+    margin: 0 1rem;
+}
+form.not-found {
+    margin: 1rem;
+}
+form.not-found ul {
+    list-style: none;
+    margin: 0.5rem 0 0 0;
+    padding: 0;
+}
+form.not-found li a {
+    display: block;
+    padding: 2px 4px;
+}
+div.run-with {
+    margin: 0.5rem 1rem 0 1rem;
+    color: #8a93a8;
+}
+div.run-with code {
+    color: white;
+    user-select: all;
+}
+div.notice {
+    background-color: #3a2f12;
+    color: #f2d675;
+    margin: 1rem;
+    padding: 0.75rem 1rem;
+    border-radius: min(0.25rem, 15px);
+    white-space: pre-wrap;
+}
+div.dashboard {
+    background-color: #1d2330;
+    margin: 1rem;
+    padding: 1rem;
+    border-radius: min(0.25rem, 15px);
+}
+div.dashboard h2 {
+    font-size: 100%;
+    color: #4cbbb9;
+    margin: 1rem 4px 0.5rem 4px;
+}
+form.search input[type="text"] {
+    background-color: #1d2330;
+    color: white;
+    border: 1px solid #2e3648;
+    font-family: inherit;
+    padding: 4px;
+    width: min(30rem, 100%);
+}
+table.frontmatter {
+    border-collapse: collapse;
+    margin-bottom: 1rem;
+}
+table.frontmatter td {
+    border-bottom: 1px solid #2e3648;
+    padding: 4px 1rem 4px 4px;
+    vertical-align: top;
+    white-space: pre-wrap;
+}
+table.frontmatter td.key {
+    color: #4cbbb9;
+}
+table.code {
+    border-collapse: collapse;
+}
+table.code td {
+    padding: 0 0.5rem;
+    vertical-align: top;
+    white-space: pre-wrap;
+}
+table.code td.line-number {
+    text-align: right;
+    user-select: none;
+}
+table.code td.line-number a {
+    color: #4a5573;
+    text-decoration: none;
+}
+table.search tr.file td {
+    padding-top: 0.75rem;
+}
+table.search tr.context code {
+    color: #8a93a8;
+}
+table.search mark {
+    background-color: #5c4d1f;
+    color: inherit;
+    border-radius: 2px;
+}
+table.code tr:target {
+    background-color: #2e3648;
+}
+div.with-toc {
+    display: flex;
+    flex-direction: row-reverse;
+    align-items: flex-start;
+}
+div.with-toc div#rounded {
+    flex: 1;
+    min-width: 0;
+}
+nav.toc {
+    position: sticky;
+    top: 1rem;
+    width: 16rem;
+    flex-shrink: 0;
+    max-height: calc(100vh - 2rem);
+    overflow-y: auto;
+    margin: 1rem 1rem 1rem 0;
+}
+nav.toc a {
+    display: block;
+    padding: 2px 0;
+}
+nav.toc a.level-2 { padding-left: 1rem; }
+nav.toc a.level-3 { padding-left: 2rem; }
+nav.toc a.level-4, nav.toc a.level-5, nav.toc a.level-6 { padding-left: 3rem; }
+@media (max-width: 50rem) {
+    div.with-toc {
+        display: block;
+    }
+    nav.toc {
+        position: static;
+        width: auto;
+        margin: 1rem;
+    }
+}
+div.prose {
+    max-width: 80ch;
+    margin: 0 auto;
+}
+div.prose code {
+    font-family: Georgia, serif;
+    line-height: 1.5;
+}
+table.data {
+    border-collapse: collapse;
+}
+table.data th, table.data td {
+    border: 1px solid #2e3648;
+    padding: 4px 0.5rem;
+    text-align: left;
+}
+table.data th {
+    color: #4cbbb9;
+}
+div.pretty details > *:not(summary) {
+    margin-left: 1.5rem;
+}
+div.pretty summary {
+    cursor: pointer;
+}
+div.pretty span.key {
+    color: #4cbbb9;
+}
+div.pretty span.count {
+    color: #4a5573;
+}
+div.languages div.bar {
+    display: flex;
+    height: 0.75rem;
+    border-radius: min(0.25rem, 15px);
+    overflow: hidden;
+    margin-bottom: 1rem;
+}
+div.languages span.color {
+    display: inline-block;
+    width: 0.75rem;
+    height: 0.75rem;
+    border-radius: 50%;
+    margin-right: 0.5rem;
+}
+div.languages td {
+    padding: 2px 1rem 2px 4px;
+}
+div.languages td.count {
+    color: #4a5573;
+}
+div.notebook div.cell {
+    margin-bottom: 1rem;
+}
+div.notebook div.code pre {
+    background-color: #111;
+    padding: 0.5rem;
+    margin: 0;
+}
+div.notebook span.prompt {
+    color: #4a5573;
+}
+div.notebook pre.output {
+    white-space: pre-wrap;
+    padding: 0 0.5rem;
+}
+div.notebook pre.error {
+    color: #e06c75;
+}
+div.notebook img {
+    max-width: 100%;
+    background-color: white;
+}
+span.count, span.symlink {
+    color: #4a5573;
+}
+img.thumbnail {
+    display: block;
+    max-width: 200px;
+    max-height: 200px;
+    margin: 0.5rem 0 0.25rem 0;
+}
+div.quick-open {
+    position: fixed;
+    inset: 0;
+    background-color: rgba(0, 0, 0, 0.6);
+    display: flex;
+    justify-content: center;
+    align-items: flex-start;
+}
+div.quick-open[hidden] {
+    display: none;
+}
+div.quick-open div.box {
+    background-color: #1d2330;
+    border: 1px solid #2e3648;
+    margin-top: 15vh;
+    padding: 0.5rem;
+    width: min(40rem, 90%);
+}
+div.quick-open input {
+    background-color: #111;
+    color: white;
+    border: 1px solid #2e3648;
+    font-family: inherit;
+    font-size: 120%;
+    padding: 4px;
+    width: 100%;
+    box-sizing: border-box;
+}
+div.quick-open ul {
+    list-style: none;
+    margin: 0.5rem 0 0 0;
+    padding: 0;
+}
+div.quick-open li a {
+    display: block;
+    padding: 2px 4px;
+    text-decoration: none;
+}
+div.quick-open li.selected a {
+    background-color: #2e3648;
+}
+a {
+    color: #0779e4;
+}
+a:visited {
+    color: #4cbbb9;
+}
+a:hover {
+    color: #77d8d8;
+}
+a:active {
+    color: #eff3c6;
+}
+footer {
+    display: flex;
+    flex-direction: column;
+    justify-content: flex-start;
+    width: 80%;
+    margin-left: auto;
+    margin-right: auto;
+    padding-bottom: 1rem;
+}
+footer div {
+    padding-top: 0.5rem;
+    padding-bottom: 0.5rem;
+}
+@media print {
+    html, body {
+        background-color: white;
+        color: black;
+        min-height: 0;
+    }
+    .title, nav.breadcrumbs, nav.toc, form.search, div.notice, div.run-with, div.quick-open, footer {
+        display: none;
+    }
+    .container {
+        width: 100%;
+        margin: 0;
+    }
+    div#rounded, div.dashboard {
+        background-color: transparent;
+        font-size: 100%;
+        margin: 0;
+        padding: 0;
+    }
+    div.with-toc {
+        display: block;
+    }
+    a, a:visited {
+        color: black;
+    }
+    table.code td.line-number a, span.count, span.symlink {
+        color: #666;
+    }
+    table.frontmatter td, table.data th, table.data td {
+        border-color: #999;
+    }
+    table.frontmatter td.key, table.data th, div.pretty span.key {
+        color: black;
+        font-weight: bold;
+    }
+    h1, h2, h3, h4, h5, h6 {
+        break-after: avoid;
+    }
+    pre, tr, img {
+        break-inside: avoid;
+    }
+}
+`
+
+// the script for the quick-open overlay in the dark template, served at
+// /-/assets/quickopen.<hash>.js. Pressing / or t opens it, and it
+// searches for files by name with /-/complete. It also lists completions
+// under the search box on 404 pages
+const quickOpenJS = `(function () {
+    // this is served from <base>/-/assets/, so /-/complete is under the same base
+    const base = document.currentScript.src.replace(/\/-\/assets\/[^/]*$/, "");
+    let overlay, input, list, timer;
+    let links = [], selected = 0, latest = 0;
+
+    function url(path) {
+        return base + "/" + path.split("/").map(encodeURIComponent).join("/") + "?dark";
+    }
+
+    function show(completions) {
+        list.replaceChildren();
+        links = completions.map(function (c, i) {
+            const li = document.createElement("li");
+            const a = document.createElement("a");
+            a.href = url(c.path);
+            a.textContent = c.path;
+            li.appendChild(a);
+            list.appendChild(li);
+            return a;
+        });
+        select(0);
+    }
+
+    function select(i) {
+        if (links.length === 0) {
+            return;
+        }
+        selected = (i + links.length) % links.length;
+        links.forEach(function (a, j) {
+            a.parentElement.classList.toggle("selected", j === selected);
+        });
+        links[selected].scrollIntoView({ block: "nearest" });
+    }
+
+    function search() {
+        const q = input.value.trim();
+        const id = ++latest;
+        if (q === "") {
+            show([]);
+            return;
+        }
+        fetch(base + "/-/complete?json&q=" + encodeURIComponent(q))
+            .then(function (resp) { return resp.json(); })
+            .then(function (completions) {
+                // ignore responses for queries which have since changed
+                if (id === latest) {
+                    show(completions);
+                }
+            })
+            .catch(function () {});
+    }
+
+    function close() {
+        overlay.hidden = true;
+    }
+
+    function open() {
+        if (!overlay) {
+            overlay = document.createElement("div");
+            overlay.className = "quick-open";
+            const box = document.createElement("div");
+            box.className = "box";
+            input = document.createElement("input");
+            input.type = "text";
+            input.placeholder = "Go to file";
+            input.setAttribute("aria-label", "Go to file");
+            list = document.createElement("ul");
+            box.append(input, list);
+            overlay.appendChild(box);
+            document.body.appendChild(overlay);
+            overlay.addEventListener("click", function (e) {
+                if (e.target === overlay) {
+                    close();
+                }
+            });
+            input.addEventListener("input", function () {
+                clearTimeout(timer);
+                timer = setTimeout(search, 100);
+            });
+            input.addEventListener("keydown", function (e) {
+                if (e.key === "Escape") {
+                    close();
+                } else if (e.key === "ArrowDown") {
+                    select(selected + 1);
+                } else if (e.key === "ArrowUp") {
+                    select(selected - 1);
+                } else if (e.key === "Enter" && links.length > 0) {
+                    window.location.href = links[selected].href;
+                } else {
+                    return;
+                }
+                e.preventDefault();
+            });
+        }
+        overlay.hidden = false;
+        input.value = "";
+        show([]);
+        input.focus();
+    }
+
+    // the search box on 404 pages lists completions as it's typed in too,
+    // starting with the name which didn't match
+    const notFound = document.querySelector("form.not-found");
+    if (notFound) {
+        const box = notFound.querySelector("input[name=q]");
+        const completions = notFound.querySelector("ul.completions");
+        let boxTimer, boxLatest = 0;
+        const suggest = function () {
+            const q = box.value.trim();
+            const id = ++boxLatest;
+            if (q === "") {
+                completions.replaceChildren();
+                return;
+            }
+            fetch(base + "/-/complete?json&q=" + encodeURIComponent(q))
+                .then(function (resp) { return resp.json(); })
+                .then(function (found) {
+                    if (id !== boxLatest) {
+                        return;
+                    }
+                    completions.replaceChildren.apply(completions, found.map(function (c) {
+                        const li = document.createElement("li");
+                        const a = document.createElement("a");
+                        a.href = url(c.path);
+                        a.textContent = c.path;
+                        li.appendChild(a);
+                        return li;
+                    }));
+                })
+                .catch(function () {});
+        };
+        box.addEventListener("input", function () {
+            clearTimeout(boxTimer);
+            boxTimer = setTimeout(suggest, 100);
+        });
+        suggest();
+    }
+
+    document.addEventListener("keydown", function (e) {
+        if ((e.key !== "/" && e.key !== "t") || e.ctrlKey || e.metaKey || e.altKey) {
+            return;
+        }
+        // don't take over typing in the search box
+        const target = e.target;
+        if (target.isContentEditable || ["INPUT", "TEXTAREA", "SELECT"].includes(target.tagName)) {
+            return;
+        }
+        e.preventDefault();
+        open();
+    });
+})();
+`
+
+// the script for the "Copy link" control in the dark template, served at
+// /-/assets/copylink.<hash>.js. Clicking it copies the link instead of
+// following it, unless the clipboard isn't available (e.g. over plain
+// HTTP), where it's a link to the page without any query params
+const copyLinkJS = `document.addEventListener("click", function (e) {
+    const a = e.target.closest("a.copy-link");
+    if (!a || !navigator.clipboard || e.ctrlKey || e.metaKey || e.shiftKey) {
+        return;
+    }
+    e.preventDefault();
+    navigator.clipboard.writeText(a.href).then(function () {
+        a.textContent = "Copied";
+        setTimeout(function () { a.textContent = "Copy link"; }, 2000);
+    });
+});
+`
+
+// 1536 -> 1.5 KiB
+func humanizeBytes(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < 5 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[unit-1])
+}
+
+// config/nvim/init.lua -> [config nvim init.lua]
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+}
+
+// a line of a file, numbered from 1
+type numberedLine struct {
+	Number int
+	Text   string
+}
+
+func numberLines(contents string) []numberedLine {
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	numbered := make([]numberedLine, len(lines))
+	for i, line := range lines {
+		numbered[i] = numberedLine{Number: i + 1, Text: line}
+	}
+	return numbered
+}
+
+// parses the contents of a CSV (or TSV, if name ends with .tsv) file
+// into rows, returns nil if it isn't valid
+func parseTable(name string, contents string) [][]string {
+	reader := csv.NewReader(strings.NewReader(contents))
+	if strings.HasSuffix(strings.ToLower(name), ".tsv") {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil
+	}
+	return rows
+}
+
+// renders markdown to HTML. Raw HTML in the markdown is omitted
+func renderMarkdown(markdown string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(markdown), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// is dark req specifies whether or not this is a
+// plain text response or rendered dark response
+func render(w *http.ResponseWriter, info *PageInfo, tmpl *template.Template, isDarkReq bool) {
+	if isDarkReq {
+		info.Theme = "dark"
+		tmpl.Execute(*w, *info)
+	} else {
+		fmt.Fprintf(*w, "%s", (*info).PageContents)
+	}
+}
+
+// renders a ?dark page into a buffer, e.g. to cache it
+func renderHTML(info *PageInfo, tmpl *template.Template) ([]byte, error) {
+	info.Theme = "dark"
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, *info)
+	return buf.Bytes(), err
+}
+
+// responds to an error which happened while handling a request, with
+// the status code from errorStatus
+//
+// if the client disconnected, there's nothing to respond to
+// if the request timed out, or the file kept changing while
+// it was being read, responds with a 503
+//
+// other errors are logged with the ID of the request. The message could
+// include paths on the server, so the page only includes the request ID
+// to find it by, unless running with -debug
+func renderError(w *http.ResponseWriter, err error, tmpl *template.Template, isDarkReq bool) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	status := errorStatus(err)
+	info := &PageInfo{}
+	var tooLarge *fileTooLargeError
+	switch {
+	case errors.Is(err, errFileChanged):
+		(*w).Header().Set("Retry-After", "1")
+		info.PageContents, info.Title = "File is being modified, try again\n", "503 - Service Unavailable"
+	case errors.Is(err, errBusy):
+		(*w).Header().Set("Retry-After", "5")
+		info.PageContents, info.Title = capitalize(err.Error())+"\n", "503 - Service Unavailable"
+	case errors.As(err, &tooLarge):
+		info.PageContents, info.Title = tooLarge.Error()+"\n", "413 - Content Too Large"
+	case errors.Is(err, context.DeadlineExceeded):
+		info.PageContents, info.Title = "Request timed out\n", "503 - Timed Out"
+	case status != http.StatusInternalServerError:
+		info.PageContents, info.Title = capitalize(err.Error())+"\n", fmt.Sprintf("%d - %s", status, http.StatusText(status))
+	default:
+		requestID := (*w).Header().Get("X-Request-Id")
+		log.Printf("Error responding to request %s: %s\n", requestID, err)
+		info.PageContents = fmt.Sprintf("Something went wrong while responding to this request.\n\nRequest ID: %s\n", requestID)
+		info.Title = "500 - Internal Server Error"
+		if debugErrors {
+			info.PageContents += "\nError:\n  " + strings.ReplaceAll(strings.Join(errorChain(err), "\n"), "\n", "\n  ") + "\n\nStack trace:\n" + string(debug.Stack())
+		}
+	}
+	(*w).WriteHeader(status)
+	render(w, info, tmpl, isDarkReq)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// renders files which match pattern with tmpl
+//
+// the pattern is either a glob matched against the name of
+// the file (e.g. *.csv), or a MIME type (e.g. text/markdown or text/*)
+type templateRule struct {
+	pattern string
+	tmpl    *template.Template
+}
+
+func (t *templateRule) matches(name string) bool {
+	if !strings.Contains(t.pattern, "/") {
+		matched, _ := path.Match(t.pattern, name)
+		return matched
+	}
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+	if mimeType == "" {
+		return false
+	}
+	if strings.HasSuffix(t.pattern, "/*") {
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(t.pattern, "*"))
+	}
+	return mimeType == t.pattern
+}
+
+// parses the -template-rules file, which has a 'pattern template' line for each rule
+//
+// the template is the name of a builtin template (code, prose, data or dark),
+// or the path to a template file, relative to the rules file
+func loadTemplateRules(rulesFile string) ([]*templateRule, error) {
+	f, err := os.Open(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read template rules: %w", err)
+	}
+	defer f.Close()
+	rules := []*templateRule{}
+	// templates are only parsed once, if multiple rules use them
+	parsed := make(map[string]*template.Template)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %d in template rules '%s', expected 'pattern template'", lineNo, rulesFile)
+		}
+		pattern, name := fields[0], fields[1]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s' on line %d in template rules '%s'", pattern, lineNo, rulesFile)
+		}
+		tmpl, ok := parsed[name]
+		if !ok {
+			if _, isBuiltin := templateVariants[name]; isBuiltin || name == "dark" {
+				tmpl, err = builtinTemplate(name)
+			} else {
+				templateFile := name
+				if !filepath.IsAbs(templateFile) {
+					templateFile = filepath.Join(filepath.Dir(rulesFile), templateFile)
+				}
+				tmpl, err = setupTemplate(templateFile)
+			}
+			if err != nil {
+				return nil, err
+			}
+			parsed[name] = tmpl
+		}
+		rules = append(rules, &templateRule{pattern: pattern, tmpl: tmpl})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read template rules: %w", err)
+	}
+	return rules, nil
+}
+
+// the template to render the file at p with, the first
+// rule which matches it, else the default template
+//
+// also returns a name for the template (the pattern of the rule, or
+// 'default'), so pages rendered with different templates can be told apart
+func (s *server) templateFor(p string) (*template.Template, string) {
+	name := path.Base(p)
+	for _, rule := range s.templateRules {
+		if rule.matches(name) {
+			return rule.tmpl, "rule:" + rule.pattern
+		}
+	}
+	return s.tmpl, "default"
+}
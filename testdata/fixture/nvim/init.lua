@@ -0,0 +1,2 @@
+require("plugins")
+vim.opt.number = true
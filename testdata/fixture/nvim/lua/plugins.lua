@@ -0,0 +1,3 @@
+return {
+  "tpope/vim-fugitive",
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+# backs up the notes <folder> & photos
+rsync -a ~/notes /mnt/backup/
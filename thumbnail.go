@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maximum width/height of a thumbnail
+	thumbnailSize = 200
+	// total size of the thumbnails which are cached
+	thumbnailCacheSize = 32 << 20
+	// images larger than this aren't decoded
+	maxThumbnailPixels = 50_000_000
+)
+
+// extensions of images which thumbnails can be generated for
+var imageExts = [...]string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+func isImage(p string) bool {
+	ext := strings.ToLower(path.Ext(p))
+	for _, imageExt := range imageExts {
+		if ext == imageExt {
+			return true
+		}
+	}
+	return false
+}
+
+// generated thumbnails are cached, keyed by the path and the
+// metadata of the image, so they're regenerated when the image changes
+func thumbnailKey(p string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", p, size, modTime.UnixNano())
+}
+
+// scales the image down to fit in thumbnailSize, as a JPEG
+//
+// transparent images are drawn over the background color of the dark template
+func generateThumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is too large to generate a thumbnail (%dx%d)", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width > height {
+			width, height = thumbnailSize, max(1, height*thumbnailSize/width)
+		} else {
+			width, height = max(1, width*thumbnailSize/height), thumbnailSize
+		}
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumbnail, thumbnail.Bounds(), image.NewUniform(color.RGBA{0x1d, 0x23, 0x30, 0xff}), image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// responds with a thumbnail of the image at p
+func (s *server) serveThumbnail(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, m *mount, reqPath string, p string) {
+	if !isImage(p) {
+		s.serveNoThumbnail(w, reqPath, opts)
+		return
+	}
+	if err := s.checkPrivate(ctx, m, p); err != nil {
+		s.serveUnauthorized(w, r, opts)
+		return
+	}
+	info, err := statFile(ctx, m.src, p)
+	if errors.Is(err, fs.ErrPermission) {
+		s.serveForbidden(w, reqPath, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	key := thumbnailKey(mountPath(m, p), info.Size(), info.ModTime())
+	thumbnail := s.thumbnails.get(key)
+	if thumbnail == nil {
+		done := timingsFrom(ctx).track("read")
+		data, _, err := s.readFileInfo(ctx, m, p)
+		done()
+		if err != nil {
+			renderError(&w, err, s.tmpl, opts.isDark)
+			return
+		}
+		defer timingsFrom(ctx).track("render")()
+		if thumbnail, err = generateThumbnail(data); err != nil {
+			log.Printf("Could not generate a thumbnail for %s: %s\n", p, err)
+			s.serveNoThumbnail(w, reqPath, opts)
+			return
+		}
+		s.thumbnails.put(key, thumbnail)
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Filepath", p)
+	if s.isPrivate(m, p) {
+		setPrivateCache(w)
+	}
+	w.Write(thumbnail)
+}
+
+func (s *server) serveNoThumbnail(w http.ResponseWriter, reqPath string, opts *requestOptions) {
+	w.WriteHeader(http.StatusNotFound)
+	render(&w, &PageInfo{
+		PageContents: fmt.Sprintf("Could not generate a thumbnail for %s\n", reqPath),
+		Title:        "404 - Not Found",
+	}, s.tmpl, opts.isDark)
+}
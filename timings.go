@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type timingsKey struct{}
+
+// records how long each phase (walk, read, render) of handling a request took
+//
+// methods are safe to call on a nil *requestTimings, so
+// code which doesn't have one in its context doesn't have to check
+type requestTimings struct {
+	start  time.Time
+	order  []string
+	phases map[string]time.Duration
+}
+
+func newRequestTimings() *requestTimings {
+	return &requestTimings{
+		start:  time.Now(),
+		phases: make(map[string]time.Duration),
+	}
+}
+
+func withTimings(ctx context.Context, t *requestTimings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+func timingsFrom(ctx context.Context) *requestTimings {
+	t, _ := ctx.Value(timingsKey{}).(*requestTimings)
+	return t
+}
+
+// starts timing a phase, call the returned function once it's done
+//
+// if a phase is tracked more than once, the durations are added together
+func (t *requestTimings) track(phase string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		if _, ok := t.phases[phase]; !ok {
+			t.order = append(t.order, phase)
+		}
+		t.phases[phase] += time.Since(start)
+	}
+}
+
+// logs the request if it took longer than the threshold
+func (t *requestTimings) logIfSlow(r *http.Request, threshold time.Duration, logFormat string) {
+	if t == nil || threshold <= 0 {
+		return
+	}
+	took := time.Since(t.start)
+	if took < threshold {
+		return
+	}
+	if logFormat == "json" {
+		phases := make(map[string]string)
+		for phase, d := range t.phases {
+			phases[phase] = d.String()
+		}
+		if err := json.NewEncoder(os.Stderr).Encode(map[string]interface{}{
+			"time":     time.Now().Format(time.RFC3339),
+			"msg":      "slow request",
+			"method":   r.Method,
+			"path":     r.URL.RequestURI(),
+			"duration": took.String(),
+			"phases":   phases,
+		}); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	var breakdown []string
+	for _, phase := range t.order {
+		breakdown = append(breakdown, fmt.Sprintf("%s=%s", phase, t.phases[phase]))
+	}
+	log.Printf("Slow request: %s %s took %s (%s)\n", r.Method, r.URL.RequestURI(), took, strings.Join(breakdown, " "))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// renders markdown, with an id for each heading (e.g. #installation) so
+// they can be linked to, from the table of contents for ?toc
+var markdownRenderer = goldmark.New(goldmark.WithParserOptions(parser.WithAutoHeadingID()))
+
+// whether the file is a markdown file, which ?toc renders
+func isMarkdown(p string) bool {
+	ext := strings.ToLower(path.Ext(p))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// a heading in rendered markdown, listed in the table of contents
+type Heading struct {
+	// 1 to 6, for <h1> to <h6>
+	Level int
+	Text  string
+	// the id of the heading, which it can be linked to with #<id>
+	ID string
+}
+
+// renders markdown to HTML like renderMarkdown, and returns each heading in it
+func renderMarkdownTOC(markdown string) (template.HTML, []Heading, error) {
+	source := []byte(markdown)
+	doc := markdownRenderer.Parser().Parse(text.NewReader(source))
+	headings := []Heading{}
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		id, _ := heading.AttributeString("id")
+		if id, ok := id.([]byte); ok {
+			headings = append(headings, Heading{Level: heading.Level, Text: string(heading.Text(source)), ID: string(id)})
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := markdownRenderer.Renderer().Render(&buf, source, doc); err != nil {
+		return "", nil, err
+	}
+	return template.HTML(buf.String()), headings, nil
+}
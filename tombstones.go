@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// file in the root of a mount, with a 'path: message' line for each
+// file which was removed on purpose
+const tombstonesFile = ".tombstones"
+
+// a file (or directory, if the path ends with a '/') which was removed,
+// and why, or the URL of its replacement
+type tombstone struct {
+	path    string
+	dir     bool
+	message string
+}
+
+// whether the message is the URL of a replacement
+func (t *tombstone) replacement() bool {
+	return strings.HasPrefix(t.message, "https://") || strings.HasPrefix(t.message, "http://")
+}
+
+// parses the .tombstones file in the root of src, returns nil if there isn't one
+//
+// invalid lines are logged and skipped, like in the .moved file
+func readTombstones(ctx context.Context, src source) ([]tombstone, error) {
+	data, err := readFile(ctx, src, tombstonesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tombstones := []tombstone{}
+	for lineNo, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, message, ok := strings.Cut(line, ":")
+		p, message = strings.TrimSpace(p), strings.TrimSpace(message)
+		dir := strings.HasSuffix(p, "/")
+		p = strings.Trim(p, "/")
+		if !ok || p == "" || message == "" {
+			log.Printf("Invalid line %d in %s in %s, expected 'path: message'\n", lineNo+1, tombstonesFile, src)
+			continue
+		}
+		tombstones = append(tombstones, tombstone{path: p, dir: dir, message: message})
+	}
+	return tombstones, nil
+}
+
+// the tombstone for query (which didn't match any file), if it
+// matches the path of a removed file, or is under a removed directory
+func findTombstone(tombstones []tombstone, query string) *tombstone {
+	for i := range tombstones {
+		if _, ok := matchesOldPath(tombstones[i].path, tombstones[i].dir, query); ok {
+			return &tombstones[i]
+		}
+	}
+	return nil
+}
+
+// responds with a 410 if the query (which didn't match any file in the
+// mount) matches a line in the .tombstones file, returns false if it doesn't
+func (s *server) serveTombstone(ctx context.Context, w http.ResponseWriter, opts *requestOptions, m *mount, query string) (bool, error) {
+	tombstones, err := readTombstones(ctx, m.src)
+	if err != nil || len(tombstones) == 0 {
+		return false, err
+	}
+	t := findTombstone(tombstones, query)
+	if t == nil {
+		return false, nil
+	}
+	message := t.message
+	if t.replacement() {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="alternate"`, t.message))
+		message = fmt.Sprintf("%s was removed, it was replaced by %s", mountPath(m, t.path), t.message)
+	}
+	w.WriteHeader(http.StatusGone)
+	render(&w, &PageInfo{
+		PageContents: message + "\n",
+		Title:        "410 - Gone",
+	}, s.tmpl, opts.isDark)
+	return true, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// a file/directory which was considered for a query, for ?trace
+type traceCandidate struct {
+	// path of the file/directory, starting with the mount name, like
+	// /-/raw/<path>. Directories end with a /
+	Path string `json:"path"`
+	// one of: matched, matched later (it also matches, but the walk found
+	// the match first), suffix differs (the name matches, but the path
+	// doesn't end with the query), ignored, mount file (e.g. .moved)
+	Outcome string `json:"outcome"`
+	// the -ignore pattern (or .git) which ignores it, if it's ignored
+	IgnoredBy string `json:"ignored_by,omitempty"`
+}
+
+// how a request was resolved, for ?trace and /-/api/trace
+type resolutionTrace struct {
+	// the path of the request, cleaned
+	Request string `json:"request"`
+	// the mount it's in (empty for -folder), and the query which is
+	// matched against the files in it
+	Mount string `json:"mount"`
+	Query string `json:"query"`
+	// one of: index, virtual, directory, file, moved, tombstone,
+	// fallback, not found
+	Strategy string `json:"strategy"`
+	// the path of the file/directory it resolved to (or was moved or
+	// removed from), or the URL it falls back to. Empty if it's private
+	// and the request can't read it, see Private
+	Result  string `json:"result"`
+	Private bool   `json:"private"`
+	// every file (or directory, for a query ending with a /) with the name
+	// at the end of the query, in the order they were walked. Private files
+	// the request can't read aren't included
+	Candidates []traceCandidate `json:"candidates"`
+	// ignored files/directories are only included in the candidates for
+	// authenticated requests, since they're never listed otherwise
+	IncludesIgnored bool   `json:"includes_ignored"`
+	WalkTime        string `json:"walk_time"`
+	Duration        string `json:"duration"`
+}
+
+// walks every file (or directory) in the mount like find (or findDir),
+// but doesn't stop at the first match, recording each one which has the
+// name at the end of the query. Returns the path which matched, and
+// whether it's private and the request can't read it
+func (s *server) traceCandidates(ctx context.Context, trace *resolutionTrace, m *mount, query string, dirs bool) (string, bool, error) {
+	name := query[strings.LastIndex(query, "/")+1:]
+	rules := ignores.Load()
+	found, private := "", false
+	err := m.src.walk(ctx, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != "." && errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		isCandidate := d.Name() == name && (dirs && d.IsDir() || !dirs && d.Type().IsRegular())
+		display := mountPath(m, p)
+		if d.IsDir() {
+			display += "/"
+		}
+		if pattern := rules.ignoredBy(p, d.IsDir()); pattern != nil {
+			// ignored directories aren't walked, so nothing in them is matched
+			if trace.IncludesIgnored && (isCandidate || d.IsDir()) {
+				trace.Candidates = append(trace.Candidates, traceCandidate{Path: display, Outcome: "ignored", IgnoredBy: pattern.line})
+			}
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !isCandidate {
+			return nil
+		}
+		if isMountFile(p) {
+			trace.Candidates = append(trace.Candidates, traceCandidate{Path: display, Outcome: "mount file"})
+			return nil
+		}
+		if !strings.HasSuffix(p, query) {
+			if dirs || s.checkPrivate(ctx, m, p) == nil {
+				trace.Candidates = append(trace.Candidates, traceCandidate{Path: display, Outcome: "suffix differs"})
+			}
+			return nil
+		}
+		if found != "" {
+			if dirs || s.checkPrivate(ctx, m, p) == nil {
+				trace.Candidates = append(trace.Candidates, traceCandidate{Path: display, Outcome: "matched later"})
+			}
+			return nil
+		}
+		found = p
+		// it still matches, but the response is a 401
+		if !dirs && s.checkPrivate(ctx, m, p) != nil {
+			private = true
+			return nil
+		}
+		trace.Candidates = append(trace.Candidates, traceCandidate{Path: display, Outcome: "matched"})
+		return nil
+	})
+	return found, private, err
+}
+
+// follows the same steps as serveQuery for the request path, recording
+// how it was resolved instead of responding with it
+func (s *server) traceQuery(ctx context.Context, r *http.Request, reqPath string) (*resolutionTrace, error) {
+	start := time.Now()
+	_, authenticated := s.authenticatedUser(r)
+	trace := &resolutionTrace{Request: reqPath, Candidates: []traceCandidate{}, IncludesIgnored: authenticated}
+	defer func() { trace.Duration = time.Since(start).String() }()
+	if _, ok := s.virtual[reqPath]; ok {
+		trace.Strategy, trace.Result = "virtual", reqPath
+		return trace, nil
+	}
+	m, query, err := resolveMount(ctx, s.config.mounts, reqPath)
+	if err != nil {
+		return nil, err
+	}
+	trace.Query = query
+	if m == nil {
+		if reqPath == "" {
+			trace.Strategy = "index"
+		} else {
+			s.traceNotFound(trace, reqPath)
+		}
+		return trace, nil
+	}
+	trace.Mount = m.name
+	if query == "" {
+		trace.Strategy = "index"
+		if m.name != "" {
+			trace.Result = m.name + "/"
+		}
+		return trace, nil
+	}
+	var walkTime time.Duration
+	// a request ending with a '/' lists the files in a matching directory,
+	// else it's the file matching it
+	if strings.HasSuffix(query, "/") {
+		walkStart := time.Now()
+		dirPath, _, err := s.traceCandidates(ctx, trace, m, strings.TrimRight(query, "/"), true)
+		walkTime += time.Since(walkStart)
+		if err != nil {
+			return nil, err
+		}
+		if dirPath != "" {
+			trace.Strategy, trace.Result, trace.WalkTime = "directory", mountPath(m, dirPath)+"/", walkTime.String()
+			return trace, nil
+		}
+	}
+	query = strings.TrimRight(query, "/")
+	walkStart := time.Now()
+	foundPath, private, err := s.traceCandidates(ctx, trace, m, query, false)
+	walkTime += time.Since(walkStart)
+	trace.WalkTime = walkTime.String()
+	if err != nil {
+		return nil, err
+	}
+	if foundPath != "" {
+		trace.Strategy, trace.Private = "file", private
+		if !private {
+			trace.Result = mountPath(m, foundPath)
+		}
+		return trace, nil
+	}
+	target, moved, err := s.movedURL(ctx, r, m, query)
+	if err != nil {
+		return nil, err
+	}
+	if moved {
+		trace.Strategy, trace.Result = "moved", target
+		return trace, nil
+	}
+	tombstones, err := readTombstones(ctx, m.src)
+	if err != nil {
+		return nil, err
+	}
+	if t := findTombstone(tombstones, query); t != nil {
+		trace.Strategy, trace.Result = "tombstone", mountPath(m, t.path)
+		return trace, nil
+	}
+	s.traceNotFound(trace, reqPath)
+	return trace, nil
+}
+
+// a request which didn't match anything is tried against -fallback-url,
+// if there is one
+func (s *server) traceNotFound(trace *resolutionTrace, reqPath string) {
+	trace.Strategy = "not found"
+	if s.config.fallbackURL != "" {
+		trace.Strategy, trace.Result = "fallback", s.config.fallbackURL+"/"+reqPath
+	}
+}
+
+// responds with how the request path would be resolved, as JSON: which
+// files were considered, which were ignored, which one matched and how
+// long it took. For ?trace, and /-/api/trace?q=<path>
+func (s *server) serveTrace(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, reqPath string) {
+	trace, err := s.traceQuery(ctx, r, reqPath)
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	// which files are included depends on how the request is authenticated
+	setPrivateCache(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trace)
+}
+
+// like ?trace, for the path in ?q=
+func (s *server) serveAPITrace(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	s.serveTrace(ctx, w, r, opts, cleanRequestPath(r.URL.Query().Get("q")))
+}
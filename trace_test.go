@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"folder1/a", "folder2/a", "folder3/b", "build/a"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer(t, dir, []string{"-folder", dir, "-ignore", "build/", "-auth-exempt-local"})
+	for _, tt := range []struct {
+		target     string
+		local      bool
+		strategy   string
+		result     string
+		candidates []traceCandidate
+	}{
+		{"/a?trace", false, "file", "folder1/a", []traceCandidate{
+			{Path: "folder1/a", Outcome: "matched"},
+			{Path: "folder2/a", Outcome: "matched later"},
+		}},
+		// ignored files are only included for authenticated requests
+		{"/a?trace", true, "file", "folder1/a", []traceCandidate{
+			{Path: "build/", Outcome: "ignored", IgnoredBy: "build/"},
+			{Path: "folder1/a", Outcome: "matched"},
+			{Path: "folder2/a", Outcome: "matched later"},
+		}},
+		{"/folder2/a?trace", false, "file", "folder2/a", []traceCandidate{
+			{Path: "folder1/a", Outcome: "suffix differs"},
+			{Path: "folder2/a", Outcome: "matched"},
+		}},
+		{"/-/api/trace?q=x/b", false, "not found", "", []traceCandidate{
+			{Path: "folder3/b", Outcome: "suffix differs"},
+		}},
+		{"/-/api/trace?q=folder3/", false, "directory", "folder3/", []traceCandidate{
+			{Path: "folder3/", Outcome: "matched"},
+		}},
+		{"/?trace", false, "index", "", []traceCandidate{}},
+	} {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.local {
+			r.RemoteAddr = "127.0.0.1:1234"
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		var trace resolutionTrace
+		if err := json.Unmarshal(w.Body.Bytes(), &trace); err != nil {
+			t.Fatalf("GET %s = %d %q: %s", tt.target, w.Code, w.Body.String(), err)
+		}
+		if trace.Strategy != tt.strategy || trace.Result != tt.result || !reflect.DeepEqual(trace.Candidates, tt.candidates) {
+			t.Errorf("GET %s (local %v) = %s %q %+v, expected %s %q %+v", tt.target, tt.local, trace.Strategy, trace.Result, trace.Candidates, tt.strategy, tt.result, tt.candidates)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// a rule from -transforms: plaintext responses for files matching the path
+// are changed by each transformer the rule sets, in the order they're
+// listed here
+type transformRule struct {
+	Path string `toml:"path"`
+	// removes lines which start with this (after any indentation), e.g. #,
+	// except a #! on the first line
+	StripComments string `toml:"strip-comments"`
+	// {{name}} is replaced with ?name= if it was passed, else the value here
+	Vars map[string]string `toml:"vars"`
+	// matches of these regular expressions are replaced with [redacted]
+	Redact []string `toml:"redact"`
+
+	pattern *regexp.Regexp
+	redact  []*regexp.Regexp
+}
+
+// {{name}}, or {{ name }}
+var transformVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// parses the [[transform]] tables in the TOML file
+func loadTransforms(transformsFile string) ([]*transformRule, error) {
+	var file struct {
+		Rules []*transformRule `toml:"transform"`
+	}
+	if _, err := toml.DecodeFile(transformsFile, &file); err != nil {
+		return nil, fmt.Errorf("could not parse transforms '%s': %w", transformsFile, err)
+	}
+	for i, rule := range file.Rules {
+		pattern, err := compilePathPattern("transforms", rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %d in '%s': %w", i+1, transformsFile, err)
+		}
+		if strings.TrimSpace(rule.StripComments) == "" && len(rule.Vars) == 0 && len(rule.Redact) == 0 {
+			return nil, fmt.Errorf("transform %d ('%s') in '%s' doesn't set strip-comments, vars or redact", i+1, rule.Path, transformsFile)
+		}
+		for _, expr := range rule.Redact {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redact pattern '%s' in transform %d in '%s': %w", expr, i+1, transformsFile, err)
+			}
+			rule.redact = append(rule.redact, re)
+		}
+		rule.StripComments = strings.TrimSpace(rule.StripComments)
+		rule.pattern = pattern
+	}
+	return file.Rules, nil
+}
+
+// removes the lines which are comments
+func stripComments(contents string, prefix string) string {
+	lines := strings.SplitAfter(contents, "\n")
+	kept := lines[:0]
+	for i, line := range lines {
+		if i == 0 && strings.HasPrefix(line, "#!") {
+			kept = append(kept, line)
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimLeft(line, " \t"), prefix) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// the contents changed by the transformers of the rule
+func (rule *transformRule) apply(contents string, queryParams url.Values) string {
+	if rule.StripComments != "" {
+		contents = stripComments(contents, rule.StripComments)
+	}
+	if len(rule.Vars) > 0 {
+		contents = transformVar.ReplaceAllStringFunc(contents, func(match string) string {
+			name := transformVar.FindStringSubmatch(match)[1]
+			value, ok := rule.Vars[name]
+			if !ok {
+				return match
+			}
+			if queryParams.Has(name) {
+				return queryParams.Get(name)
+			}
+			return value
+		})
+	}
+	for _, re := range rule.redact {
+		contents = re.ReplaceAllLiteralString(contents, "[redacted]")
+	}
+	return contents
+}
+
+// the contents of the file at p (starting with the mount name), changed by
+// every rule from -transforms which matches it, in the order they're in
+func (s *server) transform(p string, contents string, queryParams url.Values) string {
+	for _, rule := range s.transforms {
+		if rule.pattern.MatchString(p) {
+			contents = rule.apply(contents, queryParams)
+		}
+	}
+	return contents
+}
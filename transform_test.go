@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestTransformRule(t *testing.T) {
+	rule := &transformRule{
+		StripComments: "#",
+		Vars:          map[string]string{"hostname": "localhost"},
+		redact:        []*regexp.Regexp{regexp.MustCompile(`ghp_\w+`)},
+	}
+	contents := "#!/bin/sh\n# comment\n  # indented\necho {{hostname}} {{ hostname }} {{other}} # inline\ntoken=ghp_abc\n"
+	expected := "#!/bin/sh\necho laptop laptop {{other}} # inline\ntoken=[redacted]\n"
+	if got := rule.apply(contents, url.Values{"hostname": {"laptop"}}); got != expected {
+		t.Errorf("apply() = %q, expected %q", got, expected)
+	}
+	if got := rule.apply("{{hostname}}", url.Values{}); got != "localhost" {
+		t.Errorf("apply() without ?hostname= = %q, expected the default", got)
+	}
+}
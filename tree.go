@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default/maximum ?depth for /-/api/tree
+const (
+	defaultTreeDepth = 1
+	maxTreeDepth     = 10
+)
+
+// a file/directory in the response for /-/api/tree
+type treeNode struct {
+	Name string `json:"name"`
+	// path of the file/directory, like /-/raw/<path>
+	Path string `json:"path"`
+	// file or dir
+	Type string `json:"type"`
+	// for a directory, the total size of the files in it with -dir-sizes
+	Size    int64      `json:"size,omitempty"`
+	ModTime *time.Time `json:"mod_time,omitempty"`
+	// the files/directories in a directory
+	Children []*treeNode `json:"children,omitempty"`
+	// for a directory deeper than ?depth, whose children have
+	// to be requested separately
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func newTreeNode(m *mount, p string, d fs.DirEntry) *treeNode {
+	node := &treeNode{Name: d.Name(), Path: mountPath(m, p), Type: "dir"}
+	if d.IsDir() {
+		return node
+	}
+	node.Type = "file"
+	if info, err := d.Info(); err == nil {
+		modTime := info.ModTime().UTC()
+		node.Size, node.ModTime = info.Size(), &modTime
+	}
+	return node
+}
+
+// the directory dir in the mount, with the files/directories in it, up to
+// depth levels down. Returns ErrNotFound if it doesn't exist, or isn't a
+// directory, and ErrIgnored if it's ignored
+func tree(ctx context.Context, m *mount, dir string, depth int) (*treeNode, error) {
+	if dir != "." {
+		if err := checkIgnored(dir, true); err != nil {
+			return nil, err
+		}
+	}
+	// the walk starts at dir itself
+	isDir := false
+	err := m.src.walk(ctx, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		isDir = d.IsDir()
+		return errStopWalk
+	})
+	if errors.Is(err, fs.ErrNotExist) || (errors.Is(err, errStopWalk) && !isDir) {
+		return nil, ErrNotFound
+	}
+	if !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+	root := &treeNode{Path: mountPath(m, dir), Type: "dir"}
+	if root.Path != "" {
+		root.Name = path.Base(root.Path)
+	}
+	if depth == 0 {
+		root.Truncated = true
+		return root, nil
+	}
+	dirs := map[string]*treeNode{dir: root}
+	err = walkEntries(ctx, m.src, dir, func(p string, d fs.DirEntry) error {
+		parent, ok := dirs[path.Dir(p)]
+		if !ok {
+			return nil
+		}
+		node := newTreeNode(m, p, d)
+		parent.Children = append(parent.Children, node)
+		if !d.IsDir() {
+			return nil
+		}
+		if strings.Count(relativeTo(dir, p), "/")+1 >= depth {
+			node.Truncated = true
+			return fs.SkipDir
+		}
+		dirs[p] = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// responds with the directory at exactly ?path= (relative to the root,
+// like /-/raw/<path>), with the files/directories in it up to ?depth=
+// levels down, as JSON, so editors can browse the files lazily
+//
+// if ?path= is empty and there are -mount folders, the mounts are the
+// directories in the root
+func (s *server) serveTree(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions) {
+	queryParams := r.URL.Query()
+	depth := defaultTreeDepth
+	if value := queryParams.Get("depth"); value != "" {
+		var err error
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 1 || depth > maxTreeDepth {
+			w.WriteHeader(http.StatusBadRequest)
+			render(&w, &PageInfo{
+				PageContents: fmt.Sprintf("Invalid depth '%s', expected a number from 1 to %d\n", value, maxTreeDepth),
+				Title:        "400 - Bad Request",
+			}, s.tmpl, opts.isDark)
+			return
+		}
+	}
+	reqPath := strings.Trim(queryParams.Get("path"), "/")
+	done := timingsFrom(ctx).track("walk")
+	root, err := s.tree(ctx, reqPath, depth)
+	done()
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrIgnored) {
+		s.serveNotFound(ctx, w, reqPath, opts)
+		return
+	}
+	if err != nil {
+		renderError(&w, err, s.tmpl, opts.isDark)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(root)
+}
+
+// like tree, for a path relative to the root, which starts with the
+// mount name with -mount
+func (s *server) tree(ctx context.Context, reqPath string, depth int) (*treeNode, error) {
+	if reqPath == "" && s.config.mounts[0].name != "" {
+		root := &treeNode{Type: "dir"}
+		for _, m := range s.config.mounts {
+			node, err := tree(ctx, m, ".", depth-1)
+			if err != nil {
+				return nil, err
+			}
+			if s.dirSizes != nil {
+				if err := s.treeSizes(ctx, m, node); err != nil {
+					return nil, err
+				}
+				root.Size += node.Size
+			}
+			root.Children = append(root.Children, node)
+		}
+		return root, nil
+	}
+	m, p, err := resolveMount(ctx, s.config.mounts, reqPath)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		p = "."
+	}
+	if m == nil || p != path.Clean(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, ErrNotFound
+	}
+	root, err := tree(ctx, m, p, depth)
+	if err != nil || s.dirSizes == nil {
+		return root, err
+	}
+	return root, s.treeSizes(ctx, m, root)
+}
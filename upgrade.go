@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// set for a process started by an upgrade, which inherits the listener
+// as fd 3, and a pipe as fd 4 which it writes to once it's serving
+const upgradeEnv = "SUBPATH_SERVE_UPGRADE"
+
+// listens on the port, or if this process was started by an upgrade,
+// uses the listener from the previous process. The returned file is
+// the pipe to signal the previous process with, nil if there wasn't one
+func listen(port int) (net.Listener, *os.File, error) {
+	if os.Getenv(upgradeEnv) == "" {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		return listener, nil, err
+	}
+	os.Unsetenv(upgradeEnv)
+	f := os.NewFile(3, "listener")
+	defer f.Close()
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not use the listener from the previous process: %w", err)
+	}
+	return listener, os.NewFile(4, "upgrade"), nil
+}
+
+// tells the previous process this one is serving, so it can stop
+func upgradeReady(pipe *os.File) {
+	if pipe == nil {
+		return
+	}
+	pipe.Write([]byte{1})
+	pipe.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// upgrading on SIGUSR2 is only supported on unix
+func upgradeOnSignal(httpServer *http.Server, listener net.Listener, beforeUpgrade func(), done chan<- struct{}) {
+}
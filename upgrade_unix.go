@@ -0,0 +1,98 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// how long to wait for the requests on connections which were already
+	// accepted to be read after upgrading, since http.Server.Shutdown
+	// closes connections it hasn't read a request from yet
+	upgradeReadDelay = time.Second
+	// how long to wait for the requests in progress to finish after upgrading
+	upgradeTimeout = time.Minute
+)
+
+// on SIGUSR2, starts the binary (e.g. after it's been replaced with a
+// new version) with the same arguments, passing it the listener. Once the
+// new process is serving, stops accepting connections (so Serve returns
+// net.ErrClosed), waits for the requests in progress to finish, and
+// closes done
+//
+// if the new process exits before it starts serving (e.g. the config
+// is invalid), this one keeps serving
+func upgradeOnSignal(httpServer *http.Server, listener net.Listener, beforeUpgrade func(), done chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	for range signals {
+		beforeUpgrade()
+		pid, err := upgrade(listener)
+		if err != nil {
+			log.Printf("Could not upgrade: %s\n", err)
+			continue
+		}
+		log.Printf("Upgraded, process %d is serving, waiting for requests to finish\n", pid)
+		signal.Stop(signals)
+		// the new process accepts connections from now on
+		listener.Close()
+		time.Sleep(upgradeReadDelay)
+		ctx, cancel := context.WithTimeout(context.Background(), upgradeTimeout)
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Stopped waiting for requests to finish: %s\n", err)
+		}
+		cancel()
+		close(done)
+		return
+	}
+}
+
+// starts the new process with the listener, and waits for it to start
+// serving. Returns the pid of the new process
+func upgrade(listener net.Listener) (int, error) {
+	tcp, ok := listener.(*net.TCPListener)
+	if !ok {
+		return 0, errors.New("listener is not a TCP listener")
+	}
+	f, err := tcp.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.ExtraFiles = []*os.File{f, w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	// the new process writes to the pipe once it's serving, if it
+	// exits first, the pipe is closed without anything written to it
+	if n, _ := r.Read(make([]byte, 1)); n == 0 {
+		cmd.Wait()
+		return 0, errors.New("the new process exited before it started serving")
+	}
+	return cmd.Process.Pid, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// what to do with requests from a matching User-Agent
+var userAgentActions = [...]string{"plain", "dark", "block"}
+
+// a rule which changes how requests from matching User-Agents are handled
+//
+// plain always responds with plaintext (even with ?dark), dark responds
+// with HTML unless ?dark=0 is passed, and block responds with a 403
+type userAgentRule struct {
+	action  string
+	pattern *regexp.Regexp
+}
+
+// parses an 'action pattern' rule, where the pattern is matched against
+// the entire User-Agent (case-insensitive), and * matches anything
+func parseUserAgentRule(rule string) (*userAgentRule, error) {
+	action, pattern, _ := strings.Cut(strings.TrimSpace(rule), " ")
+	pattern = strings.TrimSpace(pattern)
+	valid := false
+	for _, a := range userAgentActions {
+		if action == a {
+			valid = true
+		}
+	}
+	if !valid || pattern == "" {
+		return nil, fmt.Errorf("invalid User-Agent rule '%s', expected 'action pattern', where action is one of: %s", rule, strings.Join(userAgentActions[:], ", "))
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return &userAgentRule{
+		action:  action,
+		pattern: regexp.MustCompile("(?i)^" + expr + "$"),
+	}, nil
+}
+
+// parses the rules from each -user-agent-rule, and then
+// the -user-agent-rules file, which has one rule per line
+func loadUserAgentRules(ruleFlags multiFlag, rulesFile string) ([]*userAgentRule, error) {
+	rules := []*userAgentRule{}
+	for _, value := range ruleFlags {
+		rule, err := parseUserAgentRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if rulesFile == "" {
+		return rules, nil
+	}
+	f, err := os.Open(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read User-Agent rules: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseUserAgentRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d in '%s': %w", lineNo, rulesFile, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read User-Agent rules: %w", err)
+	}
+	return rules, nil
+}
+
+// applies the first rule which matches the User-Agent of the request
+//
+// returns false if the request was blocked (and has been responded to)
+func (s *server) applyUserAgentRules(w http.ResponseWriter, r *http.Request, opts *requestOptions) bool {
+	// caches (like a CDN) have to store a response for each User-Agent
+	if len(s.userAgentRules) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	userAgent := r.UserAgent()
+	for _, rule := range s.userAgentRules {
+		if !rule.pattern.MatchString(userAgent) {
+			continue
+		}
+		switch rule.action {
+		case "plain":
+			opts.isDark = false
+		case "dark":
+			if !hasQueryParam(r.URL.Query(), "dark") {
+				opts.isDark = true
+				opts.darkByDefault = true
+			}
+		case "block":
+			w.WriteHeader(http.StatusForbidden)
+			render(&w, &PageInfo{
+				PageContents: "Forbidden\n",
+				Title:        "403 - Forbidden",
+			}, s.tmpl, opts.isDark)
+			return false
+		}
+		return true
+	}
+	return true
+}
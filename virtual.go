@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// a file from -virtual-files, whose contents are what a command writes
+// to stdout. The command is run when the file is requested and its output
+// is cached for the TTL, or if it has an interval, it's run in the
+// background that often instead
+type virtualFile struct {
+	// path of the file, like /-/raw/<path>
+	Path string `toml:"path"`
+	// the program and its arguments, which aren't run with a shell
+	Command  []string      `toml:"command"`
+	TTL      time.Duration `toml:"ttl"`
+	Interval time.Duration `toml:"interval"`
+
+	mu sync.Mutex
+	// the last output of the command, and when it finished
+	output []byte
+	ran    time.Time
+}
+
+// parses the [[file]] tables in the TOML file. Only the commands in it
+// can be run, requests can't change them or their arguments
+func loadVirtualFiles(virtualFilesFile string) (map[string]*virtualFile, error) {
+	var file struct {
+		Files []*virtualFile `toml:"file"`
+	}
+	if _, err := toml.DecodeFile(virtualFilesFile, &file); err != nil {
+		return nil, fmt.Errorf("could not parse virtual files '%s': %w", virtualFilesFile, err)
+	}
+	files := make(map[string]*virtualFile)
+	for i, v := range file.Files {
+		v.Path = strings.Trim(v.Path, "/")
+		if v.Path == "" || v.Path != path.Clean(v.Path) || strings.HasPrefix(v.Path, "../") || strings.HasPrefix(v.Path, "-/") {
+			return nil, fmt.Errorf("virtual file %d in '%s' has an invalid path '%s'", i+1, virtualFilesFile, v.Path)
+		}
+		if files[v.Path] != nil {
+			return nil, fmt.Errorf("virtual file '%s' in '%s' is a duplicate", v.Path, virtualFilesFile)
+		}
+		if len(v.Command) == 0 || v.Command[0] == "" {
+			return nil, fmt.Errorf("virtual file '%s' in '%s' doesn't have a command", v.Path, virtualFilesFile)
+		}
+		if _, err := exec.LookPath(v.Command[0]); err != nil {
+			return nil, fmt.Errorf("the command for virtual file '%s' in '%s' can't be run: %w", v.Path, virtualFilesFile, err)
+		}
+		if v.TTL < 0 || v.Interval < 0 {
+			return nil, fmt.Errorf("virtual file '%s' in '%s' has a negative ttl or interval", v.Path, virtualFilesFile)
+		}
+		files[v.Path] = v
+	}
+	return files, nil
+}
+
+// runs the command, with only PATH from the environment of the server
+func (v *virtualFile) run(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, v.Command[0], v.Command[1:]...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{max: maxDynamicOutput, err: errDynamicOutput}
+	stderr := &limitedBuffer{max: maxDynamicStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("took longer than %s", timeout)
+		}
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.output, v.ran = stdout.Bytes(), time.Now()
+	return v.output, nil
+}
+
+// the cached output, if it's still fresh
+func (v *virtualFile) cached() ([]byte, time.Time, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.ran.IsZero() {
+		return nil, v.ran, false
+	}
+	// with an interval, the output is replaced when the command is run again
+	return v.output, v.ran, v.Interval > 0 || time.Since(v.ran) < v.TTL
+}
+
+// runs the command every interval, until the server exits. If it fails,
+// the previous output is served until it succeeds again
+func (v *virtualFile) refreshEvery(timeout time.Duration) {
+	for {
+		if _, err := v.run(context.Background(), timeout); err != nil {
+			log.Printf("Could not run the command for virtual file %s: %s\n", v.Path, err)
+		}
+		time.Sleep(v.Interval)
+	}
+}
+
+// the output of the command for the virtual file, running it if what's
+// cached is older than its TTL. Concurrent requests share one run
+func (s *server) virtualOutput(ctx context.Context, v *virtualFile) ([]byte, time.Time, error) {
+	if output, ran, ok := v.cached(); ok {
+		return output, ran, nil
+	}
+	_, err := s.lookups.do(ctx, "virtual\x00"+v.Path, func(ctx context.Context) (interface{}, error) {
+		return v.run(ctx, s.dynamic.timeout)
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	output, ran, _ := v.cached()
+	return output, ran, nil
+}
+
+// responds with the output of the command for the virtual file. They're
+// requested by their exact path, and are served instead of a file which
+// matches it
+func (s *server) serveVirtual(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, v *virtualFile) {
+	defer timingsFrom(ctx).track("render")()
+	output, ran, err := s.virtualOutput(ctx, v)
+	if err != nil {
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			return
+		}
+		log.Printf("Could not run the command for virtual file %s: %s\n", v.Path, err)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusBadGateway)
+		render(&w, &PageInfo{
+			PageContents: fmt.Sprintf("Could not run the command for %s\n", v.Path),
+			Title:        "502 - Bad Gateway",
+		}, s.tmpl, opts.isDark)
+		return
+	}
+	w.Header().Set("X-Filepath", v.Path)
+	w.Header().Set("Last-Modified", ran.UTC().Format(http.TimeFormat))
+	if v.TTL > 0 && v.Interval == 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(max(int((v.TTL-time.Since(ran)).Seconds()), 0)))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if !opts.isDark {
+		w.Header().Set("Content-Type", http.DetectContentType(output))
+		w.Write(output)
+		return
+	}
+	render(&w, &PageInfo{
+		PageContents: string(output),
+		Title:        v.Path,
+	}, s.tmpl, opts.isDark)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadVirtualFiles(t *testing.T) {
+	for contents, valid := range map[string]bool{
+		"[[file]]\npath = \"status\"\ncommand = [\"true\"]\nttl = \"30s\"":                           true,
+		"[[file]]\npath = \"/status/\"\ncommand = [\"true\"]":                                        true,
+		"[[file]]\npath = \"-/status\"\ncommand = [\"true\"]":                                        false,
+		"[[file]]\npath = \"../status\"\ncommand = [\"true\"]":                                       false,
+		"[[file]]\npath = \"status\"":                                                                false,
+		"[[file]]\npath = \"status\"\ncommand = [\"not-a-command-xyz\"]":                             false,
+		"[[file]]\npath = \"status\"\ncommand = [\"true\"]\nttl = \"-1s\"":                           false,
+		"[[file]]\npath = \"a\"\ncommand = [\"true\"]\n[[file]]\npath = \"a\"\ncommand = [\"true\"]": false,
+	} {
+		p := filepath.Join(t.TempDir(), "virtual.toml")
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadVirtualFiles(p); (err == nil) != valid {
+			t.Errorf("loadVirtualFiles(%q) returned error %v", contents, err)
+		}
+	}
+}
+
+func TestVirtualOutput(t *testing.T) {
+	s := &server{lookups: &lookupGroup{}, dynamic: &dynamicFiles{timeout: 5 * time.Second}}
+	v := &virtualFile{Path: "n", Command: []string{"date", "+%N"}, TTL: time.Hour}
+	first, _, err := s.virtualOutput(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, _, _ := s.virtualOutput(context.Background(), v); string(second) != string(first) {
+		t.Errorf("the command ran again before the TTL passed: %q, then %q", first, second)
+	}
+	v.TTL = 0
+	if third, _, _ := s.virtualOutput(context.Background(), v); string(third) == string(first) {
+		t.Errorf("the command didn't run again without a TTL")
+	}
+	v.Command = []string{"false"}
+	if _, _, err := s.virtualOutput(context.Background(), v); err == nil {
+		t.Errorf("a command which failed didn't return an error")
+	}
+}
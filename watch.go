@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long to wait for changes to stop before taking a new snapshot,
+// so a deploy which writes many files only takes one
+const watchDebounce = 500 * time.Millisecond
+
+// takes a new snapshot whenever the underlying source changes
+//
+// a local folder is watched with inotify (or the native file watches of
+// the OS), so changes are picked up immediately. If it can't be watched
+// (network filesystems like NFS, which don't get events for changes made
+// by other machines, or too many directories), or for a remote -backend,
+// the source is checked for changes every interval instead
+func (s *snapshotSource) watch(interval time.Duration) {
+	if local, ok := s.src.(*localSource); ok {
+		s.setWatchMode("native", nil)
+		err := s.watchNative(local)
+		log.Printf("Could not watch %s for changes (%s), checking for changes every %s instead\n", s.src, err, interval)
+		s.setWatchMode("polling", err)
+	} else {
+		s.setWatchMode("polling", nil)
+	}
+	for range time.Tick(interval) {
+		s.refresh(context.Background())
+	}
+}
+
+// watches the folder with native file watches, only returns if that fails
+func (s *snapshotSource) watchNative(local *localSource) error {
+	if fsType := networkFilesystem(local.folder); fsType != "" {
+		return fmt.Errorf("%s is on a network filesystem (%s)", local.folder, fsType)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := addWatches(watcher, local.folder, local.folder); err != nil {
+		return err
+	}
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			// directories aren't watched recursively, so new ones have to be added
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() && !watchIgnored(local.folder, event.Name) {
+					if err := addWatches(watcher, local.folder, event.Name); err != nil {
+						return err
+					}
+				}
+			}
+			debounce = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			// some events were dropped, check everything
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return err
+			}
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			debounce = nil
+			s.refresh(context.Background())
+		}
+	}
+}
+
+// whether the directory at path (in the folder root) is ignored
+func watchIgnored(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && isIgnored(filepath.ToSlash(rel), true)
+}
+
+// watches dir (in the folder root), and every directory under it
+// (except ignored ones, like .git)
+func addWatches(watcher *fsnotify.Watcher, root string, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		// removed while walking
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && watchIgnored(root, path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("could not watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// serves the file at name in -well-known-dir as-is at /.well-known/,
+// or matches the path like any other request without -well-known-dir
+//
+// directories aren't listed, so only files which are
+// requested by name (e.g. ACME challenge tokens) are served
+func (s *server) serveWellKnown(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *requestOptions, name string) {
+	if s.config.wellKnownDir == "" {
+		s.serveQuery(ctx, w, r, opts, ".well-known/"+name)
+		return
+	}
+	fsys := os.DirFS(s.config.wellKnownDir)
+	info, err := fs.Stat(fsys, name)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFileFS(w, r, fsys, name)
+}